@@ -38,6 +38,8 @@ echo "running govluncheck on everything"
         govulncheck -test ./ingesters/massFile
         govulncheck -test ./ingesters/MSGraphIngester
         govulncheck -test ./ingesters/kafka_consumer
+        govulncheck -test ./ingesters/amqp_consumer
+        govulncheck -test ./ingesters/nats_consumer
         govulncheck -test ./ingesters/reddit_ingester
         govulncheck -test ./ingesters/O365Ingester
         govulncheck -test ./ingesters/args
@@ -62,6 +64,7 @@ echo "running govluncheck on everything"
         govulncheck -test ./ingesters/GooglePubSubIngester
         govulncheck -test ./ingesters/fileFollow
         govulncheck -test ./ingesters/singleFile
+        govulncheck -test ./ingesters/MQTTIngester
         govulncheck -test ./gwcli
         GOOS=windows govulncheck -test ./ingesters/winevents
         GOOS=windows govulncheck -test ./winevent/...
@@ -80,7 +83,10 @@ echo "Running go test -v"
         go test -v ./filewatch
         go test -v ./ingesters/utils
         go test -v ./ingesters/kafka_consumer
+        go test -v ./ingesters/amqp_consumer
+        go test -v ./ingesters/nats_consumer
         go test -v ./ingesters/SimpleRelay
+        go test -v ./ingesters/MQTTIngester
         go test -v ./ipexist
         go test -v ./netflow
         go test -v ./client/...
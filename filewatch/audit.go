@@ -0,0 +1,402 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingesters/utils"
+)
+
+const (
+	defaultAuditWindow = 2 * time.Second
+	maxAuditPending    = 1024
+	auditEOE           = `EOE`
+)
+
+var (
+	// msg=audit(<seconds>.<milliseconds>:<serial>):
+	auditIDRe = regexp.MustCompile(`^audit\(([0-9]+)\.([0-9]+):([0-9]+)\):?$`)
+	// long EXECVE arguments are split into a<N>[<chunk>] fields
+	auditArgChunk = regexp.MustCompile(`^(a[0-9]+)\[[0-9]+\]$`)
+	auditArg      = regexp.MustCompile(`^a[0-9]+$`)
+
+	// fields auditd hex encodes when the value is not a plain string
+	auditHexFields = map[string]bool{
+		`proctitle`: true, `cmd`: true, `comm`: true, `ocomm`: true, `exe`: true,
+		`cwd`: true, `name`: true, `path`: true, `data`: true, `key`: true, `acct`: true,
+	}
+
+	ErrInvalidAuditWindow = errors.New("invalid audit window")
+)
+
+type auditEvent struct {
+	id      string
+	ts      time.Time
+	serial  uint64
+	start   int64     //offset of the line holding the first record
+	seen    time.Time //when the first record was read
+	size    int
+	records [][]byte
+}
+
+// AuditReader reassembles Linux audit events.  auditd writes each event as several records that
+// share a msg=audit(<timestamp>:<serial>) ID and records of different events may be interleaved.
+// Records are grouped by ID and each event is emitted as a single JSON object with hex encoded
+// fields decoded.  An event is complete when its EOE record shows up or, for events that do not
+// get one, when records more than the window newer arrive or the window passes without new data.
+// The index never moves past the start of an event that is still being assembled.
+type AuditReader struct {
+	baseReader
+	brdr     *bufio.Reader
+	window   time.Duration
+	partial  []byte //line data without a trailing newline
+	pos      int64  //end of the lines consumed so far
+	pending  map[string]*auditEvent
+	ready    []*auditEvent
+	lastInfo EntryInfo
+}
+
+func NewAuditReader(cfg ReaderConfig) (*AuditReader, error) {
+	window := defaultAuditWindow
+	if cfg.EngineArgs != `` {
+		d, err := time.ParseDuration(cfg.EngineArgs)
+		if err != nil || d <= 0 {
+			return nil, ErrInvalidAuditWindow
+		}
+		window = d
+	}
+	br, err := newBaseReader(cfg.Fin, cfg.MaxLineLen, cfg.StartIndex)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditReader{
+		baseReader: br,
+		brdr:       bufio.NewReader(br.src),
+		window:     window,
+		pos:        cfg.StartIndex,
+		pending:    map[string]*auditEvent{},
+	}, nil
+}
+
+func (ar *AuditReader) SeekFile(offset int64) error {
+	ar.partial = nil
+	ar.pos = offset
+	ar.pending = map[string]*auditEvent{}
+	ar.ready = nil
+	if err := ar.baseReader.SeekFile(offset); err != nil {
+		return err
+	}
+	ar.brdr.Reset(ar.src)
+	return nil
+}
+
+// EntryInfo returns the info for the last event that was handed out
+func (ar *AuditReader) EntryInfo() EntryInfo {
+	return ar.lastInfo
+}
+
+func (ar *AuditReader) ReadEntry() (ln []byte, ok bool, wasEOF bool, err error) {
+	for {
+		if len(ar.ready) > 0 {
+			ln, ok = ar.pop(), true
+			return
+		}
+		b, lerr := ar.brdr.ReadBytes('\n')
+		if lerr != nil && lerr != io.EOF {
+			err = lerr
+			return
+		} else if lerr == io.EOF {
+			ar.partial = append(ar.partial, b...)
+			wasEOF = true
+			//nothing new is coming right now, events that have waited long enough are done
+			ar.expire(func(ev *auditEvent) bool { return time.Since(ev.seen) > ar.window })
+			if len(ar.ready) > 0 {
+				ln, ok = ar.pop(), true
+			}
+			return
+		}
+		if len(ar.partial) > 0 {
+			b = append(ar.partial, b...)
+			ar.partial = nil
+		}
+		start := ar.pos
+		ar.pos += int64(len(b))
+		if ln, ok = ar.handleLine(bytes.TrimRight(b, "\r\n"), start); ok {
+			return
+		}
+		ar.updateIndex()
+	}
+}
+
+// ReadRemaining is used when the file has gone idle or is going away, every event that is still
+// being assembled is handed out as it is
+func (ar *AuditReader) ReadRemaining() (ln []byte, err error) {
+	var ok bool
+	if ln, ok, _, err = ar.ReadEntry(); err != nil || ok {
+		return
+	}
+	ar.expire(func(*auditEvent) bool { return true })
+	if len(ar.ready) > 0 {
+		ln = ar.pop()
+	}
+	return
+}
+
+// handleLine processes a single record, ok is true if the line is handed out on its own
+func (ar *AuditReader) handleLine(b []byte, start int64) (ln []byte, ok bool) {
+	if len(bytes.TrimSpace(b)) == 0 {
+		return
+	}
+	typ, id, ts, serial, valid := parseAuditHeader(b)
+	if !valid {
+		//not an audit record, hand it out as is
+		ar.lastInfo = EntryInfo{}
+		ar.updateIndex()
+		return b, true
+	}
+	ar.expire(func(ev *auditEvent) bool { return ts.Sub(ev.ts) > ar.window })
+	ev, found := ar.pending[id]
+	if typ == auditEOE {
+		if found {
+			ar.finish(ev)
+		}
+		return
+	}
+	if !found {
+		if len(ar.pending) >= maxAuditPending {
+			ar.expire(func(*auditEvent) bool { return true })
+		}
+		ev = &auditEvent{id: id, ts: ts, serial: serial, start: start, seen: time.Now()}
+		ar.pending[id] = ev
+	}
+	ev.records = append(ev.records, append([]byte(nil), b...))
+	if ev.size += len(b); ar.maxLine > 0 && ev.size >= ar.maxLine {
+		ar.finish(ev)
+	}
+	return
+}
+
+// expire moves every pending event that fn selects to the ready queue
+func (ar *AuditReader) expire(fn func(*auditEvent) bool) {
+	var hit bool
+	for _, ev := range ar.pending {
+		if fn(ev) {
+			delete(ar.pending, ev.id)
+			ar.ready = append(ar.ready, ev)
+			hit = true
+		}
+	}
+	if hit {
+		sort.SliceStable(ar.ready, func(i, j int) bool { return ar.ready[i].start < ar.ready[j].start })
+	}
+}
+
+func (ar *AuditReader) finish(ev *auditEvent) {
+	delete(ar.pending, ev.id)
+	ar.ready = append(ar.ready, ev)
+}
+
+// pop hands out the first ready event
+func (ar *AuditReader) pop() []byte {
+	ev := ar.ready[0]
+	ar.ready = ar.ready[1:]
+	ar.lastInfo = EntryInfo{TS: ev.ts}
+	ar.updateIndex()
+	return ev.render()
+}
+
+// updateIndex moves the index to the end of what we have consumed unless an event has not been
+// handed out yet, in which case it stays at the start of the oldest one
+func (ar *AuditReader) updateIndex() {
+	ar.idx = ar.pos
+	for _, v := range ar.pending {
+		if v.start < ar.idx {
+			ar.idx = v.start
+		}
+	}
+	for _, v := range ar.ready {
+		if v.start < ar.idx {
+			ar.idx = v.start
+		}
+	}
+}
+
+// render builds the JSON object for the event, each record becomes an object with the type first
+func (ev *auditEvent) render() []byte {
+	bb := bytes.NewBuffer(make([]byte, 0, 256*len(ev.records)))
+	bb.WriteString(`{"timestamp":"`)
+	bb.WriteString(ev.ts.Format(time.RFC3339Nano))
+	bb.WriteString(`","serial":`)
+	bb.WriteString(strconv.FormatUint(ev.serial, 10))
+	bb.WriteString(`,"records":[`)
+	for i, rec := range ev.records {
+		if i > 0 {
+			bb.WriteByte(',')
+		}
+		bb.Write(utils.FieldsObject(parseAuditRecord(rec)))
+	}
+	bb.WriteString(`]}`)
+	return bb.Bytes()
+}
+
+// parseAuditHeader pulls the record type and event ID out of a record
+func parseAuditHeader(b []byte) (typ, id string, ts time.Time, serial uint64, ok bool) {
+	for _, tok := range auditTokens(string(b)) {
+		k, v, found := strings.Cut(tok, `=`)
+		if !found {
+			continue
+		}
+		switch k {
+		case `type`:
+			typ = v
+		case `msg`:
+			m := auditIDRe.FindStringSubmatch(v)
+			if m == nil {
+				return
+			}
+			sec, err := strconv.ParseInt(m[1], 10, 64)
+			if err != nil {
+				return
+			}
+			frac := (m[2] + `000000000`)[:9]
+			nsec, err := strconv.ParseInt(frac, 10, 64)
+			if err != nil {
+				return
+			}
+			if serial, err = strconv.ParseUint(m[3], 10, 64); err != nil {
+				return
+			}
+			ts = time.Unix(sec, nsec).UTC()
+			id = m[1] + `.` + m[2] + `:` + m[3]
+		}
+		if typ != `` && id != `` {
+			ok = true
+			return
+		}
+	}
+	return
+}
+
+// parseAuditRecord splits a record into named values, the event ID is dropped because it is
+// carried by the event, the quoted msg of user space records is flattened into the record and
+// hex encoded values are decoded
+func parseAuditRecord(b []byte) (names, vals []string) {
+	var typ string
+	seen := map[string]int{}
+	var add func(string)
+	add = func(s string) {
+		for _, tok := range auditTokens(s) {
+			k, v, found := strings.Cut(tok, `=`)
+			if !found || k == `` {
+				continue
+			}
+			if k == `msg` {
+				if auditIDRe.MatchString(v) {
+					continue
+				} else if len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'' {
+					add(v[1 : len(v)-1])
+					continue
+				}
+			}
+			if k == `type` && typ == `` {
+				typ = v
+			}
+			if m := auditArgChunk.FindStringSubmatch(k); m != nil && typ == `EXECVE` {
+				//reassemble the chunks of long arguments
+				v = decodeAuditValue(m[1], v)
+				if i, ok := seen[m[1]]; ok {
+					vals[i] += v
+				} else {
+					seen[m[1]] = len(vals)
+					names, vals = append(names, m[1]), append(vals, v)
+				}
+				continue
+			}
+			if _, ok := seen[k]; ok {
+				continue
+			}
+			if auditHexFields[k] || (typ == `EXECVE` && auditArg.MatchString(k)) {
+				v = decodeAuditValue(k, v)
+			} else {
+				v = unquoteAuditValue(v)
+			}
+			seen[k] = len(vals)
+			names, vals = append(names, k), append(vals, v)
+		}
+	}
+	add(string(b))
+	return
+}
+
+// auditTokens splits a record on whitespace, leaving quoted values intact.  Enriched logs
+// separate the interpreted fields with a group separator, which is treated as whitespace.
+func auditTokens(s string) (toks []string) {
+	var quote byte
+	start := -1
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if quote != 0 {
+			if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case ' ', '\t', 0x1d:
+			if start >= 0 {
+				toks = append(toks, s[start:i])
+				start = -1
+			}
+			continue
+		case '"', '\'':
+			quote = c
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		toks = append(toks, s[start:])
+	}
+	return
+}
+
+func unquoteAuditValue(v string) string {
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+		return v[1 : len(v)-1]
+	}
+	return v
+}
+
+// decodeAuditValue decodes fields that auditd writes as hex when they contain spaces or
+// control characters, quoted values are plain strings
+func decodeAuditValue(k, v string) string {
+	if len(v) == 0 || len(v)%2 != 0 || v[0] == '"' {
+		return unquoteAuditValue(v)
+	}
+	b, err := hex.DecodeString(v)
+	if err != nil {
+		return v
+	}
+	if k == `proctitle` {
+		//the arguments are NUL separated
+		b = bytes.ReplaceAll(bytes.TrimRight(b, "\x00"), []byte{0}, []byte{' '})
+	}
+	return string(b)
+}
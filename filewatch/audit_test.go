@@ -0,0 +1,117 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testAuditLines = []string{
+	`type=SYSCALL msg=audit(1700000000.100:100): arch=c000003e syscall=59 success=yes exe="/usr/bin/ls" key=(null)`,
+	`type=EXECVE msg=audit(1700000000.100:100): argc=3 a0="ls" a1=2D6C61 a2_len=8 a2[0]=2F746D70 a2[1]=2F6120`,
+	`type=USER_LOGIN msg=audit(1700000000.200:101): pid=1 uid=0 msg='op=login acct="root" exe="/usr/sbin/sshd" res=success'`,
+	`type=CWD msg=audit(1700000000.100:100): cwd="/root"`,
+	`type=PROCTITLE msg=audit(1700000000.100:100): proctitle=6C73002D6C61`,
+	`type=EOE msg=audit(1700000000.100:100): `,
+	`node=host1 type=SYSCALL msg=audit(1700000010.000:102): syscall=2 comm="cat"` + "\x1d" + `UID="root"`,
+	`type=EOE msg=audit(1700000010.000:102): `,
+	`not an audit record`,
+}
+
+var testAuditEvents = []string{
+	`{"timestamp":"2023-11-14T22:13:20.1Z","serial":100,"records":[` +
+		`{"type":"SYSCALL","arch":"c000003e","syscall":"59","success":"yes","exe":"/usr/bin/ls","key":"(null)"},` +
+		`{"type":"EXECVE","argc":"3","a0":"ls","a1":"-la","a2_len":"8","a2":"/tmp/a "},` +
+		`{"type":"CWD","cwd":"/root"},` +
+		`{"type":"PROCTITLE","proctitle":"ls -la"}]}`,
+	`{"timestamp":"2023-11-14T22:13:20.2Z","serial":101,"records":[` +
+		`{"type":"USER_LOGIN","pid":"1","uid":"0","op":"login","acct":"root","exe":"/usr/sbin/sshd","res":"success"}]}`,
+	`{"timestamp":"2023-11-14T22:13:30Z","serial":102,"records":[` +
+		`{"node":"host1","type":"SYSCALL","syscall":"2","comm":"cat","UID":"root"}]}`,
+	`not an audit record`,
+}
+
+func TestAuditReader(t *testing.T) {
+	data := strings.Join(testAuditLines, "\n") + "\n"
+	p := filepath.Join(t.TempDir(), `audit.log`)
+	if err := os.WriteFile(p, []byte(data), 0660); err != nil {
+		t.Fatal(err)
+	}
+	rdr := openTestReader(t, p, AuditEngine, ``, 0)
+	lines, idxs := readWithIndexes(t, rdr)
+	checkLines(t, lines, testAuditEvents)
+	//the login event was still open when the first event finished, so the index waits on it
+	if login := int64(strings.Index(data, `type=USER_LOGIN`)); idxs[0] != login {
+		t.Fatalf("index moved past an open event %d != %d", idxs[0], login)
+	} else if idxs[len(idxs)-1] != int64(len(data)) {
+		t.Fatalf("bad final index %d != %d", idxs[len(idxs)-1], len(data))
+	}
+	if info := rdr.(infoReader).EntryInfo(); !info.TS.IsZero() {
+		t.Fatalf("raw line has a timestamp %v", info.TS)
+	}
+}
+
+func TestAuditReaderTimestamp(t *testing.T) {
+	p := filepath.Join(t.TempDir(), `audit.log`)
+	if err := os.WriteFile(p, []byte(testAuditLines[0]+"\n"+testAuditLines[5]+"\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	rdr := openTestReader(t, p, AuditEngine, ``, 0)
+	if _, ok, _, err := rdr.ReadEntry(); err != nil || !ok {
+		t.Fatal("missing event", err)
+	}
+	if ts := rdr.(infoReader).EntryInfo().TS; !ts.Equal(time.Unix(1700000000, 100000000)) {
+		t.Fatalf("bad event timestamp %v", ts)
+	}
+}
+
+func TestAuditReaderIdle(t *testing.T) {
+	p := filepath.Join(t.TempDir(), `audit.log`)
+	fout, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fout.Close()
+	rdr := openTestReader(t, p, AuditEngine, `10ms`, 0)
+	if _, err = fout.WriteString(testAuditLines[2] + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	//an event without an EOE record waits for the window
+	if got := readAllEntries(t, rdr); len(got) != 0 {
+		t.Fatalf("event handed out early: %v", got)
+	}
+	time.Sleep(20 * time.Millisecond)
+	checkLines(t, readAllEntries(t, rdr), testAuditEvents[1:2])
+
+	//records still being assembled are handed out when forced
+	if _, err = fout.WriteString(testAuditLines[3] + "\n"); err != nil {
+		t.Fatal(err)
+	} else if ln, err := rdr.ReadRemaining(); err != nil || string(ln) != `{"timestamp":"2023-11-14T22:13:20.1Z","serial":100,"records":[{"type":"CWD","cwd":"/root"}]}` {
+		t.Fatalf("bad remaining %q %v", ln, err)
+	}
+}
+
+func TestAuditReaderBadWindow(t *testing.T) {
+	p := filepath.Join(t.TempDir(), `audit.log`)
+	if err := os.WriteFile(p, nil, 0660); err != nil {
+		t.Fatal(err)
+	}
+	fin, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fin.Close()
+	if _, err = NewReader(ReaderConfig{Fin: fin, MaxLineLen: defaultMaxLine, Engine: AuditEngine, EngineArgs: `soon`}); err != ErrInvalidAuditWindow {
+		t.Fatalf("bad window was accepted: %v", err)
+	}
+}
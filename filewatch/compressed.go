@@ -0,0 +1,302 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"io"
+	"math"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type compressionType int
+
+const (
+	compressionNone compressionType = iota
+	compressionGzip
+	compressionZstd
+	compressionBzip2
+)
+
+var (
+	errCompressedIncomplete = errors.New("compressed file is incomplete")
+
+	magicGzip  = []byte{0x1f, 0x8b}
+	magicZstd  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	magicBzip2 = []byte{'B', 'Z', 'h'}
+
+	// compressedExtensions are the usual names of files produced by the compressors we support
+	compressedExtensions = []string{`.gz`, `.zst`, `.bz2`}
+
+	compressionMagics = []struct {
+		ct    compressionType
+		magic []byte
+	}{
+		{compressionGzip, magicGzip},
+		{compressionZstd, magicZstd},
+		{compressionBzip2, magicBzip2},
+	}
+)
+
+func (ct compressionType) String() string {
+	switch ct {
+	case compressionNone:
+		return `none`
+	case compressionGzip:
+		return `gzip`
+	case compressionZstd:
+		return `zstd`
+	case compressionBzip2:
+		return `bzip2`
+	}
+	return `unknown`
+}
+
+// detectCompression checks the leading magic bytes of a file.  If the file is too short to
+// decide because what is there could still be the start of a magic number, ok is false.
+func detectCompression(ra io.ReaderAt) (ct compressionType, ok bool, err error) {
+	buff := make([]byte, len(magicZstd))
+	var n int
+	if n, err = ra.ReadAt(buff, 0); err != nil && err != io.EOF {
+		return
+	}
+	err = nil
+	buff = buff[:n]
+	if n == 0 {
+		return //empty files are not decided until something shows up
+	}
+	for _, v := range compressionMagics {
+		if len(buff) >= len(v.magic) {
+			if bytes.HasPrefix(buff, v.magic) {
+				ct, ok = v.ct, true
+				return
+			}
+		} else if bytes.HasPrefix(v.magic, buff) {
+			return //could still turn into a compressed file
+		}
+	}
+	ok = true
+	return
+}
+
+// isCompressedFile checks the magic bytes of the file at the given path
+func isCompressedFile(p string) bool {
+	fin, err := os.Open(p)
+	if err != nil {
+		return false
+	}
+	defer fin.Close()
+	ct, ok, err := detectCompression(fin)
+	return err == nil && ok && ct != compressionNone
+}
+
+func (ct compressionType) newReader(r io.Reader) (io.ReadCloser, error) {
+	switch ct {
+	case compressionGzip:
+		return gzip.NewReader(r)
+	case compressionZstd:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	case compressionBzip2:
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	}
+	return nil, errors.New("unknown compression type")
+}
+
+// contentReader is the byte source handed to reader engines.  Plain files are read directly,
+// compressed files are decompressed on the fly and all offsets refer to the decompressed stream.
+// Compression is detected lazily so that a file which is created empty and then filled by
+// a compressor (e.g. logrotate compressing app.log.1) is never mistaken for a plain file.
+type contentReader struct {
+	f        *os.File
+	detected bool
+	ct       compressionType
+	off      int64 //bytes handed out so far
+	dec      io.ReadCloser
+	complete bool //the compressed stream has been read to its end
+	touched  bool //content has been handed out since the last seek
+}
+
+func newContentReader(f *os.File, off int64) *contentReader {
+	return &contentReader{
+		f:   f,
+		off: off,
+	}
+}
+
+// detect attempts to determine if the file is compressed, ok is false if we can't tell yet
+func (cr *contentReader) detect() (ok bool, err error) {
+	if cr.detected {
+		return true, nil
+	}
+	if cr.ct, ok, err = detectCompression(cr.f); err == nil && ok {
+		cr.detected = true
+	}
+	return
+}
+
+func (cr *contentReader) compressed() bool {
+	return cr.detected && cr.ct != compressionNone
+}
+
+func (cr *contentReader) Read(b []byte) (n int, err error) {
+	var ok bool
+	if ok, err = cr.detect(); err != nil {
+		return
+	} else if !ok {
+		return 0, io.EOF //nothing we can hand out yet
+	}
+	if cr.ct == compressionNone {
+		n, err = cr.f.Read(b)
+		cr.off += int64(n)
+		cr.touched = cr.touched || n > 0
+		return
+	}
+	if cr.complete {
+		return 0, io.EOF
+	}
+	if cr.dec == nil {
+		if cr.dec, err = openDecompressor(cr.f, cr.ct, cr.off); err != nil {
+			cr.dec = nil
+			if err == io.EOF {
+				cr.complete = true //the stream ended before our offset, there is nothing left
+			} else if err == io.ErrUnexpectedEOF {
+				err = io.EOF //still being written, try again later
+			}
+			return
+		}
+	}
+	n, err = cr.dec.Read(b)
+	cr.off += int64(n)
+	cr.touched = cr.touched || n > 0
+	if err == io.EOF {
+		cr.complete = true
+	} else if err == io.ErrUnexpectedEOF {
+		//the compressor has not finished writing the file, decompressors cannot resume
+		//after a short read so we start over and skip what we have already handed out
+		cr.reset()
+		err = io.EOF
+	}
+	return
+}
+
+// SeekTo sets the offset of the next read, for compressed files this is the decompressed offset
+func (cr *contentReader) SeekTo(offset int64) (err error) {
+	if !cr.compressed() {
+		_, err = cr.f.Seek(offset, io.SeekStart)
+	} else {
+		cr.reset()
+		cr.complete = false
+	}
+	cr.off = offset
+	cr.touched = false
+	return
+}
+
+// Size returns the size of the plain file.  Compressed files can't be appended to or truncated
+// in place, their size is reported as the decompressed size once it is known and unbounded before.
+func (cr *contentReader) Size() (sz int64, err error) {
+	if _, err = cr.detect(); err != nil {
+		return -1, err
+	}
+	if cr.compressed() {
+		if cr.complete {
+			return cr.off, nil
+		}
+		return math.MaxInt64, nil
+	}
+	var fi os.FileInfo
+	if fi, err = cr.f.Stat(); err != nil {
+		return -1, err
+	}
+	return fi.Size(), nil
+}
+
+// Fingerprint computes the fingerprint of the content without disturbing the current read position
+func (cr *contentReader) Fingerprint() (fp Fingerprint, ok bool, err error) {
+	var r io.Reader = io.NewSectionReader(cr.f, 0, math.MaxInt64)
+	if cr.compressed() {
+		var dec io.ReadCloser
+		if dec, err = cr.ct.newReader(r); err != nil {
+			if err == io.ErrUnexpectedEOF || err == io.EOF {
+				err = errCompressedIncomplete
+			}
+			return
+		}
+		defer dec.Close()
+		r = dec
+	}
+	if fp, ok, err = fingerprintContent(r); err == io.ErrUnexpectedEOF && cr.compressed() {
+		err = errCompressedIncomplete
+	}
+	return
+}
+
+// Check hashes the content leading up to off without disturbing the current read position,
+// ok is false if the content ends before off
+func (cr *contentReader) Check(off int64) (chk contentCheck, ok bool, err error) {
+	start := off - fingerprintSize
+	if start < 0 {
+		start = 0
+	}
+	var r io.Reader = io.NewSectionReader(cr.f, start, off-start)
+	if cr.compressed() {
+		var dec io.ReadCloser
+		if dec, err = openDecompressor(cr.f, cr.ct, start); err == io.EOF {
+			err = nil //the stream ended before the check begins
+			return
+		} else if err == io.ErrUnexpectedEOF {
+			err = errCompressedIncomplete
+			return
+		} else if err != nil {
+			return
+		}
+		defer dec.Close()
+		r = dec
+	}
+	chk.Offset = off
+	if chk.Sum, ok, err = hashContent(r, int(off-start)); err == io.ErrUnexpectedEOF && cr.compressed() {
+		err = errCompressedIncomplete
+	}
+	return
+}
+
+func (cr *contentReader) reset() {
+	if cr.dec != nil {
+		cr.dec.Close()
+		cr.dec = nil
+	}
+}
+
+func (cr *contentReader) Close() {
+	cr.reset()
+}
+
+// openDecompressor opens a new decompressed stream on the file and discards the first off bytes.
+// io.EOF means the stream is complete but shorter than off, io.ErrUnexpectedEOF means it is still being written.
+func openDecompressor(f *os.File, ct compressionType, off int64) (dec io.ReadCloser, err error) {
+	if dec, err = ct.newReader(io.NewSectionReader(f, 0, math.MaxInt64)); err != nil {
+		return
+	}
+	if off > 0 {
+		if _, err = io.CopyN(io.Discard, dec, off); err != nil {
+			dec.Close()
+			return nil, err
+		}
+	}
+	return
+}
@@ -0,0 +1,404 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// 100 lines of "bzip2 line N\n", there is no bzip2 writer in the standard library
+const testBzip2Lines = `QlpoOTFBWSZTWXLBf6YAASdZgAAQQAB/4BIlQBAwAODBpEwf6qSgApkxMgyMCpUAeoBk98tbGdb477suA0PAMhgGAZDwDQuA2WAOIAHzJUlbkqSvhcD3gA5KhcB+yVJXJU73vne973upUDAMyoDIYlQDQ8lQBsvKgBxaVLWBVVujshLEJYhLkJmQlxCXIT8XckU4UJBywX+m`
+
+type orderedLH struct {
+	testTagger
+	lines []string
+}
+
+func (h *orderedLH) HandleLog(b []byte, ts time.Time, fname string) error {
+	h.lines = append(h.lines, string(b))
+	return nil
+}
+
+func testLines(prefix string, start, end int) (lines []string, content []byte) {
+	bb := bytes.NewBuffer(nil)
+	for i := start; i < end; i++ {
+		ln := fmt.Sprintf("%s line %d", prefix, i)
+		lines = append(lines, ln)
+		fmt.Fprintf(bb, "%s\n", ln)
+	}
+	content = bb.Bytes()
+	return
+}
+
+func gzipBytes(t *testing.T, b []byte) []byte {
+	bb := bytes.NewBuffer(nil)
+	gw := gzip.NewWriter(bb)
+	if _, err := gw.Write(b); err != nil {
+		t.Fatal(err)
+	} else if err = gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bb.Bytes()
+}
+
+func zstdBytes(t *testing.T, b []byte) []byte {
+	bb := bytes.NewBuffer(nil)
+	zw, err := zstd.NewWriter(bb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = zw.Write(b); err != nil {
+		t.Fatal(err)
+	} else if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bb.Bytes()
+}
+
+func readAllEntries(t *testing.T, rdr Reader) (lines []string) {
+	for {
+		ln, ok, _, err := rdr.ReadEntry()
+		if err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			return
+		}
+		lines = append(lines, string(ln))
+	}
+}
+
+func checkLines(t *testing.T, got, expected []string) {
+	t.Helper()
+	if len(got) != len(expected) {
+		t.Fatalf("line count mismatch %d != %d", len(got), len(expected))
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Fatalf("line %d mismatch %q != %q", i, got[i], expected[i])
+		}
+	}
+}
+
+func TestCompressedReaders(t *testing.T) {
+	bz, err := base64.StdEncoding.DecodeString(testBzip2Lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bzLines, bzContent := testLines(`bzip2`, 0, 100)
+	lines, content := testLines(`test`, 0, 100)
+	tsts := []struct {
+		name    string
+		data    []byte
+		lines   []string
+		content []byte
+	}{
+		{`gzip`, gzipBytes(t, content), lines, content},
+		{`zstd`, zstdBytes(t, content), lines, content},
+		{`bzip2`, bz, bzLines, bzContent},
+	}
+	dir := t.TempDir()
+	for _, tst := range tsts {
+		p := filepath.Join(dir, tst.name)
+		if err := os.WriteFile(p, tst.data, 0660); err != nil {
+			t.Fatal(err)
+		}
+		//start at the beginning and part way through, offsets are in the decompressed stream
+		for _, skip := range []int{0, 37} {
+			var start int64
+			for i := 0; i < skip; i++ {
+				start += int64(len(tst.lines[i]) + 1)
+			}
+			fin, err := os.Open(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rdr, err := NewReader(ReaderConfig{Fin: fin, MaxLineLen: defaultMaxLine, StartIndex: start})
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkLines(t, readAllEntries(t, rdr), tst.lines[skip:])
+			if rdr.Index() != int64(len(tst.content)) {
+				t.Fatalf("%s bad index %d != %d", tst.name, rdr.Index(), len(tst.content))
+			} else if sz, err := rdr.FileSize(); err != nil || sz != int64(len(tst.content)) {
+				t.Fatalf("%s bad size %d %v", tst.name, sz, err)
+			}
+			rdr.Close()
+		}
+	}
+}
+
+func TestCompressedPartialWrite(t *testing.T) {
+	lines, content := testLines(`partial`, 0, 2000)
+	gz := gzipBytes(t, content)
+	p := filepath.Join(t.TempDir(), `app.log.1.gz`)
+	fout, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fout.Close()
+	fin, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdr, err := NewLineReader(ReaderConfig{Fin: fin, MaxLineLen: defaultMaxLine})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rdr.Close()
+	//the compressor writes in chunks, the reader must never hand out garbage or duplicates
+	var got []string
+	for off := 0; off < len(gz); off += 1000 {
+		end := off + 1000
+		if end > len(gz) {
+			end = len(gz)
+		}
+		if _, err := fout.Write(gz[off:end]); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, readAllEntries(t, rdr)...)
+		if off == 0 && !rdr.compressed() {
+			t.Fatal("failed to detect compression")
+		}
+	}
+	checkLines(t, got, lines)
+}
+
+func TestCompressedDetection(t *testing.T) {
+	tsts := []struct {
+		data       []byte
+		ok         bool
+		compressed bool
+	}{
+		{nil, false, false},
+		{[]byte{0x1f}, false, false},
+		{[]byte{0x28, 0xb5}, false, false},
+		{[]byte("B"), false, false},
+		{[]byte("BZ"), false, false},
+		{[]byte("a\n"), true, false},
+		{[]byte("Bob said hi\n"), true, false},
+		{[]byte{0x1f, 0x8b}, true, true},
+		{[]byte{0x28, 0xb5, 0x2f, 0xfd}, true, true},
+		{[]byte("BZh9"), true, true},
+	}
+	for i, tst := range tsts {
+		ct, ok, err := detectCompression(bytes.NewReader(tst.data))
+		if err != nil {
+			t.Fatal(err)
+		} else if ok != tst.ok || (ct != compressionNone) != tst.compressed {
+			t.Fatalf("%d bad detection %v %v", i, ok, ct)
+		}
+	}
+}
+
+func newFingerprintFollower(t *testing.T, p string, fpt *fingerprintTracker, lh handler) (*follower, *int64) {
+	state := new(int64)
+	fl, err := NewFollower(FollowerConfig{
+		BaseName: baseName,
+		FilePath: p,
+		State:    state,
+		Handler:  lh,
+		fpt:      fpt,
+		fpPlain:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fl, state
+}
+
+func TestRotateThenCompress(t *testing.T) {
+	dir := t.TempDir()
+	first, content := testLines(`rotate`, 0, 100)
+	rest, more := testLines(`rotate`, 100, 200)
+	all := append(bytes.Clone(content), more...)
+	fpt := newFingerprintTracker(nil)
+
+	//follow app.log.1 but only get part way through it
+	plain := filepath.Join(dir, `app.log.1`)
+	if err := os.WriteFile(plain, content, 0660); err != nil {
+		t.Fatal(err)
+	}
+	var plh orderedLH
+	pfl, _ := newFingerprintFollower(t, plain, fpt, &plh)
+	if _, err := pfl.Sync(nil); err != nil {
+		t.Fatal(err)
+	}
+	checkLines(t, plh.lines, first)
+	if pfl.fpStatus != fpClaimed {
+		t.Fatal("plain file did not claim its fingerprint")
+	}
+	//more data lands and then the file is compressed before we get to it
+	f, err := os.OpenFile(plain, os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(more)
+	f.Close()
+	gz := filepath.Join(dir, `app.log.1.gz`)
+	if err := os.WriteFile(gz, gzipBytes(t, all), 0660); err != nil {
+		t.Fatal(err)
+	}
+
+	//the compressed file must wait while the plain file is still being read
+	var glh orderedLH
+	gfl, gstate := newFingerprintFollower(t, gz, fpt, &glh)
+	if _, err := gfl.Sync(nil); err != nil {
+		t.Fatal(err)
+	} else if len(glh.lines) != 0 {
+		t.Fatalf("compressed file was read while the plain file was live: %d", len(glh.lines))
+	}
+
+	//plain file goes away, the compressed file picks up exactly where it left off
+	if err := os.Remove(plain); err != nil {
+		t.Fatal(err)
+	} else if err = pfl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gfl.Sync(nil); err != nil {
+		t.Fatal(err)
+	}
+	checkLines(t, glh.lines, rest)
+	if *gstate != int64(len(all)) {
+		t.Fatalf("bad compressed state %d != %d", *gstate, len(all))
+	}
+	if err := gfl.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//the compressed file moves to a new name while we are not looking, it must not be re-read
+	gz2 := filepath.Join(dir, `app.log.2.gz`)
+	if err := os.Rename(gz, gz2); err != nil {
+		t.Fatal(err)
+	}
+	var g2lh orderedLH
+	g2fl, _ := newFingerprintFollower(t, gz2, newFingerprintTracker(fpt.export()), &g2lh)
+	if _, err := g2fl.Sync(nil); err != nil {
+		t.Fatal(err)
+	} else if len(g2lh.lines) != 0 {
+		t.Fatalf("completed compressed file was re-ingested: %d", len(g2lh.lines))
+	}
+	g2fl.Close()
+}
+
+func TestCompressedFingerprintCollision(t *testing.T) {
+	dir := t.TempDir()
+	header, hcontent := testLines(`shared header`, 0, 100)
+	_, acontent := testLines(`first`, 0, 50)
+	btail, bcontent := testLines(`second`, 0, 50)
+	fpt := newFingerprintTracker(nil)
+
+	//a plain file is read to the end and goes away
+	pa := filepath.Join(dir, `a.log`)
+	if err := os.WriteFile(pa, append(bytes.Clone(hcontent), acontent...), 0660); err != nil {
+		t.Fatal(err)
+	}
+	var alh orderedLH
+	afl, _ := newFingerprintFollower(t, pa, fpt, &alh)
+	if _, err := afl.Sync(nil); err != nil {
+		t.Fatal(err)
+	} else if err = afl.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//unrelated compressed content that shares the header must be read in full
+	all := append(bytes.Clone(hcontent), bcontent...)
+	gz := filepath.Join(dir, `b.log.gz`)
+	if err := os.WriteFile(gz, gzipBytes(t, all), 0660); err != nil {
+		t.Fatal(err)
+	}
+	var glh orderedLH
+	gfl, gstate := newFingerprintFollower(t, gz, fpt, &glh)
+	if _, err := gfl.Sync(nil); err != nil {
+		t.Fatal(err)
+	}
+	checkLines(t, glh.lines, append(header, btail...))
+	if *gstate != int64(len(all)) {
+		t.Fatalf("bad compressed state %d != %d", *gstate, len(all))
+	}
+	gfl.Close()
+
+	//a state recorded without a check is not trusted either
+	fp := sha256.Sum256(hcontent[:fingerprintSize])
+	k := fingerprintKey{BaseName: baseName, Fingerprint: fp}
+	nfpt := newFingerprintTracker(map[fingerprintKey]fingerprintState{k: {State: int64(len(hcontent))}})
+	var nlh orderedLH
+	nfl, _ := newFingerprintFollower(t, gz, nfpt, &nlh)
+	if _, err := nfl.Sync(nil); err != nil {
+		t.Fatal(err)
+	}
+	checkLines(t, nlh.lines, append(header, btail...))
+	nfl.Close()
+}
+
+func TestFollowsCompressed(t *testing.T) {
+	fm, err := NewFilterManager(filepath.Join(t.TempDir(), `state`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fm.Close()
+	for _, tc := range []struct {
+		mtchs []string
+		name  string
+		ok    bool
+	}{
+		{[]string{`app.log*`}, `app.log`, true},
+		{[]string{`app.log*`}, `app.log.1`, true},
+		{[]string{`*.log`, `*.gz`}, `app.log`, true},
+		{[]string{`*.log`}, `app.log`, false},
+		{[]string{`app.log`}, `app.log`, false},
+		{[]string{`*.log`, `*.zst`}, `app.log`, true},
+		{[]string{`syslog`, `syslog.[0-9]`, `syslog.[0-9].gz`}, `syslog`, true},
+		{[]string{`syslog`, `syslog.[0-9]`, `syslog.[0-9].gz`}, `syslog.2`, true},
+	} {
+		fm.filters = []filter{{mtchs: tc.mtchs}}
+		fcfg := FollowerConfig{FilePath: filepath.Join(`/var/log`, tc.name)}
+		if fm.setIdentity(&fcfg); fcfg.fpPlain != tc.ok {
+			t.Fatalf("%v %s: fingerprint registration %v != %v", tc.mtchs, tc.name, fcfg.fpPlain, tc.ok)
+		}
+	}
+	fm.filters = nil
+}
+
+func TestFingerprintStateFile(t *testing.T) {
+	dir := t.TempDir()
+	sf := filepath.Join(dir, `state`)
+	fm, err := NewFilterManager(sf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fp Fingerprint
+	fp[0] = 0x42
+	st := int64(1234)
+	k := fingerprintKey{BaseName: baseName, Fingerprint: fp}
+	fm.fpt.claim(k, nil, &st)
+	if err = fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	//older readers only see the path states
+	if _, err = ReadStateFile(sf); err != nil {
+		t.Fatal(err)
+	}
+	if fm, err = NewFilterManager(sf); err != nil {
+		t.Fatal(err)
+	}
+	defer fm.Close()
+	if v, ok := fm.fpt.export()[k]; !ok || v.State != st {
+		t.Fatalf("fingerprint state was not restored: %v %v", ok, v)
+	}
+}
@@ -0,0 +1,352 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	dockerConfigName  = `config.v2.json`
+	containerMetaTTL  = time.Minute
+	maxContainerMetas = 4096
+
+	evContainerID        = `container_id`
+	evContainerName      = `container_name`
+	evContainerImage     = `image`
+	evContainerNamespace = `namespace`
+	evContainerPod       = `pod`
+	evContainerStream    = `stream`
+)
+
+var (
+	// /var/log/pods/<namespace>_<pod>_<uid>/<container>/<restart count>.log
+	criPodPath = regexp.MustCompile(`/pods/([^_/]+)_([^_/]+)_[^_/]+/([^/]+)/[^/]+$`)
+	// /var/log/containers/<pod>_<namespace>_<container>-<container id>.log
+	criContainerPath = regexp.MustCompile(`/containers/([^_/]+)_([^_/]+)_(.+)-([0-9a-f]{64})\.log`)
+)
+
+// EntryInfo is what a reader engine learned about an entry beyond its contents
+type EntryInfo struct {
+	TS     time.Time //zero if the engine did not find a timestamp
+	Stream string    //stdout or stderr for container logs
+}
+
+// infoReader is implemented by reader engines that provide an EntryInfo with each entry
+type infoReader interface {
+	EntryInfo() EntryInfo
+}
+
+// entryHandler is implemented by handlers that can make use of an EntryInfo
+type entryHandler interface {
+	HandleEntry([]byte, EntryInfo, string) error
+}
+
+type containerPartial struct {
+	stream string
+	data   []byte
+	start  int64 //offset of the line holding the first part
+	ts     time.Time
+}
+
+// ContainerReader unwraps container runtime log files.  Both the Docker json-file format and the
+// CRI format used by containerd and CRI-O are handled, lines split by the runtime are reassembled
+// and the timestamp and stream from the wrapper are handed out with each entry.
+// The index never moves past the start of a message that is still being reassembled, so a restart
+// re-reads the parts rather than emitting the tail of a message on its own.  Only one message is
+// reassembled at a time, a line from another stream hands out what we have so far first.  Otherwise
+// the index would sit behind entries from the other stream that were already handed out.
+type ContainerReader struct {
+	baseReader
+	brdr     *bufio.Reader
+	partial  []byte            //line data without a trailing newline
+	pos      int64             //end of the lines consumed so far
+	pending  *containerPartial //message being reassembled, nil if there is none
+	held     []byte            //line that interrupted the pending message, it is handled next
+	heldAt   int64
+	lastInfo EntryInfo
+}
+
+func NewContainerReader(cfg ReaderConfig) (*ContainerReader, error) {
+	br, err := newBaseReader(cfg.Fin, cfg.MaxLineLen, cfg.StartIndex)
+	if err != nil {
+		return nil, err
+	}
+	return &ContainerReader{
+		baseReader: br,
+		brdr:       bufio.NewReader(br.src),
+		pos:        cfg.StartIndex,
+	}, nil
+}
+
+func (cr *ContainerReader) SeekFile(offset int64) error {
+	cr.partial = nil
+	cr.pos = offset
+	cr.pending = nil
+	cr.held = nil
+	if err := cr.baseReader.SeekFile(offset); err != nil {
+		return err
+	}
+	cr.brdr.Reset(cr.src)
+	return nil
+}
+
+// EntryInfo returns the info for the last entry that was handed out
+func (cr *ContainerReader) EntryInfo() EntryInfo {
+	return cr.lastInfo
+}
+
+func (cr *ContainerReader) ReadEntry() (ln []byte, ok bool, wasEOF bool, err error) {
+	for {
+		if b := cr.held; b != nil {
+			cr.held = nil
+			if ln, ok = cr.handleLine(b, cr.heldAt); ok {
+				return
+			}
+			cr.updateIndex()
+			continue
+		}
+		b, lerr := cr.brdr.ReadBytes('\n')
+		if lerr != nil && lerr != io.EOF {
+			err = lerr
+			return
+		} else if lerr == io.EOF {
+			//a wrapper without its newline is not complete, wait for the rest
+			cr.partial = append(cr.partial, b...)
+			wasEOF = true
+			return
+		}
+		if len(cr.partial) > 0 {
+			b = append(cr.partial, b...)
+			cr.partial = nil
+		}
+		start := cr.pos
+		cr.pos += int64(len(b))
+		if ln, ok = cr.handleLine(bytes.TrimRight(b, "\r\n"), start); ok {
+			return
+		}
+		cr.updateIndex()
+	}
+}
+
+// ReadRemaining is used when the file has gone idle or is going away, messages that are still
+// being reassembled are handed out as they are
+func (cr *ContainerReader) ReadRemaining() (ln []byte, err error) {
+	var ok bool
+	if ln, ok, _, err = cr.ReadEntry(); err != nil || ok {
+		return
+	}
+	if cr.pending != nil {
+		ln = cr.flush()
+	}
+	return
+}
+
+// handleLine processes a single wrapper line, ok is false if the line was part of a larger message
+func (cr *ContainerReader) handleLine(b []byte, start int64) (ln []byte, ok bool) {
+	if len(bytes.TrimSpace(b)) == 0 {
+		return
+	}
+	msg, info, complete, valid := parseContainerLine(b)
+	p := cr.pending
+	if p != nil && (!valid || info.Stream != p.stream) {
+		//another stream interrupted the message, hand out what we have and come back to the line
+		cr.held, cr.heldAt = b, start
+		return cr.flush(), true
+	}
+	if !valid {
+		//not something a runtime wrote, hand it out as is
+		cr.lastInfo = EntryInfo{}
+		cr.updateIndex()
+		return b, true
+	}
+	if p == nil {
+		if complete {
+			cr.lastInfo = info
+			cr.updateIndex()
+			return msg, true
+		}
+		p = &containerPartial{stream: info.Stream, start: start, ts: info.TS}
+		cr.pending = p
+	}
+	p.data = append(p.data, msg...)
+	if complete || (cr.maxLine > 0 && len(p.data) >= cr.maxLine) {
+		return cr.flush(), true
+	}
+	return nil, false
+}
+
+func (cr *ContainerReader) flush() (ln []byte) {
+	p := cr.pending
+	cr.pending = nil
+	cr.lastInfo = EntryInfo{TS: p.ts, Stream: p.stream}
+	cr.updateIndex()
+	return p.data
+}
+
+// updateIndex moves the index to the end of what we have consumed unless a message is still
+// being reassembled or a line is held back, in which case it stays at the start of it
+func (cr *ContainerReader) updateIndex() {
+	cr.idx = cr.pos
+	if cr.held != nil {
+		cr.idx = cr.heldAt
+	}
+	if cr.pending != nil && cr.pending.start < cr.idx {
+		cr.idx = cr.pending.start
+	}
+}
+
+type dockerLine struct {
+	Log    string    `json:"log"`
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+}
+
+// parseContainerLine decodes a Docker json-file or CRI log line.  Complete is false if the runtime
+// split the message and more parts follow, valid is false if the line is in neither format.
+func parseContainerLine(b []byte) (msg []byte, info EntryInfo, complete, valid bool) {
+	if b[0] == '{' {
+		var dl dockerLine
+		if err := json.Unmarshal(b, &dl); err != nil || dl.Stream == `` {
+			return
+		}
+		//docker splits long lines and only the last part carries the newline
+		msg = []byte(dl.Log)
+		if complete = bytes.HasSuffix(msg, []byte{'\n'}); complete {
+			msg = bytes.TrimSuffix(bytes.TrimSuffix(msg, []byte{'\n'}), []byte{'\r'})
+		}
+		info = EntryInfo{TS: dl.Time, Stream: dl.Stream}
+		valid = true
+		return
+	}
+	//<timestamp> <stream> <P|F>[:tags] <message>
+	flds := bytes.SplitN(b, []byte{' '}, 4)
+	if len(flds) < 3 {
+		return
+	}
+	ts, err := time.Parse(time.RFC3339Nano, string(flds[0]))
+	if err != nil {
+		return
+	}
+	flag, _, _ := bytes.Cut(flds[2], []byte{':'})
+	switch string(flag) {
+	case `F`:
+		complete = true
+	case `P`:
+	default:
+		return
+	}
+	if len(flds) == 4 {
+		msg = flds[3]
+	}
+	info = EntryInfo{TS: ts, Stream: string(flds[1])}
+	valid = true
+	return
+}
+
+// containerMeta describes the container that wrote a log file
+type containerMeta struct {
+	id        string
+	name      string
+	image     string
+	namespace string
+	pod       string
+	labels    map[string]string
+	loaded    time.Time
+}
+
+type dockerConfig struct {
+	ID     string
+	Name   string
+	Config struct {
+		Image  string
+		Labels map[string]string
+	}
+}
+
+// loadContainerMeta figures out which container wrote the log file.  Docker keeps the container
+// config next to its logs, CRI runtimes encode the pod and container in the log path.
+func loadContainerMeta(logPath string) (cm containerMeta) {
+	cm.loaded = time.Now()
+	if bts, err := os.ReadFile(filepath.Join(filepath.Dir(logPath), dockerConfigName)); err == nil {
+		var dc dockerConfig
+		if err = json.Unmarshal(bts, &dc); err == nil {
+			cm.id = dc.ID
+			cm.name = strings.TrimPrefix(dc.Name, `/`)
+			cm.image = dc.Config.Image
+			cm.labels = dc.Config.Labels
+			return
+		}
+	}
+	slashed := filepath.ToSlash(logPath)
+	if m := criContainerPath.FindStringSubmatch(slashed); m != nil {
+		cm.pod, cm.namespace, cm.name, cm.id = m[1], m[2], m[3], m[4]
+	} else if m = criPodPath.FindStringSubmatch(slashed); m != nil {
+		cm.namespace, cm.pod, cm.name = m[1], m[2], m[3]
+	}
+	return
+}
+
+// evs renders the metadata as enumerated values, if labels is empty every label is attached
+func (cm containerMeta) evs(labels []string) (evs []entry.EnumeratedValue) {
+	add := func(name, val string) {
+		if val != `` {
+			evs = append(evs, entry.EnumeratedValue{Name: name, Value: entry.StringEnumDataTail(val)})
+		}
+	}
+	add(evContainerID, cm.id)
+	add(evContainerName, cm.name)
+	add(evContainerImage, cm.image)
+	add(evContainerNamespace, cm.namespace)
+	add(evContainerPod, cm.pod)
+	if len(labels) == 0 {
+		labels = make([]string, 0, len(cm.labels))
+		for k := range cm.labels {
+			labels = append(labels, k)
+		}
+		sort.Strings(labels)
+	}
+	for _, k := range labels {
+		add(k, cm.labels[k])
+	}
+	return
+}
+
+// containerMetaCache holds container metadata by log file so that the runtime config is not
+// read for every entry, metadata is reloaded periodically to pick up renamed containers
+type containerMetaCache struct {
+	mtx   sync.Mutex
+	metas map[string]containerMeta
+}
+
+func (cmc *containerMetaCache) get(logPath string) containerMeta {
+	cmc.mtx.Lock()
+	defer cmc.mtx.Unlock()
+	if cm, ok := cmc.metas[logPath]; ok && time.Since(cm.loaded) < containerMetaTTL {
+		return cm
+	}
+	if cmc.metas == nil || len(cmc.metas) >= maxContainerMetas {
+		cmc.metas = map[string]containerMeta{}
+	}
+	cm := loadContainerMeta(logPath)
+	cmc.metas[logPath] = cm
+	return cm
+}
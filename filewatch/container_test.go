@@ -0,0 +1,263 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+)
+
+const testDockerLog = `{"log":"first line\n","stream":"stdout","time":"2024-05-01T00:00:01.000000001Z"}
+{"log":"split ","stream":"stderr","time":"2024-05-01T00:00:02Z"}
+{"log":"second\n","stream":"stdout","time":"2024-05-01T00:00:03Z"}
+{"log":"across ","stream":"stderr","time":"2024-05-01T00:00:04Z"}
+{"log":"three\r\n","stream":"stderr","time":"2024-05-01T00:00:05Z"}
+not a wrapper
+`
+
+const testCRILog = `2024-05-01T00:00:01.000000001Z stdout F first line
+2024-05-01T00:00:02Z stdout P split-
+2024-05-01T00:00:03Z stderr F interleaved
+2024-05-01T00:00:04Z stdout P across-
+2024-05-01T00:00:05Z stdout F three
+2024-05-01T00:00:06Z stdout F
+`
+
+type containerEntry struct {
+	msg    string
+	ts     time.Time
+	stream string
+}
+
+func readContainerEntries(t *testing.T, rdr Reader) (ents []containerEntry, idxs []int64) {
+	ir := rdr.(infoReader)
+	for {
+		ln, ok, _, err := rdr.ReadEntry()
+		if err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			return
+		}
+		info := ir.EntryInfo()
+		ents = append(ents, containerEntry{msg: string(ln), ts: info.TS, stream: info.Stream})
+		idxs = append(idxs, rdr.Index())
+	}
+}
+
+func tsAt(sec, nsec int) time.Time {
+	return time.Date(2024, 5, 1, 0, 0, sec, nsec, time.UTC)
+}
+
+func TestContainerReader(t *testing.T) {
+	dir := t.TempDir()
+	tsts := []struct {
+		name string
+		data string
+		ents []containerEntry
+	}{
+		{`docker-json.log`, testDockerLog, []containerEntry{
+			{`first line`, tsAt(1, 1), `stdout`},
+			{`split `, tsAt(2, 0), `stderr`},
+			{`second`, tsAt(3, 0), `stdout`},
+			{`across three`, tsAt(4, 0), `stderr`},
+			{`not a wrapper`, time.Time{}, ``},
+		}},
+		{`0.log`, testCRILog, []containerEntry{
+			{`first line`, tsAt(1, 1), `stdout`},
+			{`split-`, tsAt(2, 0), `stdout`},
+			{`interleaved`, tsAt(3, 0), `stderr`},
+			{`across-three`, tsAt(4, 0), `stdout`},
+			{``, tsAt(6, 0), `stdout`},
+		}},
+	}
+	for _, tst := range tsts {
+		p := filepath.Join(dir, tst.name)
+		if err := os.WriteFile(p, []byte(tst.data), 0660); err != nil {
+			t.Fatal(err)
+		}
+		ents, idxs := readContainerEntries(t, openTestReader(t, p, ContainerEngine, ``, 0))
+		if len(ents) != len(tst.ents) {
+			t.Fatalf("%s bad entry count %d != %d", tst.name, len(ents), len(tst.ents))
+		}
+		for i := range ents {
+			if ents[i].msg != tst.ents[i].msg || !ents[i].ts.Equal(tst.ents[i].ts) || ents[i].stream != tst.ents[i].stream {
+				t.Fatalf("%s entry %d mismatch %+v != %+v", tst.name, i, ents[i], tst.ents[i])
+			}
+		}
+		if idxs[len(idxs)-1] != int64(len(tst.data)) {
+			t.Fatalf("%s bad final index %d", tst.name, idxs[len(idxs)-1])
+		}
+		//a message interrupted by another stream is handed out with the index on the interrupting line
+		if third := strings.Index(tst.data, "\n") + 1; idxs[1] != int64(third+strings.Index(tst.data[third:], "\n")+1) {
+			t.Fatalf("%s index moved past the interrupting line: %d", tst.name, idxs[1])
+		}
+	}
+}
+
+func TestContainerReaderRestart(t *testing.T) {
+	dir := t.TempDir()
+	for _, tst := range []struct {
+		name string
+		data string
+	}{
+		{`docker-json.log`, testDockerLog},
+		{`0.log`, testCRILog},
+		{`mixed.log`, `2024-05-01T00:00:01Z stdout P a-
+2024-05-01T00:00:02Z stdout P b-
+2024-05-01T00:00:03Z stdout F c
+2024-05-01T00:00:04Z stderr P d-
+2024-05-01T00:00:05Z stdout F e
+2024-05-01T00:00:06Z stderr F f
+2024-05-01T00:00:07Z stderr P g-
+2024-05-01T00:00:08Z stderr F h
+2024-05-01T00:00:09Z stdout F i
+`},
+	} {
+		p := filepath.Join(dir, tst.name)
+		if err := os.WriteFile(p, []byte(tst.data), 0660); err != nil {
+			t.Fatal(err)
+		}
+		all, idxs := readContainerEntries(t, openTestReader(t, p, ContainerEngine, ``, 0))
+		//restarting from the index recorded with any entry must neither repeat nor drop anything
+		for i, idx := range idxs {
+			rest, _ := readContainerEntries(t, openTestReader(t, p, ContainerEngine, ``, idx))
+			got := append(append([]containerEntry{}, all[:i+1]...), rest...)
+			if len(got) != len(all) {
+				t.Fatalf("%s restart at %d: bad entry count %d != %d", tst.name, idx, len(got), len(all))
+			}
+			for j := range got {
+				if got[j].msg != all[j].msg || got[j].stream != all[j].stream {
+					t.Fatalf("%s restart at %d: entry %d mismatch %+v != %+v", tst.name, idx, j, got[j], all[j])
+				}
+			}
+		}
+	}
+}
+
+func TestContainerReaderPartialWrite(t *testing.T) {
+	p := filepath.Join(t.TempDir(), `0.log`)
+	fout, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fout.Close()
+	rdr := openTestReader(t, p, ContainerEngine, ``, 0)
+	var got []string
+	for _, chunk := range []string{"2024-05-01T00:00:01Z stdout P hel", "lo \n", "2024-05-01T00:00:02Z stdout F world\n"} {
+		if _, err = fout.WriteString(chunk); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, readAllEntries(t, rdr)...)
+	}
+	checkLines(t, got, []string{`hello world`})
+
+	//a message that never gets its final part is handed out when forced
+	if _, err = fout.WriteString("2024-05-01T00:00:03Z stdout P dangling\n"); err != nil {
+		t.Fatal(err)
+	} else if ln, err := rdr.ReadRemaining(); err != nil || string(ln) != `dangling` {
+		t.Fatalf("bad remaining %q %v", ln, err)
+	}
+}
+
+func TestContainerMeta(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), `containers`, `abc123`)
+	if err := os.MkdirAll(dir, 0770); err != nil {
+		t.Fatal(err)
+	}
+	cfg := `{"ID":"abc123","Name":"/web","Config":{"Image":"nginx:latest","Labels":{"team":"ops","com.gravwell.tag":"nginx"}}}`
+	if err := os.WriteFile(filepath.Join(dir, dockerConfigName), []byte(cfg), 0660); err != nil {
+		t.Fatal(err)
+	}
+	cm := loadContainerMeta(filepath.Join(dir, `abc123-json.log`))
+	if cm.id != `abc123` || cm.name != `web` || cm.image != `nginx:latest` || cm.labels[`team`] != `ops` {
+		t.Fatalf("bad docker metadata %+v", cm)
+	}
+	evs := cm.evs([]string{`team`})
+	if len(evs) != 4 || evs[3].Name != `team` {
+		t.Fatalf("bad evs %+v", evs)
+	}
+	if evs = cm.evs(nil); len(evs) != 5 {
+		t.Fatalf("bad evs with all labels %+v", evs)
+	}
+
+	id := strings.Repeat(`0f`, 32)
+	cm = loadContainerMeta(`/var/log/containers/web-5d8f_prod_nginx-` + id + `.log`)
+	if cm.pod != `web-5d8f` || cm.namespace != `prod` || cm.name != `nginx` || cm.id != id {
+		t.Fatalf("bad CRI container path metadata %+v", cm)
+	}
+	cm = loadContainerMeta(`/var/log/pods/prod_web-5d8f_1234-abcd/nginx/0.log`)
+	if cm.pod != `web-5d8f` || cm.namespace != `prod` || cm.name != `nginx` {
+		t.Fatalf("bad CRI pod path metadata %+v", cm)
+	}
+}
+
+type testEntryWriter struct {
+	ents []*entry.Entry
+}
+
+func (w *testEntryWriter) ProcessContext(ent *entry.Entry, ctx context.Context) error {
+	w.ents = append(w.ents, ent)
+	return nil
+}
+
+func TestContainerHandler(t *testing.T) {
+	dir := t.TempDir()
+	cfg := `{"ID":"abc123","Name":"/web","Config":{"Image":"nginx:latest","Labels":{"com.gravwell.tag":"nginx"}}}`
+	if err := os.WriteFile(filepath.Join(dir, dockerConfigName), []byte(cfg), 0660); err != nil {
+		t.Fatal(err)
+	}
+	var w testEntryWriter
+	var resolved int
+	lh, err := NewLogHandler(LogHandlerConfig{
+		Tag:           1,
+		Logger:        log.NewDiscardLogger(),
+		Ctx:           context.Background(),
+		ContainerMeta: true,
+		TagLabel:      `com.gravwell.tag`,
+		TagResolver: func(name string) (entry.EntryTag, error) {
+			resolved++
+			if name != `nginx` {
+				return 0, errors.New("unknown tag")
+			}
+			return 7, nil
+		},
+	}, &w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := tsAt(1, 0)
+	fname := filepath.Join(dir, `abc123-json.log`)
+	for i := 0; i < 2; i++ {
+		if err = lh.HandleEntry([]byte(`2020-01-01T00:00:00Z message`), EntryInfo{TS: ts, Stream: `stdout`}, fname); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(w.ents) != 2 || resolved != 1 {
+		t.Fatalf("bad entries %d or resolutions %d", len(w.ents), resolved)
+	}
+	ent := w.ents[0]
+	if ent.Tag != 7 {
+		t.Fatalf("entry was not routed by label: %v", ent.Tag)
+	} else if !ent.TS.StandardTime().Equal(ts) {
+		t.Fatalf("wrapper timestamp was not used: %v", ent.TS)
+	}
+	for _, name := range []string{evContainerStream, evContainerName, evContainerImage} {
+		if _, ok := ent.GetEnumeratedValue(name); !ok {
+			t.Fatalf("missing %s EV", name)
+		}
+	}
+}
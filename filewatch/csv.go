@@ -0,0 +1,157 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"unicode/utf8"
+
+	"github.com/gravwell/gravwell/v3/ingesters/utils"
+)
+
+// CSVReader emits each row of a CSV file as a JSON object keyed by the header row.
+// The header is the first record in the file, if the reader starts part way through a file
+// the header is re-read from the start of the file so that rows keep their names across restarts.
+type CSVReader struct {
+	baseReader
+	brdr    *bufio.Reader
+	delim   rune
+	header  []string
+	rec     []byte //lines of a record that is still open (quoted newlines)
+	partial []byte //line data without a trailing newline
+}
+
+func NewCSVReader(cfg ReaderConfig) (*CSVReader, error) {
+	delim := ','
+	if cfg.EngineArgs != `` {
+		r, sz := utf8.DecodeRuneInString(cfg.EngineArgs)
+		if sz != len(cfg.EngineArgs) || r == utf8.RuneError || r == '"' || r == '\r' || r == '\n' {
+			return nil, errors.New("invalid CSV delimiter")
+		}
+		delim = r
+	}
+	br, err := newBaseReader(cfg.Fin, cfg.MaxLineLen, cfg.StartIndex)
+	if err != nil {
+		return nil, err
+	}
+	cr := &CSVReader{
+		baseReader: br,
+		brdr:       bufio.NewReader(br.src),
+		delim:      delim,
+	}
+	if err = cr.loadHeader(cfg.StartIndex); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// loadHeader recovers the header when starting part way through the file
+func (cr *CSVReader) loadHeader(offset int64) error {
+	var rec []byte
+	return cr.scanPreamble(offset, func(ln []byte) bool {
+		if rec = append(rec, ln...); !cr.recordComplete(rec) {
+			return true
+		} else if len(bytes.TrimSpace(bytes.TrimPrefix(rec, utils.UTF8BOM))) == 0 {
+			rec = nil
+			return true
+		}
+		cr.header = cr.parse(bytes.TrimPrefix(rec, utils.UTF8BOM))
+		return false
+	})
+}
+
+func (cr *CSVReader) SeekFile(offset int64) error {
+	cr.header = nil
+	cr.rec = nil
+	cr.partial = nil
+	if err := cr.baseReader.SeekFile(offset); err != nil {
+		return err
+	}
+	cr.brdr.Reset(cr.src)
+	return cr.loadHeader(offset)
+}
+
+func (cr *CSVReader) ReadEntry() (ln []byte, ok bool, wasEOF bool, err error) {
+	for {
+		b, lerr := cr.brdr.ReadBytes('\n')
+		if lerr != nil && lerr != io.EOF {
+			err = lerr
+			return
+		} else if lerr == io.EOF {
+			//hold partial lines until the rest shows up
+			cr.partial = append(cr.partial, b...)
+			wasEOF = true
+			return
+		}
+		if len(cr.partial) > 0 {
+			b = append(cr.partial, b...)
+			cr.partial = nil
+		}
+		if cr.rec = append(cr.rec, b...); !cr.recordComplete(cr.rec) {
+			continue
+		}
+		if ln, ok = cr.handleRecord(); ok {
+			return
+		}
+	}
+}
+
+func (cr *CSVReader) ReadRemaining() (ln []byte, err error) {
+	var ok bool
+	if ln, ok, _, err = cr.ReadEntry(); err != nil || ok {
+		return
+	} else if len(cr.partial) > 0 {
+		cr.rec = append(cr.rec, cr.partial...)
+		cr.partial = nil
+		ln, _ = cr.handleRecord()
+	}
+	return
+}
+
+// handleRecord consumes the current record, ok is false if the record was a header or blank
+func (cr *CSVReader) handleRecord() (ln []byte, ok bool) {
+	rec := cr.rec
+	cr.rec = nil
+	cr.idx += int64(len(rec))
+	if cr.header == nil {
+		rec = bytes.TrimPrefix(rec, utils.UTF8BOM)
+	}
+	if len(bytes.TrimSpace(rec)) == 0 {
+		return
+	}
+	vals := cr.parse(rec)
+	if cr.header == nil {
+		cr.header = vals
+		return
+	}
+	return utils.FieldsObject(cr.header, vals), true
+}
+
+// recordComplete checks that a record isn't inside a quoted field, records that grow past the
+// maximum line length are forced out so that a stray quote can't swallow the rest of the file
+func (cr *CSVReader) recordComplete(rec []byte) bool {
+	return bytes.Count(rec, []byte{'"'})%2 == 0 || (cr.maxLine > 0 && len(rec) > cr.maxLine)
+}
+
+func (cr *CSVReader) parse(rec []byte) []string {
+	rdr := csv.NewReader(bytes.NewReader(rec))
+	rdr.Comma = cr.delim
+	rdr.LazyQuotes = true
+	rdr.FieldsPerRecord = -1
+	vals, err := rdr.Read()
+	if err != nil {
+		//not something the CSV reader could make sense of, hand back the raw record
+		vals = []string{string(bytes.TrimRight(rec, "\r\n"))}
+	}
+	return vals
+}
@@ -0,0 +1,106 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testCSV = "\xef\xbb\xbfname,value,note\r\n" +
+	"alpha,1,plain\r\n" +
+	"\r\n" +
+	"beta,2,\"quoted, with a comma\"\r\n" +
+	"gamma,3,\"spans\nlines\"\r\n" +
+	"delta,4,extra,fields\r\n"
+
+var testCSVObjects = []string{
+	`{"name":"alpha","value":"1","note":"plain"}`,
+	`{"name":"beta","value":"2","note":"quoted, with a comma"}`,
+	`{"name":"gamma","value":"3","note":"spans\nlines"}`,
+	`{"name":"delta","value":"4","note":"extra","field4":"fields"}`,
+}
+
+func openTestReader(t *testing.T, p string, engine int, args string, start int64) Reader {
+	fin, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdr, err := NewReader(ReaderConfig{Fin: fin, MaxLineLen: defaultMaxLine, StartIndex: start, Engine: engine, EngineArgs: args})
+	if err != nil {
+		fin.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rdr.Close() })
+	return rdr
+}
+
+// readWithIndexes reads every entry and records the index after each one
+func readWithIndexes(t *testing.T, rdr Reader) (lines []string, idxs []int64) {
+	for {
+		ln, ok, _, err := rdr.ReadEntry()
+		if err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			return
+		}
+		lines = append(lines, string(ln))
+		idxs = append(idxs, rdr.Index())
+	}
+}
+
+func TestCSVReader(t *testing.T) {
+	p := filepath.Join(t.TempDir(), `test.csv`)
+	if err := os.WriteFile(p, []byte(testCSV), 0660); err != nil {
+		t.Fatal(err)
+	}
+	lines, idxs := readWithIndexes(t, openTestReader(t, p, CSVEngine, ``, 0))
+	checkLines(t, lines, testCSVObjects)
+	if idxs[len(idxs)-1] != int64(len(testCSV)) {
+		t.Fatalf("bad final index %d != %d", idxs[len(idxs)-1], len(testCSV))
+	}
+	//restart after each entry, the header must be recovered and nothing repeated or lost
+	for i, idx := range idxs {
+		got := readAllEntries(t, openTestReader(t, p, CSVEngine, ``, idx))
+		checkLines(t, got, testCSVObjects[i+1:])
+	}
+}
+
+func TestCSVReaderPartialWrite(t *testing.T) {
+	p := filepath.Join(t.TempDir(), `test.tsv`)
+	fout, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fout.Close()
+	rdr := openTestReader(t, p, CSVEngine, "\t", 0)
+	var got []string
+	for _, chunk := range []string{"a\tb", "\n1\t\"two", "\n lines\"\n3", "\t4\n"} {
+		if _, err = fout.WriteString(chunk); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, readAllEntries(t, rdr)...)
+	}
+	checkLines(t, got, []string{`{"a":"1","b":"two\n lines"}`, `{"a":"3","b":"4"}`})
+
+	//truncation starts over with a new header
+	if err = fout.Truncate(0); err != nil {
+		t.Fatal(err)
+	} else if _, err = fout.WriteAt([]byte("x\ty\n5\t6\n"), 0); err != nil {
+		t.Fatal(err)
+	} else if err = rdr.SeekFile(0); err != nil {
+		t.Fatal(err)
+	}
+	checkLines(t, readAllEntries(t, rdr), []string{`{"x":"5","y":"6"}`})
+
+	if _, err = NewCSVReader(ReaderConfig{Fin: fout, MaxLineLen: defaultMaxLine, EngineArgs: `ab`}); err == nil {
+		t.Fatal("failed to catch bad delimiter")
+	}
+}
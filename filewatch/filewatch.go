@@ -98,6 +98,11 @@ func (wm *WatchManager) SetMaxFilesWatched(max int) {
 	wm.fman.SetMaxFilesWatched(max)
 }
 
+// SetFileIdentity sets how followed files are identified, it must be called before Catchup or Start
+func (wm *WatchManager) SetFileIdentity(fi FileIdentity) {
+	wm.fman.SetFileIdentity(fi)
+}
+
 func (wm *WatchManager) Context() context.Context {
 	return wm.ctx
 }
@@ -9,7 +9,6 @@
 package filewatch
 
 import (
-	"encoding/gob"
 	"errors"
 	"fmt"
 	"os"
@@ -65,6 +64,9 @@ type FilterManager struct {
 	filters         []filter
 	followers       map[FileName]*follower
 	states          map[FileName]*int64
+	fpt             *fingerprintTracker
+	identity        FileIdentity
+	identities      map[FileName]pathIdentity
 	stateFile       string
 	stateFout       *os.File
 	maxFilesWatched int
@@ -72,22 +74,24 @@ type FilterManager struct {
 }
 
 func NewFilterManager(stateFile string) (*FilterManager, error) {
-	fout, states, err := initStateFile(stateFile)
+	fout, sc, err := initStateFile(stateFile)
 	if err != nil {
 		return nil, err
 	}
-	if err := cleanStates(states); err != nil {
+	if err := cleanStates(sc.states); err != nil {
 		fout.Close()
 		return nil, err
 	}
 
 	return &FilterManager{
-		mtx:       &sync.Mutex{},
-		stateFile: stateFile,
-		stateFout: fout,
-		states:    states,
-		followers: map[FileName]*follower{},
-		logger:    ingest.NoLogger(),
+		mtx:        &sync.Mutex{},
+		stateFile:  stateFile,
+		stateFout:  fout,
+		states:     sc.states,
+		fpt:        newFingerprintTracker(sc.fps),
+		identities: sc.identities,
+		followers:  map[FileName]*follower{},
+		logger:     ingest.NoLogger(),
 	}, nil
 }
 
@@ -114,6 +118,13 @@ func (fm *FilterManager) SetMaxFilesWatched(max int) {
 	fm.maxFilesWatched = max
 }
 
+// SetFileIdentity sets how followed files are identified, it must be called before any files are loaded
+func (fm *FilterManager) SetFileIdentity(fi FileIdentity) {
+	fm.mtx.Lock()
+	defer fm.mtx.Unlock()
+	fm.identity = fi
+}
+
 func (fm *FilterManager) SetLogger(lgr ingest.IngestLogger) {
 	fm.mtx.Lock()
 	defer fm.mtx.Unlock()
@@ -223,10 +234,73 @@ func (fm *FilterManager) nolockDumpStates() error {
 	if err := fm.stateFout.Truncate(0); err != nil {
 		return err
 	}
-	if err := gob.NewEncoder(fm.stateFout).Encode(fm.states); err != nil {
-		return err
+	if fm.identity == IdentityFingerprint {
+		for k, flw := range fm.followers {
+			fm.recordIdentity(k, flw)
+		}
+	} else {
+		//identities are only meaningful if they are kept up to date
+		fm.identities = map[FileName]pathIdentity{}
 	}
-	return nil
+	for k := range fm.identities {
+		if _, ok := fm.states[k]; !ok {
+			delete(fm.identities, k)
+		}
+	}
+	sc := stateContents{
+		states:     fm.states,
+		fps:        fm.fpt.export(),
+		identities: fm.identities,
+	}
+	return sc.encode(fm.stateFout)
+}
+
+// recordIdentity captures what the path holds so that we can tell if it changes while we are not looking
+// caller MUST HOLD THE LOCK
+func (fm *FilterManager) recordIdentity(k FileName, flw *follower) {
+	fi, err := os.Stat(k.FilePath)
+	if err != nil {
+		return
+	}
+	pid := pathIdentity{
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+	}
+	if fk, ok := fm.fpt.owned(flw); ok {
+		pid.Fingerprint, pid.HasFingerprint = fk.Fingerprint, true
+	}
+	fm.identities[k] = pid
+}
+
+// setIdentity hands the file identity settings and anything we know about the path to a new follower
+// caller MUST HOLD THE LOCK
+func (fm *FilterManager) setIdentity(fcfg *FollowerConfig) {
+	if fm.identity != IdentityFingerprint {
+		fcfg.fpPlain = fm.followsCompressed(fcfg)
+		return
+	}
+	fcfg.fpIdentity = true
+	if pid, ok := fm.identities[FileName{BaseName: fcfg.BaseName, FilePath: fcfg.FilePath}]; ok {
+		fcfg.pathID = &pid
+	}
+}
+
+// followsCompressed reports if the filter would also pick up the file once it is rotated and compressed,
+// only then is it worth tracking the fingerprint of the plain file.  We try the names logrotate and
+// friends produce, the file name or its first rotation with a compression extension tacked on.
+// caller MUST HOLD THE LOCK
+func (fm *FilterManager) followsCompressed(fcfg *FollowerConfig) bool {
+	if fcfg.FilterID < 0 || fcfg.FilterID >= len(fm.filters) {
+		return false
+	}
+	mtchs := fm.filters[fcfg.FilterID].mtchs
+	fname := filepath.Base(fcfg.FilePath)
+	for _, ext := range compressedExtensions {
+		if fm.matchFile(mtchs, fname+ext) || fm.matchFile(mtchs, fname+`.1`+ext) {
+			return true
+		}
+	}
+	return false
 }
 
 func (f *FilterManager) AddFilter(bname, loc string, mtchs []string, lh handler, ecfg FollowerEngineConfig) error {
@@ -400,6 +474,7 @@ func (f *FilterManager) RenameFollower(fpath string) error {
 					FilterID:             i,
 					Handler:              v.lh,
 					FollowerEngineConfig: v.FollowerEngineConfig,
+					fpt:                  f.fpt,
 				}
 				if err := f.addFollower(fcfg); err != nil {
 					return err
@@ -463,6 +538,7 @@ func (f *FilterManager) addFollower(fcfg FollowerConfig) error {
 			return nil
 		}
 	}
+	f.setIdentity(&fcfg)
 	fl, err := NewFollower(fcfg)
 	if err != nil {
 		return err
@@ -564,6 +640,7 @@ FILTER_LOOP:
 			State:                si,
 			FilterID:             i,
 			Handler:              v.lh,
+			fpt:                  f.fpt,
 		}
 		if err := f.addFollower(fcfg); err != nil {
 			return false, err
@@ -719,6 +796,7 @@ func (f *FilterManager) CatchupFile(wf watchedFile, qc chan os.Signal) (bool, er
 			State:                si,
 			FilterID:             i,
 			Handler:              v.lh,
+			fpt:                  f.fpt,
 		}
 		if quit, err := f.catchupFollower(fcfg, qc); err != nil || quit {
 			return quit, err
@@ -731,13 +809,19 @@ func (f *FilterManager) CatchupFile(wf watchedFile, qc chan os.Signal) (bool, er
 // catchupFollower is a linear operation to get outstanding files up to date.
 func (f *FilterManager) catchupFollower(fcfg FollowerConfig, qc chan os.Signal) (bool, error) {
 	f.logger.Info("performing initial catch-up preprocessing for file", log.KV("file", fcfg.FilePath))
+	f.setIdentity(&fcfg)
 	if fl, err := NewFollower(fcfg); err != nil {
 		return false, err
 	} else if quit, err := fl.Sync(qc); err != nil || quit {
 		fl.Close()
 		return quit, err
-	} else if err = fl.Close(); err != nil {
-		return false, err
+	} else {
+		if fcfg.fpIdentity {
+			f.recordIdentity(fl.FileName, fl)
+		}
+		if err = fl.Close(); err != nil {
+			return false, err
+		}
 	}
 	f.logger.Info("file preprocessed at startup", log.KV("path", fcfg.FilePath))
 	return false, nil
@@ -791,15 +875,15 @@ func ReadStateFile(p string) (states map[string]int64, err error) {
 		fin.Close()
 		return
 	} else if fi.Size() > 0 {
-		temp := map[FileName]*int64{}
-		if err = gob.NewDecoder(fin).Decode(&temp); err != nil {
+		var sc stateContents
+		if sc, err = decodeStates(fin); err != nil {
 			err = fmt.Errorf("Failed to load existing states: %v", err)
 			fin.Close()
 			return
 		}
-		if len(temp) > 0 {
-			states = make(map[string]int64, len(temp))
-			for k, v := range temp {
+		if len(sc.states) > 0 {
+			states = make(map[string]int64, len(sc.states))
+			for k, v := range sc.states {
 				var offset int64
 				if v != nil {
 					offset = *v
@@ -812,9 +896,9 @@ func ReadStateFile(p string) (states map[string]int64, err error) {
 	return
 }
 
-func initStateFile(p string) (fout *os.File, states map[FileName]*int64, err error) {
+func initStateFile(p string) (fout *os.File, sc stateContents, err error) {
 	var fi os.FileInfo
-	states = map[FileName]*int64{}
+	sc = newStateContents()
 	//attempt to open state file
 	fi, err = os.Stat(p)
 	if err != nil {
@@ -848,7 +932,7 @@ func initStateFile(p string) (fout *os.File, states map[FileName]*int64, err err
 		return
 	}
 	if fi.Size() > 0 {
-		if err = gob.NewDecoder(fout).Decode(&states); err != nil {
+		if sc, err = decodeStates(fout); err != nil {
 			// hold onto the decode error in case we can't get to a backup
 			serr := err
 			fout.Close()
@@ -868,7 +952,7 @@ func initStateFile(p string) (fout *os.File, states map[FileName]*int64, err err
 
 				if count == RENAME_COUNT_MAX {
 					// if we got here then we ran out of attempts
-					return nil, nil, fmt.Errorf("Failed to rename old state file")
+					return nil, sc, fmt.Errorf("Failed to rename old state file")
 				}
 			}
 
@@ -887,6 +971,10 @@ func initStateFile(p string) (fout *os.File, states map[FileName]*int64, err err
 func cleanStates(states map[FileName]*int64) error {
 	for k, v := range states {
 		fi, err := os.Stat(k.FilePath)
+		if err == nil && isCompressedFile(k.FilePath) {
+			//states on compressed files are decompressed offsets, the file size means nothing
+			continue
+		}
 		if err != nil {
 			if os.IsNotExist(err) {
 				//file is gone, delete it
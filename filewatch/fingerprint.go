@@ -0,0 +1,297 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// fingerprintSize is the number of leading content bytes hashed to identify a file, files
+	// shorter than this are never fingerprinted and are tracked only by their path
+	fingerprintSize = 1024
+
+	// maxFingerprints bounds the number of fingerprints retained in the state file
+	maxFingerprints = 16384
+)
+
+// FileIdentity controls how followed files are identified across renames, rotations, and restarts
+type FileIdentity int
+
+const (
+	// IdentityInode identifies files by path and device/inode, this is the default
+	IdentityInode FileIdentity = iota
+	// IdentityFingerprint identifies files by a fingerprint of their leading content, backed up by
+	// size and modification time for files too small to fingerprint.  This survives copy-truncate
+	// rotation, filesystems that recycle inodes, and files restored from backup.
+	IdentityFingerprint
+)
+
+// ParseFileIdentity parses a file identity mode, an empty string is the default inode mode
+func ParseFileIdentity(v string) (FileIdentity, error) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case ``, `inode`:
+		return IdentityInode, nil
+	case `fingerprint`:
+		return IdentityFingerprint, nil
+	}
+	return IdentityInode, fmt.Errorf("unknown file identity %q", v)
+}
+
+func (fi FileIdentity) String() string {
+	switch fi {
+	case IdentityInode:
+		return `inode`
+	case IdentityFingerprint:
+		return `fingerprint`
+	}
+	return `unknown`
+}
+
+// Fingerprint identifies file content independent of the file name and inode, compressed
+// files are fingerprinted on their decompressed content so that app.log.1 and app.log.1.gz match
+type Fingerprint [sha256.Size]byte
+
+func (fp Fingerprint) String() string {
+	return hex.EncodeToString(fp[:])
+}
+
+// ParseFingerprint decodes the hex representation of a fingerprint
+func ParseFingerprint(v string) (fp Fingerprint, err error) {
+	var b []byte
+	if b, err = hex.DecodeString(v); err != nil {
+		return
+	} else if len(b) != len(fp) {
+		err = fmt.Errorf("invalid fingerprint length %d", len(b))
+		return
+	}
+	copy(fp[:], b)
+	return
+}
+
+// fingerprintContent hashes the first fingerprintSize bytes from the reader, ok is false
+// if the reader ended cleanly before that many bytes were available
+func fingerprintContent(r io.Reader) (fp Fingerprint, ok bool, err error) {
+	return hashContent(r, fingerprintSize)
+}
+
+// hashContent hashes the next sz bytes from the reader, ok is false if the reader ended cleanly first
+func hashContent(r io.Reader, sz int) (fp Fingerprint, ok bool, err error) {
+	buff := make([]byte, sz)
+	var n, total int
+	for total < len(buff) && err == nil {
+		n, err = r.Read(buff[total:])
+		total += n
+	}
+	if total == len(buff) {
+		fp = sha256.Sum256(buff)
+		ok = true
+		err = nil
+	} else if err == io.EOF {
+		err = nil
+	}
+	return
+}
+
+type fingerprintKey struct {
+	BaseName    string
+	Fingerprint Fingerprint
+}
+
+// contentCheck is a hash of the bytes leading up to Offset.  A fingerprint only covers the leading
+// bytes of a file, so files that share a header would collide without it.  A state recorded against a
+// fingerprint is only resumed if the new content agrees with the check.
+type contentCheck struct {
+	Offset int64
+	Sum    Fingerprint
+}
+
+// fingerprintState is what gets written to the state file for each fingerprint
+type fingerprintState struct {
+	State   int64
+	Updated time.Time
+	Check   contentCheck
+}
+
+// pathIdentity records what a path held when its state was last written so that we can tell
+// if the path now holds different content.  HasFingerprint is false for files that were too
+// small to fingerprint, in which case only the size and modification time are available.
+type pathIdentity struct {
+	Fingerprint    Fingerprint
+	HasFingerprint bool
+	Size           int64
+	ModTime        time.Time
+}
+
+// replaced uses size and modification time to decide if the file is no longer the content the
+// identity was recorded against, it is only consulted when fingerprints can't settle it
+func (pi pathIdentity) replaced(sz int64, mt time.Time) bool {
+	if sz < pi.Size {
+		return true //shrank, the content was truncated or replaced
+	}
+	//older than what we saw yet a different size means something else was put in place,
+	//an older file with the same size is treated as the same content restored from a backup
+	return mt.Before(pi.ModTime) && sz != pi.Size
+}
+
+type fingerprintEntry struct {
+	state   *int64
+	owner   *follower //the follower currently reading this content, nil if nobody is
+	updated time.Time
+	check   contentCheck
+}
+
+// fingerprintTracker maps content fingerprints to states so that content which moves to a new
+// file, such as a rotated file that is then compressed, resumes where we left off.  It has its
+// own lock because followers consult it from their routines while the FilterManager lock may
+// be held by something waiting on those same routines.
+type fingerprintTracker struct {
+	mtx     sync.Mutex
+	entries map[fingerprintKey]*fingerprintEntry
+	owners  map[*follower]fingerprintKey
+}
+
+func newFingerprintTracker(states map[fingerprintKey]fingerprintState) *fingerprintTracker {
+	fpt := &fingerprintTracker{
+		entries: make(map[fingerprintKey]*fingerprintEntry, len(states)),
+		owners:  map[*follower]fingerprintKey{},
+	}
+	for k, v := range states {
+		st := v.State
+		fpt.entries[k] = &fingerprintEntry{
+			state:   &st,
+			updated: v.Updated,
+			check:   v.Check,
+		}
+	}
+	return fpt
+}
+
+// peek returns the last known state of the fingerprint and its check without claiming it
+func (fpt *fingerprintTracker) peek(k fingerprintKey, owner *follower) (last int64, chk contentCheck, found, held bool) {
+	fpt.mtx.Lock()
+	defer fpt.mtx.Unlock()
+	if e, ok := fpt.entries[k]; ok {
+		if e.owner != nil && e.owner != owner {
+			held = true
+		} else if e.state != nil {
+			last, chk, found = *e.state, e.check, true
+		}
+	}
+	return
+}
+
+// claim attaches the follower and its state to the fingerprint and returns the last known state.
+// If another live follower already holds the fingerprint held is true and nothing is changed.
+func (fpt *fingerprintTracker) claim(k fingerprintKey, owner *follower, state *int64) (last int64, found, held bool) {
+	fpt.mtx.Lock()
+	defer fpt.mtx.Unlock()
+	e, ok := fpt.entries[k]
+	if ok {
+		if e.owner != nil && e.owner != owner {
+			held = true
+			return
+		}
+		if e.state != nil {
+			last, found = *e.state, true
+		}
+	} else {
+		e = &fingerprintEntry{}
+		fpt.entries[k] = e
+	}
+	e.state = state
+	e.owner = owner
+	e.updated = time.Now()
+	if owner != nil {
+		fpt.owners[owner] = k
+	}
+	return
+}
+
+// setCheck records the content check for a fingerprint held by the follower
+func (fpt *fingerprintTracker) setCheck(k fingerprintKey, owner *follower, chk contentCheck) {
+	fpt.mtx.Lock()
+	defer fpt.mtx.Unlock()
+	if e, ok := fpt.entries[k]; ok && e.owner == owner {
+		e.check = chk
+	}
+}
+
+// owned returns the fingerprint currently claimed by the follower
+func (fpt *fingerprintTracker) owned(owner *follower) (k fingerprintKey, ok bool) {
+	fpt.mtx.Lock()
+	defer fpt.mtx.Unlock()
+	k, ok = fpt.owners[owner]
+	return
+}
+
+// release detaches the follower from the fingerprint, the current state is retained
+func (fpt *fingerprintTracker) release(k fingerprintKey, owner *follower) {
+	fpt.mtx.Lock()
+	defer fpt.mtx.Unlock()
+	if e, ok := fpt.entries[k]; ok && e.owner == owner {
+		var st int64
+		if e.state != nil {
+			st = *e.state
+		}
+		e.state = &st //snapshot so later changes to the follower state do not leak in
+		e.owner = nil
+		e.updated = time.Now()
+	}
+	delete(fpt.owners, owner)
+}
+
+// export returns the states to persist, only the most recently used fingerprints are retained
+func (fpt *fingerprintTracker) export() map[fingerprintKey]fingerprintState {
+	fpt.mtx.Lock()
+	defer fpt.mtx.Unlock()
+	if len(fpt.entries) > maxFingerprints {
+		fpt.prune()
+	}
+	r := make(map[fingerprintKey]fingerprintState, len(fpt.entries))
+	for k, e := range fpt.entries {
+		var st int64
+		if e.state != nil {
+			st = *e.state
+		}
+		r[k] = fingerprintState{
+			State:   st,
+			Updated: e.updated,
+			Check:   e.check,
+		}
+	}
+	return r
+}
+
+// prune drops the least recently used fingerprints that are not attached to a follower
+// the caller MUST hold the lock
+func (fpt *fingerprintTracker) prune() {
+	keys := make([]fingerprintKey, 0, len(fpt.entries))
+	for k, e := range fpt.entries {
+		if e.owner == nil {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return fpt.entries[keys[i]].updated.Before(fpt.entries[keys[j]].updated)
+	})
+	for _, k := range keys {
+		if len(fpt.entries) <= maxFingerprints {
+			break
+		}
+		delete(fpt.entries, k)
+	}
+}
@@ -36,6 +36,11 @@ const (
 var (
 	ErrNotRunning = errors.New("Not running")
 	tickInterval  = 5 * time.Second
+
+	// fingerprintHoldTimeout is how long a follower waits on content that another file is
+	// being read from, distinct files that happen to share their leading content (e.g. a common
+	// header) would otherwise wait on each other forever
+	fingerprintHoldTimeout = time.Minute
 )
 
 type handler interface {
@@ -60,22 +65,41 @@ type FollowerConfig struct {
 	State    *int64
 	FilterID int
 	Handler  handler
+
+	fpt        *fingerprintTracker
+	fpIdentity bool          //identify content by fingerprint rather than trusting the path state
+	fpPlain    bool          //plain content may show up again compressed, register its fingerprint
+	pathID     *pathIdentity //what the path held when the state was recorded, nil if unknown
 }
 
+const (
+	fpPending int = iota //not fingerprinted yet
+	fpClaimed            //attached to a fingerprint in the tracker
+	fpNone               //content can't be or won't be fingerprinted
+)
+
 type follower struct {
 	FileName
-	filterId int
-	id       FileId
-	lnr      Reader
-	state    *int64
-	mtx      *sync.Mutex
-	running  int32
-	err      error
-	abortCh  chan bool
-	fsn      *fsnotify.Watcher
-	wg       *sync.WaitGroup
-	lh       handler
-	lastAct  time.Time
+	filterId   int
+	id         FileId
+	lnr        Reader
+	state      *int64
+	mtx        *sync.Mutex
+	running    int32
+	err        error
+	abortCh    chan bool
+	fsn        *fsnotify.Watcher
+	wg         *sync.WaitGroup
+	lh         handler
+	lastAct    time.Time
+	fpt        *fingerprintTracker
+	fpKey      fingerprintKey
+	fpStatus   int
+	fpHeld     time.Time //when we started waiting on a fingerprint held by another follower
+	fpCheck    int64     //offset of the last content check handed to the tracker
+	fpIdentity bool
+	fpPlain    bool
+	pathID     *pathIdentity
 }
 
 func NewFollower(cfg FollowerConfig) (*follower, error) {
@@ -129,7 +153,11 @@ func NewFollower(cfg FollowerConfig) (*follower, error) {
 			FilePath: cfg.FilePath,
 			BaseName: cfg.BaseName,
 		},
-		lastAct: time.Now(),
+		lastAct:    time.Now(),
+		fpt:        cfg.fpt,
+		fpIdentity: cfg.fpIdentity,
+		fpPlain:    cfg.fpPlain,
+		pathID:     cfg.pathID,
 	}, nil
 }
 
@@ -183,6 +211,10 @@ func (f *follower) Sync(qc chan os.Signal) (bool, error) {
 	if f.abortCh != nil || f.running != 0 {
 		return false, ErrAlreadyStarted
 	}
+	if ready, err := f.checkFingerprint(); err != nil || !ready {
+		return false, err
+	}
+	defer f.updateCheck()
 	for {
 		ln, ok, sawEOF, err := f.lnr.ReadEntry()
 		if err != nil {
@@ -194,7 +226,7 @@ func (f *follower) Sync(qc chan os.Signal) (bool, error) {
 				if ln, err = f.lnr.ReadRemaining(); err != nil {
 					return false, err
 				} else if len(ln) > 0 {
-					if err = f.lh.HandleLog(ln, time.Now(), f.FilePath); err == nil {
+					if err = f.handle(ln, time.Now()); err == nil {
 						*f.state = f.lnr.Index()
 					}
 				}
@@ -203,7 +235,7 @@ func (f *follower) Sync(qc chan os.Signal) (bool, error) {
 		}
 		//actually handle the line
 		now := time.Now()
-		if err := f.lh.HandleLog(ln, now, f.FilePath); err != nil {
+		if err := f.handle(ln, now); err != nil {
 			return false, err
 		}
 		*f.state = f.lnr.Index()
@@ -223,6 +255,19 @@ func (f *follower) Sync(qc chan os.Signal) (bool, error) {
 	return false, nil
 }
 
+// handle hands an entry to the handler along with anything the reader engine knows about it
+func (f *follower) handle(ln []byte, ts time.Time) error {
+	if ir, ok := f.lnr.(infoReader); ok {
+		info := ir.EntryInfo()
+		if eh, ok := f.lh.(entryHandler); ok {
+			return eh.HandleEntry(ln, info, f.FilePath)
+		} else if !info.TS.IsZero() {
+			ts = info.TS
+		}
+	}
+	return f.lh.HandleLog(ln, ts, f.FilePath)
+}
+
 func (f *follower) Start() error {
 	f.mtx.Lock()
 	defer f.mtx.Unlock()
@@ -269,6 +314,7 @@ func (f *follower) Close() error {
 	if f.abortCh != nil && atomic.LoadInt32(&f.running) != 0 {
 		f.stop()
 	}
+	f.releaseFingerprint()
 	if err := f.fsn.Close(); err != nil {
 		f.err = err
 	}
@@ -297,6 +343,10 @@ func (f *follower) IdleDuration() time.Duration {
 // and make sure the file wasn't truncated
 func (f *follower) processLines(writeEvent, removing, allowPartial bool) error {
 	var hit bool
+	if ready, err := f.checkFingerprint(); err != nil || !ready {
+		return err
+	}
+	defer f.updateCheck()
 	for {
 		ln, ok, sawEOF, err := f.lnr.ReadEntry()
 		if err != nil {
@@ -306,7 +356,9 @@ func (f *follower) processLines(writeEvent, removing, allowPartial bool) error {
 			// We got an EOF on the file after a write
 			sz, err := f.lnr.FileSize()
 			if sz < *f.state {
-				// the file must have been truncated
+				// the file must have been truncated, the old fingerprint keeps the state we reached
+				// in case the pre-truncation content shows up somewhere else (copytruncate rotation)
+				f.releaseFingerprint()
 				*f.state = 0
 				if err = f.lnr.SeekFile(0); err != nil {
 					return err
@@ -324,7 +376,7 @@ func (f *follower) processLines(writeEvent, removing, allowPartial bool) error {
 				if ln, err = f.lnr.ReadRemaining(); err != nil {
 					return err
 				} else if len(ln) > 0 {
-					if err = f.lh.HandleLog(ln, time.Now(), f.FilePath); err == nil {
+					if err = f.handle(ln, time.Now()); err == nil {
 						hit = true
 						*f.state = f.lnr.Index()
 					}
@@ -334,7 +386,7 @@ func (f *follower) processLines(writeEvent, removing, allowPartial bool) error {
 			break
 		}
 		//actually handle the line
-		if err := f.lh.HandleLog(ln, time.Now(), f.FilePath); err != nil {
+		if err := f.handle(ln, time.Now()); err != nil {
 			return err
 		}
 		*f.state = f.lnr.Index()
@@ -418,3 +470,187 @@ routineLoop:
 		}
 	}
 }
+
+// checkFingerprint ties the follower to the fingerprint of its content.  Compressed files wait until
+// enough content is available to fingerprint and then resume from any state recorded for that content,
+// which is what keeps a rotated file that is compressed after the fact from being ingested twice.
+// Plain files that may show up again compressed register their fingerprint as soon as they are large
+// enough.  When files are identified by fingerprint every plain file is treated the same way, they resume
+// from the state recorded for their content and wait while another file with the same content is being
+// read (e.g. copytruncate rotation).  A recorded state is only resumed if the content check agrees.
+// A file that waits longer than fingerprintHoldTimeout is read on its own, trusting only its path state.
+// If ready is false the follower should not read anything yet.
+func (f *follower) checkFingerprint() (ready bool, err error) {
+	fpr, ok := f.lnr.(fingerprinter)
+	if !ok || f.fpt == nil || f.fpStatus != fpPending {
+		return true, nil
+	}
+	if ok, err = fpr.detect(); err != nil || !ok {
+		return //we can't tell what this file is yet, it is empty or holds part of a magic number
+	}
+	compressed := fpr.compressed()
+	if !compressed {
+		if !f.fpIdentity && !f.fpPlain {
+			f.fpStatus = fpNone
+			return true, nil
+		} else if sz, err := f.lnr.FileSize(); err != nil {
+			return true, nil
+		} else if sz < fingerprintSize {
+			//not enough content to fingerprint yet
+			if f.fpIdentity {
+				if err = f.checkReplaced(fpr, sz); err != nil {
+					return false, err
+				}
+			}
+			return true, nil
+		}
+	}
+	var fp Fingerprint
+	if fp, ok, err = fpr.fingerprint(); err == errCompressedIncomplete {
+		return false, nil //the compressor is still writing the file
+	} else if err != nil {
+		return false, err
+	} else if !ok {
+		f.fpStatus = fpNone
+		return true, nil
+	}
+	k := fingerprintKey{
+		BaseName:    f.BaseName,
+		Fingerprint: fp,
+	}
+	last, chk, found, held := f.fpt.peek(k, f)
+	if found && !held {
+		if found, err = f.verifyCheck(fpr, last, chk); err == errCompressedIncomplete {
+			return false, nil //the compressor is still writing the file
+		} else if err != nil {
+			return false, err
+		}
+	}
+	if !held {
+		_, _, held = f.fpt.claim(k, f, f.state)
+	}
+	if held {
+		if compressed || f.fpIdentity {
+			if f.fpHeld.IsZero() {
+				f.fpHeld = time.Now()
+			}
+			if time.Since(f.fpHeld) < fingerprintHoldTimeout {
+				return false, nil //the same content is still being read from another file, wait for it to finish
+			}
+		}
+		//the holder isn't letting go, read this file on its own without the content state
+		f.fpHeld = time.Time{}
+		f.fpStatus = fpNone
+		if f.fpIdentity && !compressed && !fpr.consumed() {
+			err = f.resumeIdentity(fp, 0, false, false)
+		}
+		f.pathID = nil
+		return true, err
+	}
+	f.fpHeld = time.Time{}
+	f.fpKey = k
+	f.fpStatus = fpClaimed
+	if !found {
+		f.fpt.setCheck(k, f, contentCheck{}) //any recorded check belongs to other content
+	}
+	if f.fpIdentity {
+		if !fpr.consumed() {
+			err = f.resumeIdentity(fp, last, found, compressed)
+		}
+		f.pathID = nil
+	} else if compressed && found && last != *f.state {
+		err = f.seekState(last)
+	}
+	return true, err
+}
+
+// verifyCheck confirms that the content agrees with the check recorded alongside a fingerprint state.
+// A state of zero needs no confirmation, any other state without a check is not trusted.
+func (f *follower) verifyCheck(fpr fingerprinter, last int64, chk contentCheck) (bool, error) {
+	if last == 0 {
+		return true, nil
+	} else if chk.Offset == 0 || chk.Offset > last {
+		return false, nil
+	}
+	got, ok, err := fpr.check(chk.Offset)
+	if err != nil || !ok {
+		return false, err
+	}
+	return got.Sum == chk.Sum, nil
+}
+
+// updateCheck hands the tracker a check of the content we have read so far.  Compressed content
+// can't be hashed in place cheaply, it keeps the check it was resumed against.
+func (f *follower) updateCheck() {
+	if f.fpStatus != fpClaimed || *f.state == f.fpCheck {
+		return
+	}
+	fpr, ok := f.lnr.(fingerprinter)
+	if !ok || fpr.compressed() {
+		return
+	}
+	if chk, ok, err := fpr.check(*f.state); err == nil && ok {
+		f.fpt.setCheck(f.fpKey, f, chk)
+		f.fpCheck = chk.Offset
+	}
+}
+
+// resumeIdentity positions a follower that has not read anything yet.  A state recorded against the
+// content wins no matter which file it was read from, otherwise the path state is only kept if the
+// path still holds the content it was recorded against.  Without any record (e.g. a state file
+// written before identities were recorded) the path state is trusted.
+func (f *follower) resumeIdentity(fp Fingerprint, last int64, found, compressed bool) error {
+	sz, err := f.lnr.FileSize()
+	if err != nil {
+		return err
+	}
+	st := *f.state
+	if pid := f.pathID; found && (compressed || last <= sz) {
+		st = last
+	} else if pid != nil && pid.HasFingerprint {
+		if pid.Fingerprint != fp {
+			st = 0
+		}
+	} else if pid != nil {
+		if mt, err := f.lnr.LastModTime(); err != nil {
+			return err
+		} else if pid.replaced(sz, mt) {
+			st = 0
+		}
+	}
+	if st == *f.state {
+		return nil
+	}
+	return f.seekState(st)
+}
+
+// checkReplaced handles files that are too small to fingerprint, the size and modification time
+// recorded for the path are all we have to decide if the path holds something new.  It is only
+// consulted before anything has been read.
+func (f *follower) checkReplaced(fpr fingerprinter, sz int64) error {
+	pid := f.pathID
+	f.pathID = nil
+	if pid == nil || *f.state == 0 || fpr.consumed() {
+		return nil
+	}
+	if mt, err := f.lnr.LastModTime(); err != nil {
+		return err
+	} else if pid.replaced(sz, mt) {
+		return f.seekState(0)
+	}
+	return nil
+}
+
+// seekState moves the follower to a new state
+func (f *follower) seekState(st int64) error {
+	*f.state = st
+	return f.lnr.SeekFile(st)
+}
+
+func (f *follower) releaseFingerprint() {
+	if f.fpt != nil && f.fpStatus == fpClaimed {
+		f.fpt.release(f.fpKey, f)
+	}
+	f.fpStatus = fpPending
+	f.fpCheck = 0
+}
@@ -13,6 +13,7 @@ import (
 	"context"
 	"errors"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/crewjam/rfc5424"
@@ -48,6 +49,11 @@ type LogHandler struct {
 	tg *timegrinder.TimeGrinder
 	w  logWriter
 	li *utils.LineIgnorer
+
+	metas   containerMetaCache
+	tagMtx  sync.Mutex
+	tags    map[string]entry.EntryTag //tags resolved from container labels
+	badTags map[string]bool
 }
 
 type LogHandlerConfig struct {
@@ -68,6 +74,12 @@ type LogHandlerConfig struct {
 	TimeFormat              config.CustomTimeFormat
 	AttachFilename          bool
 	Trim                    bool // run trim space on entries
+
+	// Container metadata is only attached to entries from the ContainerEngine
+	ContainerMeta   bool     // attach the container name, image, and labels
+	ContainerLabels []string // labels to attach, all labels are attached if empty
+	TagLabel        string   // send entries to the tag named by this container label
+	TagResolver     func(string) (entry.EntryTag, error)
 }
 
 type logWriter interface {
@@ -130,12 +142,17 @@ func NewLogHandler(cfg LogHandlerConfig, w logWriter) (*LogHandler, error) {
 	if err != nil {
 		return nil, err
 	}
+	if cfg.TagLabel != `` && cfg.TagResolver == nil {
+		return nil, errors.New("TagLabel requires a TagResolver")
+	}
 
 	return &LogHandler{
 		LogHandlerConfig: cfg,
 		w:                w,
 		tg:               tg,
 		li:               li,
+		tags:             map[string]entry.EntryTag{},
+		badTags:          map[string]bool{},
 	}, nil
 }
 
@@ -144,6 +161,16 @@ func (lh *LogHandler) Tag() string {
 }
 
 func (lh *LogHandler) HandleLog(b []byte, catchts time.Time, fname string) error {
+	return lh.handle(b, EntryInfo{}, catchts, fname)
+}
+
+// HandleEntry handles an entry from a reader engine that knows more about the entry than its bytes,
+// a timestamp from the engine is used as is and container metadata is attached if enabled
+func (lh *LogHandler) HandleEntry(b []byte, info EntryInfo, fname string) error {
+	return lh.handle(b, info, time.Now(), fname)
+}
+
+func (lh *LogHandler) handle(b []byte, info EntryInfo, catchts time.Time, fname string) error {
 	if len(b) == 0 {
 		return nil
 	}
@@ -164,7 +191,9 @@ func (lh *LogHandler) HandleLog(b []byte, catchts time.Time, fname string) error
 		return nil
 	}
 
-	if !lh.IgnoreTS {
+	if !lh.IgnoreTS && !info.TS.IsZero() {
+		ts, ok = info.TS, true
+	} else if !lh.IgnoreTS {
 		ts, ok, err = lh.tg.Extract(b)
 		if err != nil {
 			lh.Logger.Error("catastrophic timegrinder failure", log.KVErr(err))
@@ -189,5 +218,43 @@ func (lh *LogHandler) HandleLog(b []byte, catchts time.Time, fname string) error
 			Value: entry.StringEnumDataTail(fname),
 		})
 	}
+	if info.Stream != `` {
+		ent.AddEnumeratedValue(entry.EnumeratedValue{
+			Name:  evContainerStream,
+			Value: entry.StringEnumData(info.Stream),
+		})
+	}
+	if lh.ContainerMeta {
+		cm := lh.metas.get(fname)
+		ent.AddEnumeratedValues(cm.evs(lh.ContainerLabels))
+		if lh.TagLabel != `` {
+			if tag, ok := lh.labelTag(cm.labels[lh.TagLabel]); ok {
+				ent.Tag = tag
+			}
+		}
+	}
 	return lh.w.ProcessContext(ent, lh.LogHandlerConfig.Ctx)
 }
+
+// labelTag resolves the tag named by a container label, tags that can't be resolved are
+// remembered so that we only complain about them once
+func (lh *LogHandler) labelTag(name string) (tag entry.EntryTag, ok bool) {
+	if name == `` {
+		return
+	}
+	lh.tagMtx.Lock()
+	defer lh.tagMtx.Unlock()
+	if tag, ok = lh.tags[name]; ok || lh.badTags[name] {
+		return
+	}
+	var err error
+	if tag, err = lh.TagResolver(name); err != nil {
+		lh.Logger.Warn("failed to resolve tag from container label, using the default tag",
+			log.KV("label", lh.TagLabel), log.KV("tag", name), log.KVErr(err))
+		lh.badTags[name] = true
+		return
+	}
+	lh.tags[name] = tag
+	ok = true
+	return
+}
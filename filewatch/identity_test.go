@@ -0,0 +1,318 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newIdentityFollower(t *testing.T, p string, fpt *fingerprintTracker, lh handler, st int64, pid *pathIdentity) (*follower, *int64) {
+	state := &st
+	fl, err := NewFollower(FollowerConfig{
+		BaseName:   baseName,
+		FilePath:   p,
+		State:      state,
+		Handler:    lh,
+		fpt:        fpt,
+		fpIdentity: true,
+		pathID:     pid,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fl, state
+}
+
+func TestParseFileIdentity(t *testing.T) {
+	for _, v := range []string{``, `inode`, ` Inode `} {
+		if fi, err := ParseFileIdentity(v); err != nil || fi != IdentityInode {
+			t.Fatalf("bad identity for %q: %v %v", v, fi, err)
+		}
+	}
+	if fi, err := ParseFileIdentity(`FINGERPRINT`); err != nil || fi != IdentityFingerprint {
+		t.Fatalf("bad fingerprint identity: %v %v", fi, err)
+	}
+	if _, err := ParseFileIdentity(`checksum`); err == nil {
+		t.Fatal("failed to catch bad identity")
+	}
+}
+
+func TestFingerprintCopyTruncate(t *testing.T) {
+	dir := t.TempDir()
+	first, content := testLines(`copytruncate`, 0, 100)
+	rest, more := testLines(`copytruncate`, 100, 150)
+	fpt := newFingerprintTracker(nil)
+
+	p := filepath.Join(dir, `app.log`)
+	if err := os.WriteFile(p, content, 0660); err != nil {
+		t.Fatal(err)
+	}
+	var lh orderedLH
+	fl, state := newIdentityFollower(t, p, fpt, &lh, 0, nil)
+	defer fl.Close()
+	if _, err := fl.Sync(nil); err != nil {
+		t.Fatal(err)
+	}
+	checkLines(t, lh.lines, first)
+
+	//more data lands and logrotate copies the file before we get to it
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(more)
+	f.Close()
+	cp := filepath.Join(dir, `app.log.1`)
+	if err = os.WriteFile(cp, append(content, more...), 0660); err != nil {
+		t.Fatal(err)
+	}
+
+	//the copy must wait while the original is still being read
+	var clh orderedLH
+	cfl, cstate := newIdentityFollower(t, cp, fpt, &clh, 0, nil)
+	defer cfl.Close()
+	if _, err = cfl.Sync(nil); err != nil {
+		t.Fatal(err)
+	} else if len(clh.lines) != 0 {
+		t.Fatalf("copy was read while the original was live: %d", len(clh.lines))
+	}
+
+	//original is truncated, the copy picks up exactly where the original left off
+	if err = os.Truncate(p, 0); err != nil {
+		t.Fatal(err)
+	} else if err = fl.processLines(true, false, false); err != nil {
+		t.Fatal(err)
+	} else if *state != 0 {
+		t.Fatalf("truncation was not detected: %d", *state)
+	}
+	if _, err = cfl.Sync(nil); err != nil {
+		t.Fatal(err)
+	}
+	checkLines(t, clh.lines, rest)
+	if *cstate != int64(len(content)+len(more)) {
+		t.Fatalf("bad copy state %d", *cstate)
+	}
+}
+
+func TestFingerprintHoldTimeout(t *testing.T) {
+	defer func(v time.Duration) { fingerprintHoldTimeout = v }(fingerprintHoldTimeout)
+	fingerprintHoldTimeout = 50 * time.Millisecond
+	dir := t.TempDir()
+	header, content := testLines(`shared header`, 0, 100)
+	atail, acontent := testLines(`first`, 0, 10)
+	btail, bcontent := testLines(`second`, 0, 10)
+	fpt := newFingerprintTracker(nil)
+
+	//two distinct live files that share their leading content
+	pa := filepath.Join(dir, `a.log`)
+	pb := filepath.Join(dir, `b.log`)
+	if err := os.WriteFile(pa, append(append([]byte(nil), content...), acontent...), 0660); err != nil {
+		t.Fatal(err)
+	} else if err = os.WriteFile(pb, append(append([]byte(nil), content...), bcontent...), 0660); err != nil {
+		t.Fatal(err)
+	}
+	var alh, blh orderedLH
+	afl, _ := newIdentityFollower(t, pa, fpt, &alh, 0, nil)
+	defer afl.Close()
+	if _, err := afl.Sync(nil); err != nil {
+		t.Fatal(err)
+	}
+	checkLines(t, alh.lines, append(append([]string(nil), header...), atail...))
+
+	bfl, bstate := newIdentityFollower(t, pb, fpt, &blh, 0, nil)
+	defer bfl.Close()
+	if _, err := bfl.Sync(nil); err != nil {
+		t.Fatal(err)
+	} else if len(blh.lines) != 0 {
+		t.Fatalf("second file was read while the first held the fingerprint: %d", len(blh.lines))
+	}
+
+	//the first file never lets go, the second is read on its own once the wait times out
+	time.Sleep(2 * fingerprintHoldTimeout)
+	if _, err := bfl.Sync(nil); err != nil {
+		t.Fatal(err)
+	}
+	checkLines(t, blh.lines, append(append([]string(nil), header...), btail...))
+	if *bstate != int64(len(content)+len(bcontent)) {
+		t.Fatalf("bad state %d", *bstate)
+	}
+}
+
+func TestFingerprintPathIdentity(t *testing.T) {
+	dir := t.TempDir()
+	lines, content := testLines(`identity`, 0, 100)
+	p := filepath.Join(dir, `app.log`)
+	if err := os.WriteFile(p, content, 0660); err != nil {
+		t.Fatal(err)
+	}
+	skip := int64(len(lines[0]) + 1)
+	fp := sha256.Sum256(content[:fingerprintSize])
+
+	//the path still holds what the state was recorded against, resume
+	var lh orderedLH
+	fl, _ := newIdentityFollower(t, p, newFingerprintTracker(nil), &lh, skip, &pathIdentity{Fingerprint: fp, HasFingerprint: true})
+	if _, err := fl.Sync(nil); err != nil {
+		t.Fatal(err)
+	}
+	fl.Close()
+	checkLines(t, lh.lines, lines[1:])
+
+	//the path holds different content (e.g. a recycled inode), start over
+	var other Fingerprint
+	other[0] = 0x42
+	lh.lines = nil
+	fl, _ = newIdentityFollower(t, p, newFingerprintTracker(nil), &lh, skip, &pathIdentity{Fingerprint: other, HasFingerprint: true})
+	if _, err := fl.Sync(nil); err != nil {
+		t.Fatal(err)
+	}
+	fl.Close()
+	checkLines(t, lh.lines, lines)
+
+	//no identity for the path, the state was migrated from an older state file and is trusted
+	lh.lines = nil
+	fl, _ = newIdentityFollower(t, p, newFingerprintTracker(nil), &lh, skip, nil)
+	if _, err := fl.Sync(nil); err != nil {
+		t.Fatal(err)
+	}
+	fl.Close()
+	checkLines(t, lh.lines, lines[1:])
+}
+
+func TestFingerprintSmallFileReplaced(t *testing.T) {
+	dir := t.TempDir()
+	lines, content := testLines(`small`, 0, 4)
+	p := filepath.Join(dir, `small.log`)
+	if err := os.WriteFile(p, content, 0660); err != nil {
+		t.Fatal(err)
+	}
+	mt := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(p, mt, mt); err != nil {
+		t.Fatal(err)
+	}
+	skip := int64(len(lines[0]) + 1)
+
+	//older than what we recorded and a different size, this is not what we were reading
+	var lh orderedLH
+	fl, _ := newIdentityFollower(t, p, newFingerprintTracker(nil), &lh, skip, &pathIdentity{Size: skip * 2, ModTime: time.Now()})
+	if _, err := fl.Sync(nil); err != nil {
+		t.Fatal(err)
+	}
+	fl.Close()
+	checkLines(t, lh.lines, lines)
+
+	//same size and older, the same file was restored from a backup
+	lh.lines = nil
+	fl, _ = newIdentityFollower(t, p, newFingerprintTracker(nil), &lh, skip, &pathIdentity{Size: int64(len(content)), ModTime: time.Now()})
+	if _, err := fl.Sync(nil); err != nil {
+		t.Fatal(err)
+	}
+	fl.Close()
+	checkLines(t, lh.lines, lines[1:])
+}
+
+func TestStateFileMigration(t *testing.T) {
+	dir := t.TempDir()
+	sf := filepath.Join(dir, `state`)
+	p := filepath.Join(dir, `app.log`)
+	if err := os.WriteFile(p, make([]byte, 4096), 0660); err != nil {
+		t.Fatal(err)
+	}
+	//state files from older versions only hold the path states
+	st := int64(2048)
+	k := FileName{BaseName: baseName, FilePath: p}
+	fout, err := os.Create(sf)
+	if err != nil {
+		t.Fatal(err)
+	} else if err = gob.NewEncoder(fout).Encode(map[FileName]*int64{k: &st}); err != nil {
+		t.Fatal(err)
+	}
+	fout.Close()
+
+	fm, err := NewFilterManager(sf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fm.SetFileIdentity(IdentityFingerprint)
+	if v, ok := fm.states[k]; !ok || *v != st {
+		t.Fatalf("path state was not migrated: %v", ok)
+	} else if len(fm.identities) != 0 || len(fm.fpt.export()) != 0 {
+		t.Fatal("migrated state file has identities")
+	}
+	var fp Fingerprint
+	fp[0] = 0x42
+	fm.identities[k] = pathIdentity{Fingerprint: fp, HasFingerprint: true, Size: 4096}
+	if err = fm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sts, err := DecodeStateFile(sf)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(sts) != 1 {
+		t.Fatalf("bad state count %d", len(sts))
+	} else if sts[0].State != st || sts[0].Fingerprint != fp.String() || sts[0].Size != 4096 {
+		t.Fatalf("bad state %+v", sts[0])
+	}
+}
+
+func TestStateFileEncodeDecode(t *testing.T) {
+	sf := filepath.Join(t.TempDir(), `state`)
+	var fp Fingerprint
+	fp[1] = 0x42
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	states := []FileState{
+		{BaseName: baseName, FilePath: `/tmp/a.log`, State: 100},
+		{BaseName: baseName, FilePath: `/tmp/b.log`, State: 200, Fingerprint: fp.String(), Size: 300, ModTime: ts},
+		{BaseName: baseName, FilePath: `/tmp/c.log`, State: 10, Size: 20, ModTime: ts},
+		{BaseName: baseName, State: 400, Fingerprint: fp.String(), ModTime: ts, Check: fp.String(), CheckOffset: 350},
+	}
+	if err := EncodeStateFile(sf, states); err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeStateFile(sf)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(got) != len(states) {
+		t.Fatalf("bad state count %d != %d", len(got), len(states))
+	}
+	for _, s := range states {
+		var found bool
+		for _, g := range got {
+			if g.BaseName == s.BaseName && g.FilePath == s.FilePath && g.State == s.State &&
+				g.Fingerprint == s.Fingerprint && g.Size == s.Size && g.ModTime.Equal(s.ModTime) &&
+				g.Check == s.Check && g.CheckOffset == s.CheckOffset {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("missing state %+v", s)
+		}
+	}
+	//older readers still see the path states
+	if sts, err := ReadStateFile(sf); err != nil {
+		t.Fatal(err)
+	} else if len(sts) != 3 {
+		t.Fatalf("bad path state count %d", len(sts))
+	}
+
+	//a fingerprint state must have a valid fingerprint
+	bad := []FileState{{BaseName: baseName, State: 1}}
+	if err = EncodeStateFile(sf, bad); err == nil {
+		t.Fatal("failed to catch state without path or fingerprint")
+	}
+	bad[0].Fingerprint = `abcd`
+	if err = EncodeStateFile(sf, bad); err == nil {
+		t.Fatal("failed to catch short fingerprint")
+	}
+}
@@ -0,0 +1,188 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// JSONReader streams whole JSON values out of a file regardless of how they are laid out.
+// NDJSON, concatenated values, pretty printed values, and values wrapped in a top level array all
+// produce one entry per value.  Array brackets and commas between top level values are treated
+// as separators, so a reader can pick up at any value boundary without knowing what came before.
+// Values larger than the maximum line length are truncated and the remainder is discarded.
+type JSONReader struct {
+	baseReader
+	brdr *bufio.Reader
+	buff []byte //data read but not yet consumed
+	scan int    //how far into buff the scanner has progressed
+	js   jsonScanner
+}
+
+// jsonScanner tracks where we are in a value
+type jsonScanner struct {
+	start   int //start of the current value in the buffer, -1 if between values
+	depth   int
+	inStr   bool
+	esc     bool
+	bare    bool  //the current value is a top level string, number, or literal
+	skipped int64 //bytes of an oversized value that were already discarded
+}
+
+func NewJSONReader(cfg ReaderConfig) (*JSONReader, error) {
+	br, err := newBaseReader(cfg.Fin, cfg.MaxLineLen, cfg.StartIndex)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONReader{
+		baseReader: br,
+		brdr:       bufio.NewReader(br.src),
+		js:         jsonScanner{start: -1},
+	}, nil
+}
+
+func (jr *JSONReader) SeekFile(offset int64) error {
+	jr.buff = nil
+	jr.scan = 0
+	jr.js = jsonScanner{start: -1}
+	if err := jr.baseReader.SeekFile(offset); err != nil {
+		return err
+	}
+	jr.brdr.Reset(jr.src)
+	return nil
+}
+
+func (jr *JSONReader) ReadEntry() (ln []byte, ok bool, wasEOF bool, err error) {
+	chunk := make([]byte, buffBlockSize)
+	for {
+		if ln, ok = jr.next(); ok {
+			return
+		}
+		n, lerr := jr.brdr.Read(chunk)
+		if lerr != nil && lerr != io.EOF {
+			err = lerr
+			return
+		} else if lerr == io.EOF {
+			wasEOF = true
+		}
+		if n == 0 {
+			return
+		}
+		jr.buff = append(jr.buff, chunk[:n]...)
+	}
+}
+
+// ReadRemaining only forces out a bare value at the end of the file, a partially written
+// object or array is not valid JSON and is left until the rest of it shows up
+func (jr *JSONReader) ReadRemaining() (ln []byte, err error) {
+	var ok bool
+	if ln, ok, _, err = jr.ReadEntry(); err != nil || ok {
+		return
+	} else if jr.js.start >= 0 && jr.js.bare && !jr.js.inStr && jr.js.skipped == 0 {
+		ln = jr.complete(len(jr.buff))
+	}
+	return
+}
+
+// next advances the scanner through the buffer and returns a value if one is complete
+func (jr *JSONReader) next() (ln []byte, ok bool) {
+	js := &jr.js
+	for jr.scan < len(jr.buff) {
+		i := jr.scan
+		c := jr.buff[i]
+		jr.scan++
+		if js.start < 0 {
+			switch c {
+			case ' ', '\t', '\r', '\n', ',', '[', ']':
+				continue //separators between values
+			case '{':
+				js.depth = 1
+			case '"':
+				js.inStr, js.bare = true, true
+			default:
+				js.bare = true
+			}
+			js.start = i
+			continue
+		}
+		end := -1
+		if js.inStr {
+			if js.esc {
+				js.esc = false
+			} else if c == '\\' {
+				js.esc = true
+			} else if c == '"' {
+				js.inStr = false
+				if js.bare {
+					end = i + 1
+				}
+			}
+		} else if js.bare {
+			switch c {
+			case ' ', '\t', '\r', '\n', ',', '[', ']', '{', '}', '"':
+				end = i //the terminator belongs to whatever comes next
+			}
+		} else {
+			switch c {
+			case '"':
+				js.inStr = true
+			case '{', '[':
+				js.depth++
+			case '}', ']':
+				if js.depth--; js.depth == 0 {
+					end = i + 1
+				}
+			}
+		}
+		if end >= 0 {
+			if ln = jr.complete(end); ln != nil {
+				ok = true
+				return
+			}
+			//that was the tail of an oversized value, keep going
+		}
+	}
+	if js.start < 0 {
+		//nothing but separators, drop them
+		jr.idx += int64(jr.scan)
+		jr.buff, jr.scan = jr.buff[:0], 0
+	} else if jr.maxLine > 0 && len(jr.buff)-js.start > jr.maxLine {
+		//too large, hand out what we have and discard the rest of the value as it arrives
+		ln, ok = jr.truncate()
+	}
+	return
+}
+
+// complete consumes the current value which ends at end in the buffer.  The returned slice is nil
+// if the head of the value was already handed out because it was too large.
+func (jr *JSONReader) complete(end int) (ln []byte) {
+	if jr.js.skipped == 0 {
+		ln = append([]byte(nil), jr.buff[jr.js.start:end]...)
+	}
+	jr.idx += jr.js.skipped + int64(end)
+	jr.buff = append(jr.buff[:0], jr.buff[end:]...)
+	jr.scan = 0
+	jr.js = jsonScanner{start: -1}
+	return
+}
+
+// truncate hands out the head of an oversized value and drops the buffered data, the index does not
+// move until the end of the value is found so a restart re-reads it rather than landing in the middle
+func (jr *JSONReader) truncate() (ln []byte, ok bool) {
+	js := &jr.js
+	if js.skipped == 0 {
+		ln, ok = bytes.Clone(jr.buff[js.start:js.start+jr.maxLine]), true
+	}
+	js.skipped += int64(len(jr.buff))
+	js.start = 0
+	jr.buff, jr.scan = jr.buff[:0], 0
+	return
+}
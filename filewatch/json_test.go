@@ -0,0 +1,97 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJSONReader(t *testing.T) {
+	dir := t.TempDir()
+	tsts := []struct {
+		name   string
+		data   string
+		values []string
+	}{
+		{`ndjson`, "{\"a\":1}\n{\"b\":\"}\"}\n\n{\"c\":[1,2]}\n",
+			[]string{`{"a":1}`, `{"b":"}"}`, `{"c":[1,2]}`}},
+		{`concatenated`, `{"a":1}{"b":{"c":"\"{"}} {"d":null}`,
+			[]string{`{"a":1}`, `{"b":{"c":"\"{"}}`, `{"d":null}`}},
+		{`array`, "[\n  {\n    \"a\": 1\n  },\n  {\n    \"b\": [\n      {\"c\": 2}\n    ]\n  }\n]\n",
+			[]string{"{\n    \"a\": 1\n  }", "{\n    \"b\": [\n      {\"c\": 2}\n    ]\n  }"}},
+		{`bare`, "[\"str\\\"ing\", 12.5, true, {\"a\":null}]",
+			[]string{`"str\"ing"`, `12.5`, `true`, `{"a":null}`}},
+	}
+	for _, tst := range tsts {
+		p := filepath.Join(dir, tst.name)
+		if err := os.WriteFile(p, []byte(tst.data), 0660); err != nil {
+			t.Fatal(err)
+		}
+		lines, idxs := readWithIndexes(t, openTestReader(t, p, JSONEngine, ``, 0))
+		checkLines(t, lines, tst.values)
+		//restart after each entry, nothing is repeated or lost
+		for i, idx := range idxs {
+			got := readAllEntries(t, openTestReader(t, p, JSONEngine, ``, idx))
+			checkLines(t, got, tst.values[i+1:])
+		}
+	}
+}
+
+func TestJSONReaderPartialWrite(t *testing.T) {
+	p := filepath.Join(t.TempDir(), `partial.json`)
+	fout, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fout.Close()
+	rdr := openTestReader(t, p, JSONEngine, ``, 0)
+	var got []string
+	for _, chunk := range []string{`[{"a":`, `"x"}`, `, {"b":[`, "1]}, 4", "2]"} {
+		if _, err = fout.WriteString(chunk); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, readAllEntries(t, rdr)...)
+	}
+	checkLines(t, got, []string{`{"a":"x"}`, `{"b":[1]}`, `42`})
+
+	//partial objects are never forced out
+	if _, err = fout.WriteString(`{"c":`); err != nil {
+		t.Fatal(err)
+	} else if ln, err := rdr.ReadRemaining(); err != nil || ln != nil {
+		t.Fatalf("partial object was forced out: %q %v", ln, err)
+	}
+}
+
+func TestJSONReaderOversized(t *testing.T) {
+	p := filepath.Join(t.TempDir(), `big.json`)
+	big := `{"big":"` + strings.Repeat("x", 8192) + `"}`
+	data := `{"a":1}` + "\n" + big + "\n" + `{"b":2}` + "\n"
+	if err := os.WriteFile(p, []byte(data), 0660); err != nil {
+		t.Fatal(err)
+	}
+	fin, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdr, err := NewJSONReader(ReaderConfig{Fin: fin, MaxLineLen: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rdr.Close()
+	lines, idxs := readWithIndexes(t, rdr)
+	checkLines(t, lines, []string{`{"a":1}`, big[:1024], `{"b":2}`})
+	if idxs[1] != idxs[0] {
+		t.Fatalf("index moved past a truncated value: %d != %d", idxs[1], idxs[0])
+	} else if idxs[2] != int64(len(data)-1) {
+		t.Fatalf("bad final index %d", idxs[2])
+	}
+}
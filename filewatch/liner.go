@@ -27,7 +27,7 @@ func NewLineReader(cfg ReaderConfig) (*LineReader, error) {
 	}
 	return &LineReader{
 		baseReader: br,
-		brdr:       bufio.NewReader(cfg.Fin),
+		brdr:       bufio.NewReader(br.src),
 	}, nil
 }
 
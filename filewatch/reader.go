@@ -9,7 +9,9 @@
 package filewatch
 
 import (
+	"bufio"
 	"errors"
+	"io"
 	"os"
 	"time"
 )
@@ -23,6 +25,14 @@ const (
 const (
 	LineEngine  int = 0
 	RegexEngine int = 1
+	// 2 is the windows only EvtxEngine
+	CSVEngine  int = 3 //rows become JSON objects keyed by the header, EngineArgs may set the delimiter
+	W3CEngine  int = 4 //W3C extended log format (IIS, Zeek), rows become JSON objects keyed by the #Fields directive
+	JSONEngine int = 5 //whole JSON values from NDJSON, concatenated, pretty printed, or array wrapped files
+	// Docker json-file and CRI (containerd, CRI-O) logs, messages are unwrapped and reassembled
+	ContainerEngine int = 6
+	// Linux audit logs, records are grouped into events, EngineArgs may set the grouping window
+	AuditEngine int = 7
 )
 
 type Reader interface {
@@ -46,22 +56,26 @@ type ReaderConfig struct {
 
 type baseReader struct {
 	f       *os.File
+	src     *contentReader //engines read through this so that compressed files are transparent
 	idx     int64
 	maxLine int
 }
 
+// fingerprinter is implemented by readers that can identify their content independent of the file
+type fingerprinter interface {
+	detect() (bool, error)
+	compressed() bool
+	fingerprint() (Fingerprint, bool, error)
+	check(int64) (contentCheck, bool, error)
+	consumed() bool
+}
+
 func (br baseReader) ID() (FileId, error) {
 	return getFileId(br.f)
 }
 
 func (br baseReader) FileSize() (sz int64, err error) {
-	var fi os.FileInfo
-	if fi, err = br.f.Stat(); err != nil {
-		sz = -1
-	} else {
-		sz = fi.Size()
-	}
-	return
+	return br.src.Size()
 }
 
 func (br baseReader) LastModTime() (t time.Time, err error) {
@@ -87,6 +101,7 @@ func newBaseReader(f *os.File, maxLine int, startIdx int64) (br baseReader, err
 	}
 	if err == nil {
 		br.f = f
+		br.src = newContentReader(f, startIdx)
 		br.idx = startIdx
 		br.maxLine = maxLine
 	}
@@ -101,7 +116,7 @@ func (br *baseReader) Name() string {
 }
 
 func (br *baseReader) SeekFile(offset int64) error {
-	_, err := br.f.Seek(offset, 0)
+	err := br.src.SeekTo(offset)
 	br.idx = offset
 	return err
 }
@@ -114,9 +129,59 @@ func (br *baseReader) Close() error {
 	if br.f == nil {
 		return nil
 	}
+	br.src.Close()
 	if err := br.f.Close(); err != nil {
 		return err
 	}
 	br.f = nil
 	return nil
 }
+
+func (br *baseReader) detect() (bool, error) {
+	return br.src.detect()
+}
+
+func (br *baseReader) compressed() bool {
+	return br.src.compressed()
+}
+
+func (br *baseReader) fingerprint() (Fingerprint, bool, error) {
+	return br.src.Fingerprint()
+}
+
+func (br *baseReader) check(off int64) (contentCheck, bool, error) {
+	return br.src.Check(off)
+}
+
+// consumed reports if any content has been read since the reader was opened or last positioned
+func (br *baseReader) consumed() bool {
+	return br.src.touched
+}
+
+// scanPreamble hands every complete line before offset to fn until fn returns false, this lets
+// engines recover headers and directives when they start part way through a file.
+// The read position is left at offset.
+func (br *baseReader) scanPreamble(offset int64, fn func([]byte) bool) (err error) {
+	if offset > 0 {
+		cr := newContentReader(br.f, 0)
+		defer cr.Close()
+		if _, err = cr.detect(); err != nil {
+			return
+		} else if err = cr.SeekTo(0); err != nil {
+			return
+		}
+		brdr := bufio.NewReader(io.LimitReader(cr, offset))
+		for {
+			var ln []byte
+			if ln, err = brdr.ReadBytes('\n'); err == io.EOF {
+				err = nil
+				break
+			} else if err != nil {
+				return
+			} else if !fn(ln) {
+				break
+			}
+		}
+	}
+	return br.src.SeekTo(offset)
+}
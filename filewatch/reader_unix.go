@@ -21,6 +21,16 @@ func NewReader(cfg ReaderConfig) (Reader, error) {
 	switch cfg.Engine {
 	case RegexEngine:
 		return NewRegexReader(cfg)
+	case CSVEngine:
+		return NewCSVReader(cfg)
+	case W3CEngine:
+		return NewW3CReader(cfg)
+	case JSONEngine:
+		return NewJSONReader(cfg)
+	case ContainerEngine:
+		return NewContainerReader(cfg)
+	case AuditEngine:
+		return NewAuditReader(cfg)
 	case LineEngine: //default/empty is line reader
 		return NewLineReader(cfg)
 	}
@@ -38,6 +38,16 @@ func NewReader(cfg ReaderConfig) (Reader, error) {
 	switch cfg.Engine {
 	case RegexEngine:
 		return NewRegexReader(cfg)
+	case CSVEngine:
+		return NewCSVReader(cfg)
+	case W3CEngine:
+		return NewW3CReader(cfg)
+	case JSONEngine:
+		return NewJSONReader(cfg)
+	case ContainerEngine:
+		return NewContainerReader(cfg)
+	case AuditEngine:
+		return NewAuditReader(cfg)
 	case LineEngine: //default/empty is line reader
 		//check if the filetype is .evtx, if it is, force the EvtxReader
 		//this ONLY works on windows, its kind of a hack, but i don't want to try and
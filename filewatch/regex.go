@@ -36,7 +36,7 @@ func NewRegexReader(cfg ReaderConfig) (*RegexReader, error) {
 		baseReader: br,
 		rx:         rx,
 		currLine:   make([]byte, 0, cfg.MaxLineLen),
-		brdr:       bufio.NewReader(cfg.Fin),
+		brdr:       bufio.NewReader(br.src),
 		lastRead:   time.Now(),
 	}
 	return rr, nil
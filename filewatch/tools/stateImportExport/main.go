@@ -44,6 +44,10 @@ func showHelp(app string) {
 	fmt.Printf("%s <action> <input file> <output file>\n", app)
 	fmt.Printf("\nExample Export: %s export /opt/gravwell/etc/file_follow.state /tmp/states.json\n", app)
 	fmt.Printf("\nExample Import: %s import /tmp/states.json /opt/gravwell/etc/file_follow.state\n", app)
+	fmt.Printf("\nStates are exported as one JSON object per line.  Objects with a FilePath are path states,\n")
+	fmt.Printf("the optional Fingerprint, Size, and ModTime fields describe what the path held when the state\n")
+	fmt.Printf("was recorded.  Objects without a FilePath are fingerprint states which follow file content\n")
+	fmt.Printf("and must carry a hex encoded Fingerprint.  Exports from older versions import unchanged.\n")
 }
 
 func importSet(input, output string) (err error) {
@@ -61,6 +65,15 @@ func importSet(input, output string) (err error) {
 				err = nil
 				break
 			}
+			fin.Close()
+			err = fmt.Errorf("failed to decode state %d from %q %w", len(fss)+1, input, err)
+			return
+		} else if fs.Fingerprint != `` {
+			if _, err = filewatch.ParseFingerprint(fs.Fingerprint); err != nil {
+				fin.Close()
+				err = fmt.Errorf("invalid fingerprint on state %d from %q %w", len(fss)+1, input, err)
+				return
+			}
 		}
 		fss = append(fss, fs)
 	}
@@ -68,7 +81,9 @@ func importSet(input, output string) (err error) {
 		err = fmt.Errorf("failed to close input %q %w", input, err)
 		return
 	}
-	err = filewatch.EncodeStateFile(output, fss)
+	if err = filewatch.EncodeStateFile(output, fss); err != nil {
+		err = fmt.Errorf("failed to encode state file %q %w", output, err)
+	}
 
 	return
 }
@@ -10,49 +10,158 @@ package filewatch
 
 import (
 	"encoding/gob"
+	"fmt"
+	"io"
 	"os"
+	"time"
 )
 
+// FileState is the portable representation of a state file entry.  Entries with a FilePath are
+// path states, the Fingerprint, Size, and ModTime describe what the path held when the state was
+// recorded and are only populated when files are identified by fingerprint.  Entries without a
+// FilePath are fingerprint states that follow content across files, ModTime is the last time the
+// fingerprint was used and Check is a hash of the content leading up to CheckOffset.
 type FileState struct {
-	BaseName string
-	FilePath string
-	State    int64
+	BaseName    string
+	FilePath    string `json:",omitempty"`
+	State       int64
+	Fingerprint string    `json:",omitempty"`
+	Size        int64     `json:",omitempty"`
+	ModTime     time.Time `json:",omitempty"`
+	Check       string    `json:",omitempty"`
+	CheckOffset int64     `json:",omitempty"`
+}
+
+// stateContents is everything held in a state file.  The path states come first so that older
+// readers that only decode the path states continue to work, fingerprint states and path
+// identities follow as additional values and are missing from older state files.
+type stateContents struct {
+	states     map[FileName]*int64
+	fps        map[fingerprintKey]fingerprintState
+	identities map[FileName]pathIdentity
+}
+
+func newStateContents() stateContents {
+	return stateContents{
+		states:     map[FileName]*int64{},
+		fps:        map[fingerprintKey]fingerprintState{},
+		identities: map[FileName]pathIdentity{},
+	}
+}
+
+func decodeStates(r io.Reader) (sc stateContents, err error) {
+	sc = newStateContents()
+	dec := gob.NewDecoder(r)
+	if err = dec.Decode(&sc.states); err != nil {
+		return
+	}
+	if err = dec.Decode(&sc.fps); err == nil {
+		err = dec.Decode(&sc.identities)
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return
+}
+
+func (sc stateContents) encode(w io.Writer) error {
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(sc.states); err != nil {
+		return err
+	} else if err = enc.Encode(sc.fps); err != nil {
+		return err
+	}
+	return enc.Encode(sc.identities)
 }
 
 func DecodeStateFile(sf string) (states []FileState, err error) {
-	native := map[FileName]*int64{}
+	var sc stateContents
 	var fin *os.File
 	if fin, err = os.Open(sf); err != nil {
 		return
-	} else if err = gob.NewDecoder(fin).Decode(&native); err != nil {
+	} else if sc, err = decodeStates(fin); err != nil {
 		fin.Close()
 		return
 	} else if err = fin.Close(); err != nil {
 		return
 	}
-	for k, v := range native {
+	for k, v := range sc.states {
 		var st int64
 		if v != nil {
 			st = *v
 		}
-		states = append(states, FileState{
+		fs := FileState{
 			BaseName: k.BaseName,
 			FilePath: k.FilePath,
 			State:    st,
-		})
+		}
+		if pid, ok := sc.identities[k]; ok {
+			if pid.HasFingerprint {
+				fs.Fingerprint = pid.Fingerprint.String()
+			}
+			fs.Size = pid.Size
+			fs.ModTime = pid.ModTime
+		}
+		states = append(states, fs)
+	}
+	for k, v := range sc.fps {
+		fs := FileState{
+			BaseName:    k.BaseName,
+			State:       v.State,
+			Fingerprint: k.Fingerprint.String(),
+			ModTime:     v.Updated,
+		}
+		if v.Check.Offset > 0 {
+			fs.Check = v.Check.Sum.String()
+			fs.CheckOffset = v.Check.Offset
+		}
+		states = append(states, fs)
 	}
 	return
 }
 
 func EncodeStateFile(sf string, states []FileState) (err error) {
-	native := make(map[FileName]*int64, len(states))
+	sc := newStateContents()
 	for _, s := range states {
-		native[FileName{BaseName: s.BaseName, FilePath: s.FilePath}] = &s.State
+		var fp Fingerprint
+		if s.Fingerprint != `` {
+			if fp, err = ParseFingerprint(s.Fingerprint); err != nil {
+				return fmt.Errorf("invalid fingerprint on %q: %w", s.BaseName, err)
+			}
+		}
+		if s.FilePath == `` {
+			if s.Fingerprint == `` {
+				return fmt.Errorf("state on %q has neither a file path nor a fingerprint", s.BaseName)
+			}
+			fps := fingerprintState{
+				State:   s.State,
+				Updated: s.ModTime,
+			}
+			if s.Check != `` {
+				if fps.Check.Sum, err = ParseFingerprint(s.Check); err != nil {
+					return fmt.Errorf("invalid check on %q: %w", s.BaseName, err)
+				}
+				fps.Check.Offset = s.CheckOffset
+			}
+			sc.fps[fingerprintKey{BaseName: s.BaseName, Fingerprint: fp}] = fps
+			continue
+		}
+		k := FileName{BaseName: s.BaseName, FilePath: s.FilePath}
+		st := s.State
+		sc.states[k] = &st
+		if s.Fingerprint != `` || s.Size != 0 || !s.ModTime.IsZero() {
+			sc.identities[k] = pathIdentity{
+				Fingerprint:    fp,
+				HasFingerprint: s.Fingerprint != ``,
+				Size:           s.Size,
+				ModTime:        s.ModTime,
+			}
+		}
 	}
 	var fout *os.File
 	if fout, err = os.Create(sf); err != nil {
 		return
-	} else if err = gob.NewEncoder(fout).Encode(native); err != nil {
+	} else if err = sc.encode(fout); err != nil {
 		fout.Close()
 		return
 	}
@@ -0,0 +1,151 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"io"
+	"strings"
+
+	"github.com/gravwell/gravwell/v3/ingesters/utils"
+)
+
+// W3CReader handles the W3C extended log format as written by IIS and Zeek.  Directive lines
+// starting with # are consumed and each row is emitted as a JSON object keyed by the most recent
+// #Fields directive.  Zeek style #separator directives are honored, otherwise fields are split on
+// whitespace.  If the reader starts part way through a file the directives that precede the start
+// are re-read so that rows keep their names across restarts.
+type W3CReader struct {
+	baseReader
+	brdr    *bufio.Reader
+	fields  []string
+	sep     string //empty means whitespace
+	partial []byte
+}
+
+func NewW3CReader(cfg ReaderConfig) (*W3CReader, error) {
+	br, err := newBaseReader(cfg.Fin, cfg.MaxLineLen, cfg.StartIndex)
+	if err != nil {
+		return nil, err
+	}
+	wr := &W3CReader{
+		baseReader: br,
+		brdr:       bufio.NewReader(br.src),
+	}
+	if err = wr.loadDirectives(cfg.StartIndex); err != nil {
+		return nil, err
+	}
+	return wr, nil
+}
+
+func (wr *W3CReader) loadDirectives(offset int64) error {
+	return wr.scanPreamble(offset, func(ln []byte) bool {
+		if ln = bytes.TrimRight(ln, "\r\n"); len(ln) > 0 && ln[0] == '#' {
+			wr.directive(string(ln[1:]))
+		}
+		return true
+	})
+}
+
+func (wr *W3CReader) SeekFile(offset int64) error {
+	wr.fields = nil
+	wr.sep = ``
+	wr.partial = nil
+	if err := wr.baseReader.SeekFile(offset); err != nil {
+		return err
+	}
+	wr.brdr.Reset(wr.src)
+	return wr.loadDirectives(offset)
+}
+
+func (wr *W3CReader) ReadEntry() (ln []byte, ok bool, wasEOF bool, err error) {
+	for {
+		b, lerr := wr.brdr.ReadBytes('\n')
+		if lerr != nil && lerr != io.EOF {
+			err = lerr
+			return
+		} else if lerr == io.EOF {
+			wr.partial = append(wr.partial, b...)
+			wasEOF = true
+			return
+		}
+		if len(wr.partial) > 0 {
+			b = append(wr.partial, b...)
+			wr.partial = nil
+		}
+		if ln, ok = wr.handleLine(b); ok {
+			return
+		}
+	}
+}
+
+func (wr *W3CReader) ReadRemaining() (ln []byte, err error) {
+	var ok bool
+	if ln, ok, _, err = wr.ReadEntry(); err != nil || ok {
+		return
+	} else if len(wr.partial) > 0 {
+		b := wr.partial
+		wr.partial = nil
+		ln, _ = wr.handleLine(b)
+	}
+	return
+}
+
+// handleLine consumes a line, ok is false for directives and blank lines
+func (wr *W3CReader) handleLine(b []byte) (ln []byte, ok bool) {
+	wr.idx += int64(len(b))
+	if b = bytes.TrimRight(b, "\r\n"); len(bytes.TrimSpace(b)) == 0 {
+		return
+	} else if b[0] == '#' {
+		wr.directive(string(b[1:]))
+		return
+	}
+	if wr.fields == nil {
+		return b, true //no field names yet, the best we can do is the raw line
+	}
+	return utils.FieldsObject(wr.fields, wr.split(string(b))), true
+}
+
+func (wr *W3CReader) split(v string) []string {
+	if wr.sep == `` {
+		return strings.Fields(v)
+	}
+	return strings.Split(v, wr.sep)
+}
+
+// directive handles a directive line without the leading #, IIS uses "Fields: a b c" and Zeek
+// uses "fields<sep>a<sep>b<sep>c" after declaring the separator with "separator \x09"
+func (wr *W3CReader) directive(d string) {
+	lower := strings.ToLower(d)
+	switch {
+	case strings.HasPrefix(lower, `fields`):
+		v := strings.TrimPrefix(d[len(`fields`):], `:`)
+		if wr.sep != `` {
+			wr.fields = strings.Split(strings.TrimPrefix(v, wr.sep), wr.sep)
+		} else {
+			wr.fields = strings.Fields(v)
+		}
+	case strings.HasPrefix(lower, `separator`):
+		wr.sep = decodeSeparator(strings.TrimSpace(d[len(`separator`):]))
+	}
+}
+
+// decodeSeparator handles separators written as escaped bytes, e.g. \x09
+func decodeSeparator(v string) string {
+	if !strings.HasPrefix(v, `\x`) {
+		return v
+	}
+	b, err := hex.DecodeString(strings.ReplaceAll(v, `\x`, ``))
+	if err != nil {
+		return v
+	}
+	return string(b)
+}
@@ -0,0 +1,78 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testIIS = "#Software: Microsoft Internet Information Services 10.0\r\n" +
+	"#Version: 1.0\r\n" +
+	"#Date: 2024-05-01 00:00:00\r\n" +
+	"#Fields: date time s-ip cs-method cs-uri-stem sc-status\r\n" +
+	"2024-05-01 00:00:01 10.0.0.1 GET /index.html 200\r\n" +
+	"2024-05-01 00:00:02 10.0.0.1 POST /login 302\r\n" +
+	"#Software: Microsoft Internet Information Services 10.0\r\n" +
+	"#Fields: date time cs-method sc-status\r\n" +
+	"2024-05-01 00:10:00 GET 404\r\n"
+
+var testIISObjects = []string{
+	`{"date":"2024-05-01","time":"00:00:01","s-ip":"10.0.0.1","cs-method":"GET","cs-uri-stem":"/index.html","sc-status":"200"}`,
+	`{"date":"2024-05-01","time":"00:00:02","s-ip":"10.0.0.1","cs-method":"POST","cs-uri-stem":"/login","sc-status":"302"}`,
+	`{"date":"2024-05-01","time":"00:10:00","cs-method":"GET","sc-status":"404"}`,
+}
+
+const testZeek = "#separator \\x09\n" +
+	"#set_separator\t,\n" +
+	"#path\tconn\n" +
+	"#fields\tts\tuid\tid.orig_h\tservice\n" +
+	"#types\ttime\tstring\taddr\tstring\n" +
+	"1714521600.000001\tCabc\t10.0.0.1\t-\n" +
+	"1714521601.000002\tCdef\t10.0.0.2\thttp server\n" +
+	"#close\t2024-05-01-00-00-02\n"
+
+var testZeekObjects = []string{
+	`{"ts":"1714521600.000001","uid":"Cabc","id.orig_h":"10.0.0.1","service":"-"}`,
+	`{"ts":"1714521601.000002","uid":"Cdef","id.orig_h":"10.0.0.2","service":"http server"}`,
+}
+
+func TestW3CReader(t *testing.T) {
+	dir := t.TempDir()
+	for _, tst := range []struct {
+		name    string
+		data    string
+		objects []string
+	}{
+		{`iis.log`, testIIS, testIISObjects},
+		{`conn.log`, testZeek, testZeekObjects},
+	} {
+		p := filepath.Join(dir, tst.name)
+		if err := os.WriteFile(p, []byte(tst.data), 0660); err != nil {
+			t.Fatal(err)
+		}
+		lines, idxs := readWithIndexes(t, openTestReader(t, p, W3CEngine, ``, 0))
+		checkLines(t, lines, tst.objects)
+		//restart after each entry, the most recent directives must be recovered
+		for i, idx := range idxs {
+			got := readAllEntries(t, openTestReader(t, p, W3CEngine, ``, idx))
+			checkLines(t, got, tst.objects[i+1:])
+		}
+	}
+}
+
+func TestW3CReaderNoFields(t *testing.T) {
+	p := filepath.Join(t.TempDir(), `bare.log`)
+	if err := os.WriteFile(p, []byte("#Version: 1.0\nno fields here\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	rdr := openTestReader(t, p, W3CEngine, ``, 0)
+	checkLines(t, readAllEntries(t, rdr), []string{`no fields here`})
+}
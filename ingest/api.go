@@ -179,6 +179,13 @@ type IngesterState struct {
 	Children      map[string]IngesterState
 	Configuration json.RawMessage `json:",omitempty"`
 	Metadata      json.RawMessage `json:",omitempty"`
+	Stats         json.RawMessage `json:",omitempty"` // counters from registered StatsReporters
+}
+
+// StatsReporter is implemented by components that want their counters
+// included in the ingester state, such as preprocessor sets.
+type StatsReporter interface {
+	Stats() interface{}
 }
 
 type writeCounter struct {
@@ -207,6 +214,7 @@ func trimChildConfigs(children map[string]IngesterState, depth int) {
 		}
 		v.Configuration = nil
 		v.Metadata = nil
+		v.Stats = nil
 		if len(v.Children) > 0 {
 			trimChildConfigs(v.Children, depth-1)
 		}
@@ -337,6 +345,7 @@ func (s IngesterState) MarshalJSON() ([]byte, error) {
 		Children      mis
 		Configuration json.RawMessage `json:",omitempty"`
 		Metadata      json.RawMessage `json:",omitempty"`
+		Stats         json.RawMessage `json:",omitempty"`
 	}{
 		UUID:          s.UUID,
 		Name:          s.Name,
@@ -354,6 +363,7 @@ func (s IngesterState) MarshalJSON() ([]byte, error) {
 		Children:      mis{mp: s.Children},
 		Configuration: s.Configuration,
 		Metadata:      s.Metadata,
+		Stats:         s.Stats,
 	}
 	return json.Marshal(x)
 }
@@ -120,6 +120,7 @@ type IngestConfig struct {
 	Label                      string   `json:",omitempty"` //arbitrary label that can be attached to an ingester
 	Disable_Multithreading     bool     //basically set GOMAXPROCS(1)
 	Stats_Sample_Interval      string   `json:",omitempty"` // if set to > 0 duration then we periodically throw stats
+	Preprocessor_Trace_Rate    uint64   `json:",omitempty"` // if set to N > 0 trace one of every N entries through preprocessor chains
 }
 
 type IngestStreamConfig struct {
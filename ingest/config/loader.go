@@ -79,6 +79,18 @@ func LoadConfigFile(v interface{}, p string) (err error) {
 // LoadConfigOverlays scans the given directory path for files that end in .conf
 // if they exist we load them up into the interface
 func LoadConfigOverlays(v interface{}, pth string) (err error) {
+	return loadConfigOverlays(v, pth, LoadConfigFile)
+}
+
+// LoadConfigOverlaysFatalOnly is LoadConfigOverlays for consumers that only care about part of
+// a config, data for sections and variables that the interface does not define is ignored
+func LoadConfigOverlaysFatalOnly(v interface{}, pth string) (err error) {
+	return loadConfigOverlays(v, pth, func(v interface{}, p string) error {
+		return gcfg.FatalOnly(LoadConfigFile(v, p))
+	})
+}
+
+func loadConfigOverlays(v interface{}, pth string, load func(interface{}, string) error) (err error) {
 	if pth == `` || v == nil {
 		return //just leave
 	}
@@ -106,7 +118,7 @@ func LoadConfigOverlays(v interface{}, pth string) (err error) {
 			continue
 		}
 		p := filepath.Join(pth, dent.Name())
-		if err = LoadConfigFile(v, p); err != nil {
+		if err = load(v, p); err != nil {
 			err = fmt.Errorf("failed to load %q %w", p, err)
 			return
 		}
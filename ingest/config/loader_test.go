@@ -284,3 +284,36 @@ func TestLoadBadKey(t *testing.T) {
 		t.Fatalf("Faild to call out Key name Foobar in error %q", err)
 	}
 }
+
+func TestLoadOverlaysFatalOnly(t *testing.T) {
+	dir := filepath.Join(tempDir, `overlays`)
+	if err := os.MkdirAll(dir, 0770); err != nil {
+		t.Fatal(err)
+	}
+	//each overlay holds data that the struct does not define
+	a := []byte("[item \"A\"]\n\tName=a\n\tUnknown=stuff\n[other \"x\"]\n\tfoo=bar\n")
+	b := []byte("[item \"B\"]\n\tName=b\n\tValue=2\n[global]\n\tnope=1\n")
+	if err := ioutil.WriteFile(filepath.Join(dir, `a.conf`), a, 0660); err != nil {
+		t.Fatal(err)
+	} else if err = ioutil.WriteFile(filepath.Join(dir, `b.conf`), b, 0660); err != nil {
+		t.Fatal(err)
+	}
+	var tc testStruct
+	if err := LoadConfigOverlays(&tc, dir); err == nil {
+		t.Fatal("Failed to catch unknown data")
+	}
+	tc = testStruct{}
+	if err := LoadConfigOverlaysFatalOnly(&tc, dir); err != nil {
+		t.Fatal(err)
+	} else if len(tc.Item) != 2 || tc.Item[`A`].Name != `a` || tc.Item[`B`].Name != `b` || tc.Item[`B`].Value != 2 {
+		t.Fatalf("bad overlay items %+v", tc.Item)
+	}
+
+	//fatal errors still come through
+	if err := ioutil.WriteFile(filepath.Join(dir, `c.conf`), []byte("[item \"C\"]\n\tValue=notanumber\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadConfigOverlaysFatalOnly(&tc, dir); err == nil {
+		t.Fatal("Failed to catch bad value")
+	}
+}
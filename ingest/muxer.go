@@ -140,6 +140,7 @@ type IngestMuxer struct {
 	start                time.Time    // when the muxer was started
 	attacher             *attach.Attacher
 	attachActive         bool
+	statsReporters       []StatsReporter // components whose counters ride along with the ingester state
 }
 
 type UniformMuxerConfig struct {
@@ -564,12 +565,29 @@ func (im *IngestMuxer) getIngesterState(lastPush time.Time, lastEntryCount uint6
 	} else {
 		return //nothing new in the ingester state, just return
 	}
+	// reporters are called without our lock held, a reporter may hold its own lock while
+	// writing entries and writes can wait on the muxer lock
+	im.mtx.RLock()
+	reporters := append([]StatsReporter(nil), im.statsReporters...)
+	im.mtx.RUnlock()
+	var stats json.RawMessage
+	if len(reporters) > 0 {
+		vals := make([]interface{}, 0, len(reporters))
+		for _, sr := range reporters {
+			vals = append(vals, sr.Stats())
+		}
+		if msg, err := json.Marshal(vals); err == nil {
+			stats = json.RawMessage(msg)
+		}
+	}
+
 	im.mtx.Lock()
 
 	// update the cache stats real quick
 	im.ingesterState.CacheSize = uint64(im.cache.Size())
 	im.ingesterState.Uptime = time.Since(im.start)
 	im.ingesterState.Tags = im.tags
+	im.ingesterState.Stats = stats
 
 	// The ingesterState object is of type ingest.IngesterState which contains a map of children.
 	// You must make a deep copy (which is what Copy does) if you are going to concurrently read and write it.
@@ -677,6 +695,31 @@ func (im *IngestMuxer) SetMetadata(obj interface{}) (err error) {
 	return
 }
 
+// RegisterStatsReporter adds a component whose Stats output is attached to
+// every ingester state pushed upstream.
+func (im *IngestMuxer) RegisterStatsReporter(sr StatsReporter) {
+	if sr == nil {
+		return
+	}
+	im.mtx.Lock()
+	im.statsReporters = append(im.statsReporters, sr)
+	im.ingesterStateUpdated = true
+	im.mtx.Unlock()
+}
+
+// UnregisterStatsReporter removes a component added with RegisterStatsReporter.
+func (im *IngestMuxer) UnregisterStatsReporter(sr StatsReporter) {
+	im.mtx.Lock()
+	for i, v := range im.statsReporters {
+		if v == sr {
+			im.statsReporters = append(im.statsReporters[:i], im.statsReporters[i+1:]...)
+			im.ingesterStateUpdated = true
+			break
+		}
+	}
+	im.mtx.Unlock()
+}
+
 func (im *IngestMuxer) RegisterChild(k string, v IngesterState) {
 	im.mtx.Lock()
 	v.LastSeen = time.Now() // if its being registered, we want to update its state
@@ -0,0 +1,79 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockedReporter behaves like a processor set, it holds its own lock while writing
+// entries to the muxer and takes the same lock to report stats
+type lockedReporter struct {
+	sync.Mutex
+	calls int
+}
+
+func (lr *lockedReporter) Stats() interface{} {
+	lr.Lock()
+	defer lr.Unlock()
+	lr.calls++
+	return lr.calls
+}
+
+func (lr *lockedReporter) process(im *IngestMuxer) {
+	lr.Lock()
+	runtime.Gosched()
+	im.LookupTag(0) // stands in for a write, which takes the muxer lock
+	lr.Unlock()
+}
+
+func TestStatsReporterLockOrder(t *testing.T) {
+	im, err := NewMuxer(MuxerConfig{Tags: []string{`foo`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lr := &lockedReporter{}
+	im.RegisterStatsReporter(lr)
+
+	done := make(chan bool)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10000; i++ {
+			lr.process(im)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10000; i++ {
+			if s, push := im.getIngesterState(time.Time{}, 0); !push || len(s.Stats) == 0 {
+				t.Errorf("missing stats on push %d", i)
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("stats collection deadlocked with a writer")
+	}
+
+	im.UnregisterStatsReporter(lr)
+	if s, _ := im.getIngesterState(time.Time{}, 0); s.Stats != nil {
+		t.Fatalf("unregistered reporter is still reported: %s", s.Stats)
+	}
+}
@@ -34,6 +34,7 @@ type CSVRouteConfig struct {
 
 type CSVRouter struct {
 	nocloser
+	missTracker
 	CSVRouteConfig
 	routes map[string]entry.EntryTag
 	drops  map[string]struct{}
@@ -109,11 +110,15 @@ func (cr *CSVRouter) processItem(ent *entry.Entry) *entry.Entry {
 
 	if fields, err := r.Read(); err == nil && cr.CSVRouteConfig.Route_Extraction < len(fields) {
 		if tag, drop, ok := cr.handleExtract(fields[cr.CSVRouteConfig.Route_Extraction]); drop {
+			if _, explicit := cr.drops[fields[cr.CSVRouteConfig.Route_Extraction]]; !explicit {
+				cr.miss(ent)
+			}
 			return nil
 		} else if ok {
 			ent.Tag = tag
 		}
 	} else if cr.Drop_Misses {
+		cr.miss(ent)
 		return nil
 	}
 	return ent
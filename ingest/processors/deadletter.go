@@ -0,0 +1,128 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"strings"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	// DeadLetterProcessorEV names the preprocessor that rejected a dead lettered entry
+	DeadLetterProcessorEV string = `dlq_processor`
+	// DeadLetterErrorEV carries the error string, or "miss" for entries a preprocessor could not handle
+	DeadLetterErrorEV string = `dlq_error`
+
+	deadLetterMiss string = `miss`
+)
+
+// deadLetterConfig is shared by every preprocessor section, when Dead-Letter-Tag is
+// set entries which cause the preprocessor to fail or miss are re-tagged and sent
+// straight to the ingest muxer instead of being dropped.
+type deadLetterConfig struct {
+	Dead_Letter_Tag string
+}
+
+func loadDeadLetterConfig(vc *config.VariableConfig) (dlc deadLetterConfig, err error) {
+	if err = vc.MapTo(&dlc); err != nil {
+		return
+	}
+	dlc.Dead_Letter_Tag = strings.TrimSpace(dlc.Dead_Letter_Tag)
+	if dlc.Dead_Letter_Tag != `` {
+		err = ingest.CheckTag(dlc.Dead_Letter_Tag)
+	}
+	return
+}
+
+// missReporter is implemented by processors which can hand back the entries they
+// dropped because they did not match.  Misses are only collected once tracking is enabled.
+type missReporter interface {
+	EnableMissTracking()
+	Misses() []*entry.Entry
+}
+
+// missTracker is embedded in processors to implement missReporter
+type missTracker struct {
+	trackMisses bool
+	misses      []*entry.Entry
+}
+
+func (mt *missTracker) EnableMissTracking() {
+	mt.trackMisses = true
+}
+
+// Misses returns the entries missed since the last call and resets the set
+func (mt *missTracker) Misses() (r []*entry.Entry) {
+	r = mt.misses
+	mt.misses = nil
+	return
+}
+
+func (mt *missTracker) miss(ent *entry.Entry) {
+	if mt.trackMisses && ent != nil {
+		mt.misses = append(mt.misses, ent)
+	}
+}
+
+// deadLetter is the per processor dead letter state held by a ProcessorSet
+type deadLetter struct {
+	name string
+	tag  entry.EntryTag
+}
+
+func (dl *deadLetter) route(ent *entry.Entry, reason string) *entry.Entry {
+	ent.Tag = dl.tag
+	ent.AddEnumeratedValueEx(DeadLetterProcessorEV, dl.name)
+	ent.AddEnumeratedValueEx(DeadLetterErrorEV, reason)
+	return ent
+}
+
+// collectMisses drains the misses from the processor and routes them to the dead letter tag
+func (dl *deadLetter) collectMisses(p Processor, set []*entry.Entry) []*entry.Entry {
+	if mr, ok := p.(missReporter); ok {
+		for _, ent := range mr.Misses() {
+			set = append(set, dl.route(ent, deadLetterMiss))
+		}
+	}
+	return set
+}
+
+// isolateErrors re-runs a failed batch through the processor one entry at a time, entries
+// which still fail are routed to the dead letter tag and everything else is passed on.
+// A processor may return the entries it had already handed on along with its error, those
+// and the entries the failed batch missed are final and are not run a second time.
+// saved holds deep copies of the entries as they were before the failed batch touched them.
+func (dl *deadLetter) isolateErrors(p Processor, in []*entry.Entry, saved []entry.Entry, done, dead []*entry.Entry) (out, rdead []*entry.Entry) {
+	// drain the misses from the failed batch so that a retry can't report them a second time
+	rdead = dl.collectMisses(p, dead)
+	handled := make(map[*entry.Entry]bool, len(done)+len(rdead)-len(dead))
+	for _, ent := range done {
+		handled[ent] = true
+	}
+	for _, ent := range rdead[len(dead):] {
+		handled[ent] = true
+	}
+	out = append(out, done...) //never append into the processors backing array
+	for j, ent := range in {
+		if ent == nil || handled[ent] {
+			continue
+		}
+		*ent = saved[j]
+		r, err := p.Process([]*entry.Entry{ent})
+		if err != nil {
+			rdead = append(rdead, dl.route(ent, err.Error()))
+			continue
+		}
+		out = append(out, r...)
+	}
+	return
+}
@@ -0,0 +1,200 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+var errTestFailure = errors.New("test failure")
+
+// failProcessor returns an error for any entry whose data matches
+type failProcessor struct {
+	nocloser
+	match string
+}
+
+func (fp *failProcessor) Process(ents []*entry.Entry) ([]*entry.Entry, error) {
+	for _, ent := range ents {
+		if string(ent.Data) == fp.match {
+			return nil, errTestFailure
+		}
+	}
+	return ents, nil
+}
+
+// sideEffectProcessor counts every entry it sees, scribbles on the entry that fails and
+// hands back what it already passed on along with the error
+type sideEffectProcessor struct {
+	nocloser
+	missTracker
+	calls map[string]int
+}
+
+func (sp *sideEffectProcessor) Process(ents []*entry.Entry) (out []*entry.Entry, err error) {
+	for _, ent := range ents {
+		sp.calls[string(ent.Data)]++
+		switch string(ent.Data) {
+		case `miss`:
+			sp.miss(ent)
+		case `bad`:
+			ent.Data[0] = 'X'
+			return out, errTestFailure
+		default:
+			out = append(out, ent)
+		}
+	}
+	return
+}
+
+func TestDeadLetterMisses(t *testing.T) {
+	b := []byte(`
+	[preprocessor "rtr"]
+		type = regexrouter
+		Regex="^(?P<app>\\S+)"
+		Route-Extraction=app
+		Route=foo:foo
+		Route=bar:
+		Drop-Misses=true
+		Dead-Letter-Tag=dlq
+	`)
+	tc := loadTestConfig(t, b)
+	var tw testTagWriter
+	ps, err := tc.Preprocessor.ProcessorSet(&tw, []string{`rtr`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dlq, err := tw.NegotiateTag(`dlq`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	foo, err := tw.NegotiateTag(`foo`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ents := []*entry.Entry{
+		{TS: entry.Now(), Tag: 42, Data: []byte(`foo data`)},
+		{TS: entry.Now(), Tag: 42, Data: []byte(`bar data`)}, // explicit drop
+		{TS: entry.Now(), Tag: 42, Data: []byte(`baz data`)}, // miss
+	}
+	if err := ps.ProcessBatch(ents); err != nil {
+		t.Fatal(err)
+	}
+	if len(tw.ents) != 2 {
+		t.Fatalf("bad output count: %d", len(tw.ents))
+	}
+	if tw.ents[0].Tag != foo || string(tw.ents[0].Data) != `foo data` {
+		t.Fatalf("bad routed entry: %d %s", tw.ents[0].Tag, tw.ents[0].Data)
+	}
+	ent := tw.ents[1]
+	if ent.Tag != dlq || string(ent.Data) != `baz data` {
+		t.Fatalf("bad dead letter entry: %d %s", ent.Tag, ent.Data)
+	}
+	checkDeadLetterEVs(t, ent, `rtr`, deadLetterMiss)
+}
+
+func TestDeadLetterErrors(t *testing.T) {
+	var tw testWriter
+	ps := NewProcessorSet(&tw)
+	ps.AddDeadLetterProcessor(`fail`, ``, &failProcessor{match: `bad`}, 7)
+
+	ents := []*entry.Entry{
+		{TS: entry.Now(), Data: []byte(`good`)},
+		{TS: entry.Now(), Data: []byte(`bad`)},
+	}
+	if err := ps.ProcessBatch(ents); err != nil {
+		t.Fatal(err)
+	}
+	if len(tw.ents) != 2 {
+		t.Fatalf("bad output count: %d", len(tw.ents))
+	}
+	// only the entry that causes the error is dead lettered, the good one passes through
+	for _, ent := range tw.ents {
+		switch string(ent.Data) {
+		case `good`:
+			if ent.Tag != 0 || ent.EVCount() != 0 {
+				t.Fatalf("good entry was dead lettered: %d", ent.Tag)
+			}
+		case `bad`:
+			if ent.Tag != 7 {
+				t.Fatalf("entry not dead lettered: %d", ent.Tag)
+			}
+			checkDeadLetterEVs(t, ent, `fail`, errTestFailure.Error())
+		default:
+			t.Fatalf("unexpected entry %q", ent.Data)
+		}
+	}
+
+	// without a dead letter tag the error still propagates
+	ps = NewProcessorSet(&tw)
+	ps.AddNamedProcessor(`fail`, ``, &failProcessor{match: `bad`})
+	if err := ps.Process(&entry.Entry{Data: []byte(`bad`)}); err != errTestFailure {
+		t.Fatalf("did not get expected error: %v", err)
+	}
+}
+
+func TestDeadLetterIsolation(t *testing.T) {
+	var tw testWriter
+	ps := NewProcessorSet(&tw)
+	sp := &sideEffectProcessor{calls: map[string]int{}}
+	ps.AddDeadLetterProcessor(`se`, ``, sp, 7)
+
+	ents := []*entry.Entry{
+		{TS: entry.Now(), Data: []byte(`first`)},
+		{TS: entry.Now(), Data: []byte(`miss`)},
+		{TS: entry.Now(), Data: []byte(`bad`)},
+		{TS: entry.Now(), Data: []byte(`last`)},
+	}
+	ents[2].AddEnumeratedValueEx(`ev`, `orig`)
+	if err := ps.ProcessBatch(ents); err != nil {
+		t.Fatal(err)
+	}
+	// entries the failed batch handed on or missed are not run again, the failed entry is
+	// retried from an unmodified copy and anything the batch never reached is run once
+	for k, v := range map[string]int{`first`: 1, `miss`: 1, `bad`: 2, `last`: 1} {
+		if sp.calls[k] != v {
+			t.Fatalf("%s was processed %d times, expected %d", k, sp.calls[k], v)
+		}
+	}
+	if len(tw.ents) != 4 {
+		t.Fatalf("bad output count: %d", len(tw.ents))
+	}
+	var misses, errs int
+	for _, ent := range tw.ents {
+		if ent.Tag != 7 {
+			continue
+		}
+		v, _ := ent.GetEnumeratedValue(DeadLetterErrorEV)
+		switch v {
+		case deadLetterMiss:
+			misses++
+		case errTestFailure.Error():
+			errs++
+			if ev, ok := ent.GetEnumeratedValue(`ev`); !ok || ev != `orig` {
+				t.Fatalf("failed entry lost its EVs: %v", ev)
+			}
+		}
+	}
+	if misses != 1 || errs != 1 {
+		t.Fatalf("bad dead letter counts: %d misses %d errors", misses, errs)
+	}
+}
+
+func checkDeadLetterEVs(t *testing.T, ent *entry.Entry, name, reason string) {
+	t.Helper()
+	if v, ok := ent.GetEnumeratedValue(DeadLetterProcessorEV); !ok || v != name {
+		t.Fatalf("bad %s EV: %v %v", DeadLetterProcessorEV, v, ok)
+	}
+	if v, ok := ent.GetEnumeratedValue(DeadLetterErrorEV); !ok || v != reason {
+		t.Fatalf("bad %s EV: %v %v", DeadLetterErrorEV, v, ok)
+	}
+}
@@ -30,10 +30,12 @@ import (
 const (
 	ForwarderProcessor string = `forwarder`
 
-	protoTCP  string = `tcp`
-	protoUDP  string = `udp`
-	protoTLS  string = `tls`
-	protoUnix string = `unix`
+	protoTCP   string = `tcp`
+	protoUDP   string = `udp`
+	protoTLS   string = `tls`
+	protoUnix  string = `unix`
+	protoHTTP  string = `http`
+	protoKafka string = `kafka`
 
 	defaultProto string = protoTCP
 
@@ -44,6 +46,12 @@ const (
 	defaultBuffer uint = 256
 
 	redialInterval = time.Second
+
+	defaultBatchSize     uint = 512
+	defaultBatchInterval      = time.Second
+	defaultRetries       uint = 3
+	defaultRetryBackoff       = 500 * time.Millisecond
+	maxRetryBackoff           = 30 * time.Second
 )
 
 var (
@@ -53,6 +61,7 @@ var (
 	ErrUnknownFormat   = errors.New("Unknown format")
 	ErrClosed          = errors.New("Closed")
 	ErrNilTagger       = errors.New("invalid parameter, missing tagger")
+	ErrMissingTopic    = errors.New("Kafka-Topic is required for the kafka protocol")
 )
 
 type ForwarderConfig struct {
@@ -67,6 +76,26 @@ type ForwarderConfig struct {
 	Buffer                   uint //number of entries in flight (basically channel buffer size)
 	Non_Blocking             bool
 	Insecure_Skip_TLS_Verify bool
+
+	// batching options used by the http and kafka protocols
+	Batch_Size     uint   // maximum entries per request, defaults to 512
+	Batch_Interval string // maximum time an entry waits for a batch to fill, defaults to 1s
+	Retries        uint   // attempts after a failed send before a non-blocking forwarder drops the batch, defaults to 3
+	Retry_Backoff  string // initial delay between retries, doubles on each attempt, defaults to 500ms
+	Username       string // HTTP basic auth or Kafka SASL/PLAIN credentials
+	Password       string
+
+	// http protocol options
+	Header []string // additional request headers in "Name: Value" form
+
+	// kafka protocol options
+	Kafka_Topic string
+	Kafka_Key   string // tag, source, or ev:<name>, defaults to no key
+	Kafka_TLS   bool
+
+	// entries which can't be buffered in memory are spooled to disk and drained in order
+	Spool_Path string
+	Spool_Size string // maximum size of the spool file, defaults to 256MB
 }
 
 func ForwarderLoadConfig(vc *config.VariableConfig) (c ForwarderConfig, err error) {
@@ -88,6 +117,8 @@ type Forwarder struct {
 	abrt         chan struct{} //used to abort blocked writes
 	conn         net.Conn
 	enc          EntryEncoder
+	bs           batchSender // used instead of conn and enc by the batching protocols
+	sp           *spooler
 	err          error
 	closed       bool
 	tagFilters   map[entry.EntryTag]struct{}
@@ -133,6 +164,22 @@ func NewForwarder(cfg ForwarderConfig, tgr Tagger) (nf *Forwarder, err error) {
 	}
 
 	nf.ctx, nf.cf = context.WithCancel(context.Background())
+	if cfg.Spool_Path != `` {
+		var capacity int
+		if capacity, err = spoolCapacity(cfg.Spool_Path, cfg.Spool_Size); err != nil {
+			return
+		} else if nf.sp, err = newSpooler(cfg.Spool_Path, capacity, tgr, nil, nf.spoolWrite); err != nil {
+			return
+		}
+	}
+	if cfg.batching() {
+		if nf.bs, err = nf.newBatchSender(); err != nil {
+			return
+		}
+		nf.wg.Add(1)
+		go nf.batchRoutine()
+		return
+	}
 	if !nf.Non_Blocking {
 		if conn, err = nf.newConnection(false); err != nil {
 			return
@@ -146,23 +193,69 @@ func NewForwarder(cfg ForwarderConfig, tgr Tagger) (nf *Forwarder, err error) {
 func (nf *Forwarder) Process(ents []*entry.Entry) ([]*entry.Entry, error) {
 	nf.Lock()
 	if !nf.closed {
+		var spill []*entry.Entry
 		for _, ent := range ents {
 			if ent == nil {
 				continue
 			}
 			if !nf.filter(ent) {
-				if nf.Non_Blocking {
+				if nf.sp != nil {
+					spill = nf.spoolingProcess(ent, spill)
+				} else if nf.Non_Blocking {
 					nf.nonblockingProcess(ent)
 				} else {
 					nf.blockingProcess(ent)
 				}
 			}
 		}
+		if len(spill) > 0 {
+			nf.sp.push(spill) //a spool that can't be written to behaves like a non-blocking forwarder
+		}
 	}
 	nf.Unlock()
 	return ents, nil
 }
 
+// spoolingProcess sends the entry straight to the in memory buffer unless the buffer is full
+// or the spool is already holding entries, in which case the entry joins the spill set
+func (nf *Forwarder) spoolingProcess(ent *entry.Entry, spill []*entry.Entry) []*entry.Entry {
+	if len(spill) == 0 && !nf.sp.active() {
+		select {
+		case nf.ch <- ent:
+			return spill
+		default:
+		}
+	}
+	return append(spill, ent)
+}
+
+// spoolWrite feeds spooled entries back into the in memory buffer
+func (nf *Forwarder) spoolWrite(ctx context.Context, ents []*entry.Entry) error {
+	for _, ent := range ents {
+		select {
+		case nf.ch <- ent:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-nf.abrt:
+			return ErrClosed
+		}
+	}
+	return nil
+}
+
+// spoolRemainder pushes anything left in the in memory buffer to the spool, it must
+// only be called once the channel is closed and the routine has exited
+func (nf *Forwarder) spoolRemainder(ents ...*entry.Entry) {
+	for ent := range nf.ch {
+		if ent != nil {
+			ents = append(ents, ent)
+		}
+	}
+	if len(ents) > 0 {
+		nf.sp.push(ents)
+	}
+}
+
 func (nf *Forwarder) blockingProcess(ent *entry.Entry) {
 	select {
 	case <-nf.abrt: //aborted on close
@@ -233,6 +326,9 @@ func (nf *Forwarder) Close() (err error) {
 	close(nf.abrt)
 	nf.Lock()
 	nf.closed = true
+	if nf.sp != nil {
+		nf.sp.stop() //stop draining the spool before the channel closes
+	}
 	//close the channel
 	close(nf.ch)
 	defer nf.Unlock()
@@ -240,6 +336,12 @@ func (nf *Forwarder) Close() (err error) {
 	nf.wait(nf.Timeout)
 	//if we hit here we KNOW the routine exited
 	err = nf.err
+	if nf.sp != nil {
+		nf.spoolRemainder()
+		if lerr := nf.sp.close(); lerr != nil && err == nil {
+			err = lerr
+		}
+	}
 	return
 }
 
@@ -288,6 +390,9 @@ func (nf *Forwarder) routine(conn net.Conn) {
 
 	for ent, ok := nf.getEnt(); ok == true; ent, ok = nf.getEnt() {
 		if conn, nf.err = nf.sendEntry(ent, conn); nf.err != nil {
+			if nf.err == context.Canceled && nf.sp != nil {
+				nf.sp.push([]*entry.Entry{ent}) //hang onto the entry we were trying to send
+			}
 			break
 		}
 	}
@@ -332,8 +437,13 @@ func (nfc *ForwarderConfig) Validate() (err error) {
 	if nfc.Protocol == `` {
 		nfc.Protocol = defaultProto
 	}
+	nfc.Protocol = strings.ToLower(nfc.Protocol)
 	if nfc.Format == `` {
-		nfc.Format = defaultFormat
+		if nfc.Protocol == protoHTTP {
+			nfc.Format = encJSON // NDJSON is the lingua franca of HTTP log collectors
+		} else {
+			nfc.Format = defaultFormat
+		}
 	} else {
 		nfc.Format = strings.ToLower(strings.TrimSpace(nfc.Format))
 	}
@@ -344,7 +454,6 @@ func (nfc *ForwarderConfig) Validate() (err error) {
 	if nfc.Buffer == 0 {
 		nfc.Buffer = defaultBuffer
 	}
-	nfc.Protocol = strings.ToLower(nfc.Protocol)
 
 	//check the Protocol against the what was specified in the target
 	//check that the protocol is valid
@@ -384,10 +493,30 @@ func (nfc *ForwarderConfig) Validate() (err error) {
 			err = fmt.Errorf("Unable to resolve host %s: %v", h, err)
 			return
 		}
+	case protoHTTP:
+		if _, err = nfc.parseURL(); err != nil {
+			return
+		} else if _, err = parseHeaders(nfc.Header); err != nil {
+			return
+		}
+	case protoKafka:
+		if nfc.Kafka_Topic = strings.TrimSpace(nfc.Kafka_Topic); nfc.Kafka_Topic == `` {
+			err = ErrMissingTopic
+			return
+		} else if _, err = nfc.kafkaBrokers(); err != nil {
+			return
+		} else if _, err = parseKafkaKey(nfc.Kafka_Key); err != nil {
+			return
+		}
 	default: //everything else better be a host:port pair
 		err = ErrUnknownProtocol
 		return
 	}
+	if nfc.batching() {
+		if _, _, err = nfc.batchParams(); err != nil {
+			return
+		}
+	}
 
 	//check the tags
 	for _, tagname := range nfc.Tag {
@@ -406,6 +535,11 @@ func (nfc *ForwarderConfig) Validate() (err error) {
 	if _, err = parseRegex(nfc.Regex); err != nil {
 		return
 	}
+
+	//check the spool
+	if _, err = spoolCapacity(nfc.Spool_Path, nfc.Spool_Size); err != nil {
+		return
+	}
 	return
 }
 
@@ -0,0 +1,147 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+// batchSender is implemented by forwarder protocols which ship entries in batches
+// rather than streaming them over a net.Conn.
+type batchSender interface {
+	Send(context.Context, []*entry.Entry) error
+	Close() error
+}
+
+// permanentError marks a send failure which will not succeed on retry
+type permanentError struct {
+	error
+}
+
+func (pe permanentError) Unwrap() error {
+	return pe.error
+}
+
+// partialError marks a send where only some of the entries were rejected, the
+// remaining entries are retried rather than resending the whole batch
+type partialError struct {
+	error
+	failed []*entry.Entry
+}
+
+func (pe partialError) Unwrap() error {
+	return pe.error
+}
+
+func (nfc *ForwarderConfig) batching() bool {
+	return nfc.Protocol == protoHTTP || nfc.Protocol == protoKafka
+}
+
+func (nfc *ForwarderConfig) batchParams() (interval, backoff time.Duration, err error) {
+	if nfc.Batch_Size == 0 {
+		nfc.Batch_Size = defaultBatchSize
+	}
+	if nfc.Retries == 0 {
+		nfc.Retries = defaultRetries
+	}
+	if interval, err = parseBound(nfc.Batch_Interval, defaultBatchInterval); err != nil {
+		err = fmt.Errorf("invalid Batch-Interval %q %w", nfc.Batch_Interval, err)
+	} else if backoff, err = parseBound(nfc.Retry_Backoff, defaultRetryBackoff); err != nil {
+		err = fmt.Errorf("invalid Retry-Backoff %q %w", nfc.Retry_Backoff, err)
+	}
+	return
+}
+
+func (nf *Forwarder) newBatchSender() (batchSender, error) {
+	switch nf.Protocol {
+	case protoHTTP:
+		return newHTTPSender(&nf.ForwarderConfig, nf.tgr)
+	case protoKafka:
+		return newKafkaSender(&nf.ForwarderConfig, nf.tgr)
+	}
+	return nil, ErrUnknownProtocol
+}
+
+// batchRoutine collects entries until the batch is full or the interval expires and ships them
+func (nf *Forwarder) batchRoutine() {
+	defer nf.wg.Done()
+	interval, backoff, _ := nf.batchParams() // already validated
+	tckr := time.NewTicker(interval)
+	defer tckr.Stop()
+	batch := make([]*entry.Entry, 0, nf.Batch_Size)
+	for {
+		select {
+		case ent, ok := <-nf.ch:
+			if !ok {
+				nf.sendBatch(batch, backoff)
+				if err := nf.bs.Close(); err != nil && nf.err == nil {
+					nf.err = err
+				}
+				return
+			} else if ent == nil {
+				continue
+			}
+			if batch = append(batch, ent); uint(len(batch)) >= nf.Batch_Size {
+				nf.sendBatch(batch, backoff)
+				batch = batch[:0]
+			}
+		case <-tckr.C:
+			if len(batch) > 0 {
+				nf.sendBatch(batch, backoff)
+				batch = batch[:0]
+			}
+		case <-nf.ctx.Done():
+			if nf.sp != nil && len(batch) > 0 {
+				nf.sp.push(batch)
+			}
+			nf.bs.Close()
+			return
+		}
+	}
+}
+
+// sendBatch attempts to send the batch with exponential backoff between attempts.
+// A non-blocking forwarder drops the batch once the retries are exhausted so that a dead
+// target cannot wedge the ingester, otherwise we retry until we are closed.  When spooling
+// is enabled the spool absorbs the backpressure so we also retry until we are closed.
+func (nf *Forwarder) sendBatch(batch []*entry.Entry, backoff time.Duration) {
+	if len(batch) == 0 {
+		return
+	}
+	var err error
+	for attempt := uint(0); ; attempt++ {
+		var pe partialError
+		if err = nf.bs.Send(nf.ctx, batch); err == nil {
+			return
+		} else if errors.Is(err, context.Canceled) || errors.As(err, &permanentError{}) {
+			break
+		} else if errors.As(err, &pe) && len(pe.failed) > 0 {
+			batch = pe.failed // only retry what the target rejected
+		}
+		if nf.sp == nil && nf.Non_Blocking && attempt >= nf.Retries {
+			break
+		} else if nf.sleep(backoff) {
+			err = context.Canceled
+			break
+		}
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+	if nf.sp != nil && errors.Is(err, context.Canceled) {
+		nf.sp.push(batch) //we are shutting down, the batch is sent on the next start
+		return
+	}
+	nf.err = fmt.Errorf("dropped batch of %d entries: %w", len(batch), err)
+}
@@ -0,0 +1,151 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	defaultHTTPTimeout = 30 * time.Second
+	maxErrorBody       = 512
+)
+
+func (nfc *ForwarderConfig) parseURL() (u *url.URL, err error) {
+	if u, err = url.Parse(nfc.Target); err != nil {
+		return
+	} else if u.Scheme != `http` && u.Scheme != `https` {
+		err = fmt.Errorf("Target %q must be an http or https URL", nfc.Target)
+	} else if u.Host == `` {
+		err = fmt.Errorf("Target %q is missing a host", nfc.Target)
+	}
+	return
+}
+
+func parseHeaders(hdrs []string) (h http.Header, err error) {
+	h = http.Header{}
+	for _, v := range hdrs {
+		name, val, ok := strings.Cut(v, `:`)
+		if name = strings.TrimSpace(name); !ok || name == `` {
+			err = fmt.Errorf("Invalid header %q, must be of the form Name: Value", v)
+			return
+		}
+		h.Add(name, strings.TrimSpace(val))
+	}
+	return
+}
+
+// httpSender POSTs each batch to the target, JSON formatted batches are sent as NDJSON
+type httpSender struct {
+	url     string
+	user    string
+	pass    string
+	ctype   string
+	headers http.Header
+	clnt    *http.Client
+	bb      bytes.Buffer
+	enc     EntryEncoder
+}
+
+func newHTTPSender(cfg *ForwarderConfig, tgr Tagger) (hs *httpSender, err error) {
+	var u *url.URL
+	if u, err = cfg.parseURL(); err != nil {
+		return
+	}
+	hs = &httpSender{
+		url:  u.String(),
+		user: cfg.Username,
+		pass: cfg.Password,
+	}
+	if hs.headers, err = parseHeaders(cfg.Header); err != nil {
+		return
+	}
+	switch cfg.Format {
+	case encJSON:
+		hs.ctype = `application/x-ndjson`
+		hs.enc, err = newJSONEncoder(&hs.bb, tgr)
+	case encRaw:
+		hs.ctype = `text/plain`
+		hs.enc, err = newRawEncoder(&hs.bb, []byte(cfg.Delimiter))
+	case encSYSLOG:
+		hs.ctype = `text/plain`
+		hs.enc, err = newSyslogEncoder(&hs.bb, tgr)
+	default:
+		err = ErrUnknownFormat
+	}
+	if err != nil {
+		return
+	}
+	to := defaultHTTPTimeout
+	if cfg.Timeout > 0 {
+		to = time.Duration(cfg.Timeout) * time.Second
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: cfg.Insecure_Skip_TLS_Verify,
+	}
+	hs.clnt = &http.Client{
+		Transport: tr,
+		Timeout:   to,
+	}
+	return
+}
+
+func (hs *httpSender) Send(ctx context.Context, ents []*entry.Entry) (err error) {
+	hs.bb.Reset()
+	for _, ent := range ents {
+		if err = hs.enc.Encode(ent); err != nil {
+			return
+		}
+	}
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPost, hs.url, bytes.NewReader(hs.bb.Bytes())); err != nil {
+		return
+	}
+	for k, v := range hs.headers {
+		req.Header[k] = v
+	}
+	if req.Header.Get(`Content-Type`) == `` {
+		req.Header.Set(`Content-Type`, hs.ctype)
+	}
+	if hs.user != `` || hs.pass != `` {
+		req.SetBasicAuth(hs.user, hs.pass)
+	}
+	var resp *http.Response
+	if resp, err = hs.clnt.Do(req); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, resp.Body) //drain so the connection can be reused
+		return
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	err = fmt.Errorf("%s returned %s: %s", hs.url, resp.Status, bytes.TrimSpace(body))
+	// anything other than throttling or a server side failure won't get better on retry
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode < 500 {
+		err = permanentError{err}
+	}
+	return
+}
+
+func (hs *httpSender) Close() error {
+	hs.clnt.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,235 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	kafkaKeyNone   string = ``
+	kafkaKeyTag    string = `tag`
+	kafkaKeySource string = `source`
+	kafkaKeyEVPfx  string = `ev:`
+
+	kafkaClientID     string = `gravwell_forwarder`
+	kafkaVersion      string = `2.1.1`
+	kafkaMinTLS              = tls.VersionTLS12
+	defaultKafkaDial         = 10 * time.Second
+	kafkaMaxKeyLength        = 1024
+)
+
+func (nfc *ForwarderConfig) kafkaBrokers() (brokers []string, err error) {
+	for _, b := range strings.Split(nfc.Target, `,`) {
+		if b = strings.TrimSpace(b); b == `` {
+			continue
+		} else if _, _, err = net.SplitHostPort(b); err != nil {
+			err = fmt.Errorf("Invalid Kafka broker %q: %w", b, err)
+			return
+		}
+		brokers = append(brokers, b)
+	}
+	if len(brokers) == 0 {
+		err = ErrMissingTarget
+	}
+	return
+}
+
+// parseKafkaKey returns the key selector, an empty EV name means the selector is not an EV
+func parseKafkaKey(v string) (key string, err error) {
+	key = strings.TrimSpace(v)
+	switch strings.ToLower(key) {
+	case kafkaKeyNone:
+	case kafkaKeyTag, kafkaKeySource:
+		key = strings.ToLower(key)
+	default:
+		if !strings.HasPrefix(strings.ToLower(key), kafkaKeyEVPfx) || len(key) == len(kafkaKeyEVPfx) {
+			err = fmt.Errorf("Invalid Kafka-Key %q, must be tag, source, or ev:<name>", v)
+		}
+	}
+	return
+}
+
+// kafkaSender produces one message per entry, the producer is established lazily so that
+// an unreachable cluster is retried with the same backoff as a failed send
+type kafkaSender struct {
+	cfg     *sarama.Config
+	brokers []string
+	topic   string
+	key     string
+	format  string
+	tt      *tagTrans
+	prod    sarama.SyncProducer
+	bb      bytes.Buffer
+	enc     EntryEncoder
+}
+
+func newKafkaSender(cfg *ForwarderConfig, tgr Tagger) (ks *kafkaSender, err error) {
+	ks = &kafkaSender{
+		topic:  cfg.Kafka_Topic,
+		format: cfg.Format,
+		tt:     newTagTrans(tgr),
+	}
+	if ks.brokers, err = cfg.kafkaBrokers(); err != nil {
+		return
+	} else if ks.key, err = parseKafkaKey(cfg.Kafka_Key); err != nil {
+		return
+	}
+	switch cfg.Format {
+	case encRaw: // the raw entry data is the message, no delimiter
+	case encJSON:
+		ks.enc, err = newJSONEncoder(&ks.bb, tgr)
+	case encSYSLOG:
+		ks.enc, err = newSyslogEncoder(&ks.bb, tgr)
+	default:
+		err = ErrUnknownFormat
+	}
+	if err != nil {
+		return
+	}
+	kc := sarama.NewConfig()
+	if kc.Version, err = sarama.ParseKafkaVersion(kafkaVersion); err != nil {
+		return
+	}
+	kc.ClientID = kafkaClientID
+	kc.Producer.Return.Successes = true // required by the sync producer
+	kc.Producer.RequiredAcks = sarama.WaitForAll
+	kc.Producer.Retry.Max = 0 // the forwarder handles retries
+	kc.Net.DialTimeout = defaultKafkaDial
+	if cfg.Timeout > 0 {
+		kc.Net.DialTimeout = time.Duration(cfg.Timeout) * time.Second
+		kc.Net.WriteTimeout = kc.Net.DialTimeout
+		kc.Net.ReadTimeout = kc.Net.DialTimeout
+	}
+	if cfg.Kafka_TLS {
+		kc.Net.TLS.Enable = true
+		kc.Net.TLS.Config = &tls.Config{
+			InsecureSkipVerify: cfg.Insecure_Skip_TLS_Verify,
+			MinVersion:         kafkaMinTLS,
+		}
+	}
+	if cfg.Username != `` {
+		kc.Net.SASL.Enable = true
+		kc.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		kc.Net.SASL.User = cfg.Username
+		kc.Net.SASL.Password = cfg.Password
+	}
+	if err = kc.Validate(); err != nil {
+		return
+	}
+	ks.cfg = kc
+	return
+}
+
+func (ks *kafkaSender) Send(ctx context.Context, ents []*entry.Entry) (err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	msgs := make([]*sarama.ProducerMessage, 0, len(ents))
+	for _, ent := range ents {
+		var msg *sarama.ProducerMessage
+		if msg, err = ks.message(ent); err != nil {
+			return permanentError{err}
+		}
+		msgs = append(msgs, msg)
+	}
+	if ks.prod == nil {
+		if ks.prod, err = sarama.NewSyncProducer(ks.brokers, ks.cfg); err != nil {
+			return
+		}
+	}
+	if err = ks.prod.SendMessages(msgs); err != nil {
+		// tear down the producer so the next attempt starts with fresh broker connections
+		ks.prod.Close()
+		ks.prod = nil
+		err = kafkaPartialError(err)
+	}
+	return
+}
+
+// kafkaPartialError maps the messages the brokers rejected back to their entries so
+// that a retry does not duplicate the messages which were accepted
+func kafkaPartialError(err error) error {
+	var perrs sarama.ProducerErrors
+	if !errors.As(err, &perrs) || len(perrs) == 0 {
+		return err
+	}
+	pe := partialError{error: err}
+	for _, v := range perrs {
+		if v == nil || v.Msg == nil {
+			return err // can't tell what failed, resend everything
+		}
+		ent, ok := v.Msg.Metadata.(*entry.Entry)
+		if !ok {
+			return err
+		}
+		pe.failed = append(pe.failed, ent)
+	}
+	return pe
+}
+
+func (ks *kafkaSender) message(ent *entry.Entry) (msg *sarama.ProducerMessage, err error) {
+	msg = &sarama.ProducerMessage{
+		Topic:     ks.topic,
+		Timestamp: ent.TS.StandardTime(),
+		Metadata:  ent,
+	}
+	if ks.enc == nil {
+		msg.Value = sarama.ByteEncoder(ent.Data)
+	} else {
+		ks.bb.Reset()
+		if err = ks.enc.Encode(ent); err != nil {
+			return
+		}
+		msg.Value = sarama.ByteEncoder(bytes.TrimRight(append([]byte(nil), ks.bb.Bytes()...), "\n"))
+	}
+	if key := ks.messageKey(ent); key != nil {
+		msg.Key = sarama.ByteEncoder(key)
+	}
+	return
+}
+
+// messageKey returns the partitioning key for an entry, nil means no key
+func (ks *kafkaSender) messageKey(ent *entry.Entry) (key []byte) {
+	switch ks.key {
+	case kafkaKeyNone:
+	case kafkaKeyTag:
+		key = []byte(ks.tt.TagName(ent.Tag))
+	case kafkaKeySource:
+		if ent.SRC != nil {
+			key = []byte(ent.SRC.String())
+		}
+	default:
+		if v, ok := ent.GetEnumeratedValue(ks.key[len(kafkaKeyEVPfx):]); ok {
+			key = []byte(fmt.Sprintf("%v", v))
+		}
+	}
+	if len(key) > kafkaMaxKeyLength {
+		key = key[:kafkaMaxKeyLength]
+	}
+	return
+}
+
+func (ks *kafkaSender) Close() (err error) {
+	if ks.prod != nil {
+		err = ks.prod.Close()
+		ks.prod = nil
+	}
+	return
+}
@@ -0,0 +1,301 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+type httpCapture struct {
+	sync.Mutex
+	fails int // number of requests to reject with a 503 before accepting
+	reqs  int
+	lines []map[string]interface{}
+	hdrs  http.Header
+	user  string
+	pass  string
+}
+
+func (hc *httpCapture) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hc.Lock()
+	defer hc.Unlock()
+	hc.reqs++
+	if hc.fails > 0 {
+		hc.fails--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	hc.hdrs = r.Header.Clone()
+	hc.user, hc.pass, _ = r.BasicAuth()
+	sc := bufio.NewScanner(r.Body)
+	for sc.Scan() {
+		var v map[string]interface{}
+		if err := json.Unmarshal(sc.Bytes(), &v); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		hc.lines = append(hc.lines, v)
+	}
+}
+
+func TestForwarderHTTP(t *testing.T) {
+	hc := &httpCapture{fails: 1}
+	srv := httptest.NewServer(hc)
+	defer srv.Close()
+
+	var tt testTagger
+	foo, _ := tt.NegotiateTag(`foo`)
+	bar, _ := tt.NegotiateTag(`bar`)
+	nf, err := NewForwarder(ForwarderConfig{
+		Target:        srv.URL + `/ingest`,
+		Protocol:      `HTTP`,
+		Tag:           []string{`foo`},
+		Header:        []string{`X-Api-Key: secret`},
+		Username:      `user`,
+		Password:      `pass`,
+		Batch_Size:    2,
+		Retry_Backoff: `1ms`,
+	}, &tt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ents := []*entry.Entry{
+		{TS: entry.Now(), Tag: foo, SRC: net.ParseIP("10.0.0.1"), Data: []byte(`one`)},
+		{TS: entry.Now(), Tag: bar, Data: []byte(`filtered`)},
+		{TS: entry.Now(), Tag: foo, Data: []byte(`two`)},
+		{TS: entry.Now(), Tag: foo, Data: []byte(`three`)},
+	}
+	if set, err := nf.Process(ents); err != nil {
+		t.Fatal(err)
+	} else if len(set) != len(ents) {
+		t.Fatalf("forwarder altered the entry set: %d", len(set))
+	}
+	if err = nf.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	hc.Lock()
+	defer hc.Unlock()
+	if hc.reqs != 3 { // a failure, a full batch, and the remainder flushed on close
+		t.Fatalf("bad request count: %d", hc.reqs)
+	} else if len(hc.lines) != 3 {
+		t.Fatalf("bad line count: %d", len(hc.lines))
+	}
+	for i, exp := range []string{`one`, `two`, `three`} {
+		// entry data is a byte slice and is base64 encoded in JSON
+		if hc.lines[i][`Tag`] != `foo` || hc.lines[i][`Data`] != base64.StdEncoding.EncodeToString([]byte(exp)) {
+			t.Fatalf("bad line %d: %v", i, hc.lines[i])
+		}
+	}
+	if hc.hdrs.Get(`X-Api-Key`) != `secret` || hc.hdrs.Get(`Content-Type`) != `application/x-ndjson` {
+		t.Fatalf("bad request headers: %v", hc.hdrs)
+	} else if hc.user != `user` || hc.pass != `pass` {
+		t.Fatalf("bad basic auth: %s %s", hc.user, hc.pass)
+	}
+}
+
+func TestForwarderHTTPPermanentFailure(t *testing.T) {
+	var reqs int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqs++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+	var tt testTagger
+	nf, err := NewForwarder(ForwarderConfig{
+		Target:        srv.URL,
+		Protocol:      protoHTTP,
+		Retry_Backoff: `1ms`,
+	}, &tt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nf.Process([]*entry.Entry{{TS: entry.Now(), Data: []byte(`test`)}})
+	if err = nf.Close(); err == nil {
+		t.Fatal("dropped batch was not reported")
+	} else if reqs != 1 {
+		t.Fatalf("permanent failure was retried: %d", reqs)
+	}
+}
+
+func TestForwarderHTTPRetries(t *testing.T) {
+	for _, nb := range []bool{false, true} {
+		hc := &httpCapture{fails: 5}
+		srv := httptest.NewServer(hc)
+		var tt testTagger
+		nf, err := NewForwarder(ForwarderConfig{
+			Target:        srv.URL,
+			Protocol:      protoHTTP,
+			Non_Blocking:  nb,
+			Retries:       2,
+			Retry_Backoff: `1ms`,
+		}, &tt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nf.Process([]*entry.Entry{{TS: entry.Now(), Data: []byte(`test`)}})
+		err = nf.Close()
+		srv.Close()
+		hc.Lock()
+		if nb {
+			// non-blocking forwarders give up once the retries are exhausted
+			if err == nil || hc.reqs != 3 || len(hc.lines) != 0 {
+				t.Fatalf("non-blocking batch was not dropped: %v %d %d", err, hc.reqs, len(hc.lines))
+			}
+		} else if err != nil || hc.reqs != 6 || len(hc.lines) != 1 {
+			t.Fatalf("blocking batch was not retried until delivered: %v %d %d", err, hc.reqs, len(hc.lines))
+		}
+		hc.Unlock()
+	}
+}
+
+// partialSender rejects a single entry on the first send
+type partialSender struct {
+	sends [][]*entry.Entry
+}
+
+func (ps *partialSender) Send(ctx context.Context, ents []*entry.Entry) error {
+	ps.sends = append(ps.sends, append([]*entry.Entry(nil), ents...))
+	if len(ps.sends) == 1 {
+		return partialError{error: errors.New("rejected"), failed: ents[1:2]}
+	}
+	return nil
+}
+
+func (ps *partialSender) Close() error { return nil }
+
+func TestForwarderPartialRetry(t *testing.T) {
+	ps := &partialSender{}
+	nf := &Forwarder{bs: ps}
+	nf.ctx, nf.cf = context.WithCancel(context.Background())
+	defer nf.cf()
+	batch := makeEntrySet([]byte(`partial`), 0, 3)
+	nf.sendBatch(batch, time.Millisecond)
+	if nf.err != nil {
+		t.Fatal(nf.err)
+	} else if len(ps.sends) != 2 || len(ps.sends[0]) != 3 {
+		t.Fatalf("bad sends %v", ps.sends)
+	} else if len(ps.sends[1]) != 1 || ps.sends[1][0] != batch[1] {
+		t.Fatalf("retry did not send only the rejected entry: %v", ps.sends[1])
+	}
+}
+
+func TestKafkaPartialError(t *testing.T) {
+	var tt testTagger
+	ks, err := newKafkaSender(&ForwarderConfig{Target: `localhost:9092`, Kafka_Topic: `logs`, Format: encRaw}, &tt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ents := makeEntrySet([]byte(`kafka`), 0, 3)
+	var msgs []*sarama.ProducerMessage
+	for _, ent := range ents {
+		msg, err := ks.message(ent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	perrs := sarama.ProducerErrors{
+		{Msg: msgs[0], Err: sarama.ErrNotLeaderForPartition},
+		{Msg: msgs[2], Err: sarama.ErrRequestTimedOut},
+	}
+	var pe partialError
+	if err = kafkaPartialError(perrs); !errors.As(err, &pe) {
+		t.Fatalf("producer errors were not mapped to a partial error: %v", err)
+	} else if len(pe.failed) != 2 || pe.failed[0] != ents[0] || pe.failed[1] != ents[2] {
+		t.Fatalf("bad failed set %v", pe.failed)
+	}
+	other := errors.New("broker down")
+	if err = kafkaPartialError(other); err != other {
+		t.Fatalf("unrelated error was altered: %v", err)
+	}
+}
+
+func TestForwarderBatchConfig(t *testing.T) {
+	bad := []ForwarderConfig{
+		{Target: `ftp://example.com`, Protocol: protoHTTP},
+		{Target: `http://`, Protocol: protoHTTP},
+		{Target: `http://example.com`, Protocol: protoHTTP, Header: []string{`nocolon`}},
+		{Target: `http://example.com`, Protocol: protoHTTP, Batch_Interval: `never`},
+		{Target: `broker:9092`, Protocol: protoKafka},
+		{Target: `broker`, Protocol: protoKafka, Kafka_Topic: `logs`},
+		{Target: `broker:9092`, Protocol: protoKafka, Kafka_Topic: `logs`, Kafka_Key: `ev:`},
+		{Target: `broker:9092`, Protocol: protoKafka, Kafka_Topic: `logs`, Kafka_Key: `host`},
+	}
+	for i, c := range bad {
+		if err := c.Validate(); err == nil {
+			t.Fatalf("failed to catch bad config %d: %+v", i, c)
+		}
+	}
+	c := ForwarderConfig{Target: `http://example.com`, Protocol: protoHTTP}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	} else if c.Format != encJSON || c.Batch_Size != defaultBatchSize || c.Retries != defaultRetries {
+		t.Fatalf("bad defaults: %+v", c)
+	}
+	c = ForwarderConfig{Target: `a:9092, b:9092`, Protocol: protoKafka, Kafka_Topic: `logs`, Kafka_Key: `EV:host`}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	} else if c.Format != encRaw {
+		t.Fatalf("bad kafka default format: %s", c.Format)
+	}
+}
+
+func TestForwarderKafkaMessage(t *testing.T) {
+	var tt testTagger
+	foo, _ := tt.NegotiateTag(`foo`)
+	ent := &entry.Entry{TS: entry.Now(), Tag: foo, SRC: net.ParseIP("10.0.0.1"), Data: []byte(`data`)}
+	ent.AddEnumeratedValueEx(`host`, `web01`)
+	for key, exp := range map[string]string{
+		``:        ``,
+		`tag`:     `foo`,
+		`Source`:  `10.0.0.1`,
+		`ev:host`: `web01`,
+		`ev:nope`: ``,
+	} {
+		ks, err := newKafkaSender(&ForwarderConfig{
+			Target:      `localhost:9092`,
+			Kafka_Topic: `logs`,
+			Kafka_Key:   key,
+			Format:      encRaw,
+		}, &tt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := ks.message(ent)
+		if err != nil {
+			t.Fatal(err)
+		} else if msg.Topic != `logs` {
+			t.Fatalf("bad topic %s", msg.Topic)
+		}
+		if v, _ := msg.Value.Encode(); string(v) != `data` {
+			t.Fatalf("bad message value %q", v)
+		}
+		var k []byte
+		if msg.Key != nil {
+			k, _ = msg.Key.Encode()
+		}
+		if string(k) != exp {
+			t.Fatalf("bad key for %q: %q != %q", key, k, exp)
+		}
+	}
+}
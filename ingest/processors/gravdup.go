@@ -12,6 +12,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -34,25 +35,38 @@ var (
 
 type GravwellForwarderConfig struct {
 	config.IngestConfig
+	Spool_Path string // entries are spooled here while the remote indexers are unavailable
+	Spool_Size string // maximum size of the spool file, defaults to 256MB
 }
 
 func GravwellForwarderLoadConfig(vc *config.VariableConfig) (c GravwellForwarderConfig, err error) {
 	if err = vc.MapTo(&c.IngestConfig); err != nil {
 		return
+	} else if err = vc.MapTo(&c); err != nil {
+		return
 	}
 	err = c.Verify()
 	return
 }
 
+func (c GravwellForwarderConfig) Verify() (err error) {
+	if err = c.IngestConfig.Verify(); err == nil {
+		_, err = spoolCapacity(c.Spool_Path, c.Spool_Size)
+	}
+	return
+}
+
 type GravwellForwarder struct {
 	GravwellForwarderConfig
 	ingest.UniformMuxerConfig
+	tmx sync.Mutex // the spool drain translates tags concurrently with Process
 	tm  map[entry.EntryTag]entry.EntryTag
 	hot bool
 	tgr Tagger
 	ctx context.Context
 	cf  context.CancelFunc
 	mxr *ingest.IngestMuxer
+	sp  *spooler
 }
 
 func NewGravwellForwarder(cfg GravwellForwarderConfig, tgr Tagger) (*GravwellForwarder, error) {
@@ -67,6 +81,10 @@ func NewGravwellForwarder(cfg GravwellForwarderConfig, tgr Tagger) (*GravwellFor
 	if err != nil {
 		return nil, err
 	}
+	spoolCap, err := spoolCapacity(cfg.Spool_Path, cfg.Spool_Size)
+	if err != nil {
+		return nil, err
+	}
 	tgs := tgr.KnownTags()
 
 	mxcfg := ingest.UniformMuxerConfig{
@@ -101,6 +119,13 @@ func NewGravwellForwarder(cfg GravwellForwarderConfig, tgr Tagger) (*GravwellFor
 		mxr:                     mxr,
 	}
 	gf.ctx, gf.cf = context.WithCancel(context.Background())
+	if cfg.Spool_Path != `` {
+		if gf.sp, err = newSpooler(cfg.Spool_Path, spoolCap, tgr, gf.isHot, gf.spoolWrite); err != nil {
+			gf.cf()
+			mxr.Close()
+			return nil, err
+		}
+	}
 
 	return gf, nil
 }
@@ -110,17 +135,48 @@ func (gf *GravwellForwarder) Close() error {
 		return ErrNilGF
 	}
 	gf.cf() //cancel any writes
+	if gf.sp != nil {
+		if err := gf.sp.close(); err != nil {
+			gf.mxr.Close()
+			return err
+		}
+	}
 	if err := gf.mxr.Sync(defaultTimeout); err != nil {
 		return err
 	}
 	return gf.mxr.Close()
 }
 
+// isHot returns true if the muxer has at least one live indexer connection
+func (gf *GravwellForwarder) isHot() bool {
+	n, err := gf.mxr.Hot()
+	return err == nil && n > 0
+}
+
+// spoolWrite hands entries drained from the spool to the muxer
+func (gf *GravwellForwarder) spoolWrite(ctx context.Context, ents []*entry.Entry) (err error) {
+	for _, ent := range ents {
+		var lent entry.Entry
+		if lent, err = gf.translate(ent); err != nil {
+			return
+		} else if err = gf.mxr.WriteEntryContext(ctx, &lent); err != nil {
+			return
+		}
+	}
+	return
+}
+
 func (gf *GravwellForwarder) Flush() []*entry.Entry {
 	return nil
 }
 
 func (gf *GravwellForwarder) Process(ents []*entry.Entry) (r []*entry.Entry, err error) {
+	r = ents
+	//while spooling everything goes to disk so that ordering is preserved
+	if gf.sp != nil && (gf.sp.active() || !gf.isHot()) {
+		err = gf.sp.push(ents)
+		return
+	}
 	//on first call, ensure that our muxer connection is hot
 	if !gf.hot {
 		if err = gf.mxr.WaitForHot(gf.Timeout()); err != nil {
@@ -128,25 +184,10 @@ func (gf *GravwellForwarder) Process(ents []*entry.Entry) (r []*entry.Entry, err
 		}
 		gf.hot = true
 	}
-	r = ents
 	for _, ent := range ents {
 		if ent != nil {
-			var ok bool
-			lent := *ent // this is so that we don't mutate the tag on the underlying entry
-			//lookup the tag to see if we have a translation for it
-			if lent.Tag, ok = gf.tm[ent.Tag]; !ok {
-				//figure out what the tag name is an try to negotiate it
-				var tagname string
-				if ent.Tag == entry.GravwellTagId {
-					tagname = entry.GravwellTagName
-				} else if tagname, ok = gf.tgr.LookupTag(ent.Tag); !ok {
-					err = ErrFailedTagLookup
-				} else if lent.Tag, err = gf.mxr.NegotiateTag(tagname); err == nil {
-					//negotiated, so go ahead and update our local map
-					gf.tm[ent.Tag] = lent.Tag
-				}
-			}
-			if err == nil {
+			var lent entry.Entry
+			if lent, err = gf.translate(ent); err == nil {
 				err = gf.mxr.WriteEntry(&lent)
 			}
 		}
@@ -154,13 +195,40 @@ func (gf *GravwellForwarder) Process(ents []*entry.Entry) (r []*entry.Entry, err
 	return
 }
 
+// translate returns a copy of the entry with the tag translated to the remote muxer's tag,
+// this is so that we don't mutate the tag on the underlying entry
+func (gf *GravwellForwarder) translate(ent *entry.Entry) (lent entry.Entry, err error) {
+	var ok bool
+	gf.tmx.Lock()
+	defer gf.tmx.Unlock()
+	lent = *ent
+	//lookup the tag to see if we have a translation for it
+	if lent.Tag, ok = gf.tm[ent.Tag]; !ok {
+		//figure out what the tag name is an try to negotiate it
+		var tagname string
+		if ent.Tag == entry.GravwellTagId {
+			tagname = entry.GravwellTagName
+		} else if tagname, ok = gf.tgr.LookupTag(ent.Tag); !ok {
+			err = ErrFailedTagLookup
+		} else if lent.Tag, err = gf.mxr.NegotiateTag(tagname); err == nil {
+			//negotiated, so go ahead and update our local map
+			gf.tm[ent.Tag] = lent.Tag
+		}
+	}
+	return
+}
+
 // we DO NOT want to ship the ingest secret here, so we mask it off
 func (gfc GravwellForwarderConfig) MarshalJSON() ([]byte, error) {
 	x := struct {
 		config.IngestConfig
 		Ingest_Secret string `json:",omitempty"`
+		Spool_Path    string `json:",omitempty"`
+		Spool_Size    string `json:",omitempty"`
 	}{
 		IngestConfig: gfc.IngestConfig,
+		Spool_Path:   gfc.Spool_Path,
+		Spool_Size:   gfc.Spool_Size,
 	}
 	return json.Marshal(x)
 }
@@ -68,6 +68,7 @@ func (c *JsonExtractConfig) validate() (err error) {
 // JsonExtractor
 type JsonExtractor struct {
 	nocloser
+	missTracker
 	JsonExtractConfig
 	bldr builder
 }
@@ -120,6 +121,7 @@ func (je *JsonExtractor) Process(ents []*entry.Entry) (rset []*entry.Entry, err
 func (je *JsonExtractor) processItem(ent *entry.Entry) *entry.Entry {
 	// if we have drop misses or strict extraction, validate the JSON first
 	if (je.Drop_Misses || je.Strict_Extraction) && json.Valid(ent.Data) == false {
+		je.miss(ent)
 		return nil
 	}
 	if err := je.bldr.extract(ent.Data); err != nil {
@@ -127,10 +129,12 @@ func (je *JsonExtractor) processItem(ent *entry.Entry) *entry.Entry {
 		if je.Drop_Misses == false {
 			return ent
 		}
+		je.miss(ent)
 		return nil
 	}
 	data, cnt := je.bldr.render()
 	if je.Strict_Extraction && cnt != len(je.bldr.keynames) {
+		je.miss(ent)
 		return nil //just dropping the entry
 	} else if cnt == 0 && je.Drop_Misses == false {
 		return ent
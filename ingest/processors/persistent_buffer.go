@@ -12,8 +12,6 @@
 package processors
 
 import (
-	"bytes"
-	"encoding/gob"
 	"errors"
 	"fmt"
 
@@ -60,10 +58,8 @@ func (c PersistentBufferConfig) capacity() (v int, err error) {
 // PersistentBuffer does not have any state, and doesn't do much
 type PersistentBuffer struct {
 	PersistentBufferConfig
-	tgr  Tagger
-	b    *buffer.Buffer
-	bb   *bytes.Buffer
-	tags map[entry.EntryTag]string
+	tgr Tagger
+	b   *buffer.Buffer
 }
 
 func NewPersistentBuffer(cfg PersistentBufferConfig, tagger Tagger) (*PersistentBuffer, error) {
@@ -83,9 +79,7 @@ func NewPersistentBuffer(cfg PersistentBufferConfig, tagger Tagger) (*Persistent
 	return &PersistentBuffer{
 		PersistentBufferConfig: cfg,
 		b:                      b,
-		bb:                     bytes.NewBuffer(nil),
 		tgr:                    tagger,
-		tags:                   map[entry.EntryTag]string{},
 	}, nil
 }
 
@@ -104,39 +98,14 @@ func (gd *PersistentBuffer) Process(ents []*entry.Entry) (rset []*entry.Entry, e
 	if len(ents) == 0 {
 		return
 	}
-	gd.bb.Reset()
-	strents := make([]types.StringTagEntry, 0, len(ents))
-	for _, e := range ents {
-		if e == nil {
-			continue
-		}
-		strent := types.StringTagEntry{
-			Data: e.Data,
-			TS:   e.TS.StandardTime(),
-			SRC:  e.SRC,
-			Tag:  gd.getTag(e.Tag),
-		}
-		strents = append(strents, strent)
-	}
-
-	if err = gob.NewEncoder(gd.bb).Encode(strents); err == nil {
-		gd.b.InsertWithOverwrite(gd.bb.Bytes())
+	var b []byte
+	if b, err = encodeSpoolRecord(StringTagEntries(ents, gd.tgr)); err == nil {
+		gd.b.InsertWithOverwrite(b)
 	}
 	rset = ents
 	return
 }
 
-func (gd *PersistentBuffer) getTag(tag entry.EntryTag) (s string) {
-	var ok bool
-	if s, ok = gd.tags[tag]; !ok {
-		if s, ok = gd.tgr.LookupTag(tag); ok {
-			//after the expensive lookup populate our local map
-			gd.tags[tag] = s
-		}
-	}
-	return
-}
-
 func (gd *PersistentBuffer) Flush() []*entry.Entry {
 	gd.b.Sync()
 	return nil
@@ -172,15 +141,11 @@ func (pbc *PersistentBufferConsumer) Close() (err error) {
 }
 
 func (pbc *PersistentBufferConsumer) Pop() ([]types.StringTagEntry, error) {
-	var strents []types.StringTagEntry
 	buff, err := pbc.b.Pop()
 	if err != nil {
 		return nil, err
 	} else if buff == nil {
 		return nil, ErrBufferEmpty
 	}
-	if err = gob.NewDecoder(bytes.NewBuffer(buff)).Decode(&strents); err != nil {
-		return nil, err
-	}
-	return strents, nil
+	return decodeSpoolRecord(buff)
 }
@@ -24,12 +24,14 @@ import (
 	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 	"github.com/gravwell/gravwell/v3/ingest/processors/plugin"
+	"github.com/gravwell/gravwell/v3/ingest/processors/wasm"
 	"github.com/open2b/scriggo"
 )
 
 const (
 	PluginProcessor     string = `plugin`
 	PluginEngineScriggo string = `scriggo`
+	PluginEngineWasm    string = `wasm`
 
 	defaultEngine     string = PluginEngineScriggo
 	maxPluginFileSize int64  = 1024 * 1024 * 32 //32MB is crazy and useful in case we want to allow static binary plugins
@@ -39,6 +41,7 @@ const (
 var (
 	ErrNoPlugins     = errors.New("No plugins provided in Plugin-Path")
 	ErrDuplicateFile = errors.New("dupclicate plugin file")
+	ErrWasmPathCount = errors.New("wasm plugins require exactly one Plugin-Path")
 )
 
 // PluginData implements the fs.FS interface
@@ -50,6 +53,8 @@ type PluginConfig struct {
 	Plugin_Path   []string               //path to the plugin files (this may support multifile plugins later
 	Plugin_Engine string                 // defaults to scriggo
 	Debug         bool                   // defaults to false
+	Memory_Limit  string                 // wasm only, maximum module memory, defaults to 64MB
+	Call_Timeout  string                 // wasm only, maximum time for a single call into the module, defaults to 5s
 	vc            *config.VariableConfig // we keep a handle on the variable to config to pass to the underlying plugin script
 	pd            PluginData
 	// all other config items are dynamic and passed to the underlying plugin
@@ -71,7 +76,7 @@ func (pc *PluginConfig) validate() (err error) {
 	switch pc.Plugin_Engine {
 	case ``: //deafult
 		pc.Plugin_Engine = PluginEngineScriggo
-	case PluginEngineScriggo: //this is fine
+	case PluginEngineScriggo, PluginEngineWasm: //this is fine
 	default:
 		err = fmt.Errorf("Unknown plugin engine %q", pc.Plugin_Engine)
 		return
@@ -81,6 +86,13 @@ func (pc *PluginConfig) validate() (err error) {
 	if len(pc.Plugin_Path) == 0 {
 		err = ErrNoPlugins
 		return
+	} else if pc.Plugin_Engine == PluginEngineWasm {
+		if len(pc.Plugin_Path) != 1 {
+			err = ErrWasmPathCount
+			return
+		} else if _, err = pc.wasmConfig(); err != nil {
+			return
+		}
 	}
 
 	if pc.pd.count() == 0 {
@@ -94,13 +106,58 @@ func (pc *PluginConfig) validate() (err error) {
 
 }
 
+// wasmConfig builds the wasm runtime config, every config item is handed to the module
+func (pc *PluginConfig) wasmConfig() (wc wasm.Config, err error) {
+	if pc.Memory_Limit != `` {
+		var sz int
+		if sz, err = parseDataSize(pc.Memory_Limit); err != nil {
+			err = fmt.Errorf("invalid Memory-Limit %q %w", pc.Memory_Limit, err)
+			return
+		} else if sz <= 0 {
+			err = fmt.Errorf("invalid Memory-Limit %q", pc.Memory_Limit)
+			return
+		}
+		wc.MemoryLimit = uint64(sz)
+	}
+	if pc.Call_Timeout != `` {
+		if wc.CallTimeout, err = time.ParseDuration(pc.Call_Timeout); err != nil {
+			err = fmt.Errorf("invalid Call-Timeout %q %w", pc.Call_Timeout, err)
+			return
+		} else if wc.CallTimeout <= 0 {
+			err = fmt.Errorf("invalid Call-Timeout %q", pc.Call_Timeout)
+			return
+		}
+	}
+	if pc.vc != nil {
+		wc.Params = map[string][]string{}
+		for _, name := range pc.vc.Names() {
+			if wc.Params[name], err = pc.vc.GetStringSlice(name); err != nil {
+				return
+			}
+		}
+	}
+	if pc.Debug {
+		wc.Log = os.Stderr
+	}
+	return
+}
+
+// pluginRunner is implemented by each plugin engine
+type pluginRunner interface {
+	Process([]*entry.Entry) ([]*entry.Entry, error)
+	Flush() []*entry.Entry
+	Close() error
+}
+
 type Plugin struct {
 	PluginConfig
-	pp *plugin.PluginProgram
+	pp pluginRunner
 }
 
 func NewPluginProcessor(cfg PluginConfig, tg Tagger) (p *Plugin, err error) {
-	if err = cfg.validate(); err == nil {
+	if err = cfg.validate(); err == nil && cfg.Plugin_Engine == PluginEngineWasm {
+		return newWasmPlugin(cfg, tg)
+	} else if err == nil {
 		var pp *plugin.PluginProgram
 		if pp, err = plugin.NewPlugin(cfg.pd, cfg.Debug); err == nil {
 			if err = pp.Run(registerTimeout); err == nil {
@@ -121,6 +178,26 @@ func NewPluginProcessor(cfg PluginConfig, tg Tagger) (p *Plugin, err error) {
 	return
 }
 
+func newWasmPlugin(cfg PluginConfig, tg Tagger) (p *Plugin, err error) {
+	var wc wasm.Config
+	if wc, err = cfg.wasmConfig(); err != nil {
+		return
+	}
+	bin, ok := cfg.pd.Files[filepath.Base(cfg.Plugin_Path[0])]
+	if !ok {
+		err = ErrNoPlugins
+		return
+	}
+	var m *wasm.Module
+	if m, err = wasm.New(bin, wc, tg); err == nil {
+		p = &Plugin{
+			PluginConfig: cfg,
+			pp:           m,
+		}
+	}
+	return
+}
+
 func (p *Plugin) Close() (err error) {
 	if p == nil || p.pp == nil {
 		err = ErrNotReady
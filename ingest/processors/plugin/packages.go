@@ -1754,6 +1754,7 @@ func init() {
 	decs["LoadConfigBytes"] = config.LoadConfigBytes
 	decs["LoadConfigFile"] = config.LoadConfigFile
 	decs["LoadConfigOverlays"] = config.LoadConfigOverlays
+	decs["LoadConfigOverlaysFatalOnly"] = config.LoadConfigOverlaysFatalOnly
 	decs["LoadEnvVar"] = config.LoadEnvVar
 	decs["ParseBool"] = config.ParseBool
 	decs["ParseInt64"] = config.ParseInt64
@@ -292,3 +292,41 @@ func checkAttachedValues(set []*entry.Entry) (err error) {
 	}
 	return
 }
+
+func TestPluginWasmBadConfig(t *testing.T) {
+	bad := []string{
+		`
+	[preprocessor "p"]
+		type = plugin
+		Plugin-Engine = wasm
+		Plugin-Path = "a.wasm"
+		Plugin-Path = "b.wasm"
+	`,
+		`
+	[preprocessor "p"]
+		type = plugin
+		Plugin-Engine = wasm
+		Plugin-Path = "a.wasm"
+		Memory-Limit = lots
+	`,
+		`
+	[preprocessor "p"]
+		type = plugin
+		Plugin-Engine = wasm
+		Plugin-Path = "a.wasm"
+		Call-Timeout = -1s
+	`,
+		`
+	[preprocessor "p"]
+		type = plugin
+		Plugin-Engine = lua
+		Plugin-Path = "a.lua"
+	`,
+	}
+	for i, b := range bad {
+		tc := loadTestConfig(t, []byte(b))
+		if err := tc.Preprocessor.Validate(); err == nil {
+			t.Fatalf("failed to catch bad config %d", i)
+		}
+	}
+}
@@ -19,10 +19,14 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/gravwell/gravwell/v3/ingest"
 	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 	"github.com/gravwell/gravwell/v3/ingest/processors/plugin"
+	"github.com/gravwell/gravwell/v3/ingest/processors/wasm"
 )
 
 const (
@@ -42,8 +46,16 @@ var (
 
 type ProcessorSet struct {
 	sync.Mutex
-	wtr entWriter
-	set []Processor
+	wtr         entWriter
+	set         []Processor
+	counters    []*processorCounters
+	deadLetters []*deadLetter // nil entries when a processor has no dead letter tag
+	trace       TraceConfig
+	traceSkip   uint64         // entries remaining before the next sample
+	name        string         // label attached to the stats, the preprocessor chain for configured sets
+	sr          statsRegistrar // where the set is registered for stats, nil if it is not
+
+	published atomic.Pointer[[]*processorCounters] // copy of the counters that Stats reads without the lock
 }
 
 type ProcessorConfig map[string]*config.VariableConfig
@@ -76,6 +88,7 @@ func CheckProcessor(id string) error {
 	case VpcProcessor:
 	case CorelightProcessor:
 	case SyslogRouterProcessor:
+	case TimestampSanityProcessor:
 	default:
 		return checkProcessorOS(id)
 	}
@@ -141,6 +154,8 @@ func ProcessorLoadConfig(vc *config.VariableConfig) (cfg interface{}, err error)
 		cfg, err = CorelightLoadConfig(vc)
 	case SyslogRouterProcessor:
 		cfg, err = SyslogRouterLoadConfig(vc)
+	case TimestampSanityProcessor:
+		cfg, err = TimestampSanityLoadConfig(vc)
 	default:
 		cfg, err = processorLoadConfigOS(vc)
 	}
@@ -298,6 +313,12 @@ func newProcessor(vc *config.VariableConfig, tgr Tagger) (p Processor, err error
 			return
 		}
 		p, err = NewSyslogRouter(cfg, tgr)
+	case TimestampSanityProcessor:
+		var cfg TimestampSanityConfig
+		if cfg, err = TimestampSanityLoadConfig(vc); err != nil {
+			return
+		}
+		p, err = NewTimestampSanity(cfg, tgr)
 	default:
 		p, err = newProcessorOS(vc, tgr)
 	}
@@ -317,9 +338,62 @@ func (pr *ProcessorSet) Enabled() bool {
 }
 
 func (pr *ProcessorSet) AddProcessor(p Processor) {
+	pr.AddNamedProcessor(``, ``, p)
+}
+
+// AddNamedProcessor appends a processor to the set, the name and type are used
+// when reporting statistics and traces.  If empty they are derived from the processor.
+func (pr *ProcessorSet) AddNamedProcessor(name, typ string, p Processor) {
+	pr.addProcessor(name, typ, p, nil)
+}
+
+// AddDeadLetterProcessor appends a processor to the set, entries which cause it to
+// return an error or which it reports as misses are re-tagged to dlq and written
+// directly rather than being dropped.
+func (pr *ProcessorSet) AddDeadLetterProcessor(name, typ string, p Processor, dlq entry.EntryTag) {
+	if mr, ok := p.(missReporter); ok {
+		mr.EnableMissTracking()
+	}
+	pr.addProcessor(name, typ, p, &deadLetter{tag: dlq})
+}
+
+func (pr *ProcessorSet) addProcessor(name, typ string, p Processor, dl *deadLetter) {
 	pr.Lock()
 	defer pr.Unlock()
 	pr.set = append(pr.set, p)
+	c := newProcessorCounters(name, typ, p)
+	if dl != nil {
+		dl.name = c.name
+	}
+	pr.counters = append(pr.counters, c)
+	pr.deadLetters = append(pr.deadLetters, dl)
+	counters := append([]*processorCounters(nil), pr.counters...)
+	pr.published.Store(&counters)
+}
+
+// SetTrace enables or disables sampled tracing of entries through the set.
+func (pr *ProcessorSet) SetTrace(tc TraceConfig) {
+	pr.Lock()
+	pr.trace = tc
+	pr.traceSkip = 0
+	pr.Unlock()
+}
+
+// Stats returns a snapshot of the per processor counters, it implements ingest.StatsReporter.
+// It does not take the set lock, the set holds that lock while writing to the muxer.
+func (pr *ProcessorSet) Stats() interface{} {
+	var counters []*processorCounters
+	if p := pr.published.Load(); p != nil {
+		counters = *p
+	}
+	pss := ProcessorSetStats{
+		Name:          pr.name,
+		Preprocessors: make([]ProcessorStats, 0, len(counters)),
+	}
+	for _, c := range counters {
+		pss.Preprocessors = append(pss.Preprocessors, c.snapshot())
+	}
+	return pss
 }
 
 func (pr *ProcessorSet) Process(ent *entry.Entry) (err error) {
@@ -422,11 +496,13 @@ func (pr *ProcessorSet) processItems(ents []*entry.Entry) (set []*entry.Entry, e
 	if len(pr.set) == 0 {
 		return
 	}
+	var dead []*entry.Entry
+	et := pr.sampleTrace(ents)
 	for i := 0; i < len(pr.set) && len(set) > 0; i++ {
 		orig := set
-		if set, err = pr.set[i].Process(orig); err != nil {
+		if set, dead, err = pr.runProcessor(i, orig, dead, et); err != nil {
 			//TODO FIXME Issue #1225 - https://github.com/gravwell/gravwell/issues/1225
-			if _, ok := err.(*plugin.FaultError); ok {
+			if isPluginFault(err) {
 				// LOG THIS for issue #1225 and put in some logic
 				// to throttle the frequency of the logs in case the plugin is completely broken
 				set = orig //ignore what the plugin tried to do
@@ -436,21 +512,100 @@ func (pr *ProcessorSet) processItems(ents []*entry.Entry) (set []*entry.Entry, e
 			break // something intentionally returned an error, break out
 		}
 	}
+	et.finish(pr.trace.Logger)
+	set = appendDeadLetters(set, dead)
 	return
 }
 
-// processItemsOnFlush is just a processors that allows us to hand in the set of Processors as a parameter
-// we need to be able to do this as we force a flush and process on preprocessors
-func (pr *ProcessorSet) processItemsOnFlush(prs []Processor, ents []*entry.Entry) (set []*entry.Entry, err error) {
+// isPluginFault returns true if the error came from a misbehaving plugin rather than an intentional failure
+func isPluginFault(err error) bool {
+	var pfe *plugin.FaultError
+	var wfe *wasm.FaultError
+	return errors.As(err, &pfe) || errors.As(err, &wfe)
+}
+
+// processItemsOnFlush runs flushed entries through the processors which follow the
+// processor at offset start, we need to be able to do this as we force a flush and process on preprocessors
+func (pr *ProcessorSet) processItemsOnFlush(start int, ents []*entry.Entry) (set []*entry.Entry, err error) {
 	set = ents
-	if len(set) == 0 || len(prs) == 0 {
+	if len(set) == 0 || start >= len(pr.set) {
 		return
 	}
-	for i := 0; i < len(prs) && len(set) > 0; i++ {
-		if set, err = prs[i].Process(set); err != nil {
+	var dead []*entry.Entry
+	for i := start; i < len(pr.set) && len(set) > 0; i++ {
+		if set, dead, err = pr.runProcessor(i, set, dead, nil); err != nil {
 			break
 		}
 	}
+	set = appendDeadLetters(set, dead)
+	return
+}
+
+// runProcessor hands a set to a single processor while updating its counters and the trace.
+// If the processor has a dead letter tag its misses and the entries which cause it to fail
+// are appended to dead and the error is cleared.
+func (pr *ProcessorSet) runProcessor(i int, in, dead []*entry.Entry, et *entryTrace) (out, rdead []*entry.Entry, err error) {
+	rdead = dead
+	if i >= len(pr.counters) {
+		out, err = pr.set[i].Process(in)
+		return
+	}
+	c := pr.counters[i]
+	dl := pr.deadLetters[i]
+	var orig []*entry.Entry
+	var saved []entry.Entry
+	if dl != nil {
+		// processors filter and modify in place, keep the original set and deep copies of
+		// the entries so that a failed batch can be retried one entry at a time
+		orig = append(orig, in...)
+		saved = make([]entry.Entry, len(in))
+		for j, ent := range in {
+			if ent != nil {
+				saved[j] = ent.DeepCopy()
+			}
+		}
+	}
+	// processors are free to modify entries in place, so capture the input size up front
+	inSize := dataSize(in)
+	inCount := len(in)
+	ts := time.Now()
+	out, err = pr.set[i].Process(in)
+	c.update(inCount, inSize, out, time.Since(ts), err)
+	et.step(c.name, inCount, out, err)
+	if dl != nil {
+		if err != nil {
+			out, rdead = dl.isolateErrors(pr.set[i], orig, saved, out, rdead)
+			err = nil
+		}
+		rdead = dl.collectMisses(pr.set[i], rdead)
+	}
+	return
+}
+
+// appendDeadLetters builds a new set so that we never write into the callers backing array
+func appendDeadLetters(set, dead []*entry.Entry) []*entry.Entry {
+	if len(dead) == 0 {
+		return set
+	}
+	r := make([]*entry.Entry, 0, len(set)+len(dead))
+	r = append(r, set...)
+	return append(r, dead...)
+}
+
+// sampleTrace decides if the first entry in the set should be traced
+func (pr *ProcessorSet) sampleTrace(ents []*entry.Entry) (et *entryTrace) {
+	if pr.trace.SampleRate == 0 || len(ents) == 0 {
+		return
+	}
+	if pr.traceSkip == 0 {
+		et = newEntryTrace(ents[0])
+		pr.traceSkip = pr.trace.SampleRate
+	}
+	if n := uint64(len(ents)); n >= pr.traceSkip {
+		pr.traceSkip = 0
+	} else {
+		pr.traceSkip -= n
+	}
 	return
 }
 
@@ -461,7 +616,7 @@ func (pr *ProcessorSet) Close() (err error) {
 	for i, v := range pr.set {
 		if v != nil {
 			if ents := v.Flush(); len(ents) > 0 {
-				if ents, lerr := pr.processItemsOnFlush(pr.set[i+1:], ents); lerr != nil {
+				if ents, lerr := pr.processItemsOnFlush(i+1, ents); lerr != nil {
 					err = addError(lerr, err)
 				} else if len(ents) > 0 {
 					if lerr := pr.writeSet(ents); lerr != nil {
@@ -474,6 +629,10 @@ func (pr *ProcessorSet) Close() (err error) {
 			}
 		}
 	}
+	if pr.sr != nil {
+		pr.sr.UnregisterStatsReporter(pr)
+		pr.sr = nil
+	}
 	return
 }
 
@@ -491,6 +650,23 @@ type tagWriter interface {
 	Tagger
 }
 
+// statsRegistrar is implemented by the ingest muxer, sets built with one will
+// publish their processor counters in the ingester state until they are closed
+type statsRegistrar interface {
+	RegisterStatsReporter(ingest.StatsReporter)
+	UnregisterStatsReporter(ingest.StatsReporter)
+}
+
+func (pc ProcessorConfig) processorType(name string) string {
+	var pb preprocessorBase
+	if vc, ok := pc[name]; ok && vc != nil {
+		if err := vc.MapTo(&pb); err == nil {
+			return strings.TrimSpace(strings.ToLower(pb.Type))
+		}
+	}
+	return ``
+}
+
 func (pc ProcessorConfig) ProcessorSet(t tagWriter, names []string) (pr *ProcessorSet, err error) {
 	if pc == nil {
 		pr = NewProcessorSet(t) //nothing defined
@@ -503,7 +679,26 @@ func (pc ProcessorConfig) ProcessorSet(t tagWriter, names []string) (pr *Process
 			err = fmt.Errorf("%s %v", n, err)
 			return
 		}
-		pr.AddProcessor(p)
+		var dlc deadLetterConfig
+		if dlc, err = loadDeadLetterConfig(pc[n]); err != nil {
+			err = fmt.Errorf("%s %v", n, err)
+			return
+		} else if dlc.Dead_Letter_Tag == `` {
+			pr.AddNamedProcessor(n, pc.processorType(n), p)
+			continue
+		}
+		var dlq entry.EntryTag
+		if dlq, err = t.NegotiateTag(dlc.Dead_Letter_Tag); err != nil {
+			err = fmt.Errorf("%s failed to negotiate dead letter tag %q %v", n, dlc.Dead_Letter_Tag, err)
+			return
+		}
+		pr.AddDeadLetterProcessor(n, pc.processorType(n), p, dlq)
+	}
+	pr.trace = getDefaultTrace()
+	pr.name = strings.Join(names, `,`)
+	if sr, ok := t.(statsRegistrar); ok && len(names) > 0 {
+		sr.RegisterStatsReporter(pr)
+		pr.sr = sr
 	}
 	return
 }
@@ -104,6 +104,7 @@ func (c *RegexExtractConfig) validate() (rx *regexp.Regexp, tmp *formatter, af [
 
 type RegexExtractor struct {
 	nocloser
+	missTracker
 	RegexExtractConfig
 	tmp       *formatter
 	rx        *regexp.Regexp
@@ -170,6 +171,7 @@ func (re *RegexExtractor) processEntry(ent *entry.Entry) (*entry.Entry, error) {
 		}
 	} else if re.Drop_Misses {
 		//NOT passing through misses, so set ent to nil, this is a DROP
+		re.miss(ent)
 		ent = nil
 	}
 	return ent, nil
@@ -47,6 +47,7 @@ type route struct {
 
 type RegexRouter struct {
 	nocloser
+	missTracker
 	RegexRouteConfig
 	routes   map[string]entry.EntryTag
 	drops    map[string]struct{}
@@ -119,11 +120,15 @@ func (rr *RegexRouter) Process(ents []*entry.Entry) (rset []*entry.Entry, err er
 func (rr *RegexRouter) processItem(ent *entry.Entry) *entry.Entry {
 	if mtchs := rr.rxp.FindSubmatch(ent.Data); rr.matchIdx < len(mtchs) {
 		if tag, drop, ok := rr.handleExtract(mtchs[rr.matchIdx]); drop {
+			if _, explicit := rr.drops[string(mtchs[rr.matchIdx])]; !explicit {
+				rr.miss(ent)
+			}
 			return nil
 		} else if ok {
 			ent.Tag = tag
 		}
 	} else if rr.Drop_Misses {
+		rr.miss(ent)
 		return nil
 	}
 	return ent
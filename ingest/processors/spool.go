@@ -0,0 +1,363 @@
+//go:build linux || darwin
+// +build linux darwin
+
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravwell/buffer"
+	"github.com/gravwell/gravwell/v3/client/types"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	defaultSpoolSize  = `256MB`
+	spoolPollInterval = 250 * time.Millisecond
+	spoolRetryDelay   = time.Second
+)
+
+// spoolCapacity validates the spool options shared by the forwarding preprocessors,
+// an empty path means spooling is disabled.
+func spoolCapacity(pth, size string) (capacity int, err error) {
+	if strings.TrimSpace(pth) == `` {
+		return
+	}
+	if size = strings.TrimSpace(size); size == `` {
+		size = defaultSpoolSize
+	}
+	if capacity, err = parseDataSize(size); err != nil {
+		err = fmt.Errorf("invalid Spool-Size %q %w", size, err)
+	} else if capacity <= 0 {
+		err = fmt.Errorf("invalid Spool-Size %q", size)
+	}
+	return
+}
+
+// Spool is an on disk FIFO of entry batches with a fixed maximum size.  When the spool
+// is full the oldest batches are overwritten.  The record format is shared with the
+// persistent-buffer preprocessor so either file can be handed to the spool tool.
+type Spool struct {
+	mtx  sync.Mutex
+	b    *buffer.Buffer
+	path string
+	head uint64 // bumped every time the oldest batch is removed, by a pop or an overwrite
+}
+
+// OpenSpool opens or creates a spool file, capacity is ignored if the file already exists
+func OpenSpool(pth string, capacity int) (s *Spool, err error) {
+	var b *buffer.Buffer
+	if b, err = buffer.Open(pth, capacity); err != nil {
+		return
+	}
+	s = &Spool{
+		b:    b,
+		path: pth,
+	}
+	return
+}
+
+// Push appends a batch to the spool
+func (s *Spool) Push(ents []types.StringTagEntry) (err error) {
+	var b []byte
+	if len(ents) == 0 {
+		return
+	} else if b, err = encodeSpoolRecord(ents); err == nil {
+		s.mtx.Lock()
+		if len(b) > s.b.Free() {
+			// the insert will evict at least the oldest batch
+			s.head++
+		}
+		err = s.b.InsertWithOverwrite(b)
+		s.mtx.Unlock()
+	}
+	return
+}
+
+// Peek returns the oldest batch without removing it, ErrBufferEmpty is returned on an empty spool
+func (s *Spool) Peek() (ents []types.StringTagEntry, err error) {
+	ents, _, err = s.PeekHead()
+	return
+}
+
+// PeekHead returns the oldest batch along with a head sequence number which can be handed
+// to PopHead to remove exactly that batch, ErrBufferEmpty is returned on an empty spool
+func (s *Spool) PeekHead() (ents []types.StringTagEntry, head uint64, err error) {
+	var buff []byte
+	s.mtx.Lock()
+	buff, err = s.b.Peek()
+	head = s.head
+	s.mtx.Unlock()
+	if err != nil {
+		return
+	} else if buff == nil {
+		err = ErrBufferEmpty
+		return
+	}
+	ents, err = decodeSpoolRecord(buff)
+	return
+}
+
+// PopHead removes the oldest batch only if it is still the batch returned by PeekHead,
+// false is returned if the batch was already removed or overwritten
+func (s *Spool) PopHead(head uint64) (ok bool, err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if head != s.head {
+		return
+	} else if _, err = s.b.Pop(); err == nil {
+		s.head++
+		ok = true
+	}
+	return
+}
+
+// Pop removes and returns the oldest batch, ErrBufferEmpty is returned on an empty spool
+func (s *Spool) Pop() ([]types.StringTagEntry, error) {
+	s.mtx.Lock()
+	buff, err := s.b.Pop()
+	if err == nil && buff != nil {
+		s.head++
+	}
+	s.mtx.Unlock()
+	if err != nil {
+		return nil, err
+	} else if buff == nil {
+		return nil, ErrBufferEmpty
+	}
+	return decodeSpoolRecord(buff)
+}
+
+// Size returns the number of bytes held in the spool
+func (s *Spool) Size() int {
+	return s.b.Size()
+}
+
+// Free returns the number of bytes which can be pushed before old batches are overwritten
+func (s *Spool) Free() int {
+	return s.b.Free()
+}
+
+// Capacity returns the total size of the spool file
+func (s *Spool) Capacity() (v int64) {
+	if fi, err := os.Stat(s.path); err == nil {
+		v = fi.Size()
+	}
+	return
+}
+
+func (s *Spool) Sync() error {
+	return s.b.Sync()
+}
+
+func (s *Spool) Close() (err error) {
+	if err = s.b.Sync(); err == nil {
+		err = s.b.Close()
+	} else {
+		s.b.Close()
+	}
+	return
+}
+
+func encodeSpoolRecord(ents []types.StringTagEntry) ([]byte, error) {
+	bb := bytes.NewBuffer(nil)
+	if err := gob.NewEncoder(bb).Encode(ents); err != nil {
+		return nil, err
+	}
+	return bb.Bytes(), nil
+}
+
+func decodeSpoolRecord(buff []byte) (ents []types.StringTagEntry, err error) {
+	err = gob.NewDecoder(bytes.NewBuffer(buff)).Decode(&ents)
+	return
+}
+
+// StringTagEntries converts entries to their spooled form, tag names are resolved using the tagger
+func StringTagEntries(ents []*entry.Entry, tgr Tagger) []types.StringTagEntry {
+	tt := newTagTrans(tgr)
+	r := make([]types.StringTagEntry, 0, len(ents))
+	for _, ent := range ents {
+		if ent != nil {
+			r = append(r, toStringTagEntry(ent, tt))
+		}
+	}
+	return r
+}
+
+func toStringTagEntry(ent *entry.Entry, tt *tagTrans) (ste types.StringTagEntry) {
+	ste = types.StringTagEntry{
+		TS:   ent.TS.StandardTime(),
+		SRC:  ent.SRC,
+		Data: ent.Data,
+		Tag:  tt.TagName(ent.Tag),
+	}
+	if ent.Tag == entry.GravwellTagId {
+		ste.Tag = entry.GravwellTagName
+	}
+	for _, ev := range ent.EnumeratedValues() {
+		ste.Enumerated = append(ste.Enumerated, types.EnumeratedPair{
+			Name:  ev.Name,
+			Value: ev.Value.String(),
+			RawValue: types.RawEnumeratedValue{
+				Type: uint16(ev.TypeID()),
+				Data: ev.ValueBuff(),
+			},
+		})
+	}
+	return
+}
+
+// EntryFromStringTagEntry rebuilds an entry from its spooled form, negotiating the tag with the tagger
+func EntryFromStringTagEntry(ste types.StringTagEntry, tgr Tagger) (ent *entry.Entry, err error) {
+	ent = &entry.Entry{
+		TS:   entry.FromStandard(ste.TS),
+		SRC:  ste.SRC,
+		Data: ste.Data,
+	}
+	if ste.Tag == entry.GravwellTagName {
+		ent.Tag = entry.GravwellTagId
+	} else if ent.Tag, err = tgr.NegotiateTag(ste.Tag); err != nil {
+		return
+	}
+	for _, ep := range ste.Enumerated {
+		var ed entry.EnumeratedData
+		if ed, err = entry.NewEnumeratedData(uint8(ep.RawValue.Type), ep.RawValue.Data); err != nil {
+			return
+		} else if err = ent.AddEnumeratedValue(entry.EnumeratedValue{Name: ep.Name, Value: ed}); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// spooler sits in front of a forwarding target, entries are spooled when the target can't
+// keep up and the spool is drained to the target in order.  Once anything is spooled all
+// new entries are also spooled until the spool is empty so that ordering is preserved.
+type spooler struct {
+	s     *Spool
+	tgr   Tagger
+	ready func() bool                                 // optional check that the target can accept entries
+	write func(context.Context, []*entry.Entry) error // hand entries to the target, may block
+	ctx   context.Context
+	cf    context.CancelFunc
+	wg    sync.WaitGroup
+	kick  chan struct{}
+}
+
+func newSpooler(pth string, capacity int, tgr Tagger, ready func() bool, write func(context.Context, []*entry.Entry) error) (sp *spooler, err error) {
+	var s *Spool
+	if s, err = OpenSpool(pth, capacity); err != nil {
+		return
+	}
+	sp = &spooler{
+		s:     s,
+		tgr:   tgr,
+		ready: ready,
+		write: write,
+		kick:  make(chan struct{}, 1),
+	}
+	sp.ctx, sp.cf = context.WithCancel(context.Background())
+	sp.wg.Add(1)
+	go sp.routine()
+	return
+}
+
+// active returns true if the spool is holding anything
+func (sp *spooler) active() bool {
+	return sp.s.Size() > 0
+}
+
+func (sp *spooler) push(ents []*entry.Entry) (err error) {
+	if err = sp.s.Push(StringTagEntries(ents, sp.tgr)); err == nil {
+		select {
+		case sp.kick <- empty:
+		default:
+		}
+	}
+	return
+}
+
+func (sp *spooler) routine() {
+	defer sp.wg.Done()
+	for {
+		if !sp.active() || (sp.ready != nil && !sp.ready()) {
+			if sp.sleep(spoolPollInterval) {
+				return
+			}
+			continue
+		}
+		ents, head, err := sp.peek()
+		if err != nil {
+			if errors.Is(err, ErrBufferEmpty) {
+				continue
+			}
+			sp.s.PopHead(head) // corrupt or undeliverable record, drop it rather than wedge the spool
+			continue
+		}
+		if err = sp.write(sp.ctx, ents); err != nil {
+			if sp.sleep(spoolRetryDelay) {
+				return
+			}
+			continue
+		}
+		// the batch is only removed once the target has accepted all of it, if a push
+		// overwrote it while we were writing then it is already gone
+		sp.s.PopHead(head)
+	}
+}
+
+func (sp *spooler) peek() (ents []*entry.Entry, head uint64, err error) {
+	var strents []types.StringTagEntry
+	if strents, head, err = sp.s.PeekHead(); err != nil {
+		return
+	}
+	ents = make([]*entry.Entry, 0, len(strents))
+	for _, ste := range strents {
+		var ent *entry.Entry
+		if ent, err = EntryFromStringTagEntry(ste, sp.tgr); err != nil {
+			return
+		}
+		ents = append(ents, ent)
+	}
+	return
+}
+
+// sleep waits for the duration or a kick, returning true if the spooler is stopping
+func (sp *spooler) sleep(d time.Duration) bool {
+	tmr := time.NewTimer(d)
+	defer tmr.Stop()
+	select {
+	case <-sp.ctx.Done():
+		return true
+	case <-sp.kick:
+	case <-tmr.C:
+	}
+	return false
+}
+
+// stop halts draining, the spool remains open so that in flight entries can be pushed
+func (sp *spooler) stop() {
+	sp.cf()
+	sp.wg.Wait()
+}
+
+func (sp *spooler) close() error {
+	sp.stop()
+	return sp.s.Close()
+}
@@ -0,0 +1,51 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+var (
+	ErrSpoolNotSupported = errors.New("spooling is not supported on this platform")
+)
+
+func spoolCapacity(pth, size string) (capacity int, err error) {
+	if strings.TrimSpace(pth) != `` {
+		err = ErrSpoolNotSupported
+	}
+	return
+}
+
+type spooler struct{}
+
+func newSpooler(pth string, capacity int, tgr Tagger, ready func() bool, write func(context.Context, []*entry.Entry) error) (*spooler, error) {
+	return nil, ErrSpoolNotSupported
+}
+
+func (sp *spooler) active() bool {
+	return false
+}
+
+func (sp *spooler) push(ents []*entry.Entry) error {
+	return ErrSpoolNotSupported
+}
+
+func (sp *spooler) stop() {}
+
+func (sp *spooler) close() error {
+	return ErrSpoolNotSupported
+}
@@ -0,0 +1,175 @@
+//go:build linux
+// +build linux
+
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+func TestSpool(t *testing.T) {
+	pth := filepath.Join(t.TempDir(), `spool`)
+	var tt testTagger
+	s, err := OpenSpool(pth, 1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Peek(); err != ErrBufferEmpty {
+		t.Fatalf("bad empty peek: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		set := makeEntrySet([]byte(`spool`), entry.EntryTag(i), 4)
+		if err = s.Push(StringTagEntries(set, &tt)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopen and make sure everything comes back out in order with EVs intact
+	if s, err = OpenSpool(pth, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for i := 0; i < 3; i++ {
+		strents, err := s.Pop()
+		if err != nil {
+			t.Fatal(err)
+		} else if len(strents) != 4 {
+			t.Fatalf("bad batch size %d", len(strents))
+		}
+		for j, ste := range strents {
+			ent, err := EntryFromStringTagEntry(ste, &tt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exp := fmt.Sprintf("spool %d/4", j); string(ent.Data) != exp {
+				t.Fatalf("bad data %q != %q", ent.Data, exp)
+			} else if !ent.SRC.Equal(testSrc) {
+				t.Fatalf("bad SRC %v", ent.SRC)
+			} else if v, ok := ent.GetEnumeratedValue(`testing`); !ok || v != uint64(j) {
+				t.Fatalf("bad EV %v", v)
+			}
+		}
+	}
+	if s.Size() != 0 {
+		t.Fatalf("spool not empty: %d", s.Size())
+	}
+}
+
+func TestSpoolOverwriteRace(t *testing.T) {
+	var tt testTagger
+	s, err := OpenSpool(filepath.Join(t.TempDir(), `spool`), 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	batch := StringTagEntries(makeEntrySet([]byte(`spool`), 0, 4), &tt)
+	if err = s.Push(batch); err != nil {
+		t.Fatal(err)
+	}
+	_, head, err := s.PeekHead()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// push enough newer batches to overwrite the peeked batch while it is "in flight"
+	newer := StringTagEntries(makeEntrySet([]byte(`newer`), 1, 4), &tt)
+	rec, err := encodeSpoolRecord(newer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i <= 4096/len(rec); i++ {
+		if err = s.Push(newer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sz := s.Size()
+	if ok, err := s.PopHead(head); err != nil {
+		t.Fatal(err)
+	} else if ok || s.Size() != sz {
+		t.Fatal("popped an undelivered batch after the peeked batch was overwritten")
+	}
+
+	// a peek that is not raced pops normally
+	strents, head, err := s.PeekHead()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(strents) != 4 || string(strents[0].Data) != `newer 0/4` {
+		t.Fatalf("bad head batch %+v", strents)
+	}
+	if ok, err := s.PopHead(head); err != nil || !ok {
+		t.Fatalf("failed to pop the peeked batch %v %v", ok, err)
+	} else if s.Size() >= sz {
+		t.Fatalf("spool did not shrink %d >= %d", s.Size(), sz)
+	}
+}
+
+func TestForwarderSpool(t *testing.T) {
+	// grab a port and release it so the forwarder starts with a dead target
+	lst, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lst.Addr().String()
+	lst.Close()
+
+	var tt testTagger
+	nf, err := NewForwarder(ForwarderConfig{
+		Target:     addr,
+		Protocol:   protoTCP,
+		Buffer:     2,
+		Spool_Path: filepath.Join(t.TempDir(), `spool`),
+		Spool_Size: `1MB`,
+	}, &tt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const count = 64
+	for i := 0; i < count; i++ {
+		if _, err = nf.Process(makeEntry([]byte(fmt.Sprintf("line %d", i)), 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !nf.sp.active() {
+		t.Fatal("forwarder did not spool")
+	}
+
+	// bring the target up and make sure everything arrives in order
+	if lst, err = net.Listen(`tcp`, addr); err != nil {
+		t.Skipf("failed to re-listen on %s: %v", addr, err)
+	}
+	defer lst.Close()
+	conn, err := lst.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	sc := bufio.NewScanner(conn)
+	for i := 0; i < count; i++ {
+		if !sc.Scan() {
+			t.Fatalf("missing line %d: %v", i, sc.Err())
+		} else if exp := fmt.Sprintf("line %d", i); sc.Text() != exp {
+			t.Fatalf("out of order line %q != %q", sc.Text(), exp)
+		}
+	}
+	if err = nf.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
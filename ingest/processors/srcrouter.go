@@ -39,6 +39,7 @@ type srcroute struct {
 
 type SrcRouter struct {
 	nocloser
+	missTracker
 	SrcRouteConfig
 	tree *nradix.Tree
 }
@@ -112,7 +113,10 @@ func (sr *SrcRouter) Process(ents []*entry.Entry) (rset []*entry.Entry, err erro
 
 func (sr *SrcRouter) processItem(ent *entry.Entry) *entry.Entry {
 	if tag, drop, ok := sr.handleExtract(ent.SRC); drop {
-		// They set this src to be dropped
+		// They set this src to be dropped, or it was not found and we drop misses
+		if !ok {
+			sr.miss(ent)
+		}
 		return nil
 	} else if ok {
 		// We found a tag to send it to
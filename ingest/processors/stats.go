@@ -0,0 +1,257 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+
+	"github.com/crewjam/rfc5424"
+)
+
+const (
+	// TraceEVName is the enumerated value attached to sampled entries when tracing is enabled
+	TraceEVName string = `preprocessor_trace`
+
+	traceActionPass    = `pass`
+	traceActionRetag   = `retag`
+	traceActionDrop    = `drop`
+	traceActionReplace = `replace`
+	traceActionError   = `error`
+	traceActionFanout  = `fanned out (%d)`
+)
+
+var (
+	// upper bounds of each latency bucket, anything larger lands in the overflow bucket
+	latencyBuckets = []time.Duration{
+		10 * time.Microsecond,
+		100 * time.Microsecond,
+		time.Millisecond,
+		10 * time.Millisecond,
+		100 * time.Millisecond,
+		time.Second,
+	}
+
+	defaultTrace    TraceConfig
+	defaultTraceMtx sync.Mutex
+)
+
+type traceLogger interface {
+	Info(string, ...rfc5424.SDParam) error
+}
+
+// TraceConfig controls sampled tracing of entries through a ProcessorSet.
+// A SampleRate of N traces one out of every N entries, zero disables tracing.
+type TraceConfig struct {
+	SampleRate uint64
+	Logger     traceLogger
+}
+
+// SetDefaultTrace sets the trace configuration applied to every ProcessorSet
+// built from a ProcessorConfig after this call.
+func SetDefaultTrace(tc TraceConfig) {
+	defaultTraceMtx.Lock()
+	defaultTrace = tc
+	defaultTraceMtx.Unlock()
+}
+
+func getDefaultTrace() (tc TraceConfig) {
+	defaultTraceMtx.Lock()
+	tc = defaultTrace
+	defaultTraceMtx.Unlock()
+	return
+}
+
+// ProcessorStats is a point in time snapshot of the counters for a single processor in a set.
+type ProcessorStats struct {
+	Name       string
+	Type       string
+	EntriesIn  uint64
+	EntriesOut uint64
+	Dropped    uint64
+	Errors     uint64
+	BytesIn    uint64
+	BytesOut   uint64
+	Latency    []LatencyBucket
+}
+
+// LatencyBucket counts the Process calls which completed in no more than Max.
+// The overflow bucket has an empty Max.
+type LatencyBucket struct {
+	Max   string `json:",omitempty"`
+	Count uint64
+}
+
+// ProcessorSetStats is the snapshot handed to the ingester state system.
+type ProcessorSetStats struct {
+	Name          string // sets built from a config are named for their preprocessor chain
+	Preprocessors []ProcessorStats
+}
+
+type processorCounters struct {
+	name       string
+	typ        string
+	entriesIn  atomic.Uint64
+	entriesOut atomic.Uint64
+	dropped    atomic.Uint64
+	errors     atomic.Uint64
+	bytesIn    atomic.Uint64
+	bytesOut   atomic.Uint64
+	latency    []atomic.Uint64
+}
+
+func newProcessorCounters(name, typ string, p Processor) *processorCounters {
+	if typ == `` {
+		typ = processorTypeName(p)
+	}
+	if name == `` {
+		name = typ
+	}
+	return &processorCounters{
+		name:    name,
+		typ:     typ,
+		latency: make([]atomic.Uint64, len(latencyBuckets)+1),
+	}
+}
+
+// processorTypeName derives a name from the concrete type for processors added without one
+func processorTypeName(p Processor) string {
+	s := fmt.Sprintf("%T", p)
+	if idx := strings.LastIndexByte(s, '.'); idx >= 0 {
+		s = s[idx+1:]
+	}
+	return strings.ToLower(s)
+}
+
+func (pc *processorCounters) update(inCount int, inSize uint64, out []*entry.Entry, dur time.Duration, err error) {
+	pc.entriesIn.Add(uint64(inCount))
+	pc.bytesIn.Add(inSize)
+	if err != nil {
+		pc.errors.Add(uint64(inCount))
+	} else {
+		pc.entriesOut.Add(uint64(len(out)))
+		pc.bytesOut.Add(dataSize(out))
+		if len(out) < inCount {
+			pc.dropped.Add(uint64(inCount - len(out)))
+		}
+	}
+	i := 0
+	for ; i < len(latencyBuckets); i++ {
+		if dur <= latencyBuckets[i] {
+			break
+		}
+	}
+	pc.latency[i].Add(1)
+}
+
+func (pc *processorCounters) snapshot() (ps ProcessorStats) {
+	ps = ProcessorStats{
+		Name:       pc.name,
+		Type:       pc.typ,
+		EntriesIn:  pc.entriesIn.Load(),
+		EntriesOut: pc.entriesOut.Load(),
+		Dropped:    pc.dropped.Load(),
+		Errors:     pc.errors.Load(),
+		BytesIn:    pc.bytesIn.Load(),
+		BytesOut:   pc.bytesOut.Load(),
+		Latency:    make([]LatencyBucket, len(pc.latency)),
+	}
+	for i := range pc.latency {
+		if i < len(latencyBuckets) {
+			ps.Latency[i].Max = latencyBuckets[i].String()
+		}
+		ps.Latency[i].Count = pc.latency[i].Load()
+	}
+	return
+}
+
+func dataSize(ents []*entry.Entry) (sz uint64) {
+	for _, ent := range ents {
+		if ent != nil {
+			sz += uint64(len(ent.Data))
+		}
+	}
+	return
+}
+
+// entryTrace follows a single sampled entry through the chain
+type entryTrace struct {
+	ent     *entry.Entry
+	tag     entry.EntryTag
+	steps   []string
+	done    bool // no longer following an entry
+	dropped bool // the entry did not make it through the chain
+}
+
+func newEntryTrace(ent *entry.Entry) *entryTrace {
+	return &entryTrace{
+		ent: ent,
+		tag: ent.Tag,
+	}
+}
+
+// step records what the processor did to the traced entry.  A replacement is only followed when
+// it is the sole output, if the entry fanned out into several the trace ends rather than guessing.
+func (et *entryTrace) step(name string, inCount int, out []*entry.Entry, err error) {
+	if et == nil || et.done {
+		return
+	}
+	action := traceActionReplace
+	if err != nil {
+		action = traceActionError
+		et.done, et.dropped = true, true
+	} else if idx := indexOfEntry(out, et.ent); idx >= 0 {
+		if action = traceActionPass; et.ent.Tag != et.tag {
+			action = traceActionRetag
+		}
+	} else if len(out) == 0 || len(out) < inCount {
+		action = traceActionDrop
+		et.done, et.dropped = true, true
+	} else if len(out) == 1 {
+		et.ent = out[0]
+	} else {
+		action = fmt.Sprintf(traceActionFanout, len(out))
+		et.done = true
+	}
+	et.tag = et.ent.Tag
+	et.steps = append(et.steps, name+`:`+action)
+}
+
+func (et *entryTrace) String() string {
+	return strings.Join(et.steps, ` > `)
+}
+
+// finish attaches the path to the traced entry if it survived and optionally logs it
+func (et *entryTrace) finish(lgr traceLogger) {
+	if et == nil || len(et.steps) == 0 {
+		return
+	}
+	path := et.String()
+	if !et.done {
+		et.ent.AddEnumeratedValueEx(TraceEVName, path)
+	}
+	if lgr != nil {
+		lgr.Info("preprocessor trace", log.KV("path", path), log.KV("delivered", !et.dropped))
+	}
+}
+
+func indexOfEntry(set []*entry.Entry, ent *entry.Entry) int {
+	for i := range set {
+		if set[i] == ent {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,232 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+func TestProcessorSetStats(t *testing.T) {
+	var tw testWriter
+	ps := NewProcessorSet(&tw)
+	gz, err := NewGzipDecompressor(GzipDecompressorConfig{Passthrough_Non_Gzip: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dr, err := NewDrop(DropConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps.AddNamedProcessor(`gz`, GzipProcessor, gz)
+	ps.AddProcessor(dr)
+
+	ents := []*entry.Entry{
+		{TS: entry.Now(), SRC: net.ParseIP("192.168.1.1"), Data: []byte("hello")},
+		{TS: entry.Now(), SRC: net.ParseIP("192.168.1.1"), Data: []byte("world!")},
+	}
+	// the testWriter rejects the empty set the drop processor hands back, so just check the output
+	ps.ProcessBatch(ents)
+	if len(tw.ents) != 0 {
+		t.Fatalf("drop processor let %d entries through", len(tw.ents))
+	}
+
+	pss, ok := ps.Stats().(ProcessorSetStats)
+	if !ok {
+		t.Fatalf("invalid stats type %T", ps.Stats())
+	} else if len(pss.Preprocessors) != 2 {
+		t.Fatalf("invalid stats count: %d", len(pss.Preprocessors))
+	}
+	st := pss.Preprocessors[0]
+	if st.Name != `gz` || st.Type != GzipProcessor {
+		t.Fatalf("bad name/type: %q %q", st.Name, st.Type)
+	} else if st.EntriesIn != 2 || st.EntriesOut != 2 || st.Dropped != 0 || st.Errors != 0 {
+		t.Fatalf("bad entry counts: %+v", st)
+	} else if st.BytesIn != 11 || st.BytesOut != 11 {
+		t.Fatalf("bad byte counts: %+v", st)
+	}
+	var calls uint64
+	for _, b := range st.Latency {
+		calls += b.Count
+	}
+	if calls != 1 {
+		t.Fatalf("bad latency histogram: %+v", st.Latency)
+	}
+
+	st = pss.Preprocessors[1]
+	if st.Name != `drop` {
+		t.Fatalf("bad derived name %q", st.Name)
+	} else if st.EntriesIn != 2 || st.EntriesOut != 0 || st.Dropped != 2 || st.BytesOut != 0 {
+		t.Fatalf("bad entry counts: %+v", st)
+	}
+}
+
+func TestProcessorSetStatsUnlocked(t *testing.T) {
+	var tw testWriter
+	ps := NewProcessorSet(&tw)
+	gz, err := NewGzipDecompressor(GzipDecompressorConfig{Passthrough_Non_Gzip: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps.AddNamedProcessor(`gz`, GzipProcessor, gz)
+
+	// the set lock is held while writing to the muxer, Stats must not need it
+	ps.Lock()
+	done := make(chan interface{})
+	go func() { done <- ps.Stats() }()
+	select {
+	case v := <-done:
+		if pss, ok := v.(ProcessorSetStats); !ok || len(pss.Preprocessors) != 1 {
+			t.Fatalf("bad stats %+v", v)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stats blocked on the set lock")
+	}
+	ps.Unlock()
+
+	// and it is safe to call while entries are flowing
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			ps.Process(&entry.Entry{TS: entry.Now(), Data: []byte("hello")})
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		ps.Stats()
+	}
+	wg.Wait()
+	if st := ps.Stats().(ProcessorSetStats).Preprocessors[0]; st.EntriesIn != 1000 {
+		t.Fatalf("bad entry count %d", st.EntriesIn)
+	}
+}
+
+func TestProcessorSetTrace(t *testing.T) {
+	b := []byte(`
+	[preprocessor "rtr"]
+		type = regexrouter
+		Regex="(?P<app>\\S+)"
+		Route-Extraction=app
+		Route=foo:foo
+
+	[preprocessor "gz"]
+		type = gzip
+		Passthrough-Non-Gzip=true
+	`)
+	tc := loadTestConfig(t, b)
+	var tw testTagWriter
+	ps, err := tc.Preprocessor.ProcessorSet(&tw, []string{`gz`, `rtr`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps.SetTrace(TraceConfig{SampleRate: 2})
+	for _, v := range []string{`foo bar`, `baz bar`, `foo baz`} {
+		ent := &entry.Entry{TS: entry.Now(), Tag: 42, Data: []byte(v)}
+		if err := ps.Process(ent); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(tw.ents) != 3 {
+		t.Fatalf("bad output count %d", len(tw.ents))
+	}
+	// entries 0 and 2 are sampled
+	for i, ent := range tw.ents {
+		v, ok := ent.GetEnumeratedValue(TraceEVName)
+		if (i%2 == 0) != ok {
+			t.Fatalf("entry %d trace presence is wrong: %v", i, ok)
+		} else if !ok {
+			continue
+		}
+		if s, ok := v.(string); !ok {
+			t.Fatalf("bad trace type %T", v)
+		} else if !strings.HasPrefix(s, `gz:pass > rtr:`) {
+			t.Fatalf("bad trace %q", s)
+		}
+	}
+	if v, _ := tw.ents[0].GetEnumeratedValue(TraceEVName); v != `gz:pass > rtr:retag` {
+		t.Fatalf("bad trace %v", v)
+	}
+	if len(tw.registered) != 1 {
+		t.Fatalf("set did not register for stats: %d", len(tw.registered))
+	} else if pss, ok := tw.registered[0].Stats().(ProcessorSetStats); !ok || pss.Name != `gz,rtr` {
+		t.Fatalf("bad stats label %+v", tw.registered[0].Stats())
+	}
+	if err := ps.Close(); err != nil {
+		t.Fatal(err)
+	} else if len(tw.registered) != 0 {
+		t.Fatalf("closed set is still registered for stats: %d", len(tw.registered))
+	}
+
+	// a set without any processors has nothing to report
+	if ps, err = tc.Preprocessor.ProcessorSet(&tw, nil); err != nil {
+		t.Fatal(err)
+	} else if len(tw.registered) != 0 {
+		t.Fatalf("empty set registered for stats: %d", len(tw.registered))
+	}
+	ps.Close()
+}
+
+func TestEntryTraceFanout(t *testing.T) {
+	ent := &entry.Entry{Tag: 1, Data: []byte("a")}
+	other := &entry.Entry{Tag: 1, Data: []byte("b")}
+	et := newEntryTrace(ent)
+	et.step(`keep`, 2, []*entry.Entry{other, ent}, nil) // the traced entry is followed, not out[0]
+	if et.ent != ent || et.done {
+		t.Fatalf("lost the traced entry: %v", et)
+	}
+	repl := &entry.Entry{Tag: 2, Data: []byte("c")}
+	et.step(`one`, 1, []*entry.Entry{repl}, nil) // a sole output is the replacement
+	if et.ent != repl || et.done {
+		t.Fatalf("failed to follow the replacement: %v", et)
+	}
+	et.step(`split`, 1, []*entry.Entry{other, ent}, nil)
+	if !et.done || et.dropped || et.ent != repl {
+		t.Fatalf("fan out was followed: %v", et)
+	}
+	et.step(`after`, 2, nil, nil)
+	if exp := `keep:pass > one:replace > split:fanned out (2)`; et.String() != exp {
+		t.Fatalf("bad trace %q", et.String())
+	}
+}
+
+func loadTestConfig(t *testing.T, b []byte) (tc testConfigStruct) {
+	t.Helper()
+	if err := config.LoadConfigBytes(&tc, b); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// testTagWriter satisfies tagWriter and collects stats registrations
+type testTagWriter struct {
+	testWriter
+	testTagger
+	registered []ingest.StatsReporter
+}
+
+func (ttw *testTagWriter) RegisterStatsReporter(sr ingest.StatsReporter) {
+	ttw.registered = append(ttw.registered, sr)
+}
+
+func (ttw *testTagWriter) UnregisterStatsReporter(sr ingest.StatsReporter) {
+	for i, v := range ttw.registered {
+		if v == sr {
+			ttw.registered = append(ttw.registered[:i], ttw.registered[i+1:]...)
+			break
+		}
+	}
+}
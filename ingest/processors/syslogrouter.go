@@ -60,6 +60,7 @@ func SyslogRouterLoadConfig(vc *config.VariableConfig) (c SyslogRouterConfig, er
 
 type SyslogRouter struct {
 	nocloser
+	missTracker
 	SyslogRouterConfig
 	tagger Tagger
 	routes map[string]entry.EntryTag
@@ -109,12 +110,15 @@ func (sr *SyslogRouter) Process(ents []*entry.Entry) (rset []*entry.Entry, err e
 		if parts == nil {
 			if !sr.Drop_Misses {
 				rset = append(rset, ent)
+			} else {
+				sr.miss(ent)
 			}
 			continue
 		}
 		//got a good crack, process it
 		if tag, err := sr.processEntry(ent, parts); err != nil {
 			if sr.Drop_Misses {
+				sr.miss(ent)
 				continue
 			}
 		} else {
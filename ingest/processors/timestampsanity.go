@@ -0,0 +1,263 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/timegrinder"
+)
+
+const (
+	TimestampSanityProcessor string = `timestampsanity`
+
+	tsActionClamp     string = `clamp`
+	tsActionReextract string = `reextract`
+	tsActionOffset    string = `offset`
+	tsActionRetag     string = `retag`
+
+	defaultOriginalTimestampEV string = `original_timestamp`
+	defaultMaxPast                    = 30 * 24 * time.Hour
+	defaultMaxFuture                  = time.Hour
+	maxTrackedSources                 = 4096
+	offsetSmoothing                   = 8 // learned offsets move 1/8th of the way towards each new observation
+)
+
+var (
+	ErrMissingRetagTag = errors.New("Retag action requires Retag-Tag")
+)
+
+type TimestampSanityConfig struct {
+	Max_Past                   string   // how far behind ingest time an entry may be, defaults to 30 days
+	Max_Future                 string   // how far ahead of ingest time an entry may be, defaults to 1 hour
+	Action                     string   // clamp, reextract, offset, or retag
+	Retag_Tag                  string   // tag used by the retag action
+	Alternate_Format           []string // timegrinder formats tried in order by the reextract action
+	Timezone_Override          string
+	Assume_Local_Timezone      bool
+	Original_Timestamp_EV      string // name of the EV holding the original timestamp, defaults to original_timestamp
+	Disable_Original_Timestamp bool   // do not attach the original timestamp
+}
+
+func TimestampSanityLoadConfig(vc *config.VariableConfig) (c TimestampSanityConfig, err error) {
+	if err = vc.MapTo(&c); err == nil {
+		_, _, err = c.validate()
+	}
+	return
+}
+
+func (c *TimestampSanityConfig) validate() (past, future time.Duration, err error) {
+	if past, err = parseBound(c.Max_Past, defaultMaxPast); err != nil {
+		err = fmt.Errorf("invalid Max-Past %q %w", c.Max_Past, err)
+		return
+	} else if future, err = parseBound(c.Max_Future, defaultMaxFuture); err != nil {
+		err = fmt.Errorf("invalid Max-Future %q %w", c.Max_Future, err)
+		return
+	}
+	switch c.Action = strings.TrimSpace(strings.ToLower(c.Action)); c.Action {
+	case ``:
+		c.Action = tsActionClamp
+	case tsActionClamp, tsActionOffset:
+	case tsActionReextract:
+		for _, f := range c.Alternate_Format {
+			if err = timegrinder.ValidateFormatOverride(f); err != nil {
+				return
+			}
+		}
+	case tsActionRetag:
+		if c.Retag_Tag = strings.TrimSpace(c.Retag_Tag); c.Retag_Tag == `` {
+			err = ErrMissingRetagTag
+			return
+		} else if err = ingest.CheckTag(c.Retag_Tag); err != nil {
+			return
+		}
+	default:
+		err = fmt.Errorf("unknown Action %q", c.Action)
+		return
+	}
+	if c.Timezone_Override != `` && c.Assume_Local_Timezone {
+		err = errors.New("Can't specify Assume-Local-Timezone and define a Timezone-Override at the same time")
+	} else if c.Original_Timestamp_EV = strings.TrimSpace(c.Original_Timestamp_EV); c.Original_Timestamp_EV == `` {
+		c.Original_Timestamp_EV = defaultOriginalTimestampEV
+	}
+	return
+}
+
+func parseBound(v string, def time.Duration) (d time.Duration, err error) {
+	if v = strings.TrimSpace(v); v == `` {
+		d = def
+	} else if d, err = time.ParseDuration(v); err == nil && d <= 0 {
+		err = errors.New("bound must be positive")
+	}
+	return
+}
+
+// TimestampSanity checks entry timestamps against bounds relative to the ingest time
+// and corrects or re-routes entries which fall outside of them.
+type TimestampSanity struct {
+	nocloser
+	TimestampSanityConfig
+	past    time.Duration
+	future  time.Duration
+	tag     entry.EntryTag
+	tgs     []*timegrinder.TimeGrinder
+	offsets map[string]time.Duration // learned per-source clock offsets
+	now     func() time.Time
+}
+
+func NewTimestampSanity(cfg TimestampSanityConfig, tagger Tagger) (*TimestampSanity, error) {
+	ts := &TimestampSanity{}
+	if err := ts.init(cfg, tagger); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+func (ts *TimestampSanity) Config(v interface{}, tagger Tagger) (err error) {
+	if v == nil {
+		err = ErrNilConfig
+	} else if cfg, ok := v.(TimestampSanityConfig); ok {
+		err = ts.init(cfg, tagger)
+	} else {
+		err = fmt.Errorf("Invalid configuration, unknown type type %T", v)
+	}
+	return
+}
+
+// init builds all of the action state so that a reconfigured processor never carries
+// state from a previous action
+func (ts *TimestampSanity) init(cfg TimestampSanityConfig, tagger Tagger) (err error) {
+	var past, future time.Duration
+	if past, future, err = cfg.validate(); err != nil {
+		return
+	}
+	var tag entry.EntryTag
+	var tgs []*timegrinder.TimeGrinder
+	var offsets map[string]time.Duration
+	switch cfg.Action {
+	case tsActionRetag:
+		if tagger == nil {
+			return errors.New("no tagger provided")
+		} else if tag, err = tagger.NegotiateTag(cfg.Retag_Tag); err != nil {
+			return
+		}
+	case tsActionReextract:
+		formats := cfg.Alternate_Format
+		if len(formats) == 0 {
+			formats = []string{``} // no override, let timegrinder scan everything
+		}
+		for _, f := range formats {
+			var tg *timegrinder.TimeGrinder
+			if tg, err = timegrinder.New(timegrinder.Config{FormatOverride: f}); err != nil {
+				return
+			}
+			if cfg.Assume_Local_Timezone {
+				tg.SetLocalTime()
+			} else if cfg.Timezone_Override != `` {
+				if err = tg.SetTimezone(cfg.Timezone_Override); err != nil {
+					return
+				}
+			}
+			tgs = append(tgs, tg)
+		}
+	case tsActionOffset:
+		offsets = map[string]time.Duration{}
+	}
+	ts.TimestampSanityConfig = cfg
+	ts.past, ts.future = past, future
+	ts.tag, ts.tgs, ts.offsets = tag, tgs, offsets
+	if ts.now == nil {
+		ts.now = time.Now
+	}
+	return
+}
+
+func (ts *TimestampSanity) Process(ents []*entry.Entry) ([]*entry.Entry, error) {
+	if len(ents) == 0 {
+		return ents, nil
+	}
+	now := ts.now()
+	for _, ent := range ents {
+		if ent != nil {
+			ts.processItem(ent, now)
+		}
+	}
+	return ents, nil
+}
+
+func (ts *TimestampSanity) inBounds(t, now time.Time) bool {
+	return !t.Before(now.Add(-ts.past)) && !t.After(now.Add(ts.future))
+}
+
+func (ts *TimestampSanity) processItem(ent *entry.Entry, now time.Time) {
+	orig := ent.TS.StandardTime()
+	if ts.inBounds(orig, now) {
+		return
+	}
+	var fixed time.Time
+	switch ts.Action {
+	case tsActionRetag:
+		ent.Tag = ts.tag
+		return
+	case tsActionReextract:
+		fixed = ts.reextract(ent.Data, now)
+	case tsActionOffset:
+		fixed = ts.applyOffset(ent, orig, now)
+	default:
+		fixed = now
+	}
+	ent.TS = entry.FromStandard(fixed)
+	if !ts.Disable_Original_Timestamp {
+		ent.AddEnumeratedValueEx(ts.Original_Timestamp_EV, orig)
+	}
+}
+
+// reextract tries each alternate format in order, falling back to now if none produce a sane timestamp
+func (ts *TimestampSanity) reextract(data []byte, now time.Time) time.Time {
+	for _, tg := range ts.tgs {
+		if t, ok, err := tg.Extract(data); err == nil && ok && ts.inBounds(t, now) {
+			return t
+		}
+	}
+	return now
+}
+
+// applyOffset learns how far off each source clock is and shifts entries by that amount,
+// this preserves the relative spacing of entries from a device with a skewed clock.
+func (ts *TimestampSanity) applyOffset(ent *entry.Entry, orig, now time.Time) time.Time {
+	key := srcKey(ent)
+	observed := now.Sub(orig)
+	off, ok := ts.offsets[key]
+	if !ok {
+		off = observed
+		if len(ts.offsets) >= maxTrackedSources {
+			ts.offsets = map[string]time.Duration{} // don't let a spray of sources grow without bound
+		}
+	} else {
+		off += (observed - off) / offsetSmoothing
+	}
+	ts.offsets[key] = off
+	if t := orig.Add(off); ts.inBounds(t, now) {
+		return t
+	}
+	return now
+}
+
+func srcKey(ent *entry.Entry) string {
+	if ent.SRC == nil {
+		return ``
+	}
+	return ent.SRC.String()
+}
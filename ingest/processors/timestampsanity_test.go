@@ -0,0 +1,207 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+var (
+	tsSanityNow = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+)
+
+func newTestTimestampSanity(t *testing.T, b string) *TimestampSanity {
+	t.Helper()
+	tc := loadTestConfig(t, []byte(b))
+	var tt testTagger
+	p, err := tc.Preprocessor.getProcessor(`ts`, &tt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, ok := p.(*TimestampSanity)
+	if !ok {
+		t.Fatalf("bad processor type %T", p)
+	}
+	ts.now = func() time.Time { return tsSanityNow }
+	return ts
+}
+
+func tsEntry(ts time.Time, data string) *entry.Entry {
+	return &entry.Entry{
+		TS:   entry.FromStandard(ts),
+		SRC:  net.ParseIP("10.0.0.1"),
+		Tag:  42,
+		Data: []byte(data),
+	}
+}
+
+func checkOriginalTS(t *testing.T, ent *entry.Entry, orig time.Time) {
+	t.Helper()
+	v, ok := ent.GetEnumeratedValue(defaultOriginalTimestampEV)
+	if !ok {
+		t.Fatal("missing original timestamp EV")
+	} else if ot, ok := v.(entry.Timestamp); !ok || !ot.StandardTime().Equal(orig) {
+		t.Fatalf("bad original timestamp EV: %v", v)
+	}
+}
+
+func TestTimestampSanityClamp(t *testing.T) {
+	ts := newTestTimestampSanity(t, `
+	[preprocessor "ts"]
+		type = timestampsanity
+		Max-Past=24h
+		Max-Future=1m
+	`)
+	good := tsSanityNow.Add(-time.Hour)
+	epoch := time.Unix(0, 0).UTC()
+	future := tsSanityNow.Add(5 * 365 * 24 * time.Hour)
+	set, err := ts.Process([]*entry.Entry{
+		tsEntry(good, `good`),
+		tsEntry(epoch, `epoch`),
+		tsEntry(future, `future`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(set) != 3 {
+		t.Fatalf("bad set size %d", len(set))
+	}
+	if !set[0].TS.StandardTime().Equal(good) || set[0].EVB.Populated() {
+		t.Fatalf("in bounds entry was modified: %v", set[0].TS)
+	}
+	for i, orig := range []time.Time{epoch, future} {
+		ent := set[i+1]
+		if !ent.TS.StandardTime().Equal(tsSanityNow) {
+			t.Fatalf("entry %d not clamped: %v", i+1, ent.TS)
+		}
+		checkOriginalTS(t, ent, orig)
+	}
+}
+
+func TestTimestampSanityReextract(t *testing.T) {
+	ts := newTestTimestampSanity(t, `
+	[preprocessor "ts"]
+		type = timestampsanity
+		Action=reextract
+		Alternate-Format=RFC3339
+	`)
+	good := tsSanityNow.Add(-2 * time.Hour)
+	epoch := time.Unix(0, 0).UTC()
+	set, err := ts.Process([]*entry.Entry{
+		tsEntry(epoch, `event at `+good.Format(time.RFC3339)+` happened`),
+		tsEntry(epoch, `no timestamp here`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !set[0].TS.StandardTime().Equal(good) {
+		t.Fatalf("bad re-extracted timestamp: %v != %v", set[0].TS.StandardTime(), good)
+	}
+	checkOriginalTS(t, set[0], epoch)
+	if !set[1].TS.StandardTime().Equal(tsSanityNow) {
+		t.Fatalf("failed re-extraction did not clamp: %v", set[1].TS)
+	}
+}
+
+func TestTimestampSanityOffset(t *testing.T) {
+	ts := newTestTimestampSanity(t, `
+	[preprocessor "ts"]
+		type = timestampsanity
+		Action=offset
+		Original-Timestamp-EV=origts
+	`)
+	// a device whose clock is a year behind
+	skew := 365 * 24 * time.Hour
+	first := tsSanityNow.Add(-skew)
+	second := first.Add(-10 * time.Minute)
+	set, err := ts.Process([]*entry.Entry{
+		tsEntry(first, `a`),
+		tsEntry(second, `b`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !set[0].TS.StandardTime().Equal(tsSanityNow) {
+		t.Fatalf("bad first offset timestamp: %v", set[0].TS)
+	}
+	// the second entry keeps its spacing, less the smoothing applied to the learned offset
+	exp := tsSanityNow.Add(-10*time.Minute + (10*time.Minute)/offsetSmoothing)
+	if !set[1].TS.StandardTime().Equal(exp) {
+		t.Fatalf("bad second offset timestamp: %v != %v", set[1].TS.StandardTime(), exp)
+	}
+	if _, ok := set[1].GetEnumeratedValue(`origts`); !ok {
+		t.Fatal("missing renamed original timestamp EV")
+	}
+}
+
+func TestTimestampSanityRetag(t *testing.T) {
+	ts := newTestTimestampSanity(t, `
+	[preprocessor "ts"]
+		type = timestampsanity
+		Action=retag
+		Retag-Tag=badclock
+	`)
+	epoch := time.Unix(0, 0).UTC()
+	set, err := ts.Process([]*entry.Entry{
+		tsEntry(epoch, `epoch`),
+		tsEntry(tsSanityNow, `now`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set[0].Tag != ts.tag || !set[0].TS.StandardTime().Equal(epoch) {
+		t.Fatalf("bad retagged entry: %d %v", set[0].Tag, set[0].TS)
+	} else if set[1].Tag != 42 {
+		t.Fatalf("in bounds entry was retagged")
+	}
+}
+
+func TestTimestampSanityConfig(t *testing.T) {
+	bad := []TimestampSanityConfig{
+		{Action: `bogus`},
+		{Action: tsActionRetag},
+		{Max_Past: `-1h`},
+		{Max_Future: `tomorrow`},
+		{Action: tsActionReextract, Alternate_Format: []string{`notaformat`}},
+	}
+	for i, c := range bad {
+		if _, err := NewTimestampSanity(c, &testTagger{}); err == nil {
+			t.Fatalf("failed to catch bad config %d: %+v", i, c)
+		}
+	}
+
+	// reconfiguring must build the state for the new action
+	var tt testTagger
+	ts, err := NewTimestampSanity(TimestampSanityConfig{}, &tt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.now = func() time.Time { return tsSanityNow }
+	for _, c := range []TimestampSanityConfig{
+		{Action: tsActionOffset},
+		{Action: tsActionReextract},
+		{Action: tsActionRetag, Retag_Tag: `badclock`},
+	} {
+		if err = ts.Config(c, &tt); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = ts.Process([]*entry.Entry{tsEntry(time.Unix(0, 0), `epoch`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = ts.Config(TimestampSanityConfig{Action: `bogus`}, &tt); err == nil {
+		t.Fatal("failed to catch bad reconfiguration")
+	}
+	if err := CheckProcessor(TimestampSanityProcessor); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,219 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package wasm
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+/*
+Batches of entries cross the host/guest boundary using the following little endian encoding:
+
+	batch:
+		count     uint32
+		entries   [count]entry
+	entry:
+		ts_sec    int64
+		ts_nsec   int64
+		tag       uint16
+		src_len   uint8   // 0, 4, or 16
+		src       [src_len]byte
+		data_len  uint32
+		data      [data_len]byte
+		ev_count  uint16
+		evs       [ev_count]ev
+	ev:
+		name_len  uint16
+		name      [name_len]byte
+		type      uint8   // the native Gravwell EV type ID
+		value_len uint16
+		value     [value_len]byte
+*/
+
+const (
+	batchHeaderSize = 4
+	entryHeaderSize = 8 + 8 + 2 + 1 // timestamp, tag, and source length
+	evHeaderSize    = 2 + 1 + 2     // name length, type, and value length
+)
+
+var (
+	ErrTruncatedBatch = errors.New("truncated entry batch")
+	ErrInvalidSource  = errors.New("invalid entry source length")
+	ErrBatchTooLarge  = errors.New("entry batch is too large")
+)
+
+// EncodeBatch serializes a set of entries into the ABI batch format
+func EncodeBatch(ents []*entry.Entry) (b []byte, err error) {
+	if len(ents) > math.MaxUint32 {
+		err = ErrBatchTooLarge
+		return
+	}
+	b = make([]byte, batchHeaderSize, batchSize(ents))
+	var cnt uint32
+	for _, ent := range ents {
+		if ent == nil {
+			continue
+		}
+		if b, err = appendEntry(b, ent); err != nil {
+			return
+		}
+		cnt++
+	}
+	binary.LittleEndian.PutUint32(b, cnt)
+	return
+}
+
+func batchSize(ents []*entry.Entry) (sz int) {
+	sz = batchHeaderSize
+	for _, ent := range ents {
+		if ent != nil {
+			sz += entryHeaderSize + 16 + 4 + len(ent.Data) + 2
+			for _, ev := range ent.EnumeratedValues() {
+				sz += evHeaderSize + len(ev.Name) + len(ev.Value.String())
+			}
+		}
+	}
+	return
+}
+
+func appendEntry(b []byte, ent *entry.Entry) ([]byte, error) {
+	src := ent.SRC
+	if v4 := src.To4(); v4 != nil {
+		src = v4
+	}
+	if l := len(src); l != 0 && l != net.IPv4len && l != net.IPv6len {
+		return nil, ErrInvalidSource
+	} else if uint64(len(ent.Data)) > math.MaxUint32 {
+		return nil, ErrBatchTooLarge
+	}
+	evs := ent.EnumeratedValues()
+	if len(evs) > math.MaxUint16 {
+		return nil, ErrBatchTooLarge
+	}
+	b = binary.LittleEndian.AppendUint64(b, uint64(ent.TS.Sec))
+	b = binary.LittleEndian.AppendUint64(b, uint64(ent.TS.Nsec))
+	b = binary.LittleEndian.AppendUint16(b, uint16(ent.Tag))
+	b = append(b, byte(len(src)))
+	b = append(b, src...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(ent.Data)))
+	b = append(b, ent.Data...)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(evs)))
+	for _, ev := range evs {
+		val := ev.ValueBuff()
+		b = binary.LittleEndian.AppendUint16(b, uint16(len(ev.Name)))
+		b = append(b, ev.Name...)
+		b = append(b, ev.TypeID())
+		b = binary.LittleEndian.AppendUint16(b, uint16(len(val)))
+		b = append(b, val...)
+	}
+	return b, nil
+}
+
+// DecodeBatch deserializes an ABI batch, all entry data is copied out of the buffer
+func DecodeBatch(b []byte) (ents []*entry.Entry, err error) {
+	if len(b) < batchHeaderSize {
+		err = ErrTruncatedBatch
+		return
+	}
+	cnt := binary.LittleEndian.Uint32(b)
+	b = b[batchHeaderSize:]
+	// every entry is at least a header long, don't let a garbage count drive our allocation
+	if uint64(cnt)*entryHeaderSize > uint64(len(b)) {
+		err = ErrTruncatedBatch
+		return
+	}
+	ents = make([]*entry.Entry, 0, cnt)
+	for i := uint32(0); i < cnt; i++ {
+		var ent *entry.Entry
+		if ent, b, err = decodeEntry(b); err != nil {
+			ents = nil
+			return
+		}
+		ents = append(ents, ent)
+	}
+	return
+}
+
+func decodeEntry(b []byte) (ent *entry.Entry, r []byte, err error) {
+	if len(b) < entryHeaderSize {
+		err = ErrTruncatedBatch
+		return
+	}
+	ent = &entry.Entry{
+		TS: entry.Timestamp{
+			Sec:  int64(binary.LittleEndian.Uint64(b)),
+			Nsec: int64(binary.LittleEndian.Uint64(b[8:])),
+		},
+		Tag: entry.EntryTag(binary.LittleEndian.Uint16(b[16:])),
+	}
+	srcLen := int(b[18])
+	b = b[entryHeaderSize:]
+	if srcLen != 0 && srcLen != net.IPv4len && srcLen != net.IPv6len {
+		err = ErrInvalidSource
+		return
+	} else if len(b) < srcLen+4 {
+		err = ErrTruncatedBatch
+		return
+	}
+	if srcLen > 0 {
+		ent.SRC = append(net.IP(nil), b[:srcLen]...)
+	}
+	b = b[srcLen:]
+	dataLen := binary.LittleEndian.Uint32(b)
+	b = b[4:]
+	if uint64(len(b)) < uint64(dataLen)+2 {
+		err = ErrTruncatedBatch
+		return
+	}
+	ent.Data = append([]byte(nil), b[:dataLen]...)
+	b = b[dataLen:]
+	evCount := int(binary.LittleEndian.Uint16(b))
+	b = b[2:]
+	for i := 0; i < evCount; i++ {
+		var ev entry.EnumeratedValue
+		if ev, b, err = decodeEV(b); err != nil {
+			return
+		} else if err = ent.AddEnumeratedValue(ev); err != nil {
+			return
+		}
+	}
+	r = b
+	return
+}
+
+func decodeEV(b []byte) (ev entry.EnumeratedValue, r []byte, err error) {
+	if len(b) < 2 {
+		err = ErrTruncatedBatch
+		return
+	}
+	nameLen := int(binary.LittleEndian.Uint16(b))
+	b = b[2:]
+	if len(b) < nameLen+3 {
+		err = ErrTruncatedBatch
+		return
+	}
+	ev.Name = string(b[:nameLen])
+	evType := b[nameLen]
+	valLen := int(binary.LittleEndian.Uint16(b[nameLen+1:]))
+	b = b[nameLen+3:]
+	if len(b) < valLen {
+		err = ErrTruncatedBatch
+		return
+	}
+	if ev.Value, err = entry.NewEnumeratedData(evType, append([]byte(nil), b[:valLen]...)); err != nil {
+		return
+	}
+	r = b[valLen:]
+	return
+}
@@ -0,0 +1,84 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package wasm
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+func testEntries() []*entry.Entry {
+	a := &entry.Entry{
+		TS:   entry.FromStandard(time.Date(2024, 6, 1, 12, 0, 0, 1234, time.UTC)),
+		SRC:  net.ParseIP("10.0.0.1"),
+		Tag:  3,
+		Data: []byte(`hello`),
+	}
+	a.AddEnumeratedValueEx(`str`, `value`)
+	a.AddEnumeratedValueEx(`num`, int64(-42))
+	b := &entry.Entry{
+		TS:   entry.Now(),
+		SRC:  net.ParseIP("fe80::1"),
+		Tag:  7,
+		Data: []byte(`world`),
+	}
+	c := &entry.Entry{TS: entry.Now()} // no SRC and no data
+	return []*entry.Entry{a, b, nil, c}
+}
+
+func TestBatchRoundTrip(t *testing.T) {
+	orig := testEntries()
+	b, err := EncodeBatch(orig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ents, err := DecodeBatch(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig = append(orig[:2], orig[3]) // nil entries are skipped
+	if len(ents) != len(orig) {
+		t.Fatalf("bad entry count %d != %d", len(ents), len(orig))
+	}
+	for i, ent := range ents {
+		o := orig[i]
+		if ent.TS != o.TS || ent.Tag != o.Tag || string(ent.Data) != string(o.Data) {
+			t.Fatalf("entry %d mismatch: %+v != %+v", i, ent, o)
+		} else if (o.SRC != nil || ent.SRC != nil) && !ent.SRC.Equal(o.SRC) {
+			t.Fatalf("entry %d SRC mismatch: %v != %v", i, ent.SRC, o.SRC)
+		}
+		oevs, evs := o.EnumeratedValues(), ent.EnumeratedValues()
+		if len(oevs) != len(evs) {
+			t.Fatalf("entry %d EV count mismatch: %d != %d", i, len(evs), len(oevs))
+		}
+		for j := range evs {
+			if evs[j].Name != oevs[j].Name || evs[j].Value.String() != oevs[j].Value.String() {
+				t.Fatalf("entry %d EV %d mismatch: %v != %v", i, j, evs[j], oevs[j])
+			}
+		}
+	}
+}
+
+func TestBatchTruncated(t *testing.T) {
+	b, err := EncodeBatch(testEntries())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(b); i++ {
+		if _, err := DecodeBatch(b[:i]); err == nil {
+			t.Fatalf("failed to catch truncated batch at %d/%d", i, len(b))
+		}
+	}
+	if _, err := DecodeBatch([]byte{0xff, 0xff, 0xff, 0xff}); err != ErrTruncatedBatch {
+		t.Fatalf("failed to catch bogus count: %v", err)
+	}
+}
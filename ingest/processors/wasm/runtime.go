@@ -0,0 +1,380 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+// Package wasm implements a sandboxed WebAssembly runtime for preprocessor plugins.
+// See the README in this directory for the module ABI.
+package wasm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+const (
+	HostModuleName string = `gravwell`
+
+	exportAlloc      = `gw_alloc`
+	exportConfig     = `gw_config`
+	exportStart      = `gw_start`
+	exportProcess    = `gw_process`
+	exportFlush      = `gw_flush`
+	exportClose      = `gw_close`
+	exportInitialize = `_initialize` // wasi reactor initialization
+
+	pageSize           = 64 * 1024
+	maxPages           = 65536
+	DefaultMemoryLimit = 64 * 1024 * 1024
+	DefaultCallTimeout = 5 * time.Second
+	maxLogSize         = 4096
+)
+
+var (
+	ErrNotReady       = errors.New("wasm module is not ready")
+	ErrMissingExport  = errors.New("wasm module is missing a required export")
+	ErrInvalidPointer = errors.New("wasm module returned an invalid pointer")
+)
+
+// Tagger is the subset of the preprocessor tagger interface exposed to modules
+type Tagger interface {
+	NegotiateTag(name string) (entry.EntryTag, error)
+	LookupTag(entry.EntryTag) (string, bool)
+}
+
+type Config struct {
+	MemoryLimit uint64              // maximum linear memory in bytes, defaults to 64MB
+	CallTimeout time.Duration       // maximum runtime of any single call into the module, defaults to 5s
+	Params      map[string][]string // handed to gw_config as a JSON object
+	Log         io.Writer           // destination for module log calls and stdout/stderr, nil discards
+}
+
+func (c *Config) validate() error {
+	if c.MemoryLimit == 0 {
+		c.MemoryLimit = DefaultMemoryLimit
+	} else if c.MemoryLimit < pageSize || c.MemoryLimit > maxPages*pageSize {
+		return fmt.Errorf("invalid memory limit %d, must be between %d and %d", c.MemoryLimit, pageSize, uint64(maxPages*pageSize))
+	}
+	if c.CallTimeout == 0 {
+		c.CallTimeout = DefaultCallTimeout
+	} else if c.CallTimeout < 0 {
+		return fmt.Errorf("invalid call timeout %v", c.CallTimeout)
+	}
+	if c.Log == nil {
+		c.Log = io.Discard
+	}
+	return nil
+}
+
+// FaultError is returned when the module traps, times out, or otherwise misbehaves.
+// The module is torn down and reinstantiated on the next call.
+type FaultError struct {
+	Call string
+	Err  error
+}
+
+func (fe *FaultError) Error() string {
+	return fmt.Sprintf("wasm module fault in %s: %v", fe.Call, fe.Err)
+}
+
+func (fe *FaultError) Unwrap() error {
+	return fe.Err
+}
+
+// Module is a single instantiated plugin, it is not safe for concurrent use
+type Module struct {
+	sync.Mutex
+	cfg     Config
+	tg      Tagger
+	cfgBuff []byte
+	rt      wazero.Runtime
+	cm      wazero.CompiledModule
+	mod     api.Module
+
+	// per call state populated by host functions
+	out     []*entry.Entry
+	callErr error
+	hostErr error
+}
+
+// New compiles and instantiates the module, then calls gw_config and gw_start
+func New(bin []byte, cfg Config, tg Tagger) (m *Module, err error) {
+	if err = cfg.validate(); err != nil {
+		return
+	} else if tg == nil {
+		err = errors.New("nil tagger")
+		return
+	}
+	m = &Module{
+		cfg: cfg,
+		tg:  tg,
+	}
+	if m.cfgBuff, err = json.Marshal(cfg.Params); err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	rcfg := wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(cfg.MemoryLimit / pageSize)).
+		WithCloseOnContextDone(true)
+	m.rt = wazero.NewRuntimeWithConfig(ctx, rcfg)
+	if err = m.setup(ctx, bin); err == nil {
+		err = m.instantiate()
+	}
+	if err != nil {
+		m.rt.Close(ctx)
+		m = nil
+	}
+	return
+}
+
+func (m *Module) setup(ctx context.Context, bin []byte) (err error) {
+	if _, err = wasi_snapshot_preview1.Instantiate(ctx, m.rt); err != nil {
+		return
+	}
+	_, err = m.rt.NewHostModuleBuilder(HostModuleName).
+		NewFunctionBuilder().WithFunc(m.hostNegotiateTag).Export(`negotiate_tag`).
+		NewFunctionBuilder().WithFunc(m.hostLookupTag).Export(`lookup_tag`).
+		NewFunctionBuilder().WithFunc(m.hostEmit).Export(`emit`).
+		NewFunctionBuilder().WithFunc(m.hostSetError).Export(`set_error`).
+		NewFunctionBuilder().WithFunc(m.hostLog).Export(`log`).
+		Instantiate(ctx)
+	if err != nil {
+		return
+	}
+	if m.cm, err = m.rt.CompileModule(ctx, bin); err != nil {
+		return
+	}
+	for _, name := range []string{exportAlloc, exportConfig, exportProcess} {
+		if _, ok := m.cm.ExportedFunctions()[name]; !ok {
+			return fmt.Errorf("%w: %s", ErrMissingExport, name)
+		}
+	}
+	return
+}
+
+// instantiate creates a fresh instance of the module and runs it through config and start
+func (m *Module) instantiate() (err error) {
+	mcfg := wazero.NewModuleConfig().
+		WithName(``).
+		WithStartFunctions(). // reactor modules must not run main
+		WithStdout(m.cfg.Log).
+		WithStderr(m.cfg.Log).
+		WithSysWalltime().
+		WithSysNanotime()
+	if m.mod, err = m.rt.InstantiateModule(context.Background(), m.cm, mcfg); err != nil {
+		return
+	}
+	if m.mod.ExportedFunction(exportInitialize) != nil {
+		if _, err = m.call(exportInitialize); err != nil {
+			return
+		}
+	}
+	if err = m.callWithBuffer(exportConfig, m.cfgBuff); err != nil {
+		return
+	}
+	return m.callOptional(exportStart)
+}
+
+// Process hands the entries to the module and returns whatever it emitted
+func (m *Module) Process(ents []*entry.Entry) ([]*entry.Entry, error) {
+	m.Lock()
+	defer m.Unlock()
+	if err := m.ready(); err != nil {
+		return nil, err
+	}
+	b, err := EncodeBatch(ents)
+	if err != nil {
+		return nil, err
+	}
+	if err = m.callWithBuffer(exportProcess, b); err != nil {
+		return nil, err
+	}
+	return m.takeOutput(), nil
+}
+
+// Flush asks the module to emit anything it is holding
+func (m *Module) Flush() []*entry.Entry {
+	m.Lock()
+	defer m.Unlock()
+	if m.mod == nil {
+		return nil
+	}
+	if err := m.callOptional(exportFlush); err != nil {
+		return nil
+	}
+	return m.takeOutput()
+}
+
+func (m *Module) Close() (err error) {
+	m.Lock()
+	defer m.Unlock()
+	if m.rt == nil {
+		return ErrNotReady
+	}
+	if m.mod != nil {
+		err = m.callOptional(exportClose)
+	}
+	if lerr := m.rt.Close(context.Background()); lerr != nil && err == nil {
+		err = lerr
+	}
+	m.rt, m.mod = nil, nil
+	return
+}
+
+// ready reinstantiates the module if a previous call faulted
+func (m *Module) ready() (err error) {
+	if m.rt == nil {
+		err = ErrNotReady
+	} else if m.mod == nil {
+		if err = m.instantiate(); err != nil {
+			m.teardown()
+		}
+	}
+	return
+}
+
+func (m *Module) teardown() {
+	if m.mod != nil {
+		m.mod.Close(context.Background())
+		m.mod = nil
+	}
+	m.out = nil
+}
+
+func (m *Module) takeOutput() (r []*entry.Entry) {
+	r = m.out
+	m.out = nil
+	return
+}
+
+func (m *Module) callOptional(name string) error {
+	if m.mod.ExportedFunction(name) == nil {
+		return nil
+	}
+	_, err := m.call(name)
+	return err
+}
+
+// callWithBuffer copies b into module memory allocated with gw_alloc and calls the named
+// function with the pointer and length, the module owns the buffer after the call.
+func (m *Module) callWithBuffer(name string, b []byte) (err error) {
+	var r []uint64
+	if r, err = m.call(exportAlloc, uint64(len(b))); err != nil {
+		return
+	} else if len(r) != 1 {
+		return m.fault(exportAlloc, ErrInvalidPointer)
+	}
+	ptr := uint32(r[0])
+	if !m.mod.Memory().Write(ptr, b) {
+		return m.fault(exportAlloc, ErrInvalidPointer)
+	}
+	_, err = m.call(name, uint64(ptr), uint64(len(b)))
+	return
+}
+
+// call invokes an exported function under the call timeout, a non-zero result is
+// treated as a plugin error and traps or timeouts as faults.
+func (m *Module) call(name string, params ...uint64) (r []uint64, err error) {
+	fn := m.mod.ExportedFunction(name)
+	if fn == nil {
+		return nil, fmt.Errorf("%w: %s", ErrMissingExport, name)
+	}
+	m.callErr, m.hostErr = nil, nil
+	ctx, cf := context.WithTimeout(context.Background(), m.cfg.CallTimeout)
+	defer cf()
+	if r, err = fn.Call(ctx, params...); err != nil {
+		return nil, m.fault(name, err)
+	} else if m.hostErr != nil {
+		return nil, m.fault(name, m.hostErr)
+	}
+	if name != exportAlloc && len(r) == 1 && int32(r[0]) != 0 {
+		if err = m.callErr; err == nil {
+			err = fmt.Errorf("%s returned %d", name, int32(r[0]))
+		}
+		m.out = nil
+	}
+	return
+}
+
+func (m *Module) fault(name string, err error) error {
+	m.teardown()
+	return &FaultError{Call: name, Err: err}
+}
+
+func (m *Module) read(mod api.Module, ptr, l uint32) ([]byte, bool) {
+	b, ok := mod.Memory().Read(ptr, l)
+	if !ok {
+		m.hostErr = ErrInvalidPointer
+		return nil, false
+	}
+	return b, true
+}
+
+func (m *Module) hostNegotiateTag(ctx context.Context, mod api.Module, ptr, l uint32) int32 {
+	b, ok := m.read(mod, ptr, l)
+	if !ok {
+		return -1
+	}
+	tag, err := m.tg.NegotiateTag(string(b))
+	if err != nil {
+		return -1
+	}
+	return int32(tag)
+}
+
+// hostLookupTag writes the tag name into the provided buffer and returns its length,
+// -1 is returned for unknown tags and the required length if the buffer is too small.
+func (m *Module) hostLookupTag(ctx context.Context, mod api.Module, tag, ptr, l uint32) int32 {
+	name, ok := m.tg.LookupTag(entry.EntryTag(tag))
+	if !ok {
+		return -1
+	} else if uint32(len(name)) > l {
+		return int32(len(name))
+	}
+	if !mod.Memory().WriteString(ptr, name) {
+		m.hostErr = ErrInvalidPointer
+		return -1
+	}
+	return int32(len(name))
+}
+
+func (m *Module) hostEmit(ctx context.Context, mod api.Module, ptr, l uint32) {
+	b, ok := m.read(mod, ptr, l)
+	if !ok {
+		return
+	}
+	ents, err := DecodeBatch(b)
+	if err != nil {
+		m.hostErr = err
+		return
+	}
+	m.out = append(m.out, ents...)
+}
+
+func (m *Module) hostSetError(ctx context.Context, mod api.Module, ptr, l uint32) {
+	if b, ok := m.read(mod, ptr, l); ok {
+		m.callErr = errors.New(string(b))
+	}
+}
+
+func (m *Module) hostLog(ctx context.Context, mod api.Module, ptr, l uint32) {
+	if l > maxLogSize {
+		l = maxLogSize
+	}
+	if b, ok := m.read(mod, ptr, l); ok {
+		fmt.Fprintf(m.cfg.Log, "%s\n", b)
+	}
+}
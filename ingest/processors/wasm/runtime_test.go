@@ -0,0 +1,124 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package wasm
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+type testTagger struct {
+	tags []string
+}
+
+func (tt *testTagger) NegotiateTag(name string) (entry.EntryTag, error) {
+	for i, v := range tt.tags {
+		if v == name {
+			return entry.EntryTag(i), nil
+		}
+	}
+	tt.tags = append(tt.tags, name)
+	return entry.EntryTag(len(tt.tags) - 1), nil
+}
+
+func (tt *testTagger) LookupTag(tag entry.EntryTag) (string, bool) {
+	if int(tag) < len(tt.tags) {
+		return tt.tags[tag], true
+	}
+	return ``, false
+}
+
+// buildTestModule compiles the test plugin, tests are skipped if the toolchain can't target wasip1
+func buildTestModule(t *testing.T) []byte {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("skipping wasm module build in short mode")
+	}
+	out := filepath.Join(t.TempDir(), `suffix.wasm`)
+	cmd := exec.Command(`go`, `build`, `-buildmode=c-shared`, `-o`, out, `.`)
+	cmd.Dir = filepath.Join(`testdata`, `suffix`)
+	cmd.Env = append(os.Environ(), `GOOS=wasip1`, `GOARCH=wasm`)
+	if ob, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("failed to build wasm test module: %v\n%s", err, ob)
+	}
+	bin, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bin
+}
+
+func TestModule(t *testing.T) {
+	bin := buildTestModule(t)
+	tt := &testTagger{tags: []string{`default`}}
+	lb := bytes.NewBuffer(nil)
+	m, err := New(bin, Config{
+		CallTimeout: time.Second,
+		Params:      map[string][]string{`suffix`: {`-wasm`}},
+		Log:         lb,
+	}, tt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if !strings.Contains(lb.String(), `configured`) {
+		t.Fatalf("missing module log output: %q", lb.String())
+	}
+	wasmTag, _ := tt.NegotiateTag(`wasm`)
+
+	ents := testEntries()
+	out, err := m.Process(ents)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(out) != 3 {
+		t.Fatalf("bad output count %d", len(out))
+	}
+	if string(out[0].Data) != `hello-wasm` || out[0].Tag != wasmTag || out[0].TS != ents[0].TS {
+		t.Fatalf("bad processed entry: %+v", out[0])
+	} else if v, ok := out[0].GetEnumeratedValue(`str`); !ok || v != `value` {
+		t.Fatalf("EV did not survive the module: %v", v)
+	}
+
+	// intentional errors are not faults
+	if _, err = m.Process([]*entry.Entry{{Data: []byte(`error`)}}); err == nil || err.Error() != `requested error` {
+		t.Fatalf("bad module error: %v", err)
+	}
+
+	// traps and timeouts are faults and the module is rebuilt on the next call
+	for _, v := range []string{`trap`, `spin`} {
+		var fe *FaultError
+		if _, err = m.Process([]*entry.Entry{{Data: []byte(v)}}); !errors.As(err, &fe) {
+			t.Fatalf("%s did not fault: %v", v, err)
+		}
+		if out, err = m.Process(ents[:1]); err != nil {
+			t.Fatalf("module did not recover after %s: %v", v, err)
+		} else if len(out) != 1 || string(out[0].Data) != `hello-wasm` {
+			t.Fatalf("bad entry after %s: %+v", v, out)
+		}
+	}
+}
+
+func TestModuleMissingExports(t *testing.T) {
+	// the smallest valid module, just the magic and version
+	bin := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	if _, err := New(bin, Config{}, &testTagger{}); !errors.Is(err, ErrMissingExport) {
+		t.Fatalf("failed to catch missing exports: %v", err)
+	}
+	if _, err := New(bin, Config{MemoryLimit: 1}, &testTagger{}); err == nil {
+		t.Fatal("failed to catch bad memory limit")
+	}
+}
@@ -0,0 +1,113 @@
+//go:build wasip1
+
+// suffix is a test plugin which appends a configured suffix to every entry and moves it to a new tag.
+// Entries containing "trap", "error", or "spin" exercise the fault, error, and timeout paths.
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"unsafe"
+)
+
+//go:wasmimport gravwell negotiate_tag
+func negotiateTag(ptr unsafe.Pointer, l uint32) int32
+
+//go:wasmimport gravwell emit
+func emit(ptr unsafe.Pointer, l uint32)
+
+//go:wasmimport gravwell set_error
+func setError(ptr unsafe.Pointer, l uint32)
+
+//go:wasmimport gravwell log
+func log(ptr unsafe.Pointer, l uint32)
+
+var (
+	bufs   = map[uint32][]byte{}
+	suffix []byte
+	tag    uint16
+)
+
+//go:wasmexport gw_alloc
+func gwAlloc(sz uint32) uint32 {
+	b := make([]byte, sz+1)
+	p := uint32(uintptr(unsafe.Pointer(&b[0])))
+	bufs[p] = b[:sz]
+	return p
+}
+
+func take(ptr uint32) []byte {
+	b := bufs[ptr]
+	delete(bufs, ptr)
+	return b
+}
+
+func str(s string) (unsafe.Pointer, uint32) {
+	b := []byte(s)
+	return unsafe.Pointer(&b[0]), uint32(len(b))
+}
+
+//go:wasmexport gw_config
+func gwConfig(ptr, l uint32) int32 {
+	var params map[string][]string
+	if err := json.Unmarshal(take(ptr), &params); err != nil {
+		return 1
+	}
+	for k, v := range params {
+		if k == `suffix` && len(v) > 0 {
+			suffix = []byte(v[0])
+		}
+	}
+	t := negotiateTag(str(`wasm`))
+	if t < 0 {
+		return 1
+	}
+	tag = uint16(t)
+	log(str(`configured`))
+	return 0
+}
+
+//go:wasmexport gw_process
+func gwProcess(ptr, l uint32) int32 {
+	b := take(ptr)
+	cnt := binary.LittleEndian.Uint32(b)
+	b = b[4:]
+	out := binary.LittleEndian.AppendUint32(nil, cnt)
+	for i := uint32(0); i < cnt; i++ {
+		out = append(out, b[:16]...) // timestamp
+		out = binary.LittleEndian.AppendUint16(out, tag)
+		srcLen := int(b[18])
+		out = append(out, b[18:19+srcLen]...)
+		b = b[19+srcLen:]
+		dl := binary.LittleEndian.Uint32(b)
+		data := b[4 : 4+dl]
+		b = b[4+dl:]
+		switch string(data) {
+		case `trap`:
+			panic("trap requested")
+		case `error`:
+			setError(str(`requested error`))
+			return 1
+		case `spin`:
+			for {
+			}
+		}
+		out = binary.LittleEndian.AppendUint32(out, dl+uint32(len(suffix)))
+		out = append(out, data...)
+		out = append(out, suffix...)
+		// copy the EVs through untouched
+		evc := int(binary.LittleEndian.Uint16(b))
+		end := 2
+		for j := 0; j < evc; j++ {
+			nl := int(binary.LittleEndian.Uint16(b[end:]))
+			vl := int(binary.LittleEndian.Uint16(b[end+3+nl:]))
+			end += 5 + nl + vl
+		}
+		out = append(out, b[:end]...)
+		b = b[end:]
+	}
+	emit(unsafe.Pointer(&out[0]), uint32(len(out)))
+	return 0
+}
+
+func main() {}
@@ -22,6 +22,7 @@ import (
 	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 	"github.com/gravwell/gravwell/v3/ingest/processors"
+	"github.com/gravwell/gravwell/v3/ingesters/utils"
 )
 
 const (
@@ -34,21 +35,26 @@ const (
 
 type gbl struct {
 	config.IngestConfig
-	Bind                 string
-	Max_Body             int
-	TLS_Certificate_File string
-	TLS_Key_File         string
-	Health_Check_URL     string
+	Bind                   string
+	Max_Body               int
+	TLS_Certificate_File   string
+	TLS_Key_File           string
+	Health_Check_URL       string
+	Proxy_Protocol         bool     //decode PROXY protocol v1/v2 headers from trusted upstreams
+	Proxy_Trusted_Upstream []string //CIDRs or addresses of load balancers allowed to send PROXY headers
 }
 
 type cfgReadType struct {
-	Global                   gbl
-	Attach                   attach.AttachConfig
-	Listener                 map[string]*lst
-	HEC_Compatible_Listener  map[string]*hecCompatible
-	Amazon_Firehose_Listener map[string]*afh
-	Preprocessor             processors.ProcessorConfig
-	TimeFormat               config.CustomTimeFormat
+	Global                      gbl
+	Attach                      attach.AttachConfig
+	Listener                    map[string]*lst
+	HEC_Compatible_Listener     map[string]*hecCompatible
+	Amazon_Firehose_Listener    map[string]*afh
+	OTLP_Listener               map[string]*otlp
+	Elastic_Compatible_Listener map[string]*esCompatible
+	Loki_Listener               map[string]*loki
+	Preprocessor                processors.ProcessorConfig
+	TimeFormat                  config.CustomTimeFormat
 }
 
 type lst struct {
@@ -71,6 +77,9 @@ type cfgType struct {
 	Listener     map[string]*lst
 	HECListener  map[string]*hecCompatible
 	AFHListener  map[string]*afh
+	OTLPListener map[string]*otlp
+	ESListener   map[string]*esCompatible
+	LokiListener map[string]*loki
 	Preprocessor processors.ProcessorConfig
 	TimeFormat   config.CustomTimeFormat
 }
@@ -88,6 +97,9 @@ func GetConfig(path, overlayPath string) (*cfgType, error) {
 		Listener:     cr.Listener,
 		HECListener:  cr.HEC_Compatible_Listener,
 		AFHListener:  cr.Amazon_Firehose_Listener,
+		OTLPListener: cr.OTLP_Listener,
+		ESListener:   cr.Elastic_Compatible_Listener,
+		LokiListener: cr.Loki_Listener,
 		Preprocessor: cr.Preprocessor,
 		TimeFormat:   cr.TimeFormat,
 	}
@@ -108,9 +120,11 @@ func (c *cfgType) Verify() error {
 	}
 	if err := c.ValidateTLS(); err != nil {
 		return err
+	} else if err = c.ValidateProxy(); err != nil {
+		return err
 	}
 	urls := map[route]string{}
-	if len(c.Listener) == 0 && len(c.HECListener) == 0 && len(c.AFHListener) == 0 {
+	if len(c.Listener) == 0 && len(c.HECListener) == 0 && len(c.AFHListener) == 0 && len(c.OTLPListener) == 0 && len(c.ESListener) == 0 && len(c.LokiListener) == 0 {
 		return errors.New("No Listeners specified")
 	}
 	if err := c.Preprocessor.Validate(); err != nil {
@@ -179,6 +193,63 @@ func (c *cfgType) Verify() error {
 		c.AFHListener[k] = v
 	}
 
+	grpcBinds := map[string]string{}
+	for k, v := range c.OTLPListener {
+		pth, err := v.validate(k)
+		if err != nil {
+			return err
+		}
+		rt := newRoute(http.MethodPost, pth)
+		if orig, ok := urls[rt]; ok {
+			return fmt.Errorf("URL %s duplicated in %s (was in %s)", v.URL, k, orig)
+		}
+		if v.GRPC_Bind != `` {
+			if orig, ok := grpcBinds[v.GRPC_Bind]; ok {
+				return fmt.Errorf("GRPC-Bind %s duplicated in %s (was in %s)", v.GRPC_Bind, k, orig)
+			} else if v.GRPC_Bind == c.Bind {
+				return fmt.Errorf("GRPC-Bind %s in %s conflicts with the global Bind", v.GRPC_Bind, k)
+			}
+			grpcBinds[v.GRPC_Bind] = k
+		}
+		if err := c.Preprocessor.CheckProcessors(v.Preprocessor); err != nil {
+			return fmt.Errorf("HTTP OTLP-Listener %s preprocessor invalid: %v", k, err)
+		}
+		urls[rt] = k
+		c.OTLPListener[k] = v
+	}
+
+	for k, v := range c.ESListener {
+		if _, err := v.validate(k); err != nil {
+			return err
+		}
+		for _, rt := range v.routes() {
+			if orig, ok := urls[rt]; ok {
+				return fmt.Errorf("%s %s duplicated in %s (was in %s)", rt.method, rt.uri, k, orig)
+			}
+			urls[rt] = k
+		}
+		if err := c.Preprocessor.CheckProcessors(v.Preprocessor); err != nil {
+			return fmt.Errorf("HTTP Elastic-Compatible-Listener %s preprocessor invalid: %v", k, err)
+		}
+		c.ESListener[k] = v
+	}
+
+	for k, v := range c.LokiListener {
+		pth, err := v.validate(k)
+		if err != nil {
+			return err
+		}
+		rt := newRoute(http.MethodPost, pth)
+		if orig, ok := urls[rt]; ok {
+			return fmt.Errorf("URL %s duplicated in %s (was in %s)", v.URL, k, orig)
+		}
+		if err := c.Preprocessor.CheckProcessors(v.Preprocessor); err != nil {
+			return fmt.Errorf("HTTP Loki-Listener %s preprocessor invalid: %v", k, err)
+		}
+		urls[rt] = k
+		c.LokiListener[k] = v
+	}
+
 	if len(urls) == 0 {
 		return fmt.Errorf("No listeners specified")
 	}
@@ -219,6 +290,45 @@ func (c *cfgType) Tags() (tags []string, err error) {
 			tagMp[v.Tag_Name] = true
 		}
 	}
+	for k, v := range c.OTLPListener {
+		var ltags []string
+		if ltags, err = v.tags(); err != nil {
+			err = fmt.Errorf("failed to get tags on OTLP-Listener %s %w", k, err)
+			return
+		}
+		for _, lt := range ltags {
+			if _, ok := tagMp[lt]; !ok {
+				tags = append(tags, lt)
+				tagMp[lt] = true
+			}
+		}
+	}
+	for k, v := range c.ESListener {
+		var ltags []string
+		if ltags, err = v.tags(); err != nil {
+			err = fmt.Errorf("failed to get tags on Elastic-Compatible-Listener %s %w", k, err)
+			return
+		}
+		for _, lt := range ltags {
+			if _, ok := tagMp[lt]; !ok {
+				tags = append(tags, lt)
+				tagMp[lt] = true
+			}
+		}
+	}
+	for k, v := range c.LokiListener {
+		var ltags []string
+		if ltags, err = v.tags(); err != nil {
+			err = fmt.Errorf("failed to get tags on Loki-Listener %s %w", k, err)
+			return
+		}
+		for _, lt := range ltags {
+			if _, ok := tagMp[lt]; !ok {
+				tags = append(tags, lt)
+				tagMp[lt] = true
+			}
+		}
+	}
 
 	if len(tags) == 0 {
 		err = errors.New("No tags specified")
@@ -256,6 +366,15 @@ func (g gbl) ValidateTLS() (err error) {
 	return
 }
 
+func (g gbl) ValidateProxy() (err error) {
+	if g.Proxy_Protocol {
+		_, err = utils.ParseTrustedUpstreams(g.Proxy_Trusted_Upstream)
+	} else if len(g.Proxy_Trusted_Upstream) > 0 {
+		err = errors.New("Proxy-Trusted-Upstream requires Proxy-Protocol=true")
+	}
+	return
+}
+
 func (g gbl) TLSEnabled() (r bool) {
 	r = g.TLS_Certificate_File != `` && g.TLS_Key_File != ``
 	return
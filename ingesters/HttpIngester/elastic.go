@@ -0,0 +1,503 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/gobwas/glob"
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/jsonparser"
+)
+
+const (
+	esProductHeader = `X-Elastic-Product`
+	esProduct       = `Elasticsearch`
+	esIndexEV       = `_index`
+	esTimestamp     = `@timestamp`
+
+	esIllegalArgument  = `illegal_argument_exception`
+	esMapperParsing    = `mapper_parsing_exception`
+	esValidationFailed = `action_request_validation_exception`
+	esUnavailable      = `unavailable_shards_exception`
+)
+
+var esTimestampFormats = []string{
+	time.RFC3339Nano,
+	`2006-01-02T15:04:05.999999999`,
+	`2006-01-02 15:04:05.999999999`,
+	`2006-01-02`,
+}
+
+type esIndexRouter struct {
+	pattern glob.Glob
+	tag     entry.EntryTag
+}
+
+type esHandler struct {
+	name        string
+	clusterName string
+	clusterUUID string
+	version     string
+	igst        *ingest.IngestMuxer
+	auth        authHandler
+	routers     []esIndexRouter
+	attachIndex bool
+	ignoreTs    bool
+}
+
+type esActionMeta struct {
+	Index string `json:"_index"`
+	ID    string `json:"_id"`
+}
+
+type esShards struct {
+	Total      int `json:"total"`
+	Successful int `json:"successful"`
+	Failed     int `json:"failed"`
+}
+
+type esError struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+	Index  string `json:"index,omitempty"`
+}
+
+type esBulkItem struct {
+	Index   string    `json:"_index"`
+	ID      string    `json:"_id"`
+	Version int       `json:"_version,omitempty"`
+	Result  string    `json:"result,omitempty"`
+	Shards  *esShards `json:"_shards,omitempty"`
+	Status  int       `json:"status"`
+	Error   *esError  `json:"error,omitempty"`
+}
+
+type esBulkResponse struct {
+	Took   int64                   `json:"took"`
+	Errors bool                    `json:"errors"`
+	Items  []map[string]esBulkItem `json:"items"`
+}
+
+type esErrorResponse struct {
+	Error struct {
+		RootCause []esError `json:"root_cause"`
+		esError
+	} `json:"error"`
+	Status int `json:"status"`
+}
+
+// esPending tracks a document that is waiting to be handed to the ingest muxer
+type esPending struct {
+	op   string
+	item int
+	ent  *entry.Entry
+}
+
+func newESHandler(name string, v *esCompatible, igst *ingest.IngestMuxer, lgr *log.Logger) (eh *esHandler, err error) {
+	var tms []tagMatcher
+	if tms, err = v.tagMatchers(); err != nil {
+		return
+	}
+	eh = &esHandler{
+		name:        name,
+		clusterName: v.Cluster_Name,
+		clusterUUID: esRandomID(),
+		version:     v.Version,
+		igst:        igst,
+		attachIndex: v.Attach_Index,
+		ignoreTs:    v.Ignore_Timestamps,
+	}
+	for _, tm := range tms {
+		r := esIndexRouter{}
+		if r.pattern, err = glob.Compile(tm.Value); err != nil {
+			return
+		} else if r.tag, err = igst.NegotiateTag(tm.Tag); err != nil {
+			return
+		}
+		eh.routers = append(eh.routers, r)
+	}
+	if v.Username != `` {
+		if eh.auth, err = newBasicAuthHandler(v.Username, v.Password, lgr); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (eh *esHandler) handleBulk(h *handler, cfg routeHandler, w http.ResponseWriter, r *http.Request, rdr io.Reader, ip net.IP) {
+	start := time.Now()
+	//every document is held until the batch is written, so the whole body is capped
+	lr := &io.LimitedReader{R: rdr, N: int64(maxBody + 1)}
+	scanner := bufio.NewScanner(lr)
+	scanner.Buffer(make([]byte, 64*1024), maxBody)
+
+	var resp esBulkResponse
+	var pending []esPending
+	var line int
+	next := func() ([]byte, bool) {
+		for scanner.Scan() {
+			if lr.N <= 0 {
+				break //over the limit, the final line may be truncated
+			}
+			line++
+			if bts := bytes.TrimSpace(scanner.Bytes()); len(bts) > 0 {
+				return bts, true
+			}
+		}
+		return nil, false
+	}
+	for {
+		bts, ok := next()
+		if !ok {
+			break
+		}
+		op, meta, err := parseESAction(bts)
+		if err != nil {
+			h.lgr.Info("bad bulk request", log.KV("address", ip), log.KV("es-listener", eh.name), log.KVErr(err))
+			sendESError(w, http.StatusBadRequest, esIllegalArgument, fmt.Sprintf("Malformed action/metadata line [%d], %v", line, err))
+			return
+		}
+		item := esBulkItem{
+			Index: meta.Index,
+			ID:    meta.ID,
+		}
+		if item.ID == `` {
+			item.ID = esRandomID()
+		}
+		switch op {
+		case `index`, `create`:
+			src, ok := next()
+			if !ok {
+				eh.sendTruncated(h, w, ip, lr)
+				return
+			}
+			if item.Index == `` {
+				item.setError(http.StatusBadRequest, esValidationFailed, "Validation Failed: 1: index is missing;")
+			} else if len(src) == 0 || src[0] != '{' || !json.Valid(src) {
+				item.setError(http.StatusBadRequest, esMapperParsing, "failed to parse, document is not a valid JSON object")
+			} else {
+				ent := eh.buildEntry(bytes.Clone(src), item.Index, cfg.tag, ip)
+				cfg.paramAttacher.attach(ent)
+				pending = append(pending, esPending{op: op, item: len(resp.Items), ent: ent})
+				item.Version = 1
+				item.Result = `created`
+				item.Shards = &esShards{Total: 1, Successful: 1}
+				item.Status = http.StatusCreated
+			}
+		case `update`:
+			//updates carry a partial document which we cannot apply, consume it and reject the action
+			if _, ok := next(); !ok {
+				eh.sendTruncated(h, w, ip, lr)
+				return
+			}
+			item.setError(http.StatusBadRequest, esIllegalArgument, "update operations are not supported")
+		case `delete`:
+			item.setError(http.StatusBadRequest, esIllegalArgument, "delete operations are not supported")
+		}
+		if item.Error != nil {
+			resp.Errors = true
+		}
+		resp.Items = append(resp.Items, map[string]esBulkItem{op: item})
+	}
+	if lr.N <= 0 {
+		eh.sendTooLarge(h, w, ip)
+		return
+	} else if err := scanner.Err(); err != nil {
+		h.lgr.Info("bad bulk request", log.KV("address", ip), log.KV("es-listener", eh.name), log.KVErr(err))
+		sendESError(w, http.StatusBadRequest, esIllegalArgument, err.Error())
+		return
+	} else if len(resp.Items) == 0 {
+		sendESError(w, http.StatusBadRequest, esValidationFailed, "Validation Failed: 1: no requests added;")
+		return
+	}
+
+	if len(pending) > 0 {
+		batch := make([]*entry.Entry, 0, len(pending))
+		var sz uint64
+		for _, p := range pending {
+			batch = append(batch, p.ent)
+			sz += p.ent.Size()
+		}
+		if err := cfg.pproc.ProcessBatchContext(batch, exitCtx); err != nil {
+			h.lgr.Error("failed to send entries", log.KV("es-listener", eh.name), log.KVErr(err))
+			//flag every accepted document as unavailable so that clients retry them
+			resp.Errors = true
+			for _, p := range pending {
+				item := resp.Items[p.item][p.op]
+				item.Version, item.Result, item.Shards = 0, ``, nil
+				item.setError(http.StatusServiceUnavailable, esUnavailable, "failed to ingest document")
+				resp.Items[p.item][p.op] = item
+			}
+		} else {
+			h.entSI.Add(uint64(len(batch)))
+			h.bytesSI.Add(sz)
+		}
+	}
+	resp.Took = time.Since(start).Milliseconds()
+	sendESJSON(w, http.StatusOK, resp)
+}
+
+// parseESAction parses a bulk action/metadata line such as {"index":{"_index":"logs","_id":"1"}}
+func parseESAction(b []byte) (op string, meta esActionMeta, err error) {
+	var act map[string]json.RawMessage
+	if err = json.Unmarshal(b, &act); err != nil {
+		err = fmt.Errorf("expected a JSON object: %v", err)
+		return
+	} else if len(act) != 1 {
+		err = fmt.Errorf("expected exactly one action but found %d", len(act))
+		return
+	}
+	for k, v := range act {
+		op = k
+		switch op {
+		case `index`, `create`, `update`, `delete`:
+		default:
+			err = fmt.Errorf("expected one of [create, delete, index, update] but found [%s]", op)
+			return
+		}
+		if err = json.Unmarshal(v, &meta); err != nil {
+			err = fmt.Errorf("invalid %s metadata: %v", op, err)
+		}
+	}
+	return
+}
+
+func (eh *esHandler) buildEntry(src []byte, index string, def entry.EntryTag, ip net.IP) *entry.Entry {
+	ent := &entry.Entry{
+		TS:   entry.Now(),
+		SRC:  ip,
+		Tag:  eh.route(index, def),
+		Data: src,
+	}
+	if !eh.ignoreTs {
+		if ts, ok := esDocTimestamp(src); ok {
+			ent.TS = entry.FromStandard(ts)
+		}
+	}
+	if eh.attachIndex {
+		ent.AddEnumeratedValueEx(esIndexEV, index)
+	}
+	return ent
+}
+
+// route returns the tag of the first index pattern that matches
+func (eh *esHandler) route(index string, def entry.EntryTag) entry.EntryTag {
+	for _, r := range eh.routers {
+		if r.pattern.Match(index) {
+			return r.tag
+		}
+	}
+	return def
+}
+
+// esDocTimestamp extracts the @timestamp field from a document, it may be a date string or epoch milliseconds
+func esDocTimestamp(doc []byte) (ts time.Time, ok bool) {
+	val, vt, _, err := jsonparser.Get(doc, esTimestamp)
+	if err != nil {
+		return
+	}
+	switch vt {
+	case jsonparser.String:
+		s := string(val)
+		for _, f := range esTimestampFormats {
+			if t, err := time.Parse(f, s); err == nil {
+				return t, true
+			}
+		}
+	case jsonparser.Number:
+		if ms, err := strconv.ParseInt(string(val), 10, 64); err == nil {
+			return time.UnixMilli(ms), true
+		} else if f, err := strconv.ParseFloat(string(val), 64); err == nil {
+			return time.UnixMilli(int64(f)), true
+		}
+	}
+	return
+}
+
+func (item *esBulkItem) setError(status int, tp, reason string) {
+	item.Status = status
+	item.Error = &esError{
+		Type:   tp,
+		Reason: reason,
+		Index:  item.Index,
+	}
+}
+
+func (eh *esHandler) checkAuth(w http.ResponseWriter, r *http.Request) bool {
+	if eh.auth != nil {
+		if err := eh.auth.AuthRequest(r); err != nil {
+			sendESError(w, http.StatusUnauthorized, `security_exception`, "unable to authenticate user")
+			return false
+		}
+	}
+	return true
+}
+
+// serveInfo answers the root endpoint that clients query to discover the cluster version
+func (eh *esHandler) serveInfo(w http.ResponseWriter, r *http.Request) {
+	if !eh.checkAuth(w, r) {
+		return
+	} else if r.Method == http.MethodHead {
+		w.Header().Set(esProductHeader, esProduct)
+		return
+	}
+	name, _ := os.Hostname()
+	info := map[string]interface{}{
+		`name`:         name,
+		`cluster_name`: eh.clusterName,
+		`cluster_uuid`: eh.clusterUUID,
+		`version`: map[string]interface{}{
+			`number`:                              eh.version,
+			`build_flavor`:                        `default`,
+			`build_type`:                          `tar`,
+			`lucene_version`:                      `9.8.0`,
+			`minimum_wire_compatibility_version`:  `7.17.0`,
+			`minimum_index_compatibility_version`: `7.0.0`,
+		},
+		`tagline`: `You Know, for Search`,
+	}
+	sendESJSON(w, http.StatusOK, info)
+}
+
+// serveHealth answers the cluster health endpoint, the cluster is green when we have a hot ingest connection
+func (eh *esHandler) serveHealth(w http.ResponseWriter, r *http.Request) {
+	if !eh.checkAuth(w, r) {
+		return
+	}
+	status := `green`
+	if cnt, err := eh.igst.Hot(); err != nil || cnt == 0 {
+		status = `red`
+	}
+	health := map[string]interface{}{
+		`cluster_name`:                     eh.clusterName,
+		`status`:                           status,
+		`timed_out`:                        false,
+		`number_of_nodes`:                  1,
+		`number_of_data_nodes`:             1,
+		`active_primary_shards`:            0,
+		`active_shards`:                    0,
+		`relocating_shards`:                0,
+		`initializing_shards`:              0,
+		`unassigned_shards`:                0,
+		`delayed_unassigned_shards`:        0,
+		`number_of_pending_tasks`:          0,
+		`number_of_in_flight_fetch`:        0,
+		`task_max_waiting_in_queue_millis`: 0,
+		`active_shards_percent_as_number`:  100.0,
+	}
+	sendESJSON(w, http.StatusOK, health)
+}
+
+func sendESJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set(`Content-Type`, `application/json`)
+	w.Header().Set(esProductHeader, esProduct)
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// sendTooLarge rejects a bulk request whose body is over the maxBody limit
+func (eh *esHandler) sendTooLarge(h *handler, w http.ResponseWriter, ip net.IP) {
+	h.lgr.Info("request too large", log.KV("address", ip), log.KV("es-listener", eh.name), log.KV("max-body", maxBody))
+	sendESError(w, http.StatusRequestEntityTooLarge, esIllegalArgument, fmt.Sprintf("request body is larger than %d bytes", maxBody))
+}
+
+// sendTruncated handles a body that ends part way through an action, either because it
+// was over the size limit or because the client did not send the document line
+func (eh *esHandler) sendTruncated(h *handler, w http.ResponseWriter, ip net.IP, lr *io.LimitedReader) {
+	if lr.N <= 0 {
+		eh.sendTooLarge(h, w, ip)
+		return
+	}
+	sendESError(w, http.StatusBadRequest, esIllegalArgument, "The bulk request must be terminated by a newline [\\n]")
+}
+
+func sendESError(w http.ResponseWriter, code int, tp, reason string) {
+	var resp esErrorResponse
+	resp.Error.esError = esError{Type: tp, Reason: reason}
+	resp.Error.RootCause = []esError{resp.Error.esError}
+	resp.Status = code
+	sendESJSON(w, code, resp)
+}
+
+// esRandomID generates a document ID in the same 20 character URL safe form Elasticsearch uses
+func esRandomID() string {
+	b := make([]byte, 15)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// routes returns every route that an Elastic-Compatible-Listener serves
+func (v *esCompatible) routes() []route {
+	return []route{
+		newRoute(http.MethodPost, v.bulkURL()),
+		newRoute(http.MethodPut, v.bulkURL()),
+		newRoute(http.MethodGet, v.URL),
+		newRoute(http.MethodHead, v.URL),
+		newRoute(http.MethodGet, path.Join(v.URL, `_cluster/health`)),
+	}
+}
+
+func includeESListeners(hnd *handler, igst *ingest.IngestMuxer, cfg *cfgType, lgr *log.Logger) (err error) {
+	for k, v := range cfg.ESListener {
+		var eh *esHandler
+		if eh, err = newESHandler(k, v, igst, lgr); err != nil {
+			lg.Error("failed to build Elastic-Compatible-Listener", log.KV("es-listener", k), log.KVErr(err))
+			return
+		}
+		hcfg := routeHandler{
+			handler:  eh.handleBulk,
+			auth:     eh.auth,
+			ignoreTs: v.Ignore_Timestamps,
+		}
+		if hcfg.tag, err = igst.NegotiateTag(v.Tag_Name); err != nil {
+			lg.Error("failed to pull tag", log.KV("tag", v.Tag_Name), log.KVErr(err))
+			return
+		}
+		if hcfg.pproc, err = cfg.Preprocessor.ProcessorSet(igst, v.Preprocessor); err != nil {
+			lg.Error("preprocessor construction error", log.KVErr(err))
+			return
+		}
+		rts := v.routes()
+		for _, rt := range rts[:2] {
+			if err = hnd.addHandler(rt.method, rt.uri, hcfg); err != nil {
+				lg.Error("failed to add Elastic-Compatible-Listener bulk handler", log.KVErr(err))
+				return
+			}
+		}
+		for _, rt := range rts[2:4] {
+			if err = hnd.addCustomHandler(rt.method, rt.uri, http.HandlerFunc(eh.serveInfo)); err != nil {
+				lg.Error("failed to add Elastic-Compatible-Listener info handler", log.KVErr(err))
+				return
+			}
+		}
+		if err = hnd.addCustomHandler(rts[4].method, rts[4].uri, http.HandlerFunc(eh.serveHealth)); err != nil {
+			lg.Error("failed to add Elastic-Compatible-Listener health handler", log.KVErr(err))
+			return
+		}
+		debugout("Elastic Handler URL %s handling %s\n", v.bulkURL(), v.Tag_Name)
+	}
+	return
+}
@@ -0,0 +1,114 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+
+	"github.com/gobwas/glob"
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	defaultESUrl         string = `/`
+	defaultESClusterName string = `gravwell`
+	defaultESVersion     string = `8.11.0`
+)
+
+type esCompatible struct {
+	URL               string   //base URL, defaults to "/", the bulk endpoint is at <URL>/_bulk
+	Username          string   //optional basic authentication username
+	Password          string   `json:"-"` //DO NOT SEND THIS when marshalling
+	Tag_Name          string   //the tag to assign to documents that do not match a Tag-Match
+	Tag_Match         []string //index-pattern:tag, patterns may contain wildcards and the first match wins
+	Attach_Index      bool     //attach the target index as the "_index" enumerated value
+	Ignore_Timestamps bool
+	Cluster_Name      string //cluster name reported to clients
+	Version           string //Elasticsearch version reported to clients
+	Preprocessor      []string
+}
+
+func (v *esCompatible) validate(name string) (string, error) {
+	if len(v.URL) == 0 {
+		v.URL = defaultESUrl
+	}
+	p, err := url.Parse(v.URL)
+	if err != nil {
+		return ``, fmt.Errorf("URL structure is invalid: %v", err)
+	}
+	if p.Scheme != `` {
+		return ``, errors.New("May not specify scheme in listening URL")
+	} else if p.Host != `` {
+		return ``, errors.New("May not specify host in listening URL")
+	}
+	pth := path.Clean(`/` + p.Path)
+	if len(v.Tag_Name) == 0 {
+		v.Tag_Name = entry.DefaultTagName
+	}
+	if ingest.CheckTag(v.Tag_Name) != nil {
+		return ``, errors.New("Invalid characters in the \"" + v.Tag_Name + "\"Tag-Name for " + name)
+	}
+	if (v.Username == ``) != (v.Password == ``) {
+		return ``, fmt.Errorf("Elastic-Compatible-Listener %s must specify both Username and Password", name)
+	}
+	if v.Cluster_Name == `` {
+		v.Cluster_Name = defaultESClusterName
+	}
+	if v.Version == `` {
+		v.Version = defaultESVersion
+	}
+	if _, err = v.tagMatchers(); err != nil {
+		return ``, fmt.Errorf("Elastic-Compatible-Listener %s has invalid Tag-Match %w", name, err)
+	}
+	//normalize the path
+	v.URL = pth
+	return pth, nil
+}
+
+// bulkURL is the path of the bulk API endpoint
+func (v *esCompatible) bulkURL() string {
+	return path.Join(v.URL, `_bulk`)
+}
+
+func (v *esCompatible) tagMatchers() (tags []tagMatcher, err error) {
+	var tm tagMatcher
+	for i := range v.Tag_Match {
+		if tm.Value, tm.Tag, err = extractElementTag(v.Tag_Match[i]); err != nil {
+			break
+		} else if _, err = glob.Compile(tm.Value); err != nil {
+			err = fmt.Errorf("Tag-Match index pattern %q is invalid %w", tm.Value, err)
+			break
+		}
+		tags = append(tags, tm)
+	}
+	return
+}
+
+func (v *esCompatible) tags() (tags []string, err error) {
+	var tms []tagMatcher
+	if tms, err = v.tagMatchers(); err != nil {
+		return
+	}
+	mp := map[string]bool{}
+	if v.Tag_Name != `` {
+		tags = []string{v.Tag_Name}
+		mp[v.Tag_Name] = true
+	}
+	for _, tm := range tms {
+		if _, ok := mp[tm.Tag]; !ok {
+			mp[tm.Tag] = true
+			tags = append(tags, tm.Tag)
+		}
+	}
+	return
+}
@@ -0,0 +1,283 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gobwas/glob"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+func TestParseESAction(t *testing.T) {
+	tests := []struct {
+		in    string
+		op    string
+		index string
+		id    string
+		bad   bool
+	}{
+		{in: `{"index":{"_index":"logs","_id":"1"}}`, op: `index`, index: `logs`, id: `1`},
+		{in: `{"create":{"_index":"logs"}}`, op: `create`, index: `logs`},
+		{in: `{"update":{"_index":"logs","_id":"2"}}`, op: `update`, index: `logs`, id: `2`},
+		{in: `{"delete":{"_id":"3"}}`, op: `delete`, id: `3`},
+		{in: `{"index":{}}`, op: `index`},
+		{in: `not json`, bad: true},
+		{in: `{}`, bad: true},
+		{in: `{"index":{},"create":{}}`, bad: true},
+		{in: `{"upsert":{"_index":"logs"}}`, bad: true},
+		{in: `{"index":"logs"}`, bad: true},
+		{in: `{"index":{"_index":5}}`, bad: true},
+	}
+	for _, tc := range tests {
+		op, meta, err := parseESAction([]byte(tc.in))
+		if tc.bad {
+			if err == nil {
+				t.Fatalf("%s: bad action was accepted", tc.in)
+			}
+			continue
+		} else if err != nil {
+			t.Fatalf("%s: %v", tc.in, err)
+		}
+		if op != tc.op || meta.Index != tc.index || meta.ID != tc.id {
+			t.Fatalf("%s: bad action %s %+v", tc.in, op, meta)
+		}
+	}
+}
+
+func TestESDocTimestamp(t *testing.T) {
+	tests := []struct {
+		doc string
+		exp time.Time
+		ok  bool
+	}{
+		{`{"@timestamp":"2024-05-01T12:30:45.123Z"}`, time.Date(2024, 5, 1, 12, 30, 45, 123e6, time.UTC), true},
+		{`{"@timestamp":"2024-05-01T12:30:45-06:00"}`, time.Date(2024, 5, 1, 18, 30, 45, 0, time.UTC), true},
+		{`{"@timestamp":"2024-05-01T12:30:45.5"}`, time.Date(2024, 5, 1, 12, 30, 45, 5e8, time.UTC), true},
+		{`{"@timestamp":"2024-05-01 12:30:45"}`, time.Date(2024, 5, 1, 12, 30, 45, 0, time.UTC), true},
+		{`{"@timestamp":"2024-05-01"}`, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), true},
+		{`{"@timestamp":1714566645123}`, time.UnixMilli(1714566645123), true}, // epoch milliseconds
+		{`{"@timestamp":1714566645123.0}`, time.UnixMilli(1714566645123), true},
+		{`{"@timestamp":"May 1st"}`, time.Time{}, false},
+		{`{"@timestamp":true}`, time.Time{}, false},
+		{`{"timestamp":"2024-05-01"}`, time.Time{}, false},
+	}
+	for _, tc := range tests {
+		ts, ok := esDocTimestamp([]byte(tc.doc))
+		if ok != tc.ok {
+			t.Fatalf("%s: bad extraction %v", tc.doc, ok)
+		} else if ok && !ts.Equal(tc.exp) {
+			t.Fatalf("%s: bad timestamp %v != %v", tc.doc, ts, tc.exp)
+		}
+	}
+}
+
+func newTestESHandler(t *testing.T, v *esCompatible) *esHandler {
+	t.Helper()
+	tms, err := v.tagMatchers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	eh := &esHandler{
+		name:        `test`,
+		attachIndex: v.Attach_Index,
+		ignoreTs:    v.Ignore_Timestamps,
+	}
+	for i, tm := range tms {
+		r := esIndexRouter{tag: entry.EntryTag(i + 1)}
+		if r.pattern, err = glob.Compile(tm.Value); err != nil {
+			t.Fatal(err)
+		}
+		eh.routers = append(eh.routers, r)
+	}
+	return eh
+}
+
+func TestESRoute(t *testing.T) {
+	eh := newTestESHandler(t, &esCompatible{Tag_Match: []string{
+		`filebeat-*:filebeat`,
+		`logs-*-prod:prodlogs`,
+		`logs-*:logs`,
+	}})
+	const def entry.EntryTag = 100
+	for index, exp := range map[string]entry.EntryTag{
+		`filebeat-8.13.0-2024.05.01`: 1,
+		`logs-nginx-prod`:            2, // first match wins
+		`logs-nginx-dev`:             3,
+		`metrics-system`:             def,
+		``:                           def,
+	} {
+		if tag := eh.route(index, def); tag != exp {
+			t.Fatalf("%q routed to %d, expected %d", index, tag, exp)
+		}
+	}
+}
+
+func postBulk(eh *esHandler, h *handler, rh routeHandler, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, `/_bulk`, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	eh.handleBulk(h, rh, rec, req, req.Body, net.ParseIP(`10.0.0.1`))
+	return rec
+}
+
+func TestESHandleBulk(t *testing.T) {
+	const def entry.EntryTag = 100
+	eh := newTestESHandler(t, &esCompatible{
+		Tag_Match:    []string{`filebeat-*:filebeat`},
+		Attach_Index: true,
+	})
+	h, rh, tw := newTestRoute(t, def)
+	body := strings.Join([]string{
+		`{"index":{"_index":"filebeat-2024","_id":"a"}}`,
+		`{"@timestamp":"2024-05-01T12:30:45Z","message":"one"}`,
+		``, // blank lines are ignored
+		`{"create":{"_index":"other"}}`,
+		`{"@timestamp":1714566645000,"message":"two"}`,
+		`{"index":{"_id":"c"}}`,
+		`{"message":"missing index"}`,
+		`{"index":{"_index":"other"}}`,
+		`{"message":`,
+		`{"create":{"_index":"other"}}`,
+		`["not","an","object"]`,
+		`{"update":{"_index":"other","_id":"a"}}`,
+		`{"doc":{"message":"changed"}}`,
+		`{"delete":{"_index":"other","_id":"a"}}`,
+	}, "\n") + "\n"
+	rec := postBulk(eh, h, rh, body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("bad status %d: %s", rec.Code, rec.Body.String())
+	} else if rec.Header().Get(esProductHeader) != esProduct {
+		t.Fatal("missing product header")
+	}
+	var resp esBulkResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	} else if !resp.Errors || len(resp.Items) != 7 {
+		t.Fatalf("bad bulk response %+v", resp)
+	}
+	exp := []struct {
+		op     string
+		status int
+		etype  string
+	}{
+		{`index`, http.StatusCreated, ``},
+		{`create`, http.StatusCreated, ``},
+		{`index`, http.StatusBadRequest, esValidationFailed},
+		{`index`, http.StatusBadRequest, esMapperParsing},
+		{`create`, http.StatusBadRequest, esMapperParsing},
+		{`update`, http.StatusBadRequest, esIllegalArgument},
+		{`delete`, http.StatusBadRequest, esIllegalArgument},
+	}
+	for i, e := range exp {
+		item, ok := resp.Items[i][e.op]
+		if !ok || len(resp.Items[i]) != 1 {
+			t.Fatalf("item %d is not a %s result: %+v", i, e.op, resp.Items[i])
+		} else if item.Status != e.status {
+			t.Fatalf("item %d has status %d, expected %d", i, item.Status, e.status)
+		} else if e.etype == `` && (item.Error != nil || item.Result != `created` || item.ID == ``) {
+			t.Fatalf("item %d is not a successful result: %+v", i, item)
+		} else if e.etype != `` && (item.Error == nil || item.Error.Type != e.etype || item.Result != ``) {
+			t.Fatalf("item %d has the wrong error: %+v", i, item)
+		}
+	}
+	if id := resp.Items[0][`index`].ID; id != `a` {
+		t.Fatalf("supplied document ID was not echoed: %q", id)
+	} else if id = resp.Items[1][`create`].ID; len(id) != 20 {
+		t.Fatalf("bad generated document ID %q", id)
+	}
+
+	if len(tw.ents) != 2 {
+		t.Fatalf("bad entry count %d", len(tw.ents))
+	}
+	if ent := tw.ents[0]; ent.Tag != 1 || !ent.TS.StandardTime().Equal(time.Date(2024, 5, 1, 12, 30, 45, 0, time.UTC)) {
+		t.Fatalf("bad routed entry %+v", ent)
+	} else if evString(t, ent, esIndexEV) != `filebeat-2024` {
+		t.Fatal("bad index EV")
+	}
+	if ent := tw.ents[1]; ent.Tag != def || !ent.TS.StandardTime().Equal(time.UnixMilli(1714566645000)) {
+		t.Fatalf("bad default entry %+v", ent)
+	}
+}
+
+func TestESHandleBulkErrors(t *testing.T) {
+	eh := newTestESHandler(t, &esCompatible{})
+	h, rh, tw := newTestRoute(t, 0)
+	for _, tc := range []struct {
+		body  string
+		etype string
+	}{
+		{"not json\n", esIllegalArgument},
+		{`{"upsert":{"_index":"logs"}}` + "\n{}\n", esIllegalArgument},
+		{`{"index":{"_index":"logs"}}` + "\n", esIllegalArgument}, // no source line
+		{`{"update":{"_index":"logs"}}` + "\n", esIllegalArgument},
+		{"\n\n", esValidationFailed},
+	} {
+		rec := postBulk(eh, h, rh, tc.body)
+		var resp esErrorResponse
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%q: bad status %d", tc.body, rec.Code)
+		} else if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		} else if resp.Status != http.StatusBadRequest || resp.Error.Type != tc.etype || len(resp.Error.RootCause) != 1 || resp.Error.Reason == `` {
+			t.Fatalf("%q: bad error response %+v", tc.body, resp)
+		}
+	}
+	if len(tw.ents) != 0 {
+		t.Fatalf("malformed requests produced entries: %d", len(tw.ents))
+	}
+
+	// documents that the muxer refuses are flagged as unavailable so clients retry them
+	tw.err = errors.New("nope")
+	rec := postBulk(eh, h, rh, `{"index":{"_index":"logs"}}`+"\n"+`{"message":"one"}`+"\n")
+	var resp esBulkResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	} else if item := resp.Items[0][`index`]; !resp.Errors || item.Status != http.StatusServiceUnavailable || item.Error == nil || item.Error.Type != esUnavailable || item.Result != `` {
+		t.Fatalf("bad unavailable response %+v", resp)
+	}
+}
+
+func TestESHandleBulkTooLarge(t *testing.T) {
+	eh := newTestESHandler(t, &esCompatible{})
+	h, rh, tw := newTestRoute(t, 0)
+	defer func(v int) { maxBody = v }(maxBody)
+	maxBody = 1024
+
+	doc := `{"index":{"_index":"logs"}}` + "\n" + `{"message":"` + strings.Repeat(`x`, 64) + `"}` + "\n"
+	fits := strings.Repeat(doc, maxBody/len(doc))
+	if rec := postBulk(eh, h, rh, fits); rec.Code != http.StatusOK {
+		t.Fatalf("bad status %d for a body under the limit", rec.Code)
+	} else if len(tw.ents) != maxBody/len(doc) {
+		t.Fatalf("bad entry count %d", len(tw.ents))
+	}
+
+	// shift where the limit lands relative to the action and document lines
+	tw.ents = nil
+	for _, body := range []string{fits + doc, fits + doc[:len(doc)-10], fits + doc[:10]} {
+		rec := postBulk(eh, h, rh, body+strings.Repeat(doc, 4))
+		var resp esErrorResponse
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("bad status %d for an oversized body", rec.Code)
+		} else if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		} else if resp.Status != http.StatusRequestEntityTooLarge {
+			t.Fatalf("bad error response %+v", resp)
+		}
+	}
+	if len(tw.ents) != 0 {
+		t.Fatalf("oversized requests produced entries: %d", len(tw.ents))
+	}
+}
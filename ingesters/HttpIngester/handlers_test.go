@@ -0,0 +1,84 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+)
+
+const testMaxBody = 1024 * 1024
+
+type testWriter struct {
+	sync.Mutex
+	ents []*entry.Entry
+	err  error
+}
+
+func (tw *testWriter) WriteEntry(ent *entry.Entry) error {
+	tw.Lock()
+	defer tw.Unlock()
+	if tw.err != nil {
+		return tw.err
+	}
+	tw.ents = append(tw.ents, ent)
+	return nil
+}
+
+func (tw *testWriter) WriteEntryContext(ctx context.Context, ent *entry.Entry) error {
+	return tw.WriteEntry(ent)
+}
+
+func (tw *testWriter) WriteBatch(ents []*entry.Entry) error {
+	for _, ent := range ents {
+		if err := tw.WriteEntry(ent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tw *testWriter) WriteBatchContext(ctx context.Context, ents []*entry.Entry) error {
+	return tw.WriteBatch(ents)
+}
+
+// newTestRoute builds a handler and route that write into the returned test writer
+func newTestRoute(t *testing.T, tag entry.EntryTag) (*handler, routeHandler, *testWriter) {
+	t.Helper()
+	if maxBody == 0 {
+		maxBody = testMaxBody
+	}
+	tw := &testWriter{}
+	h := &handler{
+		lgr: log.NewDiscardLogger(),
+	}
+	rh := routeHandler{
+		tag:   tag,
+		pproc: processors.NewProcessorSet(tw),
+	}
+	return h, rh, tw
+}
+
+func evString(t *testing.T, ent *entry.Entry, name string) string {
+	t.Helper()
+	ev, ok := ent.GetEnumeratedValue(name)
+	if !ok {
+		t.Fatalf("missing enumerated value %q", name)
+	}
+	s, ok := ev.(string)
+	if !ok {
+		t.Fatalf("enumerated value %q is a %T", name, ev)
+	}
+	return s
+}
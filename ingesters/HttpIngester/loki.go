@@ -0,0 +1,420 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	lokiContentProtobuf = `application/x-protobuf`
+	lokiContentJSON     = `application/json`
+)
+
+var (
+	ErrLokiUnsupportedContent = errors.New("unsupported Loki content type")
+	ErrLokiInvalidLabels      = errors.New("invalid Loki label set")
+	ErrLokiInvalidValue       = errors.New("invalid Loki stream value")
+)
+
+type lokiLabel struct {
+	name  string
+	value string
+}
+
+type lokiLine struct {
+	ts   int64 //nanoseconds since the epoch
+	line []byte
+	meta []lokiLabel //structured metadata
+}
+
+type lokiStream struct {
+	labels  []lokiLabel
+	entries []lokiLine
+}
+
+type lokiRouter struct {
+	matchers []lokiLabelMatcher
+	tag      entry.EntryTag
+}
+
+type lokiHandler struct {
+	name     string
+	ignoreTs bool
+	routers  []lokiRouter
+	attach   map[string]bool //nil means attach everything
+}
+
+func newLokiHandler(name string, v *loki, igst *ingest.IngestMuxer) (lh *lokiHandler, err error) {
+	var rules []lokiTagRule
+	if rules, err = v.tagRules(); err != nil {
+		return
+	}
+	lh = &lokiHandler{
+		name:     name,
+		ignoreTs: v.Ignore_Timestamps,
+	}
+	for _, r := range rules {
+		lr := lokiRouter{matchers: r.matchers}
+		if lr.tag, err = igst.NegotiateTag(r.tag); err != nil {
+			return
+		}
+		lh.routers = append(lh.routers, lr)
+	}
+	for _, l := range v.Attach_Labels {
+		if l = strings.TrimSpace(l); l != `` {
+			if lh.attach == nil {
+				lh.attach = map[string]bool{}
+			}
+			lh.attach[l] = true
+		}
+	}
+	return
+}
+
+func (lh *lokiHandler) handle(h *handler, cfg routeHandler, w http.ResponseWriter, r *http.Request, rdr io.Reader, ip net.IP) {
+	ct, _, err := mime.ParseMediaType(r.Header.Get(`Content-Type`))
+	if err != nil {
+		ct = lokiContentProtobuf //promtail always sends protobuf
+	}
+	lr := io.LimitedReader{R: rdr, N: int64(maxBody + 1)}
+	b, err := io.ReadAll(&lr)
+	if err != nil {
+		h.lgr.Info("bad request", log.KV("address", ip), log.KV("loki-listener", lh.name), log.KVErr(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if len(b) > maxBody {
+		h.lgr.Info("request too large", log.KV("address", ip), log.KV("loki-listener", lh.name), log.KV("max-body", maxBody))
+		http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	var streams []lokiStream
+	switch ct {
+	case lokiContentProtobuf:
+		var raw []byte
+		if n, lerr := snappy.DecodedLen(b); lerr != nil {
+			err = lerr
+		} else if n > maxBody {
+			h.lgr.Info("request too large", log.KV("address", ip), log.KV("loki-listener", lh.name), log.KV("max-body", maxBody))
+			http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+			return
+		} else if raw, err = snappy.Decode(nil, b); err == nil {
+			streams, err = decodeLokiPush(raw)
+		}
+	case lokiContentJSON:
+		streams, err = decodeLokiJSON(b)
+	default:
+		err = ErrLokiUnsupportedContent
+	}
+	if err != nil {
+		h.lgr.Info("bad request", log.KV("address", ip), log.KV("loki-listener", lh.name), log.KV("content-type", ct), log.KVErr(err))
+		if err == ErrLokiUnsupportedContent {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	var batch []*entry.Entry
+	var sz uint64
+	now := entry.Now()
+	for _, s := range streams {
+		tag := lh.route(s.labels, cfg.tag)
+		for _, l := range s.entries {
+			ent := &entry.Entry{
+				TS:   now,
+				SRC:  ip,
+				Tag:  tag,
+				Data: l.line,
+			}
+			if !lh.ignoreTs && l.ts != 0 {
+				ent.TS = entry.UnixTime(l.ts/1e9, l.ts%1e9)
+			}
+			lh.attachLabels(ent, s.labels)
+			lh.attachLabels(ent, l.meta)
+			cfg.paramAttacher.attach(ent)
+			batch = append(batch, ent)
+			sz += ent.Size()
+		}
+	}
+	if len(batch) > 0 {
+		if err = cfg.pproc.ProcessBatchContext(batch, exitCtx); err != nil {
+			h.lgr.Error("failed to send entries", log.KV("loki-listener", lh.name), log.KVErr(err))
+			http.Error(w, "failed to ingest entries", http.StatusServiceUnavailable)
+			return
+		}
+		h.entSI.Add(uint64(len(batch)))
+		h.bytesSI.Add(sz)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// route returns the tag of the first rule whose label matchers all match the stream
+func (lh *lokiHandler) route(lbls []lokiLabel, def entry.EntryTag) entry.EntryTag {
+	for _, r := range lh.routers {
+		matched := true
+		for _, m := range r.matchers {
+			if v, ok := lokiLabelValue(lbls, m.label); !ok || !m.value.Match(v) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return r.tag
+		}
+	}
+	return def
+}
+
+func (lh *lokiHandler) attachLabels(ent *entry.Entry, lbls []lokiLabel) {
+	for _, l := range lbls {
+		if lh.attach == nil || lh.attach[l.name] {
+			ent.AddEnumeratedValueEx(l.name, l.value)
+		}
+	}
+}
+
+func lokiLabelValue(lbls []lokiLabel, name string) (string, bool) {
+	for _, l := range lbls {
+		if l.name == name {
+			return l.value, true
+		}
+	}
+	return ``, false
+}
+
+// parseLokiLabels parses a Prometheus style label set such as {job="nginx", host="web01"}
+func parseLokiLabels(s string) (lbls []lokiLabel, err error) {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 || s[0] != '{' || s[len(s)-1] != '}' {
+		err = ErrLokiInvalidLabels
+		return
+	}
+	s = s[1 : len(s)-1]
+	for {
+		if s = strings.TrimLeft(s, " \t,"); s == `` {
+			break
+		}
+		name, rest, ok := strings.Cut(s, `=`)
+		if name = strings.TrimSpace(name); !ok || name == `` {
+			err = ErrLokiInvalidLabels
+			return
+		}
+		rest = strings.TrimSpace(rest)
+		var q, val string
+		if q, err = strconv.QuotedPrefix(rest); err != nil {
+			err = fmt.Errorf("%w: bad value for label %s", ErrLokiInvalidLabels, name)
+			return
+		} else if val, err = strconv.Unquote(q); err != nil {
+			err = fmt.Errorf("%w: bad value for label %s", ErrLokiInvalidLabels, name)
+			return
+		}
+		lbls = append(lbls, lokiLabel{name: name, value: val})
+		s = rest[len(q):]
+	}
+	return
+}
+
+// lokiFields walks the fields of a protobuf message, handing each length delimited
+// or varint field to the callback and skipping everything else
+func lokiFields(b []byte, fn func(num protowire.Number, val []byte, x uint64) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		var val []byte
+		var x uint64
+		switch typ {
+		case protowire.BytesType:
+			val, n = protowire.ConsumeBytes(b)
+		case protowire.VarintType:
+			x, n = protowire.ConsumeVarint(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ == protowire.BytesType || typ == protowire.VarintType {
+			if err := fn(num, val, x); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// decodeLokiPush decodes a logproto.PushRequest
+func decodeLokiPush(b []byte) (streams []lokiStream, err error) {
+	err = lokiFields(b, func(num protowire.Number, val []byte, _ uint64) (err error) {
+		if num == 1 {
+			var s lokiStream
+			if s, err = decodeLokiStream(val); err == nil {
+				streams = append(streams, s)
+			}
+		}
+		return
+	})
+	return
+}
+
+func decodeLokiStream(b []byte) (s lokiStream, err error) {
+	err = lokiFields(b, func(num protowire.Number, val []byte, _ uint64) (err error) {
+		switch num {
+		case 1: //labels
+			s.labels, err = parseLokiLabels(string(val))
+		case 2: //entries
+			var l lokiLine
+			if l, err = decodeLokiEntry(val); err == nil {
+				s.entries = append(s.entries, l)
+			}
+		}
+		return
+	})
+	return
+}
+
+func decodeLokiEntry(b []byte) (l lokiLine, err error) {
+	err = lokiFields(b, func(num protowire.Number, val []byte, _ uint64) (err error) {
+		switch num {
+		case 1: //google.protobuf.Timestamp
+			var sec, nsec int64
+			err = lokiFields(val, func(num protowire.Number, _ []byte, x uint64) error {
+				if num == 1 {
+					sec = int64(x)
+				} else if num == 2 {
+					nsec = int64(int32(x))
+				}
+				return nil
+			})
+			l.ts = sec*1e9 + nsec
+		case 2: //line
+			l.line = val
+		case 3: //structured metadata
+			var lbl lokiLabel
+			err = lokiFields(val, func(num protowire.Number, v []byte, _ uint64) error {
+				if num == 1 {
+					lbl.name = string(v)
+				} else if num == 2 {
+					lbl.value = string(v)
+				}
+				return nil
+			})
+			if err == nil && lbl.name != `` {
+				l.meta = append(l.meta, lbl)
+			}
+		}
+		return
+	})
+	return
+}
+
+type lokiJSONPush struct {
+	Streams []struct {
+		Stream map[string]string   `json:"stream"`
+		Values [][]json.RawMessage `json:"values"`
+	} `json:"streams"`
+}
+
+// decodeLokiJSON decodes the JSON push format, values are [ "<unix ns>", "<line>", {metadata} ] tuples
+func decodeLokiJSON(b []byte) (streams []lokiStream, err error) {
+	var req lokiJSONPush
+	if err = json.Unmarshal(b, &req); err != nil {
+		return
+	}
+	for _, js := range req.Streams {
+		var s lokiStream
+		for k, v := range js.Stream {
+			s.labels = append(s.labels, lokiLabel{name: k, value: v})
+		}
+		sort.Slice(s.labels, func(i, j int) bool { return s.labels[i].name < s.labels[j].name })
+		for _, v := range js.Values {
+			if len(v) < 2 {
+				err = ErrLokiInvalidValue
+				return
+			}
+			var l lokiLine
+			var ts, line string
+			if err = json.Unmarshal(v[0], &ts); err != nil {
+				return
+			} else if l.ts, err = strconv.ParseInt(ts, 10, 64); err != nil {
+				return
+			} else if err = json.Unmarshal(v[1], &line); err != nil {
+				return
+			}
+			l.line = []byte(line)
+			if len(v) > 2 {
+				var meta map[string]string
+				if err = json.Unmarshal(v[2], &meta); err != nil {
+					return
+				}
+				for k, mv := range meta {
+					l.meta = append(l.meta, lokiLabel{name: k, value: mv})
+				}
+				sort.Slice(l.meta, func(i, j int) bool { return l.meta[i].name < l.meta[j].name })
+			}
+			s.entries = append(s.entries, l)
+		}
+		streams = append(streams, s)
+	}
+	return
+}
+
+func includeLokiListeners(hnd *handler, igst *ingest.IngestMuxer, cfg *cfgType, lgr *log.Logger) (err error) {
+	for k, v := range cfg.LokiListener {
+		var lh *lokiHandler
+		if lh, err = newLokiHandler(k, v, igst); err != nil {
+			lg.Error("failed to build Loki-Listener", log.KV("loki-listener", k), log.KVErr(err))
+			return
+		}
+		hcfg := routeHandler{
+			handler:  lh.handle,
+			ignoreTs: v.Ignore_Timestamps,
+		}
+		if hcfg.tag, err = igst.NegotiateTag(v.Tag_Name); err != nil {
+			lg.Error("failed to pull tag", log.KV("tag", v.Tag_Name), log.KVErr(err))
+			return
+		}
+		if hcfg.pproc, err = cfg.Preprocessor.ProcessorSet(igst, v.Preprocessor); err != nil {
+			lg.Error("preprocessor construction error", log.KVErr(err))
+			return
+		}
+		if v.TokenValue != `` {
+			if hcfg.auth, err = newPresharedHeaderTokenHandler(v.Token_Header, v.TokenValue, lgr); err != nil {
+				lg.Error("failed to generate Loki auth", log.KVErr(err))
+				return
+			}
+		}
+		if err = hnd.addHandler(http.MethodPost, v.URL, hcfg); err != nil {
+			lg.Error("failed to add Loki-Listener handler", log.KVErr(err))
+			return
+		}
+		debugout("Loki Handler URL %s handling %s\n", v.URL, v.Tag_Name)
+	}
+	return
+}
@@ -0,0 +1,125 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/gobwas/glob"
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	defaultLokiUrl         string = `/loki/api/v1/push`
+	defaultLokiTokenHeader string = `Authorization`
+)
+
+type loki struct {
+	URL               string   //override the URL, defaults to "/loki/api/v1/push"
+	TokenValue        string   `json:"-"` //DO NOT SEND THIS when marshalling
+	Token_Header      string   //header carrying the token, defaults to "Authorization"
+	Tag_Name          string   //the tag to assign to streams that do not match a Tag-Match
+	Tag_Match         []string //label=value[,label=value...]:tag, values may contain wildcards and the first match wins
+	Attach_Labels     []string //labels to attach as enumerated values, all labels are attached when empty
+	Ignore_Timestamps bool
+	Preprocessor      []string
+}
+
+func (v *loki) validate(name string) (string, error) {
+	if len(v.URL) == 0 {
+		v.URL = defaultLokiUrl
+	}
+	p, err := url.Parse(v.URL)
+	if err != nil {
+		return ``, fmt.Errorf("URL structure is invalid: %v", err)
+	}
+	if p.Scheme != `` {
+		return ``, errors.New("May not specify scheme in listening URL")
+	} else if p.Host != `` {
+		return ``, errors.New("May not specify host in listening URL")
+	}
+	pth := p.Path
+	if len(v.Tag_Name) == 0 {
+		v.Tag_Name = entry.DefaultTagName
+	}
+	if ingest.CheckTag(v.Tag_Name) != nil {
+		return ``, errors.New("Invalid characters in the \"" + v.Tag_Name + "\"Tag-Name for " + name)
+	}
+	if len(v.Token_Header) == 0 {
+		v.Token_Header = defaultLokiTokenHeader
+	}
+	if _, err = v.tagRules(); err != nil {
+		return ``, fmt.Errorf("Loki-Listener %s has invalid Tag-Match %w", name, err)
+	}
+	//normalize the path
+	v.URL = pth
+	return pth, nil
+}
+
+// lokiLabelMatcher matches a single label against a value pattern
+type lokiLabelMatcher struct {
+	label string
+	value glob.Glob
+}
+
+// lokiTagRule routes streams whose labels satisfy every matcher to a tag
+type lokiTagRule struct {
+	matchers []lokiLabelMatcher
+	tag      string
+}
+
+// tagRules parses the Tag-Match directives which take the form label=value:tag, multiple
+// label matchers can be combined with commas, e.g. job=nginx,env=prod*:nginx
+func (v *loki) tagRules() (rules []lokiTagRule, err error) {
+	for _, tm := range v.Tag_Match {
+		var match string
+		var r lokiTagRule
+		if match, r.tag, err = extractElementTag(tm); err != nil {
+			return
+		}
+		for _, m := range strings.Split(match, `,`) {
+			lbl, val, ok := strings.Cut(m, `=`)
+			if lbl = strings.TrimSpace(lbl); !ok || lbl == `` {
+				err = fmt.Errorf("Tag-Match specification of %q is invalid, expected label=value:tag", tm)
+				return
+			}
+			lm := lokiLabelMatcher{label: lbl}
+			if lm.value, err = glob.Compile(strings.Trim(strings.TrimSpace(val), `"`)); err != nil {
+				err = fmt.Errorf("Tag-Match value %q is invalid %w", val, err)
+				return
+			}
+			r.matchers = append(r.matchers, lm)
+		}
+		rules = append(rules, r)
+	}
+	return
+}
+
+func (v *loki) tags() (tags []string, err error) {
+	var rules []lokiTagRule
+	if rules, err = v.tagRules(); err != nil {
+		return
+	}
+	mp := map[string]bool{}
+	if v.Tag_Name != `` {
+		tags = []string{v.Tag_Name}
+		mp[v.Tag_Name] = true
+	}
+	for _, r := range rules {
+		if _, ok := mp[r.tag]; !ok {
+			mp[r.tag] = true
+			tags = append(tags, r.tag)
+		}
+	}
+	return
+}
@@ -0,0 +1,330 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	lokiTestSec  = 1700000000
+	lokiTestNsec = 123456789
+	lokiTestTS   = lokiTestSec*1e9 + lokiTestNsec
+)
+
+func protoBytes(b []byte, num protowire.Number, v []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func protoVarint(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+// lokiEntryProto encodes a logproto.EntryAdapter
+func lokiEntryProto(sec, nsec int64, line string, meta ...lokiLabel) (b []byte) {
+	var ts []byte
+	ts = protoVarint(ts, 1, uint64(sec))
+	ts = protoVarint(ts, 2, uint64(nsec))
+	b = protoBytes(b, 1, ts)
+	b = protoBytes(b, 2, []byte(line))
+	for _, m := range meta {
+		var lb []byte
+		lb = protoBytes(lb, 1, []byte(m.name))
+		lb = protoBytes(lb, 2, []byte(m.value))
+		b = protoBytes(b, 3, lb)
+	}
+	return
+}
+
+// lokiStreamProto encodes a logproto.StreamAdapter
+func lokiStreamProto(labels string, entries ...[]byte) (b []byte) {
+	b = protoBytes(b, 1, []byte(labels))
+	for _, e := range entries {
+		b = protoBytes(b, 2, e)
+	}
+	return
+}
+
+// lokiPushProto encodes a logproto.PushRequest
+func lokiPushProto(streams ...[]byte) (b []byte) {
+	for _, s := range streams {
+		b = protoBytes(b, 1, s)
+	}
+	return
+}
+
+func TestParseLokiLabels(t *testing.T) {
+	tests := []struct {
+		in  string
+		exp []lokiLabel
+		bad bool
+	}{
+		{in: `{}`},
+		{in: `{job="nginx"}`, exp: []lokiLabel{{`job`, `nginx`}}},
+		{in: ` { job="nginx", host = "web01" } `, exp: []lokiLabel{{`job`, `nginx`}, {`host`, `web01`}}},
+		{in: `{msg="say \"hi\"",path="C:\\logs"}`, exp: []lokiLabel{{`msg`, `say "hi"`}, {`path`, `C:\logs`}}},
+		{in: ``, bad: true},
+		{in: `job="nginx"`, bad: true},
+		{in: `{job}`, bad: true},
+		{in: `{="nginx"}`, bad: true},
+		{in: `{job=nginx}`, bad: true},
+		{in: `{job="nginx}`, bad: true},
+	}
+	for _, tc := range tests {
+		lbls, err := parseLokiLabels(tc.in)
+		if tc.bad {
+			if !errors.Is(err, ErrLokiInvalidLabels) {
+				t.Fatalf("%q: expected invalid labels, got %v", tc.in, err)
+			}
+			continue
+		} else if err != nil {
+			t.Fatalf("%q: %v", tc.in, err)
+		}
+		if len(lbls) != len(tc.exp) {
+			t.Fatalf("%q: bad labels %v", tc.in, lbls)
+		}
+		for i := range lbls {
+			if lbls[i] != tc.exp[i] {
+				t.Fatalf("%q: bad label %d %v != %v", tc.in, i, lbls[i], tc.exp[i])
+			}
+		}
+	}
+}
+
+func TestDecodeLokiEntry(t *testing.T) {
+	l, err := decodeLokiEntry(lokiEntryProto(lokiTestSec, lokiTestNsec, `hello`, lokiLabel{`trace_id`, `abc`}))
+	if err != nil {
+		t.Fatal(err)
+	} else if l.ts != lokiTestTS {
+		t.Fatalf("bad nanosecond timestamp %d != %d", l.ts, int64(lokiTestTS))
+	} else if string(l.line) != `hello` {
+		t.Fatalf("bad line %q", l.line)
+	} else if len(l.meta) != 1 || l.meta[0] != (lokiLabel{`trace_id`, `abc`}) {
+		t.Fatalf("bad structured metadata %v", l.meta)
+	}
+
+	// metadata without a name is dropped, unknown fields are skipped
+	b := lokiEntryProto(lokiTestSec, 0, `x`, lokiLabel{``, `nameless`})
+	b = protoVarint(b, 15, 42)
+	if l, err = decodeLokiEntry(b); err != nil {
+		t.Fatal(err)
+	} else if len(l.meta) != 0 || l.ts != lokiTestSec*1e9 {
+		t.Fatalf("bad entry %+v", l)
+	}
+
+	good := lokiEntryProto(lokiTestSec, lokiTestNsec, `hello`)
+	for _, bad := range [][]byte{
+		good[:len(good)-1],       // truncated line
+		{0x0a, 0x05, 0x08},       // timestamp longer than the message
+		{0x0a, 0x02, 0x08, 0x80}, // truncated varint in the timestamp
+	} {
+		if _, err = decodeLokiEntry(bad); err == nil {
+			t.Fatalf("malformed entry %x was accepted", bad)
+		}
+	}
+}
+
+func TestDecodeLokiPush(t *testing.T) {
+	push := lokiPushProto(
+		lokiStreamProto(`{job="nginx", host="web01"}`,
+			lokiEntryProto(lokiTestSec, lokiTestNsec, `GET /`),
+			lokiEntryProto(lokiTestSec+1, 0, `GET /favicon.ico`),
+		),
+		lokiStreamProto(`{job="sshd"}`, lokiEntryProto(lokiTestSec, 0, `Accepted publickey`)),
+	)
+	// promtail snappy compresses the protobuf body
+	raw, err := snappy.Decode(nil, snappy.Encode(nil, push))
+	if err != nil {
+		t.Fatal(err)
+	}
+	streams, err := decodeLokiPush(raw)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(streams) != 2 {
+		t.Fatalf("bad stream count %d", len(streams))
+	}
+	if s := streams[0]; len(s.labels) != 2 || len(s.entries) != 2 {
+		t.Fatalf("bad first stream %+v", s)
+	} else if s.entries[0].ts != lokiTestTS || string(s.entries[1].line) != `GET /favicon.ico` {
+		t.Fatalf("bad first stream entries %+v", s.entries)
+	}
+	if s := streams[1]; len(s.labels) != 1 || s.labels[0].value != `sshd` || len(s.entries) != 1 {
+		t.Fatalf("bad second stream %+v", s)
+	}
+
+	for _, bad := range [][]byte{
+		lokiPushProto(lokiStreamProto(`job="nginx"`, lokiEntryProto(lokiTestSec, 0, `x`))), // bad label set
+		push[:len(push)-2],
+		{0xff},
+	} {
+		if _, err = decodeLokiPush(bad); err == nil {
+			t.Fatalf("malformed push %x was accepted", bad)
+		}
+	}
+}
+
+func TestDecodeLokiJSON(t *testing.T) {
+	streams, err := decodeLokiJSON([]byte(`{"streams":[
+		{"stream":{"job":"nginx","host":"web01"},"values":[
+			["1700000000123456789","GET /"],
+			["1700000001000000000","GET /favicon.ico",{"trace_id":"abc","span_id":"def"}]
+		]},
+		{"stream":{"job":"sshd"},"values":[]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(streams) != 2 {
+		t.Fatalf("bad stream count %d", len(streams))
+	}
+	s := streams[0]
+	// labels are sorted so that routing and attachment is stable
+	if len(s.labels) != 2 || s.labels[0] != (lokiLabel{`host`, `web01`}) || s.labels[1] != (lokiLabel{`job`, `nginx`}) {
+		t.Fatalf("bad labels %v", s.labels)
+	} else if len(s.entries) != 2 || s.entries[0].ts != lokiTestTS || string(s.entries[0].line) != `GET /` {
+		t.Fatalf("bad entries %+v", s.entries)
+	} else if m := s.entries[1].meta; len(m) != 2 || m[0] != (lokiLabel{`span_id`, `def`}) || m[1] != (lokiLabel{`trace_id`, `abc`}) {
+		t.Fatalf("bad structured metadata %v", m)
+	}
+
+	for _, bad := range []string{
+		`{"streams":[{"stream":{},"values":[["1700000000000000000"]]}]}`,
+		`{"streams":[{"stream":{},"values":[["yesterday","x"]]}]}`,
+		`{"streams":[{"stream":{},"values":[[1700000000000000000,"x"]]}]}`,
+		`{"streams":[{"stream":{},"values":[["1700000000000000000",5]]}]}`,
+		`{"streams":[{"stream":{},"values":[["1700000000000000000","x",["meta"]]]}]}`,
+		`{"streams":[{"stream":{"job":1}}]}`,
+		`{"streams":`,
+	} {
+		if _, err = decodeLokiJSON([]byte(bad)); err == nil {
+			t.Fatalf("malformed push %s was accepted", bad)
+		}
+	}
+}
+
+func newTestLokiHandler(t *testing.T, v *loki) *lokiHandler {
+	t.Helper()
+	rules, err := v.tagRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lh := &lokiHandler{name: `test`}
+	for i, r := range rules {
+		lh.routers = append(lh.routers, lokiRouter{matchers: r.matchers, tag: entry.EntryTag(i + 1)})
+	}
+	for _, l := range v.Attach_Labels {
+		if lh.attach == nil {
+			lh.attach = map[string]bool{}
+		}
+		lh.attach[l] = true
+	}
+	return lh
+}
+
+func TestLokiRoute(t *testing.T) {
+	lh := newTestLokiHandler(t, &loki{Tag_Match: []string{
+		`job=nginx,env=prod*:nginx`,
+		`job=nginx:nginxdev`,
+		`host=web*:web`,
+	}})
+	const def entry.EntryTag = 100
+	tests := []struct {
+		labels string
+		exp    entry.EntryTag
+	}{
+		{`{job="nginx", env="production"}`, 1},
+		{`{env="prod", job="nginx"}`, 1},
+		{`{job="nginx", env="dev"}`, 2},
+		{`{job="nginx", host="web01"}`, 2}, // first match wins
+		{`{job="sshd", host="web01"}`, 3},
+		{`{job="sshd"}`, def},
+		{`{}`, def},
+	}
+	for _, tc := range tests {
+		lbls, err := parseLokiLabels(tc.labels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tag := lh.route(lbls, def); tag != tc.exp {
+			t.Fatalf("%s routed to %d, expected %d", tc.labels, tag, tc.exp)
+		}
+	}
+}
+
+func TestLokiHandle(t *testing.T) {
+	const def entry.EntryTag = 100
+	lh := newTestLokiHandler(t, &loki{
+		Tag_Match:     []string{`job=nginx:nginx`},
+		Attach_Labels: []string{`job`, `trace_id`},
+	})
+	h, rh, tw := newTestRoute(t, def)
+	ip := net.ParseIP(`10.0.0.1`)
+	post := func(ct string, body []byte) int {
+		req := httptest.NewRequest(http.MethodPost, defaultLokiUrl, bytes.NewReader(body))
+		if ct != `` {
+			req.Header.Set(`Content-Type`, ct)
+		}
+		rec := httptest.NewRecorder()
+		lh.handle(h, rh, rec, req, req.Body, ip)
+		return rec.Code
+	}
+
+	push := lokiPushProto(lokiStreamProto(`{job="nginx", host="web01"}`,
+		lokiEntryProto(lokiTestSec, lokiTestNsec, `GET /`, lokiLabel{`trace_id`, `abc`})))
+	// promtail does not always set a content type, protobuf is assumed
+	if code := post(``, snappy.Encode(nil, push)); code != http.StatusNoContent {
+		t.Fatalf("bad protobuf response %d", code)
+	}
+	if code := post(lokiContentJSON+`; charset=utf-8`, []byte(`{"streams":[{"stream":{"job":"sshd"},"values":[["1700000000123456789","Accepted"]]}]}`)); code != http.StatusNoContent {
+		t.Fatalf("bad JSON response %d", code)
+	}
+	if len(tw.ents) != 2 {
+		t.Fatalf("bad entry count %d", len(tw.ents))
+	}
+	exp := entry.UnixTime(lokiTestSec, lokiTestNsec)
+	if ent := tw.ents[0]; ent.Tag != 1 || !ent.SRC.Equal(ip) || ent.TS != exp || string(ent.Data) != `GET /` {
+		t.Fatalf("bad protobuf entry %+v", ent)
+	} else if evString(t, ent, `job`) != `nginx` || evString(t, ent, `trace_id`) != `abc` {
+		t.Fatal("bad attached labels")
+	} else if _, ok := ent.GetEnumeratedValue(`host`); ok {
+		t.Fatal("label outside of Attach-Labels was attached")
+	}
+	if ent := tw.ents[1]; ent.Tag != def || ent.TS != exp || string(ent.Data) != `Accepted` {
+		t.Fatalf("bad JSON entry %+v", ent)
+	}
+
+	for _, tc := range []struct {
+		ct   string
+		body []byte
+		code int
+	}{
+		{lokiContentProtobuf, push, http.StatusBadRequest}, // not snappy compressed
+		{lokiContentProtobuf, snappy.Encode(nil, []byte{0xff}), http.StatusBadRequest},
+		{lokiContentJSON, []byte(`{"streams":[{"values":[["now","x"]]}]}`), http.StatusBadRequest},
+		{`text/plain`, []byte(`hello`), http.StatusUnsupportedMediaType},
+		{lokiContentJSON, bytes.Repeat([]byte(` `), maxBody+1), http.StatusRequestEntityTooLarge},
+	} {
+		if code := post(tc.ct, tc.body); code != tc.code {
+			t.Fatalf("%s request returned %d, expected %d", tc.ct, code, tc.code)
+		}
+	}
+	if len(tw.ents) != 2 {
+		t.Fatalf("malformed requests produced entries: %d", len(tw.ents))
+	}
+}
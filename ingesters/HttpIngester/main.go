@@ -173,6 +173,21 @@ func main() {
 	if err = includeAFHListeners(hnd, igst, cfg, lg); err != nil {
 		lg.Fatal("failed to include Amazon Firehose Listeners", log.KVErr(err))
 	}
+	if err = includeESListeners(hnd, igst, cfg, lg); err != nil {
+		lg.Fatal("failed to include Elastic Compatible Listeners", log.KVErr(err))
+	}
+	if err = includeLokiListeners(hnd, igst, cfg, lg); err != nil {
+		lg.Fatal("failed to include Loki Listeners", log.KVErr(err))
+	}
+	otlpGRPCs, err := includeOTLPListeners(hnd, igst, cfg, lg)
+	if err != nil {
+		lg.Fatal("failed to include OTLP Listeners", log.KVErr(err))
+	}
+	for _, gs := range otlpGRPCs {
+		if err = gs.start(); err != nil {
+			lg.Fatal("failed to start OTLP gRPC listener", log.KV("bind", gs.bind), log.KVErr(err))
+		}
+	}
 	var httpLogger *dlog.Logger
 	if debugOn || cfg.LogLevel() == `INFO` {
 		httpLogger = lg.StandardLogger()
@@ -188,7 +203,7 @@ func main() {
 	}
 	srv.SetKeepAlivesEnabled(true)
 	var lst net.Listener
-	if lst, err = newListener(cfg.Bind, ib); err != nil {
+	if lst, err = newListener(cfg.gbl, ib); err != nil {
 		lg.Fatalf("failed to bind to %v %v", cfg.Bind, err)
 	}
 	defer lst.Close()
@@ -226,6 +241,9 @@ func main() {
 		}
 		cf()
 	}
+	for _, gs := range otlpGRPCs {
+		gs.stop()
+	}
 	debugout("Server is exiting\n")
 	ib.AnnounceShutdown()
 
@@ -295,14 +313,23 @@ type instrumentListener struct {
 	lst net.Listener
 }
 
-func newListener(bind string, ib base.IngesterBase) (lst net.Listener, err error) {
+func newListener(g gbl, ib base.IngesterBase) (lst net.Listener, err error) {
 	var si *utils.StatsItem
 	var tlst net.Listener
 	if si, err = ib.RegisterStat(`connections`); err != nil {
 		return
-	} else if tlst, err = net.Listen(`tcp`, bind); err != nil {
+	} else if tlst, err = net.Listen(`tcp`, g.Bind); err != nil {
 		return
 	}
+	if g.Proxy_Protocol {
+		//the PROXY header precedes the TLS handshake, so decode it beneath the TLS layer the server adds
+		var nets []*net.IPNet
+		if nets, err = utils.ParseTrustedUpstreams(g.Proxy_Trusted_Upstream); err != nil {
+			tlst.Close()
+			return
+		}
+		tlst = utils.NewProxyListener(tlst, nets)
+	}
 	lst = &instrumentListener{
 		s:   si,
 		lst: tlst,
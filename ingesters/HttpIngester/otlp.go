@@ -0,0 +1,563 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingesters/utils"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	otlpContentProtobuf    = `application/x-protobuf`
+	otlpContentProtobufAlt = `application/protobuf`
+	otlpContentJSON        = `application/json`
+
+	otlpTraceIDSize = 16
+	otlpSpanIDSize  = 8
+)
+
+var (
+	ErrOTLPUnsupportedContent = errors.New("unsupported OTLP content type")
+)
+
+type otlpRouter struct {
+	attribute string
+	value     string
+	tag       entry.EntryTag
+}
+
+type otlpHandler struct {
+	name      string
+	ignoreTs  bool
+	routers   []otlpRouter
+	attachAll bool
+	attach    []string
+}
+
+// otlpRecord is the JSON representation of a single log record that is handed to Gravwell,
+// attributes are flattened into objects rather than the OTLP key/value lists
+type otlpRecord struct {
+	Timestamp         string                 `json:"timestamp,omitempty"`
+	ObservedTimestamp string                 `json:"observed_timestamp,omitempty"`
+	SeverityText      string                 `json:"severity_text,omitempty"`
+	SeverityNumber    int32                  `json:"severity_number,omitempty"`
+	Body              interface{}            `json:"body,omitempty"`
+	Attributes        map[string]interface{} `json:"attributes,omitempty"`
+	TraceID           string                 `json:"trace_id,omitempty"`
+	SpanID            string                 `json:"span_id,omitempty"`
+	Flags             uint32                 `json:"flags,omitempty"`
+	Resource          map[string]interface{} `json:"resource,omitempty"`
+	Scope             *otlpScope             `json:"scope,omitempty"`
+}
+
+type otlpScope struct {
+	Name       string                 `json:"name,omitempty"`
+	Version    string                 `json:"version,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+func newOTLPHandler(name string, v *otlp, igst *ingest.IngestMuxer) (oh *otlpHandler, err error) {
+	var tms []otlpTagMatcher
+	if tms, err = v.tagMatchers(); err != nil {
+		return
+	}
+	oh = &otlpHandler{
+		name:     name,
+		ignoreTs: v.Ignore_Timestamps,
+	}
+	for _, tm := range tms {
+		r := otlpRouter{
+			attribute: tm.Attribute,
+			value:     tm.Value,
+		}
+		if r.tag, err = igst.NegotiateTag(tm.Tag); err != nil {
+			return
+		}
+		oh.routers = append(oh.routers, r)
+	}
+	for _, a := range v.Attach_Attributes {
+		if a == `*` {
+			oh.attachAll = true
+		} else if a != `` {
+			oh.attach = append(oh.attach, a)
+		}
+	}
+	return
+}
+
+func (oh *otlpHandler) handle(h *handler, cfg routeHandler, w http.ResponseWriter, r *http.Request, rdr io.Reader, ip net.IP) {
+	ct, _, err := mime.ParseMediaType(r.Header.Get(`Content-Type`))
+	if err != nil {
+		ct = otlpContentProtobuf //the OTLP/HTTP default encoding
+	}
+	lr := io.LimitedReader{R: rdr, N: int64(maxBody + 1)}
+	b, err := io.ReadAll(&lr)
+	if err != nil {
+		h.lgr.Info("bad request", log.KV("address", ip), log.KV("otlp-listener", oh.name), log.KVErr(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if len(b) > maxBody {
+		h.lgr.Info("request too large", log.KV("address", ip), log.KV("otlp-listener", oh.name), log.KV("max-body", maxBody))
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	var req collogspb.ExportLogsServiceRequest
+	if err = decodeOTLPRequest(ct, b, &req); err != nil {
+		h.lgr.Info("bad request", log.KV("address", ip), log.KV("otlp-listener", oh.name), log.KV("content-type", ct), log.KVErr(err))
+		if err == ErrOTLPUnsupportedContent {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		return
+	}
+	if err = oh.export(h, cfg, &req, ip); err != nil {
+		h.lgr.Error("failed to send entries", log.KV("otlp-listener", oh.name), log.KVErr(err))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var resp []byte
+	if ct == otlpContentJSON {
+		resp, err = protojson.Marshal(&collogspb.ExportLogsServiceResponse{})
+	} else {
+		resp, err = proto.Marshal(&collogspb.ExportLogsServiceResponse{})
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(`Content-Type`, ct)
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// decodeOTLPRequest decodes an export request using either the binary protobuf or JSON encodings
+func decodeOTLPRequest(ct string, b []byte, req *collogspb.ExportLogsServiceRequest) (err error) {
+	switch ct {
+	case otlpContentProtobuf, otlpContentProtobufAlt:
+		err = proto.Unmarshal(b, req)
+	case otlpContentJSON:
+		if err = (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(b, req); err == nil {
+			fixOTLPJSONIds(req)
+		}
+	default:
+		err = ErrOTLPUnsupportedContent
+	}
+	return
+}
+
+// fixOTLPJSONIds repairs trace and span IDs decoded from OTLP/JSON.  The OTLP JSON encoding
+// deviates from the standard protobuf mapping and carries IDs as hex strings rather than base64,
+// so the standard decoder hands us the base64 decoding of the hex text.  Re-encoding recovers the
+// original hex which we can then decode properly.
+func fixOTLPJSONIds(req *collogspb.ExportLogsServiceRequest) {
+	for _, rl := range req.GetResourceLogs() {
+		for _, sl := range rl.GetScopeLogs() {
+			for _, lr := range sl.GetLogRecords() {
+				lr.TraceId = fixOTLPJSONId(lr.TraceId, otlpTraceIDSize)
+				lr.SpanId = fixOTLPJSONId(lr.SpanId, otlpSpanIDSize)
+			}
+		}
+	}
+}
+
+func fixOTLPJSONId(v []byte, sz int) []byte {
+	if len(v) == 0 || len(v) == sz {
+		return v
+	} else if len(v) != base64.StdEncoding.DecodedLen(sz*2) {
+		return nil
+	}
+	r, err := hex.DecodeString(base64.StdEncoding.EncodeToString(v))
+	if err != nil {
+		return nil
+	}
+	return r
+}
+
+// export converts every log record in the request into an entry and hands the batch to the preprocessors
+func (oh *otlpHandler) export(h *handler, cfg routeHandler, req *collogspb.ExportLogsServiceRequest, ip net.IP) (err error) {
+	var batch []*entry.Entry
+	var sz uint64
+	now := entry.Now()
+	for _, rl := range req.GetResourceLogs() {
+		resAttrs := rl.GetResource().GetAttributes()
+		tag := oh.route(resAttrs, cfg.tag)
+		resource := otlpAttributes(resAttrs)
+		for _, sl := range rl.GetScopeLogs() {
+			scope := sl.GetScope()
+			var sc *otlpScope
+			if scope != nil {
+				sc = &otlpScope{
+					Name:       scope.GetName(),
+					Version:    scope.GetVersion(),
+					Attributes: otlpAttributes(scope.GetAttributes()),
+				}
+			}
+			for _, lr := range sl.GetLogRecords() {
+				var ent *entry.Entry
+				if ent, err = oh.buildEntry(lr, resource, sc, now); err != nil {
+					h.lgr.Warn("failed to encode OTLP log record", log.KV("otlp-listener", oh.name), log.KVErr(err))
+					continue
+				}
+				ent.SRC = ip
+				ent.Tag = tag
+				oh.attachAttributes(ent, lr.GetAttributes(), scope.GetAttributes(), resAttrs)
+				batch = append(batch, ent)
+				sz += ent.Size()
+			}
+		}
+	}
+	if len(batch) == 0 {
+		return
+	}
+	if err = cfg.pproc.ProcessBatchContext(batch, exitCtx); err == nil {
+		h.entSI.Add(uint64(len(batch)))
+		h.bytesSI.Add(sz)
+	}
+	return
+}
+
+// route returns the tag of the first router whose attribute matches a resource attribute
+func (oh *otlpHandler) route(attrs []*commonpb.KeyValue, def entry.EntryTag) entry.EntryTag {
+	for _, r := range oh.routers {
+		if v, ok := otlpLookup(attrs, r.attribute); ok && otlpValueString(v) == r.value {
+			return r.tag
+		}
+	}
+	return def
+}
+
+func (oh *otlpHandler) buildEntry(lr *logspb.LogRecord, resource map[string]interface{}, sc *otlpScope, now entry.Timestamp) (ent *entry.Entry, err error) {
+	rec := otlpRecord{
+		Timestamp:         otlpTimeString(lr.GetTimeUnixNano()),
+		ObservedTimestamp: otlpTimeString(lr.GetObservedTimeUnixNano()),
+		SeverityText:      lr.GetSeverityText(),
+		SeverityNumber:    int32(lr.GetSeverityNumber()),
+		Attributes:        otlpAttributes(lr.GetAttributes()),
+		Flags:             lr.GetFlags(),
+		Resource:          resource,
+		Scope:             sc,
+	}
+	if body := lr.GetBody(); body != nil {
+		rec.Body = otlpValue(body)
+	}
+	if id := lr.GetTraceId(); len(id) > 0 {
+		rec.TraceID = hex.EncodeToString(id)
+	}
+	if id := lr.GetSpanId(); len(id) > 0 {
+		rec.SpanID = hex.EncodeToString(id)
+	}
+	bb := bytes.NewBuffer(nil)
+	enc := json.NewEncoder(bb)
+	enc.SetEscapeHTML(false)
+	if err = enc.Encode(rec); err != nil {
+		return
+	}
+	ent = &entry.Entry{
+		TS:   now,
+		Data: bytes.TrimSpace(bb.Bytes()),
+	}
+	if !oh.ignoreTs {
+		if ts := lr.GetTimeUnixNano(); ts != 0 {
+			ent.TS = otlpTimestamp(ts)
+		} else if ts = lr.GetObservedTimeUnixNano(); ts != 0 {
+			ent.TS = otlpTimestamp(ts)
+		}
+	}
+	return
+}
+
+// attachAttributes attaches the configured attributes as enumerated values, log record attributes
+// take precedence over scope attributes which take precedence over resource attributes
+func (oh *otlpHandler) attachAttributes(ent *entry.Entry, sets ...[]*commonpb.KeyValue) {
+	if oh.attachAll {
+		seen := map[string]bool{}
+		for _, set := range sets {
+			for _, kv := range set {
+				if k := kv.GetKey(); k != `` && !seen[k] {
+					seen[k] = true
+					ent.AddEnumeratedValue(entry.EnumeratedValue{Name: k, Value: otlpEnumData(kv.GetValue())})
+				}
+			}
+		}
+		return
+	}
+	for _, name := range oh.attach {
+		for _, set := range sets {
+			if v, ok := otlpLookup(set, name); ok {
+				ent.AddEnumeratedValue(entry.EnumeratedValue{Name: name, Value: otlpEnumData(v)})
+				break
+			}
+		}
+	}
+}
+
+func otlpTimestamp(ns uint64) entry.Timestamp {
+	return entry.UnixTime(int64(ns/uint64(time.Second)), int64(ns%uint64(time.Second)))
+}
+
+func otlpTimeString(ns uint64) string {
+	if ns == 0 {
+		return ``
+	}
+	return otlpTimestamp(ns).StandardTime().Format(time.RFC3339Nano)
+}
+
+func otlpLookup(attrs []*commonpb.KeyValue, key string) (*commonpb.AnyValue, bool) {
+	for _, kv := range attrs {
+		if kv.GetKey() == key {
+			return kv.GetValue(), true
+		}
+	}
+	return nil, false
+}
+
+func otlpAttributes(attrs []*commonpb.KeyValue) (r map[string]interface{}) {
+	if len(attrs) == 0 {
+		return
+	}
+	r = make(map[string]interface{}, len(attrs))
+	for _, kv := range attrs {
+		r[kv.GetKey()] = otlpValue(kv.GetValue())
+	}
+	return
+}
+
+// otlpValue converts an OTLP AnyValue into something the JSON encoder can handle
+func otlpValue(v *commonpb.AnyValue) interface{} {
+	switch x := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return x.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return x.BoolValue
+	case *commonpb.AnyValue_IntValue:
+		return x.IntValue
+	case *commonpb.AnyValue_DoubleValue:
+		if math.IsNaN(x.DoubleValue) || math.IsInf(x.DoubleValue, 0) {
+			return fmt.Sprint(x.DoubleValue) //JSON cannot represent these
+		}
+		return x.DoubleValue
+	case *commonpb.AnyValue_BytesValue:
+		return x.BytesValue
+	case *commonpb.AnyValue_ArrayValue:
+		vals := x.ArrayValue.GetValues()
+		r := make([]interface{}, 0, len(vals))
+		for _, av := range vals {
+			r = append(r, otlpValue(av))
+		}
+		return r
+	case *commonpb.AnyValue_KvlistValue:
+		if r := otlpAttributes(x.KvlistValue.GetValues()); r != nil {
+			return r
+		}
+		return map[string]interface{}{}
+	}
+	return nil
+}
+
+func otlpValueString(v *commonpb.AnyValue) string {
+	switch x := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return x.StringValue
+	case *commonpb.AnyValue_ArrayValue, *commonpb.AnyValue_KvlistValue, *commonpb.AnyValue_BytesValue:
+		b, _ := json.Marshal(otlpValue(v))
+		return string(b)
+	case nil:
+		return ``
+	}
+	return fmt.Sprint(otlpValue(v))
+}
+
+func otlpEnumData(v *commonpb.AnyValue) entry.EnumeratedData {
+	switch x := v.GetValue().(type) {
+	case *commonpb.AnyValue_BoolValue:
+		return entry.BoolEnumData(x.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return entry.Int64EnumData(x.IntValue)
+	case *commonpb.AnyValue_DoubleValue:
+		return entry.Float64EnumData(x.DoubleValue)
+	case *commonpb.AnyValue_BytesValue:
+		return entry.SliceEnumData(x.BytesValue)
+	}
+	return entry.StringEnumData(otlpValueString(v))
+}
+
+// otlpGRPCServer implements the OTLP/gRPC logs service on a dedicated bind
+type otlpGRPCServer struct {
+	collogspb.UnimplementedLogsServiceServer
+	oh          *otlpHandler
+	h           *handler
+	rh          routeHandler
+	bind        string
+	tokenHeader string
+	tokenValue  string
+	srv         *grpc.Server
+	proxy       bool         //decode PROXY headers like the HTTP listener does
+	trusted     []*net.IPNet //upstreams allowed to send PROXY headers
+}
+
+func (s *otlpGRPCServer) Export(ctx context.Context, req *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error) {
+	s.h.reqSI.Add(1)
+	ip := net.ParseIP(`127.0.0.1`)
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			if pip := net.ParseIP(host); pip != nil {
+				ip = pip
+			}
+		}
+	}
+	if !s.authorized(ctx) {
+		s.h.lgr.Info("access denied", log.KV("address", ip), log.KV("otlp-listener", s.oh.name))
+		return nil, status.Error(codes.Unauthenticated, ErrUnauthorized.Error())
+	}
+	if s.h.igst.WillBlock() {
+		return nil, status.Error(codes.Unavailable, "ingest is blocked")
+	}
+	if err := s.oh.export(s.h, s.rh, req, ip); err != nil {
+		s.h.lgr.Error("failed to send entries", log.KV("otlp-listener", s.oh.name), log.KVErr(err))
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return &collogspb.ExportLogsServiceResponse{}, nil
+}
+
+// authorized checks the request token in constant time so that it can't be recovered by timing
+func (s *otlpGRPCServer) authorized(ctx context.Context) bool {
+	if s.tokenValue == `` {
+		return true
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	vals := md.Get(s.tokenHeader)
+	return len(vals) > 0 && subtle.ConstantTimeCompare([]byte(vals[0]), []byte(s.tokenValue)) == 1
+}
+
+// listen binds the gRPC listener, PROXY headers sit in front of the TLS handshake so the
+// wrapper goes underneath the gRPC credentials and the decoded source is reported as the peer
+func (s *otlpGRPCServer) listen() (lst net.Listener, err error) {
+	if lst, err = net.Listen(`tcp`, s.bind); err != nil {
+		return
+	}
+	if s.proxy {
+		lst = utils.NewProxyListener(lst, s.trusted)
+	}
+	return
+}
+
+func (s *otlpGRPCServer) start() (err error) {
+	var lst net.Listener
+	if lst, err = s.listen(); err != nil {
+		return
+	}
+	go func() {
+		if err := s.srv.Serve(lst); err != nil {
+			lg.Error("failed to serve OTLP gRPC", log.KV("otlp-listener", s.oh.name), log.KVErr(err))
+		}
+	}()
+	debugout("OTLP gRPC Handler %s bound to %s\n", s.oh.name, s.bind)
+	return
+}
+
+func (s *otlpGRPCServer) stop() {
+	s.srv.GracefulStop()
+}
+
+func includeOTLPListeners(hnd *handler, igst *ingest.IngestMuxer, cfg *cfgType, lgr *log.Logger) (grpcs []*otlpGRPCServer, err error) {
+	for k, v := range cfg.OTLPListener {
+		var oh *otlpHandler
+		if oh, err = newOTLPHandler(k, v, igst); err != nil {
+			lg.Error("failed to build OTLP-Listener", log.KV("otlp-listener", k), log.KVErr(err))
+			return
+		}
+		hcfg := routeHandler{
+			handler:  oh.handle,
+			ignoreTs: v.Ignore_Timestamps,
+		}
+		if hcfg.tag, err = igst.NegotiateTag(v.Tag_Name); err != nil {
+			lg.Error("failed to pull tag", log.KV("tag", v.Tag_Name), log.KVErr(err))
+			return
+		}
+		if hcfg.pproc, err = cfg.Preprocessor.ProcessorSet(igst, v.Preprocessor); err != nil {
+			lg.Error("preprocessor construction error", log.KVErr(err))
+			return
+		}
+		if v.TokenValue != `` {
+			if hcfg.auth, err = newPresharedHeaderTokenHandler(v.Token_Header, v.TokenValue, lgr); err != nil {
+				lg.Error("failed to generate OTLP auth", log.KVErr(err))
+				return
+			}
+		}
+		if err = hnd.addHandler(http.MethodPost, v.URL, hcfg); err != nil {
+			lg.Error("failed to add OTLP-Listener handler", log.KVErr(err))
+			return
+		}
+		debugout("OTLP Handler URL %s handling %s\n", v.URL, v.Tag_Name)
+
+		if v.GRPC_Bind == `` {
+			continue
+		}
+		opts := []grpc.ServerOption{
+			grpc.MaxRecvMsgSize(maxBody),
+		}
+		if cfg.TLSEnabled() {
+			var creds credentials.TransportCredentials
+			if creds, err = credentials.NewServerTLSFromFile(cfg.TLS_Certificate_File, cfg.TLS_Key_File); err != nil {
+				lg.Error("failed to load OTLP gRPC TLS credentials", log.KVErr(err))
+				return
+			}
+			opts = append(opts, grpc.Creds(creds))
+		}
+		gs := &otlpGRPCServer{
+			oh:          oh,
+			h:           hnd,
+			rh:          hcfg,
+			bind:        v.GRPC_Bind,
+			tokenHeader: v.Token_Header,
+			tokenValue:  v.TokenValue,
+			srv:         grpc.NewServer(opts...),
+			proxy:       cfg.Proxy_Protocol,
+		}
+		if gs.proxy {
+			if gs.trusted, err = utils.ParseTrustedUpstreams(cfg.Proxy_Trusted_Upstream); err != nil {
+				lg.Error("invalid Proxy-Trusted-Upstream", log.KVErr(err))
+				return
+			}
+		}
+		collogspb.RegisterLogsServiceServer(gs.srv, gs)
+		grpcs = append(grpcs, gs)
+	}
+	return
+}
@@ -0,0 +1,123 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	defaultOTLPUrl         string = `/v1/logs`
+	defaultOTLPTokenHeader string = `Authorization`
+)
+
+type otlp struct {
+	URL               string   //override the URL, defaults to "/v1/logs"
+	GRPC_Bind         string   //optional bind string for an OTLP/gRPC logs service
+	TokenValue        string   `json:"-"` //DO NOT SEND THIS when marshalling
+	Token_Header      string   //header carrying the token, defaults to "Authorization"
+	Tag_Name          string   //the default tag to assign to log records
+	Tag_Match         []string //resource-attribute=value:tag, the first matching rule wins
+	Attach_Attributes []string //attributes to attach as enumerated values, "*" attaches everything
+	Ignore_Timestamps bool
+	Preprocessor      []string
+}
+
+func (v *otlp) validate(name string) (string, error) {
+	if len(v.URL) == 0 {
+		v.URL = defaultOTLPUrl
+	}
+	p, err := url.Parse(v.URL)
+	if err != nil {
+		return ``, fmt.Errorf("URL structure is invalid: %v", err)
+	}
+	if p.Scheme != `` {
+		return ``, errors.New("May not specify scheme in listening URL")
+	} else if p.Host != `` {
+		return ``, errors.New("May not specify host in listening URL")
+	}
+	pth := p.Path
+	if len(v.Tag_Name) == 0 {
+		v.Tag_Name = entry.DefaultTagName
+	}
+	if ingest.CheckTag(v.Tag_Name) != nil {
+		return ``, errors.New("Invalid characters in the \"" + v.Tag_Name + "\"Tag-Name for " + name)
+	}
+	if len(v.Token_Header) == 0 {
+		v.Token_Header = defaultOTLPTokenHeader
+	}
+	if v.GRPC_Bind != `` {
+		if _, _, err = net.SplitHostPort(v.GRPC_Bind); err != nil {
+			return ``, fmt.Errorf("OTLP-Listener %s has an invalid GRPC-Bind %w", name, err)
+		}
+	}
+	if _, err = v.tagMatchers(); err != nil {
+		return ``, fmt.Errorf("OTLP-Listener %s has invalid Tag-Match %w", name, err)
+	}
+	//normalize the path
+	v.URL = pth
+	return pth, nil
+}
+
+// otlpTagMatcher routes log records whose resource carries a matching attribute
+type otlpTagMatcher struct {
+	Attribute string
+	Value     string
+	Tag       string
+}
+
+// tagMatchers parses the Tag-Match directives which take the form attribute=value:tag,
+// for example service.name=checkout:shop
+func (v *otlp) tagMatchers() (tags []otlpTagMatcher, err error) {
+	for _, tm := range v.Tag_Match {
+		var match, tag string
+		if match, tag, err = extractElementTag(tm); err != nil {
+			return
+		} else if err = ingest.CheckTag(tag); err != nil {
+			return
+		}
+		attr, val, ok := strings.Cut(match, `=`)
+		if attr = strings.TrimSpace(attr); !ok || attr == `` {
+			err = fmt.Errorf("Tag-Match specification of %q is invalid, expected attribute=value:tag", tm)
+			return
+		}
+		tags = append(tags, otlpTagMatcher{
+			Attribute: attr,
+			Value:     strings.TrimSpace(val),
+			Tag:       tag,
+		})
+	}
+	return
+}
+
+func (v *otlp) tags() (tags []string, err error) {
+	var tms []otlpTagMatcher
+	if tms, err = v.tagMatchers(); err != nil {
+		return
+	}
+	mp := map[string]bool{}
+	if v.Tag_Name != `` {
+		mp[v.Tag_Name] = true
+		tags = append(tags, v.Tag_Name)
+	}
+	for _, tm := range tms {
+		if !mp[tm.Tag] {
+			mp[tm.Tag] = true
+			tags = append(tags, tm.Tag)
+		}
+	}
+	return
+}
@@ -0,0 +1,377 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingesters/utils"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	otlpTestTS      = 1714566645123456789
+	otlpTestTraceID = `5b8efff798038103d269b633813fc60c`
+	otlpTestSpanID  = `eee19b7ec3c1b174`
+)
+
+func otlpString(k, v string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: k, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v}}}
+}
+
+func otlpInt(k string, v int64) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: k, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: v}}}
+}
+
+func testOTLPRequest(t *testing.T) *collogspb.ExportLogsServiceRequest {
+	t.Helper()
+	traceID, _ := hex.DecodeString(otlpTestTraceID)
+	spanID, _ := hex.DecodeString(otlpTestSpanID)
+	return &collogspb.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{
+			{
+				Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+					otlpString(`service.name`, `checkout`),
+					otlpString(`host.name`, `web01`),
+				}},
+				ScopeLogs: []*logspb.ScopeLogs{{
+					Scope: &commonpb.InstrumentationScope{Name: `app`, Version: `1.0`, Attributes: []*commonpb.KeyValue{otlpString(`host.name`, `scope`)}},
+					LogRecords: []*logspb.LogRecord{
+						{
+							TimeUnixNano:   otlpTestTS,
+							SeverityText:   `ERROR`,
+							SeverityNumber: logspb.SeverityNumber_SEVERITY_NUMBER_ERROR,
+							Body:           &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: `payment failed`}},
+							Attributes:     []*commonpb.KeyValue{otlpInt(`http.status_code`, 502)},
+							TraceId:        traceID,
+							SpanId:         spanID,
+						},
+						{
+							ObservedTimeUnixNano: otlpTestTS + 1,
+							Body: &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: &commonpb.KeyValueList{
+								Values: []*commonpb.KeyValue{otlpString(`user`, `bob`), otlpInt(`items`, 3)},
+							}}},
+						},
+					},
+				}},
+			},
+			{
+				Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{otlpString(`service.name`, `frontend`)}},
+				ScopeLogs: []*logspb.ScopeLogs{{
+					LogRecords: []*logspb.LogRecord{{Body: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: `no timestamp`}}}},
+				}},
+			},
+		},
+	}
+}
+
+func TestDecodeOTLPRequest(t *testing.T) {
+	orig := testOTLPRequest(t)
+	pb, err := proto.Marshal(orig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ct := range []string{otlpContentProtobuf, otlpContentProtobufAlt} {
+		var req collogspb.ExportLogsServiceRequest
+		if err = decodeOTLPRequest(ct, pb, &req); err != nil {
+			t.Fatal(err)
+		} else if !proto.Equal(&req, orig) {
+			t.Fatalf("%s request did not round trip", ct)
+		}
+	}
+
+	// OTLP/JSON carries IDs as hex, nanosecond timestamps as strings, and enums as numbers
+	js := `{"resourceLogs":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"checkout"}}]},
+		"scopeLogs":[{"logRecords":[{"timeUnixNano":"1714566645123456789","severityNumber":17,
+		"body":{"stringValue":"payment failed"},"traceId":"` + otlpTestTraceID + `","spanId":"` + otlpTestSpanID + `",
+		"attributes":[{"key":"http.status_code","value":{"intValue":"502"}}],"unknownField":true}]}]}]}`
+	var req collogspb.ExportLogsServiceRequest
+	if err = decodeOTLPRequest(otlpContentJSON, []byte(js), &req); err != nil {
+		t.Fatal(err)
+	}
+	lr := req.GetResourceLogs()[0].GetScopeLogs()[0].GetLogRecords()[0]
+	if lr.GetTimeUnixNano() != otlpTestTS || lr.GetSeverityNumber() != logspb.SeverityNumber_SEVERITY_NUMBER_ERROR {
+		t.Fatalf("bad JSON record %v", lr)
+	} else if hex.EncodeToString(lr.GetTraceId()) != otlpTestTraceID || hex.EncodeToString(lr.GetSpanId()) != otlpTestSpanID {
+		t.Fatalf("bad JSON IDs %x %x", lr.GetTraceId(), lr.GetSpanId())
+	}
+
+	for _, tc := range []struct {
+		ct   string
+		body []byte
+	}{
+		{otlpContentProtobuf, []byte{0x0a, 0x05, 0x01}},
+		{otlpContentJSON, []byte(`{"resourceLogs":`)},
+		{otlpContentJSON, []byte(`{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"timeUnixNano":"yesterday"}]}]}]}`)},
+		{`text/plain`, []byte(`hello`)},
+	} {
+		if err = decodeOTLPRequest(tc.ct, tc.body, &collogspb.ExportLogsServiceRequest{}); err == nil {
+			t.Fatalf("malformed %s request was accepted", tc.ct)
+		}
+	}
+	if err = decodeOTLPRequest(`text/plain`, nil, &collogspb.ExportLogsServiceRequest{}); err != ErrOTLPUnsupportedContent {
+		t.Fatalf("bad unsupported content error %v", err)
+	}
+}
+
+func TestFixOTLPJSONId(t *testing.T) {
+	raw, _ := hex.DecodeString(otlpTestSpanID)
+	// the standard protojson decoder base64 decodes the hex text
+	mangled, err := base64.StdEncoding.DecodeString(otlpTestSpanID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := fixOTLPJSONId(mangled, otlpSpanIDSize); !bytes.Equal(id, raw) {
+		t.Fatalf("bad repaired ID %x", id)
+	} else if id = fixOTLPJSONId(raw, otlpSpanIDSize); !bytes.Equal(id, raw) {
+		t.Fatalf("correctly sized ID was altered %x", id)
+	} else if id = fixOTLPJSONId([]byte{1, 2, 3}, otlpSpanIDSize); id != nil {
+		t.Fatalf("bad ID was not dropped %x", id)
+	} else if id = fixOTLPJSONId(nil, otlpSpanIDSize); id != nil {
+		t.Fatalf("empty ID was altered %x", id)
+	}
+}
+
+func TestOTLPValue(t *testing.T) {
+	tests := []struct {
+		v   *commonpb.AnyValue
+		exp string
+	}{
+		{&commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: `x`}}, `x`},
+		{&commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: true}}, `true`},
+		{&commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: -5}}, `-5`},
+		{&commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: 1.5}}, `1.5`},
+		{&commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: math.NaN()}}, `NaN`},
+		{&commonpb.AnyValue{Value: &commonpb.AnyValue_BytesValue{BytesValue: []byte(`hi`)}}, `"aGk="`},
+		{&commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{Values: []*commonpb.AnyValue{
+			{Value: &commonpb.AnyValue_IntValue{IntValue: 1}},
+			{Value: &commonpb.AnyValue_StringValue{StringValue: `two`}},
+		}}}}, `[1,"two"]`},
+		{&commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: &commonpb.KeyValueList{}}}, `{}`},
+		{&commonpb.AnyValue{}, ``},
+		{nil, ``},
+	}
+	for i, tc := range tests {
+		if s := otlpValueString(tc.v); s != tc.exp {
+			t.Fatalf("%d: bad value string %q != %q", i, s, tc.exp)
+		}
+	}
+}
+
+func newTestOTLPHandler(t *testing.T, v *otlp) *otlpHandler {
+	t.Helper()
+	tms, err := v.tagMatchers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	oh := &otlpHandler{name: `test`, ignoreTs: v.Ignore_Timestamps}
+	for i, tm := range tms {
+		oh.routers = append(oh.routers, otlpRouter{attribute: tm.Attribute, value: tm.Value, tag: entry.EntryTag(i + 1)})
+	}
+	for _, a := range v.Attach_Attributes {
+		if a == `*` {
+			oh.attachAll = true
+		} else {
+			oh.attach = append(oh.attach, a)
+		}
+	}
+	return oh
+}
+
+func TestOTLPHandle(t *testing.T) {
+	const def entry.EntryTag = 100
+	oh := newTestOTLPHandler(t, &otlp{
+		Tag_Match:         []string{`service.name=checkout:shop`},
+		Attach_Attributes: []string{`host.name`, `http.status_code`},
+	})
+	h, rh, tw := newTestRoute(t, def)
+	ip := net.ParseIP(`10.0.0.1`)
+	post := func(ct string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, `/v1/logs`, bytes.NewReader(body))
+		if ct != `` {
+			req.Header.Set(`Content-Type`, ct)
+		}
+		rec := httptest.NewRecorder()
+		oh.handle(h, rh, rec, req, req.Body, ip)
+		return rec
+	}
+	pb, err := proto.Marshal(testOTLPRequest(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// protobuf is the default when no content type is set
+	if rec := post(``, pb); rec.Code != http.StatusOK || rec.Header().Get(`Content-Type`) != otlpContentProtobuf {
+		t.Fatalf("bad protobuf response %d %q", rec.Code, rec.Header().Get(`Content-Type`))
+	}
+	if len(tw.ents) != 3 {
+		t.Fatalf("bad entry count %d", len(tw.ents))
+	}
+
+	ent := tw.ents[0]
+	if ent.Tag != 1 || !ent.SRC.Equal(ip) || ent.TS != otlpTimestamp(otlpTestTS) {
+		t.Fatalf("bad routed entry %+v", ent)
+	} else if evString(t, ent, `host.name`) != `scope` {
+		t.Fatal("scope attributes did not take precedence over resource attributes")
+	} else if v, ok := ent.GetEnumeratedValue(`http.status_code`); !ok || v != int64(502) {
+		t.Fatalf("bad typed attribute %v", v)
+	}
+	var rec otlpRecord
+	if err = json.Unmarshal(ent.Data, &rec); err != nil {
+		t.Fatal(err)
+	} else if rec.Body != `payment failed` || rec.SeverityText != `ERROR` || rec.SeverityNumber != 17 {
+		t.Fatalf("bad record %+v", rec)
+	} else if rec.TraceID != otlpTestTraceID || rec.SpanID != otlpTestSpanID {
+		t.Fatalf("bad record IDs %s %s", rec.TraceID, rec.SpanID)
+	} else if rec.Timestamp != `2024-05-01T12:30:45.123456789Z` || rec.Resource[`service.name`] != `checkout` {
+		t.Fatalf("bad record %+v", rec)
+	} else if rec.Scope == nil || rec.Scope.Name != `app` || rec.Scope.Version != `1.0` {
+		t.Fatalf("bad record scope %+v", rec.Scope)
+	}
+
+	// the observed timestamp is used when the record has no timestamp
+	if ent = tw.ents[1]; ent.TS != otlpTimestamp(otlpTestTS+1) {
+		t.Fatalf("bad observed timestamp %v", ent.TS)
+	} else if err = json.Unmarshal(ent.Data, &rec); err != nil {
+		t.Fatal(err)
+	} else if body, ok := rec.Body.(map[string]interface{}); !ok || body[`user`] != `bob` || body[`items`] != float64(3) {
+		t.Fatalf("bad structured body %v", rec.Body)
+	}
+	if ent = tw.ents[2]; ent.Tag != def {
+		t.Fatalf("unmatched resource was routed to %d", ent.Tag)
+	}
+
+	// JSON requests get JSON responses
+	if rec := post(otlpContentJSON, []byte(`{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"body":{"stringValue":"x"}}]}]}]}`)); rec.Code != http.StatusOK || rec.Header().Get(`Content-Type`) != otlpContentJSON {
+		t.Fatalf("bad JSON response %d %q", rec.Code, rec.Header().Get(`Content-Type`))
+	} else if len(tw.ents) != 4 {
+		t.Fatalf("bad entry count %d", len(tw.ents))
+	}
+
+	for _, tc := range []struct {
+		ct   string
+		body []byte
+		code int
+	}{
+		{otlpContentProtobuf, []byte{0x0a, 0x05, 0x01}, http.StatusBadRequest},
+		{otlpContentJSON, []byte(`not json`), http.StatusBadRequest},
+		{`text/plain`, []byte(`hello`), http.StatusUnsupportedMediaType},
+		{otlpContentJSON, bytes.Repeat([]byte(` `), maxBody+1), http.StatusRequestEntityTooLarge},
+	} {
+		if rec := post(tc.ct, tc.body); rec.Code != tc.code {
+			t.Fatalf("%s request returned %d, expected %d", tc.ct, rec.Code, tc.code)
+		}
+	}
+	if len(tw.ents) != 4 {
+		t.Fatalf("malformed requests produced entries: %d", len(tw.ents))
+	}
+}
+
+func TestOTLPGRPCAuth(t *testing.T) {
+	s := &otlpGRPCServer{tokenHeader: `authorization`}
+	if !s.authorized(context.Background()) {
+		t.Fatal("request rejected without a configured token")
+	}
+	s.tokenValue = `secret`
+	for _, tc := range []struct {
+		md metadata.MD
+		ok bool
+	}{
+		{nil, false},
+		{metadata.Pairs(`authorization`, `secret`), true},
+		{metadata.Pairs(`Authorization`, `secret`), true}, // metadata keys are case insensitive
+		{metadata.Pairs(`authorization`, `secre`), false},
+		{metadata.Pairs(`authorization`, `secret2`), false},
+		{metadata.Pairs(`x-token`, `secret`), false},
+	} {
+		ctx := context.Background()
+		if tc.md != nil {
+			ctx = metadata.NewIncomingContext(ctx, tc.md)
+		}
+		if ok := s.authorized(ctx); ok != tc.ok {
+			t.Fatalf("%v: authorized returned %v", tc.md, ok)
+		}
+	}
+}
+
+func TestOTLPGRPCProxy(t *testing.T) {
+	trusted, err := utils.ParseTrustedUpstreams([]string{`127.0.0.0/8`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &otlpGRPCServer{bind: `127.0.0.1:0`, proxy: true, trusted: trusted}
+	lst, err := s.listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// capture the peer and bail before the export handler runs
+	peers := make(chan string, 1)
+	srv := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if p, ok := peer.FromContext(ctx); ok {
+			peers <- p.Addr.String()
+		}
+		return nil, status.Error(codes.Unavailable, `test`)
+	}))
+	collogspb.RegisterLogsServiceServer(srv, s)
+	go srv.Serve(lst)
+	defer srv.Stop()
+
+	dialer := func(ctx context.Context, addr string) (net.Conn, error) {
+		var d net.Dialer
+		c, err := d.DialContext(ctx, `tcp`, addr)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = c.Write([]byte("PROXY TCP4 203.0.113.7 10.0.0.5 51000 4317\r\n")); err != nil {
+			c.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+	cc, err := grpc.NewClient(lst.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(dialer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cf()
+	if _, err = collogspb.NewLogsServiceClient(cc).Export(ctx, &collogspb.ExportLogsServiceRequest{}); status.Code(err) != codes.Unavailable {
+		t.Fatalf("unexpected export result %v", err)
+	}
+	select {
+	case p := <-peers:
+		if p != `203.0.113.7:51000` {
+			t.Fatalf("peer %q did not come from the PROXY header", p)
+		}
+	default:
+		t.Fatal("request never reached the server")
+	}
+}
@@ -0,0 +1,362 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+	"github.com/gravwell/gravwell/v3/timegrinder"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	topicEVName       = `topic`
+	connectRetryDelay = 10 * time.Second
+	connectWait       = 5 * time.Second
+	disconnectTimeout = 5 * time.Second
+	disconnectQuiesce = uint(disconnectTimeout / time.Millisecond)
+)
+
+// message is a received PUBLISH independent of the protocol version
+type message struct {
+	topic   string
+	payload []byte
+	props   []paho.UserProperty //MQTT 5 only
+}
+
+// subscription is the runtime side of a Subscription config block
+type subscription struct {
+	name        string
+	filters     []string
+	qos         byte
+	tag         entry.EntryTag
+	tg          *timegrinder.TimeGrinder
+	attachTopic bool
+	attachProps bool
+	proc        *processors.ProcessorSet
+}
+
+// router hands messages to the first subscription with a matching filter, brokers do not tell
+// MQTT 3.1.1 clients which subscription a message arrived on so we have to work it out
+type router struct {
+	name string
+	subs []*subscription
+	src  net.IP
+	ctx  context.Context
+	lg   *log.Logger
+
+	mtx       sync.Mutex
+	count     uint64
+	size      uint64
+	unrouted  uint64
+	lastWarn  time.Time
+	warnEvery time.Duration
+}
+
+func (r *router) route(topic string) *subscription {
+	for _, s := range r.subs {
+		for _, f := range s.filters {
+			if topicMatch(f, topic) {
+				return s
+			}
+		}
+	}
+	return nil
+}
+
+// handle converts a message into an entry and pushes it into the subscriptions processor set,
+// the message can only be acknowledged once this returns.
+func (r *router) handle(m message) (err error) {
+	sub := r.route(m.topic)
+	if sub == nil {
+		//most likely a left over subscription in a persistent session, ack it so it doesn't come back
+		r.mtx.Lock()
+		r.unrouted++
+		if time.Since(r.lastWarn) > r.warnEvery {
+			r.lastWarn = time.Now()
+			r.lg.Warn("dropping message that does not match any subscription",
+				log.KV("broker", r.name), log.KV("topic", m.topic), log.KV("dropped", r.unrouted))
+		}
+		r.mtx.Unlock()
+		return
+	}
+	ent := &entry.Entry{
+		SRC:  r.src, //may be nil, ingest muxer will handle if it is
+		Tag:  sub.tag,
+		Data: m.payload,
+	}
+	ent.TS = entry.Now()
+	if sub.tg != nil {
+		if ts, ok, lerr := sub.tg.Extract(m.payload); lerr != nil {
+			r.lg.Warn("catastrophic timegrinder error", log.KV("subscription", sub.name), log.KVErr(lerr))
+		} else if ok {
+			ent.TS = entry.FromStandard(ts)
+		}
+	}
+	if sub.attachTopic {
+		if err = ent.AddEnumeratedValueEx(topicEVName, m.topic); err != nil {
+			err = fmt.Errorf("failed to attach topic %w", err)
+			return
+		}
+	}
+	if sub.attachProps {
+		for _, p := range m.props {
+			if p.Key == `` {
+				continue
+			}
+			if lerr := ent.AddEnumeratedValueEx(p.Key, p.Value); lerr != nil {
+				r.lg.Warn("failed to attach user property", log.KV("subscription", sub.name), log.KV("property", p.Key), log.KVErr(lerr))
+			}
+		}
+	}
+	if err = sub.proc.ProcessContext(ent, r.ctx); err == nil {
+		r.mtx.Lock()
+		r.count++
+		r.size += uint64(len(m.payload))
+		r.mtx.Unlock()
+	}
+	return
+}
+
+// done decides if a handled message should be acknowledged.  Messages that were not taken
+// because we are shutting down stay unacknowledged so the broker redelivers them when the session
+// resumes, anything else would fail again on redelivery and holding its ack stalls the session.
+func (r *router) done(broker, topic string, err error) (ack bool) {
+	if err == nil {
+		return true
+	} else if r.ctx.Err() != nil {
+		return false
+	}
+	r.lg.Error("failed to handle message", log.KV("broker", broker), log.KV("topic", topic), log.KVErr(err))
+	return true
+}
+
+func (r *router) stats() (count, size, unrouted uint64) {
+	r.mtx.Lock()
+	count, size, unrouted = r.count, r.size, r.unrouted
+	r.mtx.Unlock()
+	return
+}
+
+// brokerClient is implemented for each protocol version
+type brokerClient interface {
+	Start() error
+	Close() error
+}
+
+type clientConfig struct {
+	name          string
+	uri           *url.URL
+	clientID      string
+	username      string
+	password      string
+	cleanSession  bool
+	sessionExpiry time.Duration
+	rtr           *router
+	lg            *log.Logger
+}
+
+func newBrokerClient(bc *brokerConfig, cc clientConfig) (brokerClient, error) {
+	tc, err := bc.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if bc.protocol() == protocolV5 {
+		return newV5Client(cc, tc), nil
+	}
+	return newV311Client(cc, tc), nil
+}
+
+// v311Client speaks MQTT 3.1.1 using the paho client
+type v311Client struct {
+	clientConfig
+	cli mqtt.Client
+}
+
+func newV311Client(cc clientConfig, tc *tls.Config) *v311Client {
+	c := &v311Client{clientConfig: cc}
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(cc.uri.String())
+	opts.SetClientID(cc.clientID)
+	opts.SetProtocolVersion(4) //3.1.1
+	opts.SetCleanSession(cc.cleanSession)
+	opts.SetKeepAlive(defaultKeepAlive)
+	opts.SetTLSConfig(tc)
+	opts.SetOrderMatters(true) //acks have to go out in the order messages arrived
+	opts.SetAutoAckDisabled(true)
+	opts.SetAutoReconnect(true)
+	opts.SetConnectRetry(true)
+	opts.SetConnectRetryInterval(connectRetryDelay)
+	opts.SetMaxReconnectInterval(connectRetryDelay)
+	if cc.username != `` {
+		opts.SetUsername(cc.username)
+		opts.SetPassword(cc.password)
+	}
+	opts.SetOnConnectHandler(c.onConnect)
+	opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		c.lg.Warn("lost broker connection", log.KV("broker", c.name), log.KVErr(err))
+	})
+	opts.SetDefaultPublishHandler(c.onMessage)
+	c.cli = mqtt.NewClient(opts)
+	return c
+}
+
+func (c *v311Client) Start() error {
+	//with connect retry enabled this only fails on bad options, connection errors are retried
+	if tkn := c.cli.Connect(); tkn.WaitTimeout(connectWait) && tkn.Error() != nil {
+		return tkn.Error()
+	}
+	return nil
+}
+
+// onConnect subscribes on every connection, brokers may have dropped the session
+func (c *v311Client) onConnect(cli mqtt.Client) {
+	c.lg.Info("connected to broker", log.KV("broker", c.name), log.KV("clientid", c.clientID))
+	filters := map[string]byte{}
+	for _, s := range c.rtr.subs {
+		for _, f := range s.filters {
+			filters[f] = s.qos
+		}
+	}
+	tkn := cli.SubscribeMultiple(filters, nil) //nil callback means the default handler gets everything
+	go func() {
+		if tkn.Wait(); tkn.Error() != nil {
+			c.lg.Error("failed to subscribe", log.KV("broker", c.name), log.KVErr(tkn.Error()))
+		}
+	}()
+}
+
+func (c *v311Client) onMessage(_ mqtt.Client, msg mqtt.Message) {
+	if !c.rtr.done(c.name, msg.Topic(), c.rtr.handle(message{topic: msg.Topic(), payload: msg.Payload()})) {
+		return
+	}
+	msg.Ack()
+}
+
+func (c *v311Client) Close() error {
+	c.cli.Disconnect(disconnectQuiesce)
+	return nil
+}
+
+// v5Client speaks MQTT 5 using the paho.golang autopaho client
+type v5Client struct {
+	clientConfig
+	cfg    autopaho.ClientConfig
+	cm     *autopaho.ConnectionManager
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newV5Client(cc clientConfig, tc *tls.Config) *v5Client {
+	c := &v5Client{clientConfig: cc}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.cfg = autopaho.ClientConfig{
+		ServerUrls:                    []*url.URL{cc.uri},
+		TlsCfg:                        tc,
+		KeepAlive:                     uint16(defaultKeepAlive / time.Second),
+		CleanStartOnInitialConnection: cc.cleanSession,
+		SessionExpiryInterval:         uint32(cc.sessionExpiry / time.Second),
+		ReconnectBackoff:              autopaho.NewConstantBackoff(connectRetryDelay),
+		ConnectUsername:               cc.username,
+		ConnectPassword:               []byte(cc.password),
+		OnConnectionUp:                c.onConnect,
+		OnConnectError: func(err error) {
+			c.lg.Warn("failed to connect to broker", log.KV("broker", c.name), log.KVErr(err))
+		},
+		ClientConfig: paho.ClientConfig{
+			ClientID:                   cc.clientID,
+			EnableManualAcknowledgment: true,
+			OnPublishReceived:          []func(paho.PublishReceived) (bool, error){c.onMessage},
+			OnClientError: func(err error) {
+				c.lg.Warn("broker client error", log.KV("broker", c.name), log.KVErr(err))
+			},
+			OnServerDisconnect: func(d *paho.Disconnect) {
+				c.lg.Warn("broker disconnected", log.KV("broker", c.name), log.KV("reason", d.ReasonCode))
+			},
+		},
+	}
+	return c
+}
+
+func (c *v5Client) Start() (err error) {
+	c.cm, err = autopaho.NewConnection(c.ctx, c.cfg)
+	return
+}
+
+func (c *v5Client) onConnect(cm *autopaho.ConnectionManager, ca *paho.Connack) {
+	c.lg.Info("connected to broker", log.KV("broker", c.name), log.KV("clientid", c.clientID), log.KV("session-present", ca.SessionPresent))
+	sub := &paho.Subscribe{}
+	for _, s := range c.rtr.subs {
+		for _, f := range s.filters {
+			sub.Subscriptions = append(sub.Subscriptions, paho.SubscribeOptions{Topic: f, QoS: s.qos})
+		}
+	}
+	if _, err := cm.Subscribe(c.ctx, sub); err != nil && c.ctx.Err() == nil {
+		c.lg.Error("failed to subscribe", log.KV("broker", c.name), log.KVErr(err))
+	}
+}
+
+func (c *v5Client) onMessage(pr paho.PublishReceived) (bool, error) {
+	m := message{
+		topic:   pr.Packet.Topic,
+		payload: pr.Packet.Payload,
+	}
+	if pr.Packet.Properties != nil {
+		m.props = pr.Packet.Properties.User
+	}
+	if !c.rtr.done(c.name, m.topic, c.rtr.handle(m)) {
+		return true, nil
+	}
+	if err := pr.Client.Ack(pr.Packet); err != nil && !errors.Is(err, paho.ErrManualAcknowledgmentDisabled) {
+		c.lg.Warn("failed to acknowledge message", log.KV("broker", c.name), log.KV("topic", m.topic), log.KVErr(err))
+	}
+	return true, nil
+}
+
+func (c *v5Client) Close() (err error) {
+	if c.cm != nil {
+		ctx, cf := context.WithTimeout(context.Background(), disconnectTimeout)
+		err = c.cm.Disconnect(ctx)
+		cf()
+	}
+	c.cancel()
+	return
+}
+
+// brokerURI normalizes the broker URL, both clients understand the same schemes but the port
+// is filled in if it is missing
+func brokerURI(v string) (uri *url.URL, err error) {
+	if uri, err = url.Parse(v); err != nil {
+		return
+	}
+	uri.Scheme = strings.ToLower(uri.Scheme)
+	if uri.Port() == `` {
+		switch uri.Scheme {
+		case `ssl`, `tls`, `mqtts`:
+			uri.Host = net.JoinHostPort(uri.Hostname(), `8883`)
+		case `tcp`, `mqtt`:
+			uri.Host = net.JoinHostPort(uri.Hostname(), `1883`)
+		}
+	}
+	return
+}
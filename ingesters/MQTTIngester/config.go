@@ -0,0 +1,396 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/attach"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+	"github.com/gravwell/gravwell/v3/timegrinder"
+)
+
+const (
+	MAX_CONFIG_SIZE int64 = (1024 * 1024 * 2) //2MB, even this is crazy large
+
+	protocolV311 = `3.1.1`
+	protocolV5   = `5`
+
+	defaultQoS           byte = 1
+	defaultSessionExpiry      = time.Hour
+	defaultKeepAlive          = 30 * time.Second
+	minTLSVersion             = tls.VersionTLS12
+
+	sharedSubPrefix = `$share/`
+)
+
+type brokerConfig struct {
+	Broker_URL               string //tcp://, mqtt://, ssl://, tls://, mqtts://, ws://, or wss://
+	Protocol_Version         string //3.1.1 (protocol level 4) or 5, defaults to 3.1.1
+	Client_ID                string //required for persistent sessions, defaults to one derived from the ingester UUID
+	Username                 string
+	Password                 string `json:"-"` // DO NOT send this when marshalling
+	CA_Cert                  string
+	Client_Cert              string
+	Client_Key               string
+	Insecure_Skip_TLS_Verify bool
+	Clean_Session            bool   //throw away the broker side session on connect
+	Session_Expiry           string //MQTT 5 only, how long the broker holds our session while we are away
+	Source_Override          string
+}
+
+type subscriptionConfig struct {
+	Broker                    string
+	Topic_Filter              []string
+	QoS                       string //0, 1, or 2, defaults to 1
+	Tag_Name                  string
+	Attach_Topic              bool //attach the message topic as the "topic" EV
+	Attach_User_Properties    bool //attach MQTT 5 user properties as EVs
+	Ignore_Timestamps         bool //Just apply the current timestamp to messages as we get them
+	Assume_Local_Timezone     bool
+	Timezone_Override         string
+	Timestamp_Format_Override string //override the timestamp format
+	Preprocessor              []string
+}
+
+type cfgType struct {
+	Global       config.IngestConfig
+	Attach       attach.AttachConfig
+	Broker       map[string]*brokerConfig
+	Subscription map[string]*subscriptionConfig
+	Preprocessor processors.ProcessorConfig
+	TimeFormat   config.CustomTimeFormat
+}
+
+func GetConfig(path, overlayPath string) (*cfgType, error) {
+	var c cfgType
+	if err := config.LoadConfigFile(&c, path); err != nil {
+		return nil, err
+	} else if err = config.LoadConfigOverlays(&c, overlayPath); err != nil {
+		return nil, err
+	}
+	if err := c.Verify(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *cfgType) Verify() error {
+	if err := c.Global.Verify(); err != nil {
+		return err
+	} else if err = c.Attach.Verify(); err != nil {
+		return err
+	} else if err = c.Preprocessor.Validate(); err != nil {
+		return err
+	} else if err = c.TimeFormat.Validate(); err != nil {
+		return err
+	}
+	if len(c.Broker) == 0 {
+		return errors.New("No Broker specified")
+	} else if len(c.Subscription) == 0 {
+		return errors.New("No Subscription specified")
+	}
+	for k, v := range c.Broker {
+		if v == nil {
+			return fmt.Errorf("Broker %s config is nil", k)
+		} else if err := v.validate(); err != nil {
+			return fmt.Errorf("Broker %s is invalid: %w", k, err)
+		}
+	}
+	used := map[string]bool{}
+	filters := map[string]string{}
+	for k, v := range c.Subscription {
+		if v == nil {
+			return fmt.Errorf("Subscription %s config is nil", k)
+		} else if err := v.validate(); err != nil {
+			return fmt.Errorf("Subscription %s is invalid: %w", k, err)
+		} else if err = c.Preprocessor.CheckProcessors(v.Preprocessor); err != nil {
+			return fmt.Errorf("Subscription %s preprocessor invalid: %w", k, err)
+		}
+		bc, ok := c.Broker[v.Broker]
+		if !ok {
+			return fmt.Errorf("Subscription %s references unknown Broker %q", k, v.Broker)
+		} else if v.Attach_User_Properties && bc.protocol() != protocolV5 {
+			return fmt.Errorf("Subscription %s Attach-User-Properties requires MQTT 5", k)
+		}
+		used[v.Broker] = true
+		for _, f := range v.Topic_Filter {
+			key := v.Broker + "\x00" + f
+			if other, ok := filters[key]; ok && other != k {
+				return fmt.Errorf("Subscription %s and %s both subscribe to %q on Broker %s", k, other, f, v.Broker)
+			}
+			filters[key] = k
+		}
+	}
+	for k := range c.Broker {
+		if !used[k] {
+			return fmt.Errorf("Broker %s has no subscriptions", k)
+		}
+	}
+	return nil
+}
+
+func (c *cfgType) Tags() (tags []string, err error) {
+	tagMp := make(map[string]bool, len(c.Subscription))
+	for _, v := range c.Subscription {
+		if _, ok := tagMp[v.Tag_Name]; !ok {
+			tags = append(tags, v.Tag_Name)
+			tagMp[v.Tag_Name] = true
+		}
+	}
+	if len(tags) == 0 {
+		err = errors.New("No tags specified")
+	} else {
+		sort.Strings(tags)
+	}
+	return
+}
+
+func (c *cfgType) IngestBaseConfig() config.IngestConfig {
+	return c.Global
+}
+
+func (c *cfgType) AttachConfig() attach.AttachConfig {
+	return c.Attach
+}
+
+// subscriptions returns the subscriptions for a broker in name order, messages are routed to the
+// first subscription with a filter that matches so the order needs to be stable
+func (c *cfgType) subscriptions(broker string) (names []string) {
+	for k, v := range c.Subscription {
+		if v.Broker == broker {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	return
+}
+
+func (bc *brokerConfig) validate() (err error) {
+	if bc.Broker_URL == `` {
+		return errors.New("missing Broker-URL")
+	}
+	var uri *url.URL
+	if uri, err = url.Parse(bc.Broker_URL); err != nil {
+		return fmt.Errorf("invalid Broker-URL %q %w", bc.Broker_URL, err)
+	}
+	switch strings.ToLower(uri.Scheme) {
+	case `tcp`, `mqtt`, `ssl`, `tls`, `mqtts`, `ws`, `wss`:
+	default:
+		return fmt.Errorf("unsupported Broker-URL scheme %q", uri.Scheme)
+	}
+	if uri.Hostname() == `` {
+		return fmt.Errorf("Broker-URL %q is missing a host", bc.Broker_URL)
+	}
+	switch bc.Protocol_Version {
+	case ``, `4`, protocolV311, protocolV5, `5.0`:
+	default:
+		return fmt.Errorf("unknown Protocol-Version %q", bc.Protocol_Version)
+	}
+	if bc.Password != `` && bc.Username == `` {
+		return errors.New("Password requires a Username")
+	} else if (bc.Client_Cert == ``) != (bc.Client_Key == ``) {
+		return errors.New("Client-Cert and Client-Key must be set together")
+	} else if bc.Source_Override != `` && net.ParseIP(bc.Source_Override) == nil {
+		return fmt.Errorf("invalid Source-Override %q", bc.Source_Override)
+	}
+	if bc.Session_Expiry != `` {
+		if bc.protocol() != protocolV5 {
+			return errors.New("Session-Expiry requires MQTT 5")
+		} else if _, err = bc.sessionExpiry(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// protocol normalizes the protocol version, 3.1.1 is the default because every broker speaks it
+func (bc *brokerConfig) protocol() string {
+	switch bc.Protocol_Version {
+	case protocolV5, `5.0`:
+		return protocolV5
+	}
+	return protocolV311
+}
+
+func (bc *brokerConfig) sessionExpiry() (d time.Duration, err error) {
+	if bc.Session_Expiry == `` {
+		d = defaultSessionExpiry
+	} else if d, err = time.ParseDuration(bc.Session_Expiry); err != nil {
+		err = fmt.Errorf("invalid Session-Expiry %q %w", bc.Session_Expiry, err)
+	} else if d < 0 || d.Seconds() > float64(^uint32(0)) {
+		err = fmt.Errorf("Session-Expiry %v is out of range", d)
+	}
+	return
+}
+
+// clientID returns the configured client ID or one derived from the ingester UUID, persistent
+// sessions are tied to the client ID so it must not change between restarts
+func (bc *brokerConfig) clientID(name, ingesterUUID string) string {
+	if bc.Client_ID != `` {
+		return bc.Client_ID
+	}
+	return fmt.Sprintf("gravwell-%s-%s", ingesterUUID, name)
+}
+
+func (bc *brokerConfig) srcOverride() net.IP {
+	if bc.Source_Override == `` {
+		return nil
+	}
+	return net.ParseIP(bc.Source_Override)
+}
+
+func (bc *brokerConfig) tlsConfig() (tc *tls.Config, err error) {
+	tc = &tls.Config{
+		MinVersion:         minTLSVersion,
+		InsecureSkipVerify: bc.Insecure_Skip_TLS_Verify,
+	}
+	if bc.CA_Cert != `` {
+		var bts []byte
+		if bts, err = os.ReadFile(bc.CA_Cert); err != nil {
+			err = fmt.Errorf("failed to load CA-Cert %w", err)
+			return
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(bts) {
+			err = fmt.Errorf("CA-Cert %q does not contain any certificates", bc.CA_Cert)
+			return
+		}
+	}
+	if bc.Client_Cert != `` {
+		var cert tls.Certificate
+		if cert, err = tls.LoadX509KeyPair(bc.Client_Cert, bc.Client_Key); err != nil {
+			err = fmt.Errorf("failed to load Client-Cert and Client-Key %w", err)
+			return
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return
+}
+
+func (sc *subscriptionConfig) validate() (err error) {
+	if sc.Broker == `` {
+		return errors.New("missing Broker")
+	} else if len(sc.Topic_Filter) == 0 {
+		return errors.New("missing Topic-Filter")
+	} else if sc.Tag_Name == `` {
+		return errors.New("missing Tag-Name")
+	} else if err = ingest.CheckTag(sc.Tag_Name); err != nil {
+		return
+	} else if _, err = sc.qos(); err != nil {
+		return
+	}
+	for _, f := range sc.Topic_Filter {
+		if err = validateTopicFilter(f); err != nil {
+			return
+		}
+	}
+	if sc.Timezone_Override != `` {
+		if sc.Assume_Local_Timezone {
+			return errors.New("Cannot specify Assume-Local-Timezone and Timezone-Override in the same subscription")
+		} else if _, err = time.LoadLocation(sc.Timezone_Override); err != nil {
+			return fmt.Errorf("Invalid timezone override %v: %w", sc.Timezone_Override, err)
+		}
+	}
+	return
+}
+
+func (sc *subscriptionConfig) qos() (byte, error) {
+	if sc.QoS == `` {
+		return defaultQoS, nil
+	}
+	v, err := strconv.ParseUint(sc.QoS, 10, 8)
+	if err != nil || v > 2 {
+		return 0, fmt.Errorf("invalid QoS %q, must be 0, 1, or 2", sc.QoS)
+	}
+	return byte(v), nil
+}
+
+func (sc *subscriptionConfig) timegrinder(tf config.CustomTimeFormat) (tg *timegrinder.TimeGrinder, err error) {
+	if sc.Ignore_Timestamps {
+		return
+	}
+	tcfg := timegrinder.Config{
+		EnableLeftMostSeed: true,
+		FormatOverride:     sc.Timestamp_Format_Override,
+	}
+	if tg, err = timegrinder.NewTimeGrinder(tcfg); err != nil {
+		return
+	} else if err = tf.LoadFormats(tg); err != nil {
+		return
+	}
+	if sc.Assume_Local_Timezone {
+		tg.SetLocalTime()
+	}
+	if sc.Timezone_Override != `` {
+		err = tg.SetTimezone(sc.Timezone_Override)
+	}
+	return
+}
+
+// validateTopicFilter enforces the MQTT wildcard rules so that bad filters are caught at startup
+// instead of the broker rejecting the subscription
+func validateTopicFilter(f string) error {
+	if f == `` {
+		return errors.New("empty Topic-Filter")
+	}
+	if strings.HasPrefix(f, sharedSubPrefix) {
+		parts := strings.SplitN(strings.TrimPrefix(f, sharedSubPrefix), `/`, 2)
+		if len(parts) != 2 || parts[0] == `` || strings.ContainsAny(parts[0], `+#`) {
+			return fmt.Errorf("invalid shared subscription %q", f)
+		}
+		f = parts[1]
+	}
+	levels := strings.Split(f, `/`)
+	for i, l := range levels {
+		if strings.Contains(l, `#`) && (l != `#` || i != len(levels)-1) {
+			return fmt.Errorf("invalid Topic-Filter %q, # must be the entire last level", f)
+		} else if strings.Contains(l, `+`) && l != `+` {
+			return fmt.Errorf("invalid Topic-Filter %q, + must be an entire level", f)
+		}
+	}
+	return nil
+}
+
+// topicMatch checks a topic name against a subscription filter using the MQTT wildcard rules
+func topicMatch(filter, topic string) bool {
+	if strings.HasPrefix(filter, sharedSubPrefix) {
+		if parts := strings.SplitN(strings.TrimPrefix(filter, sharedSubPrefix), `/`, 2); len(parts) == 2 {
+			filter = parts[1]
+		}
+	}
+	//wildcards at the first level do not match the $ system topics
+	if strings.HasPrefix(topic, `$`) && (strings.HasPrefix(filter, `+`) || strings.HasPrefix(filter, `#`)) {
+		return false
+	}
+	fl := strings.Split(filter, `/`)
+	tl := strings.Split(topic, `/`)
+	for i, f := range fl {
+		if f == `#` {
+			return true //matches the parent level and everything under it
+		} else if i >= len(tl) {
+			return false
+		} else if f != `+` && f != tl[i] {
+			return false
+		}
+	}
+	return len(fl) == len(tl)
+}
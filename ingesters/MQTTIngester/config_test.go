@@ -0,0 +1,283 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+)
+
+const (
+	globalConfig = `
+[Global]
+Ingest-Secret = IngestSecrets
+Connection-Timeout = 0
+Cleartext-Backend-target=127.0.0.1:4023
+Log-Level=INFO
+`
+	baseConfig = globalConfig + `
+[Broker "mosquitto"]
+	Broker-URL="tcp://127.0.0.1"
+	Username=gravwell
+	Password=secret
+
+[Broker "emqx"]
+	Broker-URL="tls://emqx.example.com:8883"
+	Protocol-Version=5
+	Session-Expiry=24h
+
+[Subscription "telemetry"]
+	Broker=mosquitto
+	Topic-Filter="plant1/+/telemetry"
+	Topic-Filter="plant2/#"
+	Tag-Name=ot
+	Attach-Topic=true
+
+[Subscription "alarms"]
+	Broker=emqx
+	Topic-Filter="$share/gravwell/alarms/#"
+	QoS=2
+	Tag-Name=alarms
+	Attach-User-Properties=true
+`
+)
+
+func writeConfig(t *testing.T, v string) string {
+	t.Helper()
+	pth := filepath.Join(t.TempDir(), `mqtt.conf`)
+	if err := os.WriteFile(pth, []byte(v), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return pth
+}
+
+func TestBasicConfig(t *testing.T) {
+	cfg, err := GetConfig(writeConfig(t, baseConfig), ``)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Broker) != 2 || len(cfg.Subscription) != 2 {
+		t.Fatalf("bad config counts %d %d", len(cfg.Broker), len(cfg.Subscription))
+	}
+	if tags, err := cfg.Tags(); err != nil {
+		t.Fatal(err)
+	} else if strings.Join(tags, `,`) != `alarms,ot` {
+		t.Fatalf("bad tags %v", tags)
+	}
+	if bc := cfg.Broker[`mosquitto`]; bc.protocol() != protocolV311 {
+		t.Fatalf("bad default protocol %v", bc.protocol())
+	} else if d, err := bc.sessionExpiry(); err != nil || d != defaultSessionExpiry {
+		t.Fatalf("bad default session expiry %v %v", d, err)
+	} else if bc.clientID(`mosquitto`, `abc`) != `gravwell-abc-mosquitto` {
+		t.Fatalf("bad default client ID %v", bc.clientID(`mosquitto`, `abc`))
+	}
+	if bc := cfg.Broker[`emqx`]; bc.protocol() != protocolV5 {
+		t.Fatalf("bad protocol %v", bc.protocol())
+	} else if d, err := bc.sessionExpiry(); err != nil || d != 24*time.Hour {
+		t.Fatalf("bad session expiry %v %v", d, err)
+	}
+	if q, err := cfg.Subscription[`telemetry`].qos(); err != nil || q != defaultQoS {
+		t.Fatalf("bad default QoS %v %v", q, err)
+	} else if q, err = cfg.Subscription[`alarms`].qos(); err != nil || q != 2 {
+		t.Fatalf("bad QoS %v %v", q, err)
+	}
+	if uri, err := brokerURI(cfg.Broker[`mosquitto`].Broker_URL); err != nil || uri.Host != `127.0.0.1:1883` {
+		t.Fatalf("bad broker URI %v %v", uri, err)
+	}
+}
+
+func TestBadConfigs(t *testing.T) {
+	broker := `
+[Broker "b"]
+	Broker-URL="tcp://127.0.0.1:1883"
+`
+	sub := `
+[Subscription "s"]
+	Broker=b
+	Topic-Filter="a/#"
+	Tag-Name=foo
+`
+	bad := []string{
+		broker, //no subscriptions
+		sub,    //no brokers
+		broker + sub + `	QoS=3`,
+		broker + sub + `	Topic-Filter="a/#/b"`,
+		broker + sub + `	Topic-Filter="a/b+"`,
+		broker + sub + `	Topic-Filter="$share//a"`,
+		broker + sub + `	Attach-User-Properties=true`, //requires MQTT 5
+		broker + sub + `	Assume-Local-Timezone=true
+	Timezone-Override="America/Denver"`,
+		broker + `	Protocol-Version=6` + sub,
+		broker + `	Session-Expiry=1h` + sub, //requires MQTT 5
+		broker + `	Password=foo` + sub,
+		broker + `	Client-Cert=/tmp/cert.pem` + sub,
+		broker + `	Source-Override=foo` + sub,
+		`
+[Broker "b"]
+	Broker-URL="http://127.0.0.1"
+` + sub,
+		broker + sub + `
+[Subscription "s2"]
+	Broker=b
+	Topic-Filter="a/#"
+	Tag-Name=bar
+`,
+		broker + sub + `
+[Subscription "s2"]
+	Broker=nope
+	Topic-Filter="c"
+	Tag-Name=bar
+`,
+		broker + sub + `
+[Broker "unused"]
+	Broker-URL="tcp://127.0.0.1:1883"
+`,
+	}
+	for i, v := range bad {
+		if _, err := GetConfig(writeConfig(t, globalConfig+v), ``); err == nil {
+			t.Fatalf("bad config %d was accepted:\n%s", i, v)
+		}
+	}
+}
+
+func TestTopicMatch(t *testing.T) {
+	tests := []struct {
+		filter string
+		topic  string
+		match  bool
+	}{
+		{`a/b/c`, `a/b/c`, true},
+		{`a/b/c`, `a/b`, false},
+		{`a/+/c`, `a/b/c`, true},
+		{`a/+/c`, `a/b/d`, false},
+		{`a/+`, `a/b/c`, false},
+		{`a/#`, `a/b/c`, true},
+		{`a/#`, `a`, true},
+		{`#`, `a/b`, true},
+		{`#`, `$SYS/broker/uptime`, false},
+		{`+/broker/uptime`, `$SYS/broker/uptime`, false},
+		{`$SYS/#`, `$SYS/broker/uptime`, true},
+		{`+`, `/`, false},
+		{`+/+`, `/`, true},
+		{`$share/grp/a/+`, `a/b`, true},
+		{`$share/grp/a/+`, `grp/a/b`, false},
+	}
+	for _, tc := range tests {
+		if topicMatch(tc.filter, tc.topic) != tc.match {
+			t.Fatalf("%q against %q should be %v", tc.filter, tc.topic, tc.match)
+		}
+	}
+}
+
+type testWriter struct {
+	sync.Mutex
+	ents []*entry.Entry
+	err  error
+}
+
+func (tw *testWriter) WriteEntry(ent *entry.Entry) error {
+	tw.Lock()
+	defer tw.Unlock()
+	if tw.err != nil {
+		return tw.err
+	}
+	tw.ents = append(tw.ents, ent)
+	return nil
+}
+
+func (tw *testWriter) WriteEntryContext(ctx context.Context, ent *entry.Entry) error {
+	return tw.WriteEntry(ent)
+}
+
+func (tw *testWriter) WriteBatch(ents []*entry.Entry) error {
+	for _, ent := range ents {
+		if err := tw.WriteEntry(ent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tw *testWriter) WriteBatchContext(ctx context.Context, ents []*entry.Entry) error {
+	return tw.WriteBatch(ents)
+}
+
+func TestRouter(t *testing.T) {
+	sc := subscriptionConfig{}
+	tg, err := sc.timegrinder(config.CustomTimeFormat{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var first, second testWriter
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rtr := &router{
+		name: `test`,
+		ctx:  ctx,
+		lg:   log.NewDiscardLogger(),
+		subs: []*subscription{
+			{name: `a`, filters: []string{`plant/+/temp`}, tag: 1, tg: tg, attachTopic: true, attachProps: true, proc: processors.NewProcessorSet(&first)},
+			{name: `b`, filters: []string{`plant/#`}, tag: 2, proc: processors.NewProcessorSet(&second)},
+		},
+	}
+	props := []paho.UserProperty{{Key: `site`, Value: `denver`}, {Key: ``, Value: `skipped`}}
+	if err = rtr.handle(message{topic: `plant/1/temp`, payload: []byte(`2024-05-01T00:00:00Z 21.5`), props: props}); err != nil {
+		t.Fatal(err)
+	} else if err = rtr.handle(message{topic: `plant/1/pressure`, payload: []byte(`2024-05-01T00:00:00Z 1000`)}); err != nil {
+		t.Fatal(err)
+	} else if err = rtr.handle(message{topic: `other`, payload: []byte(`x`)}); err != nil {
+		t.Fatal(err)
+	}
+	if len(first.ents) != 1 || len(second.ents) != 1 {
+		t.Fatalf("bad routing %d %d", len(first.ents), len(second.ents))
+	}
+	ent := first.ents[0]
+	if ent.Tag != 1 || !ent.TS.StandardTime().Equal(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("bad entry %+v", ent)
+	} else if ev, ok := ent.GetEnumeratedValue(topicEVName); !ok || ev.(string) != `plant/1/temp` {
+		t.Fatalf("missing topic EV %v", ev)
+	} else if ev, ok = ent.GetEnumeratedValue(`site`); !ok || ev.(string) != `denver` {
+		t.Fatalf("missing user property EV %v", ev)
+	} else if ent.EVCount() != 2 {
+		t.Fatalf("bad EV count %d", ent.EVCount())
+	}
+	if ent = second.ents[0]; ent.Tag != 2 || ent.EVCount() != 0 || ent.TS.StandardTime().Year() == 2024 {
+		t.Fatalf("second subscription should not attach EVs or extract timestamps %+v", ent)
+	}
+	if count, _, unrouted := rtr.stats(); count != 2 || unrouted != 1 {
+		t.Fatalf("bad stats %d %d", count, unrouted)
+	}
+
+	//failures are acked unless we are shutting down
+	first.err = errors.New("nope")
+	err = rtr.handle(message{topic: `plant/2/temp`, payload: []byte(`x`)})
+	if err == nil {
+		t.Fatal("writer error was not returned")
+	} else if !rtr.done(`test`, `plant/2/temp`, err) {
+		t.Fatal("failed message was not acked while running")
+	}
+	cancel()
+	if rtr.done(`test`, `plant/2/temp`, err) {
+		t.Fatal("failed message was acked during shutdown")
+	} else if !rtr.done(`test`, `plant/2/temp`, nil) {
+		t.Fatal("handled message was not acked during shutdown")
+	}
+}
@@ -0,0 +1,206 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	// Embed tzdata so that we don't rely on potentially broken timezone DBs on the host
+	_ "time/tzdata"
+
+	"github.com/gravwell/gravwell/v3/debug"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+	"github.com/gravwell/gravwell/v3/ingesters/base"
+	"github.com/gravwell/gravwell/v3/ingesters/utils"
+)
+
+const (
+	defaultConfigLoc  = `/opt/gravwell/etc/mqtt.conf`
+	defaultConfigDLoc = `/opt/gravwell/etc/mqtt.conf.d`
+	ingesterName      = `MQTT`
+	appName           = `mqtt`
+
+	unroutedWarnInterval = time.Minute
+)
+
+var (
+	debugOn bool
+	lg      *log.Logger
+)
+
+func main() {
+	go debug.HandleDebugSignals(ingesterName)
+	var cfg *cfgType
+	ibc := base.IngesterBaseConfig{
+		IngesterName:                 ingesterName,
+		AppName:                      appName,
+		DefaultConfigLocation:        defaultConfigLoc,
+		DefaultConfigOverlayLocation: defaultConfigDLoc,
+		GetConfigFunc:                GetConfig,
+	}
+	ib, err := base.Init(ibc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to get configuration %v\n", err)
+		return
+	} else if err = ib.AssignConfig(&cfg); err != nil || cfg == nil {
+		fmt.Fprintf(os.Stderr, "failed to assign configuration %v %v\n", err, cfg == nil)
+		return
+	}
+	debugOn = ib.Verbose
+	lg = ib.Logger
+	id, ok := cfg.Global.IngesterUUID()
+	if !ok {
+		ib.Logger.FatalCode(0, "could not read ingester UUID")
+	}
+
+	igst, err := ib.GetMuxer()
+	if err != nil {
+		ib.Logger.FatalCode(0, "failed to get ingest connection", log.KVErr(err))
+		return
+	}
+	defer igst.Close()
+	ib.AnnounceStartup()
+
+	debugout("Started ingester muxer\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var procs []*processors.ProcessorSet
+	var clients []brokerClient
+	var routers []*router
+
+	brokers := make([]string, 0, len(cfg.Broker))
+	for k := range cfg.Broker {
+		brokers = append(brokers, k)
+	}
+	sort.Strings(brokers)
+
+	for _, bname := range brokers {
+		bc := cfg.Broker[bname]
+		rtr := &router{
+			name:      bname,
+			src:       bc.srcOverride(),
+			ctx:       ctx,
+			lg:        lg,
+			warnEvery: unroutedWarnInterval,
+		}
+		for _, sname := range cfg.subscriptions(bname) {
+			sc := cfg.Subscription[sname]
+			sub := &subscription{
+				name:        sname,
+				filters:     sc.Topic_Filter,
+				attachTopic: sc.Attach_Topic,
+				attachProps: sc.Attach_User_Properties,
+			}
+			if sub.qos, err = sc.qos(); err != nil {
+				lg.Fatal("invalid QoS", log.KV("subscription", sname), log.KVErr(err))
+			}
+			if sub.tag, err = igst.GetTag(sc.Tag_Name); err != nil {
+				lg.Fatal("failed to resolve tag", log.KV("subscription", sname), log.KV("tag", sc.Tag_Name), log.KVErr(err))
+			}
+			if sub.tg, err = sc.timegrinder(cfg.TimeFormat); err != nil {
+				lg.Fatal("failed to create timegrinder", log.KV("subscription", sname), log.KVErr(err))
+			}
+			if sub.proc, err = cfg.Preprocessor.ProcessorSet(igst, sc.Preprocessor); err != nil {
+				lg.Fatal("preprocessor construction error", log.KV("subscription", sname), log.KVErr(err))
+			}
+			procs = append(procs, sub.proc)
+			rtr.subs = append(rtr.subs, sub)
+		}
+
+		cc := clientConfig{
+			name:         bname,
+			clientID:     bc.clientID(bname, id.String()),
+			username:     bc.Username,
+			password:     bc.Password,
+			cleanSession: bc.Clean_Session,
+			rtr:          rtr,
+			lg:           lg,
+		}
+		if cc.uri, err = brokerURI(bc.Broker_URL); err != nil {
+			lg.Fatal("invalid Broker-URL", log.KV("broker", bname), log.KVErr(err))
+		}
+		if bc.protocol() == protocolV5 {
+			if cc.sessionExpiry, err = bc.sessionExpiry(); err != nil {
+				lg.Fatal("invalid Session-Expiry", log.KV("broker", bname), log.KVErr(err))
+			}
+		}
+		cl, err := newBrokerClient(bc, cc)
+		if err != nil {
+			lg.Fatal("failed to create broker client", log.KV("broker", bname), log.KVErr(err))
+		}
+		if err = cl.Start(); err != nil {
+			lg.Fatal("failed to start broker client", log.KV("broker", bname), log.KVErr(err))
+		}
+		lg.Info("started broker client", log.KV("broker", bname), log.KV("url", cc.uri.Redacted()),
+			log.KV("protocol", bc.protocol()), log.KV("clientid", cc.clientID), log.KV("subscriptions", len(rtr.subs)))
+		clients = append(clients, cl)
+		routers = append(routers, rtr)
+	}
+
+	//fire off a verbose ticker for debugging and stats output
+	if debugOn {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Second):
+				}
+				for _, r := range routers {
+					count, size, unrouted := r.stats()
+					debugout("%s: %d entries %d bytes %d unrouted\n", r.name, count, size, unrouted)
+				}
+			}
+		}()
+	}
+
+	//listen for signals so we can close gracefully
+	utils.WaitForQuit()
+	ib.AnnounceShutdown()
+
+	//cancel first so that anything blocked on the muxer bails out without being acknowledged
+	cancel()
+	for i, cl := range clients {
+		if err := cl.Close(); err != nil {
+			lg.Error("failed to close broker client", log.KV("broker", brokers[i]), log.KVErr(err))
+		}
+	}
+
+	//close down all the preprocessors
+	for _, v := range procs {
+		if v != nil {
+			if err := v.Close(); err != nil {
+				lg.Error("failed to close processors", log.KVErr(err))
+			}
+		}
+	}
+
+	for _, r := range routers {
+		count, size, unrouted := r.stats()
+		lg.Info("broker stats", log.KV("broker", r.name), log.KV("count", count), log.KV("size", size), log.KV("unrouted", unrouted))
+	}
+	if err := igst.Sync(time.Second); err != nil {
+		lg.Error("failed to sync", log.KVErr(err))
+	}
+	if err := igst.Close(); err != nil {
+		lg.Error("failed to close", log.KVErr(err))
+	}
+}
+
+func debugout(format string, args ...interface{}) {
+	if debugOn {
+		fmt.Printf(format, args...)
+	}
+}
@@ -0,0 +1,491 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+
+	"github.com/gravwell/jsonparser"
+)
+
+const (
+	lumberjackV1 byte = '1'
+	lumberjackV2 byte = '2'
+
+	lumberjackWindow     byte = 'W'
+	lumberjackCompressed byte = 'C'
+	lumberjackJSON       byte = 'J'
+	lumberjackData       byte = 'D'
+	lumberjackAck        byte = 'A'
+
+	beatsTimestampField = `@timestamp`
+	beatsMaxCompression = 2 //compressed frames should not nest, but allow a little slack
+)
+
+var (
+	ErrBeatsVersion     = errors.New("unsupported lumberjack protocol version")
+	ErrBeatsFrameType   = errors.New("unknown lumberjack frame type")
+	ErrBeatsOversized   = errors.New("lumberjack frame exceeds maximum size")
+	ErrBeatsNesting     = errors.New("lumberjack compressed frames nested too deeply")
+	ErrBeatsInvalidJSON = errors.New("lumberjack event is not a JSON object")
+)
+
+type beatsRule struct {
+	beatsTagRule
+	tg entry.EntryTag
+}
+
+type beatsHandlerConfig struct {
+	name             string
+	defTag           entry.EntryTag
+	rules            []beatsRule
+	ignoreTimestamps bool
+	src              net.IP
+	wg               *sync.WaitGroup
+	proc             *processors.ProcessorSet
+	ctx              context.Context
+	maxFrameSize     int
+}
+
+func startBeatsListeners(cfg *cfgType, igst *ingest.IngestMuxer, wg *sync.WaitGroup, f *flusher, ctx context.Context) error {
+	var err error
+	//short circuit out on empty
+	if len(cfg.BeatsListener) == 0 {
+		return nil
+	}
+
+	for k, v := range cfg.BeatsListener {
+		if err := v.Validate(); err != nil {
+			return fmt.Errorf("BeatsListener %s configuration is invalid: %w", k, err)
+		}
+		bhc := beatsHandlerConfig{
+			name:             k,
+			wg:               wg,
+			ignoreTimestamps: v.Ignore_Timestamps,
+			ctx:              ctx,
+			maxFrameSize:     int(v.Max_Frame_Size),
+		}
+		if bhc.proc, err = cfg.Preprocessor.ProcessorSet(igst, v.Preprocessor); err != nil {
+			lg.Fatal("preprocessor error", log.KVErr(err))
+		}
+		f.Add(bhc.proc)
+		if v.Source_Override != `` {
+			bhc.src = net.ParseIP(v.Source_Override)
+			if bhc.src == nil {
+				return fmt.Errorf("BeatsListener %v invalid source override \"%s\"", k, v.Source_Override)
+			}
+		} else if cfg.Source_Override != `` {
+			// global override
+			bhc.src = net.ParseIP(cfg.Source_Override)
+			if bhc.src == nil {
+				return fmt.Errorf("global source override \"%s\" is invalid", cfg.Source_Override)
+			}
+		}
+		//resolve the default tag
+		if bhc.defTag, err = igst.GetTag(v.Default_Tag); err != nil {
+			return err
+		}
+
+		//resolve the tag routing rules
+		rules, err := v.TagRules()
+		if err != nil {
+			return err
+		}
+		for _, r := range rules {
+			tg, err := igst.GetTag(r.tag)
+			if err != nil {
+				return err
+			}
+			bhc.rules = append(bhc.rules, beatsRule{beatsTagRule: r, tg: tg})
+		}
+
+		tp, str, err := translateBindType(v.Bind_String)
+		if err != nil {
+			lg.FatalCode(0, "invalid bind", log.KV("bindstring", v.Bind_String), log.KVErr(err))
+		}
+		addr, err := net.ResolveTCPAddr("tcp", str)
+		if err != nil {
+			return fmt.Errorf("%s Bind-String \"%s\" is invalid: %v\n", k, v.Bind_String, err)
+		}
+		var l net.Listener
+		if tp.TLS() {
+			config := &tls.Config{
+				MinVersion: tls.VersionTLS12,
+			}
+			config.Certificates = make([]tls.Certificate, 1)
+			config.Certificates[0], err = tls.LoadX509KeyPair(v.Cert_File, v.Key_File)
+			if err != nil {
+				lg.Fatal("failed to load certificate", log.KV("certfile", v.Cert_File), log.KV("keyfile", v.Key_File), log.KVErr(err))
+			}
+			if v.Client_CA_File != `` {
+				if config.ClientCAs, err = loadCertPool(v.Client_CA_File); err != nil {
+					lg.Fatal("failed to load client CA", log.KV("cafile", v.Client_CA_File), log.KVErr(err))
+				}
+				if v.Require_Client_Cert {
+					config.ClientAuth = tls.RequireAndVerifyClientCert
+				} else {
+					config.ClientAuth = tls.VerifyClientCertIfGiven
+				}
+			}
+			if l, err = v.listen("tcp", addr, config); err != nil {
+				lg.FatalCode(0, "failed to listen via TLS", log.KV("address", addr), log.KV("beatslistener", k), log.KVErr(err))
+			}
+		} else if l, err = v.listen("tcp", addr, nil); err != nil {
+			return fmt.Errorf("%s Failed to listen on \"%s\": %v\n", k, addr, err)
+		}
+		connID := addConn(l)
+		//start the acceptor
+		wg.Add(1)
+		go beatsAcceptor(l, connID, bhc, tp)
+	}
+	debugout("Started %d beats listeners\n", len(cfg.BeatsListener))
+	return nil
+}
+
+func loadCertPool(p string) (*x509.CertPool, error) {
+	bts, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bts) {
+		return nil, fmt.Errorf("no certificates found in %s", p)
+	}
+	return pool, nil
+}
+
+func beatsAcceptor(lst net.Listener, id int, cfg beatsHandlerConfig, tp bindType) {
+	defer cfg.wg.Done()
+	defer delConn(id)
+	defer lst.Close()
+	var failCount int
+	for {
+		conn, err := lst.Accept()
+		if err != nil {
+			if strings.Contains(err.Error(), "closed") {
+				break
+			}
+			failCount++
+			fmt.Fprintf(os.Stderr, "Failed to accept %v connection: %v\n", tp.String(), err)
+			if failCount > 3 {
+				break
+			}
+			continue
+		}
+		failCount = 0
+		go func(conn net.Conn) {
+			logAccepted(conn, `beats`, tp, cfg.name)
+			beatsConnHandler(conn, cfg)
+		}(conn)
+	}
+}
+
+func beatsConnHandler(c net.Conn, cfg beatsHandlerConfig) {
+	cfg.wg.Add(1)
+	id := addConn(c)
+	defer cfg.wg.Done()
+	defer delConn(id)
+	defer c.Close()
+	var rip net.IP
+
+	if ipstr, _, err := net.SplitHostPort(c.RemoteAddr().String()); err != nil {
+		lg.Error("failed to get host from remote addr", log.KV("remoteaddress", c.RemoteAddr().String()), log.KVErr(err))
+		return
+	} else if rip = net.ParseIP(ipstr); rip == nil {
+		lg.Error("failed to get remote address", log.KV("remoteaddress", ipstr))
+		return
+	}
+	ll := log.NewLoggerWithKV(lg, log.KV("beats-listener", cfg.name), log.KV("remoteaddress", rip))
+	if cfg.src != nil {
+		rip = cfg.src
+	}
+	bc := newBeatsConn(c, &cfg, rip, func(ents []*entry.Entry) error {
+		return cfg.proc.ProcessBatchContext(ents, cfg.ctx)
+	})
+	bc.ll = ll
+	if err := bc.run(); err != nil {
+		ll.Error("lumberjack handler error", log.KVErr(err))
+	}
+}
+
+// beatsConn handles a single lumberjack connection, events are batched up to the client
+// window size and acknowledged once they have been handed to the ingest muxer.
+type beatsConn struct {
+	cfg     *beatsHandlerConfig
+	r       *bufio.Reader
+	w       io.Writer
+	rip     net.IP
+	emit    func([]*entry.Entry) error
+	ll      *log.KVLogger
+	window  uint32
+	lastSeq uint32
+	pending []*entry.Entry
+}
+
+func newBeatsConn(rw io.ReadWriter, cfg *beatsHandlerConfig, rip net.IP, emit func([]*entry.Entry) error) *beatsConn {
+	return &beatsConn{
+		cfg:  cfg,
+		r:    bufio.NewReader(rw),
+		w:    rw,
+		rip:  rip,
+		emit: emit,
+	}
+}
+
+func (bc *beatsConn) run() error {
+	for {
+		if err := bc.readFrame(bc.r, 0); err != nil {
+			if errors.Is(err, io.EOF) {
+				//the client hung up so there is nobody to acknowledge, just keep what we have
+				return bc.emitPending()
+			}
+			return err
+		}
+		//if the client has nothing else queued up acknowledge what we have so far
+		if bc.r.Buffered() == 0 {
+			if err := bc.flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readFrame consumes a single frame, compressed frames are expanded and every frame they contain is handled
+func (bc *beatsConn) readFrame(r io.Reader, depth int) (err error) {
+	var hdr [2]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		return
+	}
+	if hdr[0] != lumberjackV2 && hdr[0] != lumberjackV1 {
+		return fmt.Errorf("%w %q", ErrBeatsVersion, hdr[0])
+	}
+	switch hdr[1] {
+	case lumberjackWindow:
+		var w uint32
+		if w, err = readUint32(r); err != nil {
+			return
+		}
+		//a new window means a new batch, anything outstanding should already be acknowledged
+		if err = bc.flush(); err == nil {
+			bc.window = w
+		}
+	case lumberjackCompressed:
+		err = bc.readCompressed(r, depth)
+	case lumberjackJSON:
+		err = bc.readJSON(r)
+	case lumberjackData:
+		err = bc.readData(r)
+	default:
+		err = fmt.Errorf("%w %q", ErrBeatsFrameType, hdr[1])
+	}
+	return
+}
+
+func (bc *beatsConn) readCompressed(r io.Reader, depth int) (err error) {
+	var l uint32
+	var zr io.ReadCloser
+	if depth >= beatsMaxCompression {
+		return ErrBeatsNesting
+	} else if l, err = readUint32(r); err != nil {
+		return
+	} else if int64(l) > int64(bc.cfg.maxFrameSize) {
+		return ErrBeatsOversized
+	}
+	payload := make([]byte, l)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return
+	}
+	if zr, err = zlib.NewReader(bytes.NewReader(payload)); err != nil {
+		return
+	}
+	defer zr.Close()
+	lr := &io.LimitedReader{R: zr, N: int64(bc.cfg.maxFrameSize) * 4}
+	br := bufio.NewReader(lr)
+	for {
+		if _, err = br.Peek(1); err == io.EOF {
+			return limitExceeded(lr, nil)
+		} else if err != nil {
+			return
+		}
+		if err = bc.readFrame(br, depth+1); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = limitExceeded(lr, io.ErrUnexpectedEOF)
+			}
+			return
+		}
+	}
+}
+
+// limitExceeded returns ErrBeatsOversized if the limited reader stopped short of the end of
+// the decompressed payload, otherwise the provided error is returned
+func limitExceeded(lr *io.LimitedReader, err error) error {
+	if lr.N > 0 {
+		return err
+	}
+	var b [1]byte
+	if n, _ := io.ReadFull(lr.R, b[:]); n > 0 {
+		return ErrBeatsOversized
+	}
+	return err
+}
+
+func (bc *beatsConn) readJSON(r io.Reader) (err error) {
+	var seq, l uint32
+	if seq, err = readUint32(r); err != nil {
+		return
+	} else if l, err = readUint32(r); err != nil {
+		return
+	} else if int64(l) > int64(bc.cfg.maxFrameSize) {
+		return ErrBeatsOversized
+	}
+	data := make([]byte, l)
+	if _, err = io.ReadFull(r, data); err != nil {
+		return
+	}
+	return bc.addEvent(seq, data)
+}
+
+// readData handles the original key/value data frames, which are converted to a flat JSON object
+func (bc *beatsConn) readData(r io.Reader) (err error) {
+	var seq, pairs uint32
+	if seq, err = readUint32(r); err != nil {
+		return
+	} else if pairs, err = readUint32(r); err != nil {
+		return
+	}
+	bb := bytes.NewBuffer(nil)
+	bb.WriteByte('{')
+	for i := uint32(0); i < pairs; i++ {
+		var k, v []byte
+		if k, err = bc.readBlob(r); err != nil {
+			return
+		} else if v, err = bc.readBlob(r); err != nil {
+			return
+		}
+		if i > 0 {
+			bb.WriteByte(',')
+		}
+		writeJSONString(bb, string(k))
+		bb.WriteByte(':')
+		writeJSONString(bb, string(v))
+		if bb.Len() > bc.cfg.maxFrameSize {
+			return ErrBeatsOversized
+		}
+	}
+	bb.WriteByte('}')
+	return bc.addEvent(seq, bb.Bytes())
+}
+
+func (bc *beatsConn) readBlob(r io.Reader) (b []byte, err error) {
+	var l uint32
+	if l, err = readUint32(r); err != nil {
+		return
+	} else if int64(l) > int64(bc.cfg.maxFrameSize) {
+		err = ErrBeatsOversized
+		return
+	}
+	b = make([]byte, l)
+	_, err = io.ReadFull(r, b)
+	return
+}
+
+func (bc *beatsConn) addEvent(seq uint32, data []byte) error {
+	if ent, err := bc.cfg.buildEntry(data, bc.rip); err != nil {
+		//a bad event should not wedge the client, drop it and keep the sequence moving
+		if bc.ll != nil {
+			bc.ll.Warn("dropping invalid beats event", log.KV("sequence", seq), log.KVErr(err))
+		}
+	} else {
+		bc.pending = append(bc.pending, ent)
+	}
+	bc.lastSeq = seq
+	if bc.window > 0 && uint32(len(bc.pending)) >= bc.window {
+		return bc.flush()
+	}
+	return nil
+}
+
+func (bc *beatsConn) emitPending() (err error) {
+	if len(bc.pending) > 0 {
+		if err = bc.emit(bc.pending); err == nil {
+			bc.pending = nil
+		}
+	}
+	return
+}
+
+// flush hands pending entries off and acknowledges the last sequence number seen
+func (bc *beatsConn) flush() error {
+	if err := bc.emitPending(); err != nil {
+		return err
+	} else if bc.lastSeq == 0 {
+		return nil
+	}
+	ack := [6]byte{lumberjackV2, lumberjackAck}
+	binary.BigEndian.PutUint32(ack[2:], bc.lastSeq)
+	bc.lastSeq = 0
+	_, err := bc.w.Write(ack[:])
+	return err
+}
+
+// buildEntry turns a single beats event into an entry
+func (cfg *beatsHandlerConfig) buildEntry(data []byte, rip net.IP) (*entry.Entry, error) {
+	if data = bytes.TrimSpace(data); len(data) == 0 || data[0] != '{' {
+		return nil, ErrBeatsInvalidJSON
+	}
+	ent := &entry.Entry{
+		SRC:  rip,
+		Tag:  cfg.defTag,
+		Data: data,
+	}
+	if !cfg.ignoreTimestamps {
+		if s, err := jsonparser.GetString(data, beatsTimestampField); err == nil {
+			if ts, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				ent.TS = entry.FromStandard(ts)
+			}
+		}
+	}
+	if ent.TS.IsZero() {
+		ent.TS = entry.Now()
+	}
+	for _, r := range cfg.rules {
+		if v, vt, _, err := jsonparser.Get(data, r.field...); err == nil && r.value.Match(gelfFieldString(v, vt)) {
+			ent.Tag = r.tg
+			break
+		}
+	}
+	return ent, nil
+}
+
+func readUint32(r io.Reader) (v uint32, err error) {
+	var buff [4]byte
+	if _, err = io.ReadFull(r, buff[:]); err == nil {
+		v = binary.BigEndian.Uint32(buff[:])
+	}
+	return
+}
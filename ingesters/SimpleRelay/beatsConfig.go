@@ -0,0 +1,124 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gobwas/glob"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	defaultBeatsMaxFrameSize uint = 16 * 1024 * 1024
+)
+
+var (
+	ErrBeatsUDP           = errors.New("Beats listeners do not support UDP")
+	ErrBeatsClientCANoTLS = errors.New("Client-CA-File requires a TLS Bind-String")
+)
+
+type beatsListener struct {
+	baseConfig
+	Default_Tag         string
+	Tag_Match           []string // field=value:tag rules, the first matching rule wins
+	Max_Frame_Size      uint     // largest event or compressed frame, defaults to 16MB
+	Client_CA_File      string   // CA bundle used to verify client certificates
+	Require_Client_Cert bool     // reject TLS clients that do not present a valid certificate
+}
+
+func (bl *beatsListener) Validate() error {
+	if err := bl.baseConfig.Validate(); err != nil {
+		return err
+	}
+	tp, _, err := translateBindType(bl.Bind_String)
+	if err != nil {
+		return err
+	} else if tp.UDP() {
+		return ErrBeatsUDP
+	} else if !tp.TLS() && (bl.Client_CA_File != `` || bl.Require_Client_Cert) {
+		return ErrBeatsClientCANoTLS
+	} else if bl.Require_Client_Cert && bl.Client_CA_File == `` {
+		return errors.New("Require-Client-Cert requires a Client-CA-File")
+	}
+	if bl.Timestamp_Format_Override != `` {
+		return errors.New("Timestamp-Format-Override is not supported, Beats events carry an @timestamp field")
+	}
+	if strings.TrimSpace(bl.Default_Tag) == `` {
+		if v := strings.TrimSpace(bl.Tag_Name); v != `` {
+			bl.Default_Tag = v
+		} else {
+			bl.Default_Tag = entry.DefaultTagName
+		}
+	}
+	if _, err := (jsonListener{Default_Tag: bl.Default_Tag}).defaultTag(); err != nil {
+		return err
+	}
+	if _, err := bl.TagRules(); err != nil {
+		return err
+	}
+	if bl.Max_Frame_Size == 0 {
+		bl.Max_Frame_Size = defaultBeatsMaxFrameSize
+	}
+	return nil
+}
+
+// beatsTagRule routes events whose field matches a value pattern to a tag
+type beatsTagRule struct {
+	field []string // path to the field, e.g. agent.type or fields.logtype
+	value glob.Glob
+	tag   string
+}
+
+// TagRules parses the Tag-Match directives which take the form field=value:tag, the field is a
+// dotted path into the event such as agent.type, event.dataset, or fields.logtype and the value may
+// contain glob wildcards.
+func (bl beatsListener) TagRules() (rules []beatsTagRule, err error) {
+	for _, v := range bl.Tag_Match {
+		var r beatsTagRule
+		var match string
+		if match, r.tag, err = extractElementTag(v); err != nil {
+			return
+		}
+		fld, val, ok := strings.Cut(match, `=`)
+		if fld = strings.TrimSpace(fld); !ok || fld == `` {
+			err = fmt.Errorf("Invalid Tag-Match %q, expected field=value:tag", v)
+			return
+		}
+		if r.field, err = getJsonFields(fld); err != nil {
+			return
+		}
+		if r.value, err = glob.Compile(strings.TrimSpace(val)); err != nil {
+			err = fmt.Errorf("Invalid Tag-Match value %q %w", val, err)
+			return
+		}
+		rules = append(rules, r)
+	}
+	return
+}
+
+func (bl beatsListener) Tags() (tags []string, err error) {
+	var rules []beatsTagRule
+	if rules, err = bl.TagRules(); err != nil {
+		return
+	}
+	tags = []string{bl.Default_Tag}
+	mp := map[string]bool{
+		bl.Default_Tag: true,
+	}
+	for _, r := range rules {
+		if !mp[r.tag] {
+			mp[r.tag] = true
+			tags = append(tags, r.tag)
+		}
+	}
+	return
+}
@@ -0,0 +1,215 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+var testBeatsEvents = []string{
+	`{"@timestamp":"2024-06-01T12:00:00.123Z","agent":{"type":"winlogbeat"},"message":"a"}`,
+	`{"@timestamp":"2024-06-01T12:00:01.123Z","event":{"dataset":"nginx.access"},"message":"b"}`,
+	`{"@timestamp":"2024-06-01T12:00:02.123Z","fields":{"logtype":"firewall"},"message":"c"}`,
+	`{"@timestamp":"2024-06-01T12:00:03.123Z","message":"d"}`,
+}
+
+func beatsFrame(tp byte, vals ...interface{}) []byte {
+	bb := bytes.NewBuffer([]byte{lumberjackV2, tp})
+	for _, v := range vals {
+		switch x := v.(type) {
+		case int:
+			binary.Write(bb, binary.BigEndian, uint32(x))
+		case []byte:
+			bb.Write(x)
+		case string:
+			bb.WriteString(x)
+		}
+	}
+	return bb.Bytes()
+}
+
+func beatsCompressed(frames ...[]byte) []byte {
+	bb := bytes.NewBuffer(nil)
+	zw := zlib.NewWriter(bb)
+	for _, f := range frames {
+		zw.Write(f)
+	}
+	zw.Close()
+	return beatsFrame(lumberjackCompressed, bb.Len(), bb.Bytes())
+}
+
+func newTestBeatsConfig(t *testing.T) *beatsHandlerConfig {
+	bl := beatsListener{
+		baseConfig: baseConfig{Bind_String: `:5044`},
+		Tag_Match: []string{
+			`agent.type=winlogbeat:windows`,
+			`event.dataset=nginx.*:web`,
+			`fields.logtype=firewall:fw`,
+		},
+	}
+	if err := bl.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := bl.TagRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &beatsHandlerConfig{maxFrameSize: int(bl.Max_Frame_Size)}
+	for i, r := range rules {
+		cfg.rules = append(cfg.rules, beatsRule{beatsTagRule: r, tg: entry.EntryTag(i + 1)})
+	}
+	return cfg
+}
+
+func readBeatsAck(t *testing.T, r io.Reader) uint32 {
+	t.Helper()
+	var ack [6]byte
+	if _, err := io.ReadFull(r, ack[:]); err != nil {
+		t.Fatal(err)
+	} else if ack[0] != lumberjackV2 || ack[1] != lumberjackAck {
+		t.Fatalf("bad ack header %q", ack[:2])
+	}
+	return binary.BigEndian.Uint32(ack[2:])
+}
+
+func TestBeatsProtocol(t *testing.T) {
+	cfg := newTestBeatsConfig(t)
+	srv, cli := net.Pipe()
+	defer cli.Close()
+	ch := make(chan []*entry.Entry, 16)
+	errch := make(chan error, 1)
+	bc := newBeatsConn(srv, cfg, net.ParseIP(`10.0.0.1`), func(ents []*entry.Entry) error {
+		ch <- ents
+		return nil
+	})
+	go func() {
+		errch <- bc.run()
+		srv.Close()
+	}()
+
+	//a compressed batch of JSON frames
+	var frames [][]byte
+	for i, ev := range testBeatsEvents {
+		frames = append(frames, beatsFrame(lumberjackJSON, i+1, len(ev), ev))
+	}
+	msg := append(beatsFrame(lumberjackWindow, len(frames)), beatsCompressed(frames...)...)
+	if _, err := cli.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	if seq := readBeatsAck(t, cli); seq != uint32(len(testBeatsEvents)) {
+		t.Fatalf("bad ack sequence %d", seq)
+	}
+	ents := <-ch
+	if len(ents) != len(testBeatsEvents) {
+		t.Fatalf("bad entry count %d", len(ents))
+	}
+	for i, ent := range ents {
+		exp := time.Date(2024, 6, 1, 12, 0, i, 123000000, time.UTC)
+		if ent.Tag != entry.EntryTag((i+1)%4) {
+			t.Fatalf("bad tag on %d: %d", i, ent.Tag)
+		} else if !ent.TS.StandardTime().Equal(exp) {
+			t.Fatalf("bad timestamp on %d: %v", i, ent.TS.StandardTime())
+		} else if string(ent.Data) != testBeatsEvents[i] {
+			t.Fatalf("bad data on %d: %s", i, ent.Data)
+		}
+	}
+
+	//an uncompressed key/value data frame, the window is larger than what is sent so we get a partial ack
+	msg = append(beatsFrame(lumberjackWindow, 10), beatsFrame(lumberjackData, 1, 2, 4, `line`, 5, `hello`, 4, `host`, 2, `h1`)...)
+	if _, err := cli.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	if seq := readBeatsAck(t, cli); seq != 1 {
+		t.Fatalf("bad partial ack sequence %d", seq)
+	}
+	if ents = <-ch; len(ents) != 1 || string(ents[0].Data) != `{"line":"hello","host":"h1"}` {
+		t.Fatalf("bad data frame entry %v", ents)
+	}
+
+	//garbage
+	if _, err := cli.Write([]byte{'3', 'W', 0, 0, 0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errch; err == nil {
+		t.Fatal("failed to catch bad protocol version")
+	}
+}
+
+func TestBeatsCompressedLimit(t *testing.T) {
+	cfg := newTestBeatsConfig(t)
+	cfg.maxFrameSize = 128 //decompressed payloads are capped at 512 bytes
+	srv, cli := net.Pipe()
+	defer cli.Close()
+	defer srv.Close()
+	frames := func(cnt, sz int) (r [][]byte) {
+		ev := `{"m":"` + strings.Repeat(`x`, sz-8) + `"}`
+		for i := 0; i < cnt; i++ {
+			r = append(r, beatsFrame(lumberjackJSON, i+1, len(ev), ev))
+		}
+		return
+	}
+	for _, tc := range []struct {
+		frames [][]byte
+		err    error
+	}{
+		{frames(8, 54), nil},               // exactly at the limit
+		{frames(9, 54), ErrBeatsOversized}, // the limit lands on a frame boundary
+		{frames(9, 50), ErrBeatsOversized}, // the limit lands inside a frame
+		{frames(2, 54), nil},               // well under the limit
+	} {
+		bc := newBeatsConn(srv, cfg, net.ParseIP(`10.0.0.1`), func(ents []*entry.Entry) error { return nil })
+		msg := beatsCompressed(tc.frames...)
+		if err := bc.readFrame(bytes.NewReader(msg), 0); err != tc.err {
+			t.Fatalf("%d frames: bad error %v != %v", len(tc.frames), err, tc.err)
+		} else if err == nil && len(bc.pending) != len(tc.frames) {
+			t.Fatalf("%d frames: bad event count %d", len(tc.frames), len(bc.pending))
+		}
+	}
+}
+
+func TestBeatsConfig(t *testing.T) {
+	bl := beatsListener{
+		baseConfig:     baseConfig{Bind_String: `tls://0.0.0.0:5044`, Tag_Name: `beats`},
+		Tag_Match:      []string{`agent.type=filebeat:files`, `agent.type=winlogbeat:windows`, `fields.env=prod*:files`},
+		Client_CA_File: `/tmp/ca.pem`,
+	}
+	if err := bl.Validate(); err != nil {
+		t.Fatal(err)
+	} else if bl.Default_Tag != `beats` || bl.Max_Frame_Size != defaultBeatsMaxFrameSize {
+		t.Fatalf("bad defaults %+v", bl)
+	}
+	if tags, err := bl.Tags(); err != nil {
+		t.Fatal(err)
+	} else if len(tags) != 3 {
+		t.Fatalf("bad tags %v", tags)
+	}
+	bad := []beatsListener{
+		{},
+		{baseConfig: baseConfig{Bind_String: `udp://:5044`}},
+		{baseConfig: baseConfig{Bind_String: `:5044`}, Client_CA_File: `/tmp/ca.pem`},
+		{baseConfig: baseConfig{Bind_String: `tls://:5044`}, Require_Client_Cert: true},
+		{baseConfig: baseConfig{Bind_String: `:5044`}, Tag_Match: []string{`agent.type:tag`}},
+		{baseConfig: baseConfig{Bind_String: `:5044`}, Tag_Match: []string{`agent.type=[:tag`}},
+		{baseConfig: baseConfig{Bind_String: `:5044`}, Tag_Match: []string{`agent.type=x:bad tag`}},
+	}
+	for i, b := range bad {
+		if err := b.Validate(); err == nil {
+			t.Fatalf("failed to catch bad config %d", i)
+		}
+	}
+}
@@ -9,8 +9,10 @@
 package main
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
 	"sort"
 	"strings"
 	"time"
@@ -20,6 +22,7 @@ import (
 	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 	"github.com/gravwell/gravwell/v3/ingest/processors"
+	"github.com/gravwell/gravwell/v3/ingesters/utils"
 )
 
 const (
@@ -33,6 +36,7 @@ const (
 	lineReader    readerType = iota
 	rfc5424Reader readerType = iota
 	rfc6587Reader readerType = iota
+	relpReader    readerType = iota
 )
 
 var ()
@@ -57,27 +61,35 @@ type baseConfig struct {
 	Timestamp_Format_Override string //override the timestamp format
 	Cert_File                 string
 	Key_File                  string
+	Proxy_Protocol            bool     //decode PROXY protocol v1/v2 headers from trusted upstreams
+	Proxy_Trusted_Upstream    []string //CIDRs or addresses of load balancers allowed to send PROXY headers
 	Preprocessor              []string
 }
 
 type cfgReadType struct {
-	Global        config.IngestConfig
-	Attach        attach.AttachConfig
-	Listener      map[string]*listener
-	JSONListener  map[string]*jsonListener
-	RegexListener map[string]*regexListener
-	Preprocessor  processors.ProcessorConfig
-	TimeFormat    config.CustomTimeFormat
+	Global         config.IngestConfig
+	Attach         attach.AttachConfig
+	Listener       map[string]*listener
+	JSONListener   map[string]*jsonListener
+	RegexListener  map[string]*regexListener
+	GELFListener   map[string]*gelfListener
+	FluentListener map[string]*fluentListener
+	BeatsListener  map[string]*beatsListener
+	Preprocessor   processors.ProcessorConfig
+	TimeFormat     config.CustomTimeFormat
 }
 
 type cfgType struct {
 	config.IngestConfig
-	Attach        attach.AttachConfig
-	Listener      map[string]*listener
-	JSONListener  map[string]*jsonListener
-	RegexListener map[string]*regexListener
-	Preprocessor  processors.ProcessorConfig
-	TimeFormat    config.CustomTimeFormat
+	Attach         attach.AttachConfig
+	Listener       map[string]*listener
+	JSONListener   map[string]*jsonListener
+	RegexListener  map[string]*regexListener
+	GELFListener   map[string]*gelfListener
+	FluentListener map[string]*fluentListener
+	BeatsListener  map[string]*beatsListener
+	Preprocessor   processors.ProcessorConfig
+	TimeFormat     config.CustomTimeFormat
 }
 
 func GetConfig(path, overlayPath string) (*cfgType, error) {
@@ -89,13 +101,16 @@ func GetConfig(path, overlayPath string) (*cfgType, error) {
 		return nil, err
 	}
 	c := &cfgType{
-		IngestConfig:  cr.Global,
-		Attach:        cr.Attach,
-		Listener:      cr.Listener,
-		RegexListener: cr.RegexListener,
-		JSONListener:  cr.JSONListener,
-		Preprocessor:  cr.Preprocessor,
-		TimeFormat:    cr.TimeFormat,
+		IngestConfig:   cr.Global,
+		Attach:         cr.Attach,
+		Listener:       cr.Listener,
+		RegexListener:  cr.RegexListener,
+		JSONListener:   cr.JSONListener,
+		GELFListener:   cr.GELFListener,
+		FluentListener: cr.FluentListener,
+		BeatsListener:  cr.BeatsListener,
+		Preprocessor:   cr.Preprocessor,
+		TimeFormat:     cr.TimeFormat,
 	}
 
 	if err := c.Verify(); err != nil {
@@ -111,7 +126,7 @@ func (c *cfgType) Verify() error {
 	} else if err = c.Attach.Verify(); err != nil {
 		return err
 	}
-	if len(c.Listener) == 0 && len(c.RegexListener) == 0 && len(c.JSONListener) == 0 {
+	if len(c.Listener) == 0 && len(c.RegexListener) == 0 && len(c.JSONListener) == 0 && len(c.GELFListener) == 0 && len(c.FluentListener) == 0 && len(c.BeatsListener) == 0 {
 		return errors.New("No listeners specified")
 	}
 	if err := c.Preprocessor.Validate(); err != nil {
@@ -216,6 +231,42 @@ func (c *cfgType) Verify() error {
 	if err := checkJsonConfigs(c.JSONListener); err != nil {
 		return err
 	}
+	for k, v := range c.GELFListener {
+		if err := v.Validate(); err != nil {
+			return fmt.Errorf("GELFListener %s configuration error: %v", k, err)
+		}
+		if n, ok := bindMp[v.Bind_String]; ok {
+			return errors.New("Bind-String for " + k + " already in use by " + n)
+		}
+		bindMp[v.Bind_String] = k
+		if err := c.Preprocessor.CheckProcessors(v.Preprocessor); err != nil {
+			return fmt.Errorf("GELFListener %s preprocessor invalid: %v", k, err)
+		}
+	}
+	for k, v := range c.FluentListener {
+		if err := v.Validate(); err != nil {
+			return fmt.Errorf("FluentListener %s configuration error: %v", k, err)
+		}
+		if n, ok := bindMp[v.Bind_String]; ok {
+			return errors.New("Bind-String for " + k + " already in use by " + n)
+		}
+		bindMp[v.Bind_String] = k
+		if err := c.Preprocessor.CheckProcessors(v.Preprocessor); err != nil {
+			return fmt.Errorf("FluentListener %s preprocessor invalid: %v", k, err)
+		}
+	}
+	for k, v := range c.BeatsListener {
+		if err := v.Validate(); err != nil {
+			return fmt.Errorf("BeatsListener %s configuration error: %v", k, err)
+		}
+		if n, ok := bindMp[v.Bind_String]; ok {
+			return errors.New("Bind-String for " + k + " already in use by " + n)
+		}
+		bindMp[v.Bind_String] = k
+		if err := c.Preprocessor.CheckProcessors(v.Preprocessor); err != nil {
+			return fmt.Errorf("BeatsListener %s preprocessor invalid: %v", k, err)
+		}
+	}
 	return nil
 }
 
@@ -257,6 +308,48 @@ func (c *cfgType) Tags() ([]string, error) {
 		}
 	}
 
+	//iterate over GELF listeners
+	for _, v := range c.GELFListener {
+		tgs, err := v.Tags()
+		if err != nil {
+			return nil, err
+		}
+		for _, tg := range tgs {
+			if _, ok := tagMp[tg]; !ok {
+				tags = append(tags, tg)
+				tagMp[tg] = true
+			}
+		}
+	}
+
+	//iterate over fluent listeners
+	for _, v := range c.FluentListener {
+		tgs, err := v.Tags()
+		if err != nil {
+			return nil, err
+		}
+		for _, tg := range tgs {
+			if _, ok := tagMp[tg]; !ok {
+				tags = append(tags, tg)
+				tagMp[tg] = true
+			}
+		}
+	}
+
+	//iterate over beats listeners
+	for _, v := range c.BeatsListener {
+		tgs, err := v.Tags()
+		if err != nil {
+			return nil, err
+		}
+		for _, tg := range tgs {
+			if _, ok := tagMp[tg]; !ok {
+				tags = append(tags, tg)
+				tagMp[tg] = true
+			}
+		}
+	}
+
 	if len(tags) == 0 {
 		return nil, errors.New("No tags specified")
 	}
@@ -284,7 +377,7 @@ func checkListenerSettings(l *listener) (err error) {
 	if bt, _, err = translateBindType(l.Bind_String); err != nil {
 		return
 	}
-	if l.Drop_Priority && !(lt == rfc5424Reader || lt == rfc6587Reader) {
+	if l.Drop_Priority && !(lt == rfc5424Reader || lt == rfc6587Reader || lt == relpReader) {
 		err = fmt.Errorf("Drop-Priority is not compatible with reader type %s", lt)
 		return
 	}
@@ -292,6 +385,10 @@ func checkListenerSettings(l *listener) (err error) {
 		err = fmt.Errorf("RFC6587 reader type is not compatible with a UDP bind string")
 		return
 	}
+	if lt == relpReader && bt.UDP() {
+		err = fmt.Errorf("RELP reader type is not compatible with a UDP bind string")
+		return
+	}
 	return
 }
 
@@ -299,9 +396,42 @@ func (l baseConfig) Validate() error {
 	if len(l.Bind_String) == 0 {
 		return errors.New("No Bind-String provided")
 	}
+	if l.Proxy_Protocol {
+		if bt, _, err := translateBindType(l.Bind_String); err != nil {
+			return err
+		} else if bt.UDP() {
+			return errors.New("Proxy-Protocol is not supported on UDP listeners")
+		}
+		if _, err := utils.ParseTrustedUpstreams(l.Proxy_Trusted_Upstream); err != nil {
+			return err
+		}
+	} else if len(l.Proxy_Trusted_Upstream) > 0 {
+		return errors.New("Proxy-Trusted-Upstream requires Proxy-Protocol=true")
+	}
 	return nil
 }
 
+// listen binds a stream listener, layering PROXY protocol decoding and then TLS on top when configured
+func (l baseConfig) listen(network string, addr *net.TCPAddr, tlsConfig *tls.Config) (lst net.Listener, err error) {
+	var tl *net.TCPListener
+	if tl, err = net.ListenTCP(network, addr); err != nil {
+		return
+	}
+	lst = tl
+	if l.Proxy_Protocol {
+		var nets []*net.IPNet
+		if nets, err = utils.ParseTrustedUpstreams(l.Proxy_Trusted_Upstream); err != nil {
+			tl.Close()
+			return
+		}
+		lst = utils.NewProxyListener(lst, nets)
+	}
+	if tlsConfig != nil {
+		lst = tls.NewListener(lst, tlsConfig)
+	}
+	return
+}
+
 func translateBindType(bstr string) (bindType, string, error) {
 	bits := strings.SplitN(bstr, "://", 2)
 	//if nothing specified, just return the tcp type
@@ -368,6 +498,8 @@ func translateReaderType(s string) (readerType, error) {
 		return rfc5424Reader, nil
 	case `rfc6587`:
 		return rfc6587Reader, nil
+	case `relp`:
+		return relpReader, nil
 	case ``:
 		return lineReader, nil
 	}
@@ -382,6 +514,8 @@ func (rt readerType) String() string {
 		return `RFC5424`
 	case rfc6587Reader:
 		return `RFC6587`
+	case relpReader:
+		return `RELP`
 	}
 	return "UNKNOWN"
 }
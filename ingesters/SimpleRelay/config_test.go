@@ -71,6 +71,7 @@ func TestBadConfig(t *testing.T) {
 		badConfigWrongListener,
 		badConfigDropPriority,
 		badConfigReaderBind,
+		badConfigRELPBind,
 	}
 
 	for _, v := range cfgs {
@@ -224,4 +225,34 @@ Log-File=/tmp/simple_relay.log
 	Drop-Priority=true
 	Reader-Type=rfc6587
 `
+
+	badConfigRELPBind string = `
+[Global]
+Ingest-Secret = IngestSecrets
+Cleartext-Backend-target=127.0.0.1:4023 #example of adding a cleartext connection
+Log-Level=INFO
+Log-File=/tmp/simple_relay.log
+
+[Listener "rsyslog"]
+	Bind-String="udp://0.0.0.0:2514"
+	Reader-Type=relp
+`
 )
+
+func TestProxyProtocolConfig(t *testing.T) {
+	good := baseConfig{Bind_String: `tls://0.0.0.0:601`, Proxy_Protocol: true, Proxy_Trusted_Upstream: []string{`10.0.0.0/8`, `192.168.1.10`}}
+	if err := good.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	bad := []baseConfig{
+		{Bind_String: `udp://0.0.0.0:514`, Proxy_Protocol: true, Proxy_Trusted_Upstream: []string{`10.0.0.0/8`}},
+		{Bind_String: `0.0.0.0:601`, Proxy_Protocol: true},
+		{Bind_String: `0.0.0.0:601`, Proxy_Protocol: true, Proxy_Trusted_Upstream: []string{`10.0.0.0/40`}},
+		{Bind_String: `0.0.0.0:601`, Proxy_Trusted_Upstream: []string{`10.0.0.0/8`}},
+	}
+	for i, b := range bad {
+		if err := b.Validate(); err == nil {
+			t.Fatalf("failed to catch bad config %d", i)
+		}
+	}
+}
@@ -0,0 +1,751 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/vmihailenco/msgpack/v5/msgpcode"
+)
+
+const (
+	fluentEventTimeExt   int8 = 0
+	fluentMaxDepth            = 64
+	fluentNonceSize           = 16
+	fluentCompressedGzip      = `gzip`
+)
+
+var (
+	ErrFluentAuthFailed  = errors.New("fluent shared key mismatch")
+	ErrFluentBadPing     = errors.New("invalid fluent PING message")
+	ErrFluentBadMessage  = errors.New("invalid fluent forward message")
+	ErrFluentBadTime     = errors.New("invalid fluent event time")
+	ErrFluentTooDeep     = errors.New("fluent record nesting is too deep")
+	ErrFluentOversized   = errors.New("fluent payload exceeds maximum size")
+	ErrFluentCompression = errors.New("unsupported fluent compression")
+)
+
+type fluentRule struct {
+	fluentTagRule
+	tg entry.EntryTag
+}
+
+type fluentHandlerConfig struct {
+	name             string
+	defTag           entry.EntryTag
+	rules            []fluentRule
+	sharedKey        string
+	hostname         string
+	attachTag        bool
+	ignoreTimestamps bool
+	src              net.IP
+	wg               *sync.WaitGroup
+	proc             *processors.ProcessorSet
+	ctx              context.Context
+	maxMessageSize   int
+}
+
+func startFluentListeners(cfg *cfgType, igst *ingest.IngestMuxer, wg *sync.WaitGroup, f *flusher, ctx context.Context) error {
+	var err error
+	//short circuit out on empty
+	if len(cfg.FluentListener) == 0 {
+		return nil
+	}
+
+	for k, v := range cfg.FluentListener {
+		if err := v.Validate(); err != nil {
+			return fmt.Errorf("FluentListener %s configuration is invalid: %w", k, err)
+		}
+		fhc := fluentHandlerConfig{
+			name:             k,
+			wg:               wg,
+			sharedKey:        v.Shared_Key,
+			hostname:         v.Self_Hostname,
+			attachTag:        v.Attach_Fluent_Tag,
+			ignoreTimestamps: v.Ignore_Timestamps,
+			ctx:              ctx,
+			maxMessageSize:   int(v.Max_Message_Size),
+		}
+		if fhc.proc, err = cfg.Preprocessor.ProcessorSet(igst, v.Preprocessor); err != nil {
+			lg.Fatal("preprocessor error", log.KVErr(err))
+		}
+		f.Add(fhc.proc)
+		if v.Source_Override != `` {
+			fhc.src = net.ParseIP(v.Source_Override)
+			if fhc.src == nil {
+				return fmt.Errorf("FluentListener %v invalid source override \"%s\"", k, v.Source_Override)
+			}
+		} else if cfg.Source_Override != `` {
+			// global override
+			fhc.src = net.ParseIP(cfg.Source_Override)
+			if fhc.src == nil {
+				return fmt.Errorf("global source override \"%s\" is invalid", cfg.Source_Override)
+			}
+		}
+		//resolve the default tag
+		if fhc.defTag, err = igst.GetTag(v.Default_Tag); err != nil {
+			return err
+		}
+
+		//resolve the tag routing rules
+		rules, err := v.TagRules()
+		if err != nil {
+			return err
+		}
+		for _, r := range rules {
+			tg, err := igst.GetTag(r.tag)
+			if err != nil {
+				return err
+			}
+			fhc.rules = append(fhc.rules, fluentRule{fluentTagRule: r, tg: tg})
+		}
+
+		tp, str, err := translateBindType(v.Bind_String)
+		if err != nil {
+			lg.FatalCode(0, "invalid bind", log.KV("bindstring", v.Bind_String), log.KVErr(err))
+		}
+		addr, err := net.ResolveTCPAddr("tcp", str)
+		if err != nil {
+			return fmt.Errorf("%s Bind-String \"%s\" is invalid: %v\n", k, v.Bind_String, err)
+		}
+		var l net.Listener
+		if tp.TLS() {
+			config := &tls.Config{
+				MinVersion: tls.VersionTLS12,
+			}
+			config.Certificates = make([]tls.Certificate, 1)
+			config.Certificates[0], err = tls.LoadX509KeyPair(v.Cert_File, v.Key_File)
+			if err != nil {
+				lg.Fatal("failed to load certificate", log.KV("certfile", v.Cert_File), log.KV("keyfile", v.Key_File), log.KVErr(err))
+			}
+			if l, err = v.listen("tcp", addr, config); err != nil {
+				lg.FatalCode(0, "failed to listen via TLS", log.KV("address", addr), log.KV("fluentlistener", k), log.KVErr(err))
+			}
+		} else if l, err = v.listen("tcp", addr, nil); err != nil {
+			return fmt.Errorf("%s Failed to listen on \"%s\": %v\n", k, addr, err)
+		}
+		connID := addConn(l)
+		//start the acceptor
+		wg.Add(1)
+		go fluentAcceptor(l, connID, fhc, tp)
+	}
+	debugout("Started %d fluent listeners\n", len(cfg.FluentListener))
+	return nil
+}
+
+func fluentAcceptor(lst net.Listener, id int, cfg fluentHandlerConfig, tp bindType) {
+	defer cfg.wg.Done()
+	defer delConn(id)
+	defer lst.Close()
+	var failCount int
+	for {
+		conn, err := lst.Accept()
+		if err != nil {
+			if strings.Contains(err.Error(), "closed") {
+				break
+			}
+			failCount++
+			fmt.Fprintf(os.Stderr, "Failed to accept %v connection: %v\n", tp.String(), err)
+			if failCount > 3 {
+				break
+			}
+			continue
+		}
+		failCount = 0
+		go func(conn net.Conn) {
+			logAccepted(conn, `fluent`, tp, cfg.name)
+			fluentConnHandler(conn, cfg)
+		}(conn)
+	}
+}
+
+func fluentConnHandler(c net.Conn, cfg fluentHandlerConfig) {
+	cfg.wg.Add(1)
+	id := addConn(c)
+	defer cfg.wg.Done()
+	defer delConn(id)
+	defer c.Close()
+	var rip net.IP
+
+	if ipstr, _, err := net.SplitHostPort(c.RemoteAddr().String()); err != nil {
+		lg.Error("failed to get host from remote addr", log.KV("remoteaddress", c.RemoteAddr().String()), log.KVErr(err))
+		return
+	} else if rip = net.ParseIP(ipstr); rip == nil {
+		lg.Error("failed to get remote address", log.KV("remoteaddress", ipstr))
+		return
+	}
+	ll := log.NewLoggerWithKV(lg, log.KV("fluent-listener", cfg.name), log.KV("remoteaddress", rip))
+	if cfg.src != nil {
+		rip = cfg.src
+	}
+	fc := newFluentConn(c, &cfg, rip, func(ents []*entry.Entry) error {
+		return cfg.proc.ProcessBatchContext(ents, cfg.ctx)
+	})
+	if err := fc.run(); err != nil {
+		ll.Error("fluent forward handler error", log.KVErr(err))
+	}
+}
+
+type fluentOption struct {
+	chunk      string
+	compressed string
+}
+
+// fluentConn handles a single Fluentd Forward protocol connection
+type fluentConn struct {
+	cfg  *fluentHandlerConfig
+	w    io.Writer
+	dec  *msgpack.Decoder
+	enc  *msgpack.Encoder
+	out  bytes.Buffer
+	bb   bytes.Buffer
+	rip  net.IP
+	emit func([]*entry.Entry) error
+	tags map[string]entry.EntryTag // resolved fluent tags
+}
+
+func newFluentConn(rw io.ReadWriter, cfg *fluentHandlerConfig, rip net.IP, emit func([]*entry.Entry) error) *fluentConn {
+	fc := &fluentConn{
+		cfg:  cfg,
+		w:    rw,
+		dec:  msgpack.NewDecoder(rw),
+		rip:  rip,
+		emit: emit,
+		tags: map[string]entry.EntryTag{},
+	}
+	fc.enc = msgpack.NewEncoder(&fc.out)
+	return fc
+}
+
+func (fc *fluentConn) run() error {
+	if fc.cfg.sharedKey != `` {
+		if err := fc.handshake(); err != nil {
+			return err
+		}
+	}
+	for {
+		if err := fc.readMessage(); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// send encodes a value and writes it out in a single write
+func (fc *fluentConn) send(v interface{}) (err error) {
+	fc.out.Reset()
+	if err = fc.enc.Encode(v); err == nil {
+		_, err = fc.w.Write(fc.out.Bytes())
+	}
+	return
+}
+
+// handshake performs the forward protocol shared key authentication, user authentication is not supported
+// so the HELO carries an empty auth salt.
+func (fc *fluentConn) handshake() error {
+	nonce := make([]byte, fluentNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	helo := []interface{}{`HELO`, map[string]interface{}{
+		`nonce`:     nonce,
+		`auth`:      []byte{},
+		`keepalive`: true,
+	}}
+	if err := fc.send(helo); err != nil {
+		return err
+	}
+	n, err := fc.dec.DecodeArrayLen()
+	if err != nil {
+		return err
+	} else if n < 4 {
+		return ErrFluentBadPing
+	}
+	ping := make([]string, 4)
+	for i := 0; i < n; i++ {
+		if i >= len(ping) {
+			err = fc.dec.Skip()
+		} else {
+			ping[i], err = fc.dec.DecodeString()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if ping[0] != `PING` {
+		return ErrFluentBadPing
+	}
+	host, salt, digest := ping[1], ping[2], ping[3]
+	if exp := fluentDigest(salt, host, nonce, fc.cfg.sharedKey); subtle.ConstantTimeCompare([]byte(exp), []byte(digest)) != 1 {
+		fc.send([]interface{}{`PONG`, false, `shared_key mismatch`, ``, ``})
+		return ErrFluentAuthFailed
+	}
+	return fc.send([]interface{}{`PONG`, true, ``, fc.cfg.hostname, fluentDigest(salt, fc.cfg.hostname, nonce, fc.cfg.sharedKey)})
+}
+
+func fluentDigest(salt, host string, nonce []byte, key string) string {
+	h := sha512.New()
+	h.Write([]byte(salt))
+	h.Write([]byte(host))
+	h.Write(nonce)
+	h.Write([]byte(key))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// readMessage consumes a single Message, Forward, PackedForward, or CompressedPackedForward message
+func (fc *fluentConn) readMessage() (err error) {
+	var n int
+	var tag string
+	var c byte
+	var ents []*entry.Entry
+	var packed []byte
+	var opt fluentOption
+	if n, err = fc.dec.DecodeArrayLen(); err != nil {
+		return
+	} else if n < 2 {
+		return ErrFluentBadMessage
+	}
+	if tag, err = fc.dec.DecodeString(); err != nil {
+		return
+	} else if c, err = fc.dec.PeekCode(); err != nil {
+		return
+	}
+	remaining := n - 2
+	switch {
+	case msgpcode.IsFixedArray(c) || c == msgpcode.Array16 || c == msgpcode.Array32:
+		//Forward mode
+		if ents, err = fc.readEntries(fc.dec, tag, false); err != nil {
+			return
+		}
+	case msgpcode.IsString(c) || msgpcode.IsBin(c):
+		//PackedForward mode, we need the options before we know if it is compressed
+		if packed, err = fc.readPacked(); err != nil {
+			return
+		}
+	default:
+		//Message mode
+		if remaining < 1 {
+			return ErrFluentBadMessage
+		}
+		var ent *entry.Entry
+		if ent, err = fc.readEvent(fc.dec, tag); err != nil {
+			return
+		}
+		ents = append(ents, ent)
+		remaining--
+	}
+	if remaining > 0 {
+		if opt, err = fc.readOption(); err != nil {
+			return
+		}
+		remaining--
+	}
+	for ; remaining > 0; remaining-- {
+		if err = fc.dec.Skip(); err != nil {
+			return
+		}
+	}
+	if packed != nil {
+		if ents, err = fc.unpack(tag, packed, opt.compressed); err != nil {
+			return
+		}
+	}
+	if len(ents) > 0 {
+		if err = fc.emit(ents); err != nil {
+			return
+		}
+	}
+	if opt.chunk != `` {
+		err = fc.send(map[string]string{`ack`: opt.chunk})
+	}
+	return
+}
+
+func (fc *fluentConn) readPacked() (b []byte, err error) {
+	var n int
+	if n, err = fc.dec.DecodeBytesLen(); err != nil {
+		return
+	} else if n > fc.cfg.maxMessageSize {
+		err = ErrFluentOversized
+		return
+	} else if n < 0 {
+		n = 0
+	}
+	b = make([]byte, n)
+	err = fc.dec.ReadFull(b)
+	return
+}
+
+func (fc *fluentConn) unpack(tag string, b []byte, compression string) (ents []*entry.Entry, err error) {
+	switch compression {
+	case ``:
+	case fluentCompressedGzip:
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(bytes.NewReader(b)); err != nil {
+			return
+		}
+		bb := bytes.NewBuffer(nil)
+		var n int64
+		if n, err = io.Copy(bb, io.LimitReader(gz, int64(fc.cfg.maxMessageSize)+1)); err != nil {
+			return
+		} else if n > int64(fc.cfg.maxMessageSize) {
+			err = ErrFluentOversized
+			return
+		}
+		b = bb.Bytes()
+	default:
+		err = fmt.Errorf("%w %q", ErrFluentCompression, compression)
+		return
+	}
+	return fc.readEntries(msgpack.NewDecoder(bytes.NewReader(b)), tag, true)
+}
+
+// readEntries reads a set of [time, record] events, in packed mode the events are a bare
+// stream which runs to the end of the decoder, otherwise they are wrapped in an array.
+func (fc *fluentConn) readEntries(dec *msgpack.Decoder, tag string, packed bool) (ents []*entry.Entry, err error) {
+	cnt := -1
+	if !packed {
+		if cnt, err = dec.DecodeArrayLen(); err != nil {
+			return
+		}
+	}
+	for i := 0; cnt < 0 || i < cnt; i++ {
+		var n int
+		var ent *entry.Entry
+		if n, err = dec.DecodeArrayLen(); err != nil {
+			if packed && err == io.EOF {
+				err = nil
+			}
+			return
+		} else if n < 2 {
+			err = ErrFluentBadMessage
+			return
+		}
+		if ent, err = fc.readEvent(dec, tag); err != nil {
+			return
+		}
+		for ; n > 2; n-- {
+			if err = dec.Skip(); err != nil {
+				return
+			}
+		}
+		ents = append(ents, ent)
+	}
+	return
+}
+
+// readEvent reads a time and record pair and builds an entry from them
+func (fc *fluentConn) readEvent(dec *msgpack.Decoder, tag string) (ent *entry.Entry, err error) {
+	var ts entry.Timestamp
+	if ts, err = fluentTime(dec); err != nil {
+		return
+	}
+	if fc.cfg.ignoreTimestamps {
+		ts = entry.Now()
+	}
+	fc.bb.Reset()
+	if err = msgpackToJSON(dec, &fc.bb, 0); err != nil {
+		return
+	}
+	ent = &entry.Entry{
+		TS:   ts,
+		SRC:  fc.rip,
+		Tag:  fc.resolveTag(tag),
+		Data: append([]byte(nil), fc.bb.Bytes()...),
+	}
+	if fc.cfg.attachTag {
+		ent.AddEnumeratedValueEx(defaultFluentTagEV, tag)
+	}
+	return
+}
+
+func (fc *fluentConn) readOption() (opt fluentOption, err error) {
+	var c byte
+	var n int
+	if c, err = fc.dec.PeekCode(); err != nil {
+		return
+	} else if c == msgpcode.Nil {
+		err = fc.dec.DecodeNil()
+		return
+	}
+	if n, err = fc.dec.DecodeMapLen(); err != nil {
+		return
+	}
+	for i := 0; i < n; i++ {
+		var k string
+		if k, err = fc.dec.DecodeString(); err != nil {
+			return
+		}
+		switch k {
+		case `chunk`:
+			opt.chunk, err = fc.dec.DecodeString()
+		case `compressed`:
+			opt.compressed, err = fc.dec.DecodeString()
+		default:
+			err = fc.dec.Skip()
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// resolveTag maps a fluent tag to a gravwell tag using the first matching rule
+func (fc *fluentConn) resolveTag(tag string) (tg entry.EntryTag) {
+	var ok bool
+	if tg, ok = fc.tags[tag]; ok {
+		return
+	}
+	tg = fc.cfg.defTag
+	for _, r := range fc.cfg.rules {
+		if r.Match(tag) {
+			tg = r.tg
+			break
+		}
+	}
+	if len(fc.tags) < 1024 {
+		fc.tags[tag] = tg
+	}
+	return
+}
+
+// fluentTime decodes an event time which may be an EventTime extension, an integer, or a float
+func fluentTime(dec *msgpack.Decoder) (ts entry.Timestamp, err error) {
+	var c byte
+	if c, err = dec.PeekCode(); err != nil {
+		return
+	}
+	switch {
+	case msgpcode.IsExt(c):
+		var id int8
+		var l int
+		if id, l, err = dec.DecodeExtHeader(); err != nil {
+			return
+		} else if id != fluentEventTimeExt || l != 8 {
+			err = ErrFluentBadTime
+			return
+		}
+		var buff [8]byte
+		if err = dec.ReadFull(buff[:]); err == nil {
+			ts = fluentEventTime(buff[:])
+		}
+	case c == msgpcode.Float || c == msgpcode.Double:
+		var f float64
+		if f, err = dec.DecodeFloat64(); err == nil {
+			sec := int64(f)
+			ts = entry.UnixTime(sec, int64((f-float64(sec))*1e9))
+		}
+	case c == msgpcode.Nil:
+		if err = dec.DecodeNil(); err == nil {
+			ts = entry.Now()
+		}
+	default:
+		var sec int64
+		if sec, err = dec.DecodeInt64(); err == nil {
+			ts = entry.UnixTime(sec, 0)
+		}
+	}
+	return
+}
+
+func fluentEventTime(b []byte) entry.Timestamp {
+	return entry.UnixTime(int64(binary.BigEndian.Uint32(b)), int64(binary.BigEndian.Uint32(b[4:])))
+}
+
+// msgpackToJSON transcodes a single msgpack value to JSON while preserving map ordering,
+// binary values are rendered as strings when they are valid UTF-8 and base64 otherwise.
+func msgpackToJSON(dec *msgpack.Decoder, bb *bytes.Buffer, depth int) (err error) {
+	var c byte
+	if depth > fluentMaxDepth {
+		return ErrFluentTooDeep
+	} else if c, err = dec.PeekCode(); err != nil {
+		return
+	}
+	switch {
+	case c == msgpcode.Nil:
+		if err = dec.DecodeNil(); err == nil {
+			bb.WriteString(`null`)
+		}
+	case c == msgpcode.True || c == msgpcode.False:
+		var v bool
+		if v, err = dec.DecodeBool(); err == nil {
+			bb.WriteString(strconv.FormatBool(v))
+		}
+	case c == msgpcode.Uint8 || c == msgpcode.Uint16 || c == msgpcode.Uint32 || c == msgpcode.Uint64:
+		var v uint64
+		if v, err = dec.DecodeUint64(); err == nil {
+			bb.WriteString(strconv.FormatUint(v, 10))
+		}
+	case msgpcode.IsFixedNum(c) || c == msgpcode.Int8 || c == msgpcode.Int16 || c == msgpcode.Int32 || c == msgpcode.Int64:
+		var v int64
+		if v, err = dec.DecodeInt64(); err == nil {
+			bb.WriteString(strconv.FormatInt(v, 10))
+		}
+	case c == msgpcode.Float || c == msgpcode.Double:
+		var v float64
+		if v, err = dec.DecodeFloat64(); err == nil {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				bb.WriteString(`null`)
+			} else {
+				bb.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+			}
+		}
+	case msgpcode.IsString(c):
+		var v string
+		if v, err = dec.DecodeString(); err == nil {
+			writeJSONString(bb, v)
+		}
+	case msgpcode.IsBin(c):
+		var v []byte
+		if v, err = dec.DecodeBytes(); err == nil {
+			if utf8.Valid(v) {
+				writeJSONString(bb, string(v))
+			} else {
+				writeJSONString(bb, base64.StdEncoding.EncodeToString(v))
+			}
+		}
+	case msgpcode.IsFixedArray(c) || c == msgpcode.Array16 || c == msgpcode.Array32:
+		var n int
+		if n, err = dec.DecodeArrayLen(); err != nil {
+			return
+		}
+		bb.WriteByte('[')
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				bb.WriteByte(',')
+			}
+			if err = msgpackToJSON(dec, bb, depth+1); err != nil {
+				return
+			}
+		}
+		bb.WriteByte(']')
+	case msgpcode.IsFixedMap(c) || c == msgpcode.Map16 || c == msgpcode.Map32:
+		var n int
+		if n, err = dec.DecodeMapLen(); err != nil {
+			return
+		}
+		bb.WriteByte('{')
+		for i := 0; i < n; i++ {
+			var k string
+			if i > 0 {
+				bb.WriteByte(',')
+			}
+			if k, err = msgpackKey(dec); err != nil {
+				return
+			}
+			writeJSONString(bb, k)
+			bb.WriteByte(':')
+			if err = msgpackToJSON(dec, bb, depth+1); err != nil {
+				return
+			}
+		}
+		bb.WriteByte('}')
+	case msgpcode.IsExt(c):
+		var id int8
+		var l int
+		if id, l, err = dec.DecodeExtHeader(); err != nil {
+			return
+		}
+		buff := make([]byte, l)
+		if err = dec.ReadFull(buff); err != nil {
+			return
+		}
+		if id == fluentEventTimeExt && l == 8 {
+			writeJSONString(bb, fluentEventTime(buff).StandardTime().Format(time.RFC3339Nano))
+		} else {
+			bb.WriteString(`null`)
+		}
+	default:
+		err = fmt.Errorf("unknown msgpack code %#x", c)
+	}
+	return
+}
+
+// msgpackKey decodes a map key, non-string keys are formatted
+func msgpackKey(dec *msgpack.Decoder) (k string, err error) {
+	var c byte
+	if c, err = dec.PeekCode(); err != nil {
+		return
+	} else if msgpcode.IsString(c) || msgpcode.IsBin(c) {
+		return dec.DecodeString()
+	}
+	var v interface{}
+	if v, err = dec.DecodeInterfaceLoose(); err == nil {
+		k = fmt.Sprint(v)
+	}
+	return
+}
+
+// writeJSONString writes an escaped JSON string without the HTML escaping done by encoding/json
+func writeJSONString(bb *bytes.Buffer, s string) {
+	const hex = `0123456789abcdef`
+	bb.WriteByte('"')
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			switch c {
+			case '"', '\\':
+				bb.WriteByte('\\')
+				bb.WriteByte(c)
+			case '\n':
+				bb.WriteString(`\n`)
+			case '\r':
+				bb.WriteString(`\r`)
+			case '\t':
+				bb.WriteString(`\t`)
+			default:
+				if c < 0x20 {
+					bb.WriteString(`\u00`)
+					bb.WriteByte(hex[c>>4])
+					bb.WriteByte(hex[c&0xf])
+				} else {
+					bb.WriteByte(c)
+				}
+			}
+			i++
+			continue
+		}
+		r, sz := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && sz == 1 {
+			bb.WriteString(`�`)
+		} else {
+			bb.WriteString(s[i : i+sz])
+		}
+		i += sz
+	}
+	bb.WriteByte('"')
+}
@@ -0,0 +1,152 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gobwas/glob"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	defaultFluentMaxMessageSize uint = 16 * 1024 * 1024 //fluent bit chunks can get big
+	defaultFluentTagEV               = `fluent_tag`
+)
+
+var (
+	ErrFluentUDP = errors.New("Fluentd forward listeners do not support UDP")
+)
+
+type fluentListener struct {
+	baseConfig
+	Default_Tag       string
+	Tag_Match         []string // fluent-pattern:tag pairs, the first matching pattern wins
+	Shared_Key        string   // enables the shared key handshake
+	Self_Hostname     string   // hostname presented during the handshake, defaults to the system hostname
+	Max_Message_Size  uint     // largest packed or decompressed payload, defaults to 16MB
+	Attach_Fluent_Tag bool     // attach the fluent tag as the fluent_tag enumerated value
+}
+
+func (fl *fluentListener) Validate() error {
+	if err := fl.baseConfig.Validate(); err != nil {
+		return err
+	}
+	if tp, _, err := translateBindType(fl.Bind_String); err != nil {
+		return err
+	} else if tp.UDP() {
+		return ErrFluentUDP
+	}
+	if fl.Timestamp_Format_Override != `` {
+		return errors.New("Timestamp-Format-Override is not supported, fluent events carry their own timestamp")
+	}
+	if strings.TrimSpace(fl.Default_Tag) == `` {
+		if v := strings.TrimSpace(fl.Tag_Name); v != `` {
+			fl.Default_Tag = v
+		} else {
+			fl.Default_Tag = entry.DefaultTagName
+		}
+	}
+	if _, err := (jsonListener{Default_Tag: fl.Default_Tag}).defaultTag(); err != nil {
+		return err
+	}
+	if _, err := fl.TagRules(); err != nil {
+		return err
+	}
+	if fl.Shared_Key != `` && strings.TrimSpace(fl.Self_Hostname) == `` {
+		if hn, err := os.Hostname(); err == nil {
+			fl.Self_Hostname = hn
+		} else {
+			fl.Self_Hostname = `gravwell`
+		}
+	}
+	if fl.Max_Message_Size == 0 {
+		fl.Max_Message_Size = defaultFluentMaxMessageSize
+	}
+	return nil
+}
+
+// fluentTagRule maps fluent tags matching any of a set of patterns to a gravwell tag
+type fluentTagRule struct {
+	pattern string
+	globs   []glob.Glob
+	tag     string
+}
+
+func (r fluentTagRule) Match(v string) bool {
+	for _, g := range r.globs {
+		if g.Match(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// TagRules compiles the Tag-Match directives, patterns follow fluentd match semantics where
+// * matches a single tag part, ** matches zero or more parts, {a,b} matches either
+// and multiple space separated patterns may share a tag.
+func (fl fluentListener) TagRules() (rules []fluentTagRule, err error) {
+	for _, v := range fl.Tag_Match {
+		var r fluentTagRule
+		if r.pattern, r.tag, err = extractElementTag(v); err != nil {
+			return
+		}
+		for _, p := range strings.Fields(r.pattern) {
+			var gs []glob.Glob
+			if gs, err = compileFluentPattern(p); err != nil {
+				err = fmt.Errorf("Invalid Tag-Match pattern %q %w", p, err)
+				return
+			}
+			r.globs = append(r.globs, gs...)
+		}
+		if len(r.globs) == 0 {
+			err = fmt.Errorf("Invalid Tag-Match %q, missing pattern", v)
+			return
+		}
+		rules = append(rules, r)
+	}
+	return
+}
+
+func compileFluentPattern(p string) (gs []glob.Glob, err error) {
+	var g glob.Glob
+	if g, err = glob.Compile(p, '.'); err != nil {
+		return
+	}
+	gs = append(gs, g)
+	//fluentd lets a trailing .** match zero parts, so a.** also matches a
+	if pfx := strings.TrimSuffix(p, `.**`); pfx != p && pfx != `` {
+		if g, err = glob.Compile(pfx, '.'); err != nil {
+			return
+		}
+		gs = append(gs, g)
+	}
+	return
+}
+
+func (fl fluentListener) Tags() (tags []string, err error) {
+	var rules []fluentTagRule
+	if rules, err = fl.TagRules(); err != nil {
+		return
+	}
+	tags = []string{fl.Default_Tag}
+	mp := map[string]bool{
+		fl.Default_Tag: true,
+	}
+	for _, r := range rules {
+		if !mp[r.tag] {
+			mp[r.tag] = true
+			tags = append(tags, r.tag)
+		}
+	}
+	return
+}
@@ -0,0 +1,286 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const testFluentKey = `secretsauce`
+
+var testFluentTS = time.Date(2024, 6, 1, 12, 0, 0, 123456789, time.UTC)
+
+// testEventTime implements the msgpack EventTime extension for test clients
+type testEventTime time.Time
+
+func (et *testEventTime) MarshalMsgpack() ([]byte, error) {
+	b := make([]byte, 8)
+	t := time.Time(*et)
+	binary.BigEndian.PutUint32(b, uint32(t.Unix()))
+	binary.BigEndian.PutUint32(b[4:], uint32(t.Nanosecond()))
+	return b, nil
+}
+
+func (et *testEventTime) UnmarshalMsgpack(b []byte) error {
+	*et = testEventTime(fluentEventTime(b).StandardTime())
+	return nil
+}
+
+func init() {
+	msgpack.RegisterExt(0, (*testEventTime)(nil))
+}
+
+type testRecord struct {
+	Msg  string `msgpack:"msg"`
+	Num  int    `msgpack:"num"`
+	Host []byte `msgpack:"host"`
+}
+
+func newTestFluentConfig(t *testing.T, key string) *fluentHandlerConfig {
+	fl := fluentListener{
+		baseConfig: baseConfig{Bind_String: `:24224`},
+		Tag_Match:  []string{`app.**:apps`, `kube.*.{web,api} sys:infra`},
+		Shared_Key: key,
+	}
+	if err := fl.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := fl.TagRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &fluentHandlerConfig{
+		defTag:         0,
+		sharedKey:      key,
+		hostname:       `relay`,
+		attachTag:      true,
+		maxMessageSize: int(fl.Max_Message_Size),
+	}
+	for i, r := range rules {
+		cfg.rules = append(cfg.rules, fluentRule{fluentTagRule: r, tg: entry.EntryTag(i + 1)})
+	}
+	return cfg
+}
+
+// startFluentTest runs a fluent connection handler over a pipe, returning the client side and a channel of entry batches
+func startFluentTest(t *testing.T, cfg *fluentHandlerConfig) (net.Conn, chan []*entry.Entry, chan error) {
+	srv, cli := net.Pipe()
+	ch := make(chan []*entry.Entry, 16)
+	errch := make(chan error, 1)
+	fc := newFluentConn(srv, cfg, net.ParseIP(`10.0.0.1`), func(ents []*entry.Entry) error {
+		ch <- ents
+		return nil
+	})
+	go func() {
+		errch <- fc.run()
+		srv.Close()
+	}()
+	t.Cleanup(func() { cli.Close() })
+	return cli, ch, errch
+}
+
+// writeFluent encodes a message and sends it in a single write, the msgpack encoder issues
+// zero length writes for empty strings which would block on a pipe
+func writeFluent(t *testing.T, w net.Conn, v interface{}) {
+	t.Helper()
+	b, err := msgpack.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err = w.Write(b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkFluentEntry(t *testing.T, ent *entry.Entry, tag entry.EntryTag, ftag string) {
+	t.Helper()
+	if ent.Tag != tag {
+		t.Fatalf("bad tag for %s: %d != %d", ftag, ent.Tag, tag)
+	} else if !ent.TS.StandardTime().Equal(testFluentTS) {
+		t.Fatalf("bad timestamp %v", ent.TS.StandardTime())
+	} else if string(ent.Data) != `{"msg":"hello \"world\" <3","num":42,"host":"web01"}` {
+		t.Fatalf("bad data %s", ent.Data)
+	} else if v, ok := ent.GetEnumeratedValue(defaultFluentTagEV); !ok || v != ftag {
+		t.Fatalf("bad fluent tag EV %v", v)
+	}
+}
+
+func TestFluentModes(t *testing.T) {
+	cfg := newTestFluentConfig(t, ``)
+	cli, ch, errch := startFluentTest(t, cfg)
+	dec := msgpack.NewDecoder(cli)
+	et := &testEventTime{}
+	*et = testEventTime(testFluentTS)
+	rec := testRecord{Msg: `hello "world" <3`, Num: 42, Host: []byte(`web01`)}
+	event := []interface{}{et, rec}
+
+	var packed bytes.Buffer
+	penc := msgpack.NewEncoder(&packed)
+	for i := 0; i < 3; i++ {
+		if err := penc.Encode(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(packed.Bytes())
+	gz.Close()
+
+	tsts := []struct {
+		msg  []interface{}
+		cnt  int
+		tag  entry.EntryTag
+		ftag string
+		ack  string
+	}{
+		{msg: []interface{}{`app.nginx.access`, et, rec}, cnt: 1, tag: 1, ftag: `app.nginx.access`},
+		{msg: []interface{}{`app`, et, rec, map[string]interface{}{`chunk`: `abc`}}, cnt: 1, tag: 1, ftag: `app`, ack: `abc`},
+		{msg: []interface{}{`kube.prod.web`, []interface{}{event, event}}, cnt: 2, tag: 2, ftag: `kube.prod.web`},
+		{msg: []interface{}{`sys`, packed.Bytes(), map[string]interface{}{`size`: 3, `chunk`: `def`}}, cnt: 3, tag: 2, ftag: `sys`, ack: `def`},
+		{msg: []interface{}{`kube.prod.db`, compressed.Bytes(), map[string]interface{}{`compressed`: `gzip`}}, cnt: 3, tag: 0, ftag: `kube.prod.db`},
+	}
+	for i, tst := range tsts {
+		writeFluent(t, cli, tst.msg)
+		ents := <-ch
+		if len(ents) != tst.cnt {
+			t.Fatalf("%d bad entry count %d != %d", i, len(ents), tst.cnt)
+		}
+		for _, ent := range ents {
+			checkFluentEntry(t, ent, tst.tag, tst.ftag)
+		}
+		if tst.ack != `` {
+			var ack map[string]string
+			if err := dec.Decode(&ack); err != nil {
+				t.Fatal(err)
+			} else if ack[`ack`] != tst.ack {
+				t.Fatalf("bad ack %v", ack)
+			}
+		}
+	}
+	cli.Close()
+	if err := <-errch; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFluentIntegerTime(t *testing.T) {
+	cfg := newTestFluentConfig(t, ``)
+	cli, ch, _ := startFluentTest(t, cfg)
+	writeFluent(t, cli, []interface{}{`x`, 1700000000, map[string]interface{}{`a`: []interface{}{1.5, nil, true}}})
+	ents := <-ch
+	if ents[0].TS.StandardTime().Unix() != 1700000000 {
+		t.Fatalf("bad timestamp %v", ents[0].TS)
+	} else if string(ents[0].Data) != `{"a":[1.5,null,true]}` {
+		t.Fatalf("bad data %s", ents[0].Data)
+	}
+}
+
+func testFluentHandshake(t *testing.T, key string) (pong []interface{}, nonce []byte, errch chan error, ch chan []*entry.Entry, cli net.Conn) {
+	cfg := newTestFluentConfig(t, testFluentKey)
+	cli, ch, errch = startFluentTest(t, cfg)
+	dec := msgpack.NewDecoder(cli)
+	var helo []interface{}
+	if err := dec.Decode(&helo); err != nil {
+		t.Fatal(err)
+	} else if len(helo) != 2 || helo[0] != `HELO` {
+		t.Fatalf("bad HELO %v", helo)
+	}
+	opts, ok := helo[1].(map[string]interface{})
+	if !ok {
+		t.Fatalf("bad HELO options %T", helo[1])
+	}
+	if nonce, ok = opts[`nonce`].([]byte); !ok || len(nonce) != fluentNonceSize {
+		t.Fatalf("bad nonce %v", opts[`nonce`])
+	}
+	salt := `saltysalt`
+	ping := []interface{}{`PING`, `client`, salt, fluentDigest(salt, `client`, nonce, key), ``, ``}
+	writeFluent(t, cli, ping)
+	if err := dec.Decode(&pong); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestFluentHandshake(t *testing.T) {
+	pong, nonce, _, ch, cli := testFluentHandshake(t, testFluentKey)
+	if len(pong) != 5 || pong[0] != `PONG` || pong[1] != true || pong[3] != `relay` {
+		t.Fatalf("bad PONG %v", pong)
+	} else if pong[4] != fluentDigest(`saltysalt`, `relay`, nonce, testFluentKey) {
+		t.Fatalf("bad server digest %v", pong[4])
+	}
+	writeFluent(t, cli, []interface{}{`x`, 1700000000, map[string]interface{}{`a`: 1}})
+	if ents := <-ch; len(ents) != 1 {
+		t.Fatalf("bad entry count %d", len(ents))
+	}
+
+	pong, _, errch, _, _ := testFluentHandshake(t, `wrong`)
+	if len(pong) != 5 || pong[1] != false {
+		t.Fatalf("bad rejection PONG %v", pong)
+	} else if err := <-errch; err != ErrFluentAuthFailed {
+		t.Fatalf("bad auth error %v", err)
+	}
+}
+
+func TestFluentTagRules(t *testing.T) {
+	cfg := newTestFluentConfig(t, ``)
+	fc := newFluentConn(&bytes.Buffer{}, cfg, nil, nil)
+	tsts := map[string]entry.EntryTag{
+		`app`:           1,
+		`app.a.b.c`:     1,
+		`apps`:          0,
+		`kube.x.web`:    2,
+		`kube.x.api`:    2,
+		`kube.x.y.web`:  0,
+		`kube.x.db`:     0,
+		`sys`:           2,
+		`sys.something`: 0,
+	}
+	for k, v := range tsts {
+		if tg := fc.resolveTag(k); tg != v {
+			t.Fatalf("bad tag for %s: %d != %d", k, tg, v)
+		}
+	}
+}
+
+func TestFluentConfig(t *testing.T) {
+	fl := fluentListener{
+		baseConfig: baseConfig{Bind_String: `tcp://0.0.0.0:24224`, Tag_Name: `fluent`},
+		Tag_Match:  []string{`app.**:apps`, `sys.*:infra`, `kube.**:apps`},
+		Shared_Key: `key`,
+	}
+	if err := fl.Validate(); err != nil {
+		t.Fatal(err)
+	} else if fl.Self_Hostname == `` || fl.Default_Tag != `fluent` || fl.Max_Message_Size != defaultFluentMaxMessageSize {
+		t.Fatalf("bad defaults %+v", fl)
+	}
+	if tags, err := fl.Tags(); err != nil {
+		t.Fatal(err)
+	} else if len(tags) != 3 {
+		t.Fatalf("bad tags %v", tags)
+	}
+	bad := []fluentListener{
+		{},
+		{baseConfig: baseConfig{Bind_String: `udp://:24224`}},
+		{baseConfig: baseConfig{Bind_String: `:24224`}, Tag_Match: []string{`app.**`}},
+		{baseConfig: baseConfig{Bind_String: `:24224`}, Tag_Match: []string{`app.[a:tag`}},
+		{baseConfig: baseConfig{Bind_String: `:24224`}, Tag_Match: []string{`app.**:bad tag`}},
+	}
+	for i, b := range bad {
+		if err := b.Validate(); err == nil {
+			t.Fatalf("failed to catch bad config %d", i)
+		}
+	}
+}
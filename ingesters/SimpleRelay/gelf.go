@@ -0,0 +1,551 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+
+	"github.com/gravwell/jsonparser"
+)
+
+const (
+	gelfChunkHeaderSize = 12  // 2 byte magic, 8 byte message ID, sequence number, and sequence count
+	gelfMaxChunks       = 128 // maximum sequence count allowed by the GELF spec
+	gelfMaxDatagram     = 64 * 1024
+)
+
+var (
+	gelfChunkMagic = []byte{0x1e, 0x0f}
+	gelfGzipMagic  = []byte{0x1f, 0x8b}
+
+	ErrGELFOversized     = errors.New("GELF message exceeds maximum size")
+	ErrGELFInvalidChunk  = errors.New("invalid GELF chunk")
+	ErrGELFNotObject     = errors.New("GELF message is not a JSON object")
+	ErrGELFTooManyChunks = errors.New("too many pending chunked GELF messages")
+)
+
+type gelfHandlerConfig struct {
+	name             string
+	defTag           entry.EntryTag
+	tags             map[string]entry.EntryTag
+	tagField         string
+	attachFields     bool
+	ignoreTimestamps bool
+	src              net.IP
+	wg               *sync.WaitGroup
+	proc             *processors.ProcessorSet
+	ctx              context.Context
+	maxMessageSize   int
+	chunkTimeout     time.Duration
+	maxChunked       int
+}
+
+func startGELFListeners(cfg *cfgType, igst *ingest.IngestMuxer, wg *sync.WaitGroup, f *flusher, ctx context.Context) error {
+	var err error
+	//short circuit out on empty
+	if len(cfg.GELFListener) == 0 {
+		return nil
+	}
+
+	for k, v := range cfg.GELFListener {
+		if err := v.Validate(); err != nil {
+			return fmt.Errorf("GELFListener %s configuration is invalid: %w", k, err)
+		}
+		ghc := gelfHandlerConfig{
+			name:             k,
+			wg:               wg,
+			tags:             map[string]entry.EntryTag{},
+			tagField:         v.Tag_Field,
+			attachFields:     v.Attach_Fields,
+			ignoreTimestamps: v.Ignore_Timestamps,
+			ctx:              ctx,
+			maxMessageSize:   int(v.Max_Message_Size),
+			maxChunked:       int(v.Max_Chunked_Messages),
+		}
+		if ghc.chunkTimeout, err = v.chunkTimeout(); err != nil {
+			return err
+		}
+		if ghc.proc, err = cfg.Preprocessor.ProcessorSet(igst, v.Preprocessor); err != nil {
+			lg.Fatal("preprocessor error", log.KVErr(err))
+		}
+		f.Add(ghc.proc)
+		if v.Source_Override != `` {
+			ghc.src = net.ParseIP(v.Source_Override)
+			if ghc.src == nil {
+				return fmt.Errorf("GELFListener %v invalid source override \"%s\"", k, v.Source_Override)
+			}
+		} else if cfg.Source_Override != `` {
+			// global override
+			ghc.src = net.ParseIP(cfg.Source_Override)
+			if ghc.src == nil {
+				return fmt.Errorf("global source override \"%s\" is invalid", cfg.Source_Override)
+			}
+		}
+		//resolve the default tag
+		if ghc.defTag, err = igst.GetTag(v.Default_Tag); err != nil {
+			return err
+		}
+
+		//resolve all the other tags
+		tms, err := v.TagMatchers()
+		if err != nil {
+			return err
+		}
+		for _, tm := range tms {
+			tg, err := igst.GetTag(tm.Tag)
+			if err != nil {
+				return err
+			}
+			ghc.tags[tm.Value] = tg
+		}
+
+		tp, str, err := translateBindType(v.Bind_String)
+		if err != nil {
+			lg.FatalCode(0, "invalid bind", log.KV("bindstring", v.Bind_String), log.KVErr(err))
+		}
+
+		if tp.TCP() {
+			//get the socket
+			addr, err := net.ResolveTCPAddr("tcp", str)
+			if err != nil {
+				return fmt.Errorf("%s Bind-String \"%s\" is invalid: %v\n", k, v.Bind_String, err)
+			}
+			l, err := v.listen("tcp", addr, nil)
+			if err != nil {
+				return fmt.Errorf("%s Failed to listen on \"%s\": %v\n", k, addr, err)
+			}
+			connID := addConn(l)
+			//start the acceptor
+			wg.Add(1)
+			go gelfAcceptor(l, connID, ghc, tp)
+		} else if tp.TLS() {
+			config := &tls.Config{
+				MinVersion: tls.VersionTLS12,
+			}
+
+			config.Certificates = make([]tls.Certificate, 1)
+			config.Certificates[0], err = tls.LoadX509KeyPair(v.Cert_File, v.Key_File)
+			if err != nil {
+				lg.Fatal("failed to load certificate", log.KV("certfile", v.Cert_File), log.KV("keyfile", v.Key_File), log.KVErr(err))
+			}
+			//get the socket
+			addr, err := net.ResolveTCPAddr("tcp", str)
+			if err != nil {
+				lg.FatalCode(0, "invalid Bind-String", log.KV("bindstring", v.Bind_String), log.KV("gelflistener", k), log.KVErr(err))
+			}
+			l, err := v.listen("tcp", addr, config)
+			if err != nil {
+				lg.FatalCode(0, "failed to listen via TLS", log.KV("address", addr), log.KV("gelflistener", k), log.KVErr(err))
+			}
+			connID := addConn(l)
+			//start the acceptor
+			wg.Add(1)
+			go gelfAcceptor(l, connID, ghc, tp)
+		} else if tp.UDP() {
+			addr, err := net.ResolveUDPAddr(tp.String(), str)
+			if err != nil {
+				lg.FatalCode(0, "invalid Bind-String", log.KV("bindstring", v.Bind_String), log.KV("gelflistener", k), log.KVErr(err))
+			}
+			l, err := net.ListenUDP(tp.String(), addr)
+			if err != nil {
+				lg.FatalCode(0, "failed to listen via udp", log.KV("address", addr), log.KV("gelflistener", k), log.KVErr(err))
+			}
+			connID := addConn(l)
+			wg.Add(1)
+			go gelfAcceptorUDP(l, connID, ghc)
+		}
+	}
+	debugout("Started %d GELF listeners\n", len(cfg.GELFListener))
+	return nil
+}
+
+func gelfAcceptor(lst net.Listener, id int, cfg gelfHandlerConfig, tp bindType) {
+	defer cfg.wg.Done()
+	defer delConn(id)
+	defer lst.Close()
+	var failCount int
+	for {
+		conn, err := lst.Accept()
+		if err != nil {
+			if strings.Contains(err.Error(), "closed") {
+				break
+			}
+			failCount++
+			fmt.Fprintf(os.Stderr, "Failed to accept %v connection: %v\n", tp.String(), err)
+			if failCount > 3 {
+				break
+			}
+			continue
+		}
+		failCount = 0
+		go func(conn net.Conn) {
+			logAccepted(conn, `gelf`, tp, cfg.name)
+			gelfConnHandler(conn, cfg)
+		}(conn)
+	}
+}
+
+// gelfConnHandler reads null delimited GELF messages from a TCP or TLS stream
+func gelfConnHandler(c net.Conn, cfg gelfHandlerConfig) {
+	cfg.wg.Add(1)
+	id := addConn(c)
+	defer cfg.wg.Done()
+	defer delConn(id)
+	defer c.Close()
+	var rip net.IP
+
+	if ipstr, _, err := net.SplitHostPort(c.RemoteAddr().String()); err != nil {
+		lg.Error("failed to get host from remote addr", log.KV("remoteaddress", c.RemoteAddr().String()), log.KVErr(err))
+		return
+	} else if rip = net.ParseIP(ipstr); rip == nil {
+		lg.Error("failed to get remote address", log.KV("remoteaddress", ipstr))
+		return
+	}
+	if cfg.src != nil {
+		rip = cfg.src
+	}
+	ll := log.NewLoggerWithKV(lg, log.KV("gelf-listener", cfg.name))
+
+	if err := handleGELFStream(c, cfg, rip, ll); err != nil {
+		ll.Error("GELF stream handler error", log.KV("remoteaddress", rip), log.KVErr(err))
+	}
+}
+
+func handleGELFStream(rdr io.Reader, cfg gelfHandlerConfig, rip net.IP, ll *log.KVLogger) error {
+	sc := bufio.NewScanner(rdr)
+	sc.Buffer(make([]byte, initDataSize), cfg.maxMessageSize+1)
+	sc.Split(nullSplitter)
+	for sc.Scan() {
+		msg := bytes.TrimSpace(sc.Bytes())
+		if len(msg) == 0 {
+			continue
+		}
+		ent, err := cfg.buildEntry(msg, rip)
+		if err != nil {
+			ll.Warn("dropping invalid GELF message", log.KV("remoteaddress", rip), log.KVErr(err))
+			continue
+		}
+		cfg.proc.ProcessContext(ent, cfg.ctx)
+	}
+	if err := sc.Err(); err == bufio.ErrTooLong {
+		return ErrGELFOversized
+	} else if err != nil && !strings.Contains(err.Error(), "closed") {
+		return err
+	}
+	return nil
+}
+
+func gelfAcceptorUDP(conn *net.UDPConn, id int, cfg gelfHandlerConfig) {
+	defer cfg.wg.Done()
+	defer delConn(id)
+	defer conn.Close()
+
+	buff := make([]byte, gelfMaxDatagram)
+	ll := log.NewLoggerWithKV(lg, log.KV("gelf-listener", cfg.name))
+	asm := newGELFAssembler(cfg.chunkTimeout, cfg.maxChunked, cfg.maxMessageSize)
+	for {
+		//wake up periodically so that abandoned chunked messages are expired even when idle
+		if err := conn.SetReadDeadline(time.Now().Add(cfg.chunkTimeout)); err != nil {
+			break
+		}
+		n, raddr, err := conn.ReadFromUDP(buff)
+		if dropped := asm.expire(time.Now()); dropped > 0 {
+			ll.Warn("timed out waiting for GELF chunks", log.KV("dropped", dropped))
+		}
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			break
+		}
+		if n == 0 || raddr == nil || n > len(buff) {
+			continue
+		}
+		rip := raddr.IP
+		if cfg.src != nil {
+			rip = cfg.src
+		}
+		msg, ok, err := asm.add(raddr.String(), buff[:n], time.Now())
+		if err != nil {
+			ll.Warn("dropping invalid GELF datagram", log.KV("remoteaddress", raddr.IP), log.KVErr(err))
+			continue
+		} else if !ok {
+			continue //waiting on more chunks
+		}
+		if msg, err = gelfDecompress(msg, cfg.maxMessageSize); err != nil {
+			ll.Warn("failed to decompress GELF datagram", log.KV("remoteaddress", raddr.IP), log.KVErr(err))
+			continue
+		}
+		ent, err := cfg.buildEntry(msg, rip)
+		if err != nil {
+			ll.Warn("dropping invalid GELF message", log.KV("remoteaddress", raddr.IP), log.KVErr(err))
+			continue
+		}
+		cfg.proc.ProcessContext(ent, cfg.ctx)
+	}
+}
+
+// buildEntry turns a single decompressed GELF message into an entry, the message is copied
+// so callers may reuse their buffers.
+func (cfg *gelfHandlerConfig) buildEntry(msg []byte, rip net.IP) (ent *entry.Entry, err error) {
+	msg = bytes.TrimSpace(msg)
+	if len(msg) == 0 || msg[0] != '{' {
+		return nil, ErrGELFNotObject
+	}
+	ent = &entry.Entry{
+		SRC:  rip,
+		Tag:  cfg.defTag,
+		Data: append([]byte(nil), msg...),
+	}
+	var tsSet bool
+	err = jsonparser.ObjectEach(ent.Data, func(key, val []byte, vt jsonparser.ValueType, _ int) error {
+		k := string(key)
+		if k == `timestamp` {
+			if !cfg.ignoreTimestamps && vt == jsonparser.Number {
+				if ts, err := parseGELFTimestamp(val); err == nil {
+					ent.TS = ts
+					tsSet = true
+				}
+			}
+			return nil
+		}
+		if cfg.tagField != `` && k == cfg.tagField {
+			if tag, ok := cfg.tags[gelfFieldString(val, vt)]; ok {
+				ent.Tag = tag
+			}
+		}
+		if cfg.attachFields {
+			addGELFField(ent, k, val, vt)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !tsSet {
+		ent.TS = entry.Now()
+	}
+	return
+}
+
+// addGELFField attaches a GELF field as an enumerated value, the message bodies are already
+// in the entry data so they are skipped and the leading underscore is dropped from additional fields.
+func addGELFField(ent *entry.Entry, key string, val []byte, vt jsonparser.ValueType) {
+	switch key {
+	case `version`, `short_message`, `full_message`:
+		return
+	}
+	if key = strings.TrimPrefix(key, `_`); key == `` {
+		return
+	}
+	switch vt {
+	case jsonparser.String:
+		if s, err := jsonparser.ParseString(val); err == nil {
+			ent.AddEnumeratedValueEx(key, s)
+		}
+	case jsonparser.Number:
+		if v, err := strconv.ParseInt(string(val), 10, 64); err == nil {
+			ent.AddEnumeratedValueEx(key, v)
+		} else if f, err := strconv.ParseFloat(string(val), 64); err == nil {
+			ent.AddEnumeratedValueEx(key, f)
+		}
+	case jsonparser.Boolean:
+		if b, err := jsonparser.ParseBoolean(val); err == nil {
+			ent.AddEnumeratedValueEx(key, b)
+		}
+	case jsonparser.Null:
+	default:
+		ent.AddEnumeratedValueEx(key, string(val))
+	}
+}
+
+func gelfFieldString(val []byte, vt jsonparser.ValueType) string {
+	if vt == jsonparser.String {
+		if s, err := jsonparser.ParseString(val); err == nil {
+			return s
+		}
+	}
+	return string(val)
+}
+
+// parseGELFTimestamp handles the seconds.fraction epoch timestamps used by GELF without
+// routing through a float64 so that sub-microsecond precision is preserved.
+func parseGELFTimestamp(v []byte) (ts entry.Timestamp, err error) {
+	var sec, nsec int64
+	s := string(v)
+	if strings.ContainsAny(s, `eE-`) {
+		var f float64
+		if f, err = strconv.ParseFloat(s, 64); err == nil {
+			sec = int64(f)
+			ts = entry.UnixTime(sec, int64((f-float64(sec))*1e9))
+		}
+		return
+	}
+	whole, frac, _ := strings.Cut(s, `.`)
+	if sec, err = strconv.ParseInt(whole, 10, 64); err != nil {
+		return
+	}
+	if len(frac) > 9 {
+		frac = frac[:9]
+	}
+	if frac != `` {
+		if nsec, err = strconv.ParseInt(frac+strings.Repeat(`0`, 9-len(frac)), 10, 64); err != nil {
+			return
+		}
+	}
+	ts = entry.UnixTime(sec, nsec)
+	return
+}
+
+// gelfDecompress detects and inflates gzip or zlib compressed GELF payloads,
+// uncompressed payloads are handed back untouched.
+func gelfDecompress(b []byte, max int) ([]byte, error) {
+	var rdr io.Reader
+	var err error
+	if bytes.HasPrefix(b, gelfGzipMagic) {
+		if rdr, err = gzip.NewReader(bytes.NewReader(b)); err != nil {
+			return nil, err
+		}
+	} else if len(b) >= 2 && b[0] == 0x78 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0 {
+		//zlib header, CMF of 0x78 and a valid FCHECK
+		if rdr, err = zlib.NewReader(bytes.NewReader(b)); err != nil {
+			return nil, err
+		}
+	} else if len(b) > max {
+		return nil, ErrGELFOversized
+	} else {
+		return b, nil
+	}
+	bb := bytes.NewBuffer(nil)
+	if n, err := io.Copy(bb, io.LimitReader(rdr, int64(max)+1)); err != nil {
+		return nil, err
+	} else if n > int64(max) {
+		return nil, ErrGELFOversized
+	}
+	return bb.Bytes(), nil
+}
+
+type gelfChunkKey struct {
+	src string
+	id  [8]byte
+}
+
+type gelfChunkSet struct {
+	chunks [][]byte
+	seen   int
+	size   int
+	start  time.Time
+}
+
+// gelfAssembler reassembles chunked GELF UDP messages, incomplete messages are
+// discarded once the chunk timeout passes.
+type gelfAssembler struct {
+	timeout    time.Duration
+	maxPending int
+	maxSize    int
+	pending    map[gelfChunkKey]*gelfChunkSet
+}
+
+func newGELFAssembler(timeout time.Duration, maxPending, maxSize int) *gelfAssembler {
+	return &gelfAssembler{
+		timeout:    timeout,
+		maxPending: maxPending,
+		maxSize:    maxSize,
+		pending:    map[gelfChunkKey]*gelfChunkSet{},
+	}
+}
+
+// add consumes a datagram, returning the complete message and true when one is available.
+// Unchunked datagrams are handed straight back.
+func (ga *gelfAssembler) add(src string, pkt []byte, now time.Time) ([]byte, bool, error) {
+	if !bytes.HasPrefix(pkt, gelfChunkMagic) {
+		return pkt, true, nil
+	}
+	if len(pkt) <= gelfChunkHeaderSize {
+		return nil, false, ErrGELFInvalidChunk
+	}
+	key := gelfChunkKey{src: src}
+	copy(key.id[:], pkt[2:10])
+	seq, count := int(pkt[10]), int(pkt[11])
+	if count == 0 || count > gelfMaxChunks || seq >= count {
+		return nil, false, ErrGELFInvalidChunk
+	}
+	cs, ok := ga.pending[key]
+	if !ok {
+		if len(ga.pending) >= ga.maxPending {
+			return nil, false, ErrGELFTooManyChunks
+		}
+		cs = &gelfChunkSet{
+			chunks: make([][]byte, count),
+			start:  now,
+		}
+		ga.pending[key] = cs
+	} else if len(cs.chunks) != count {
+		delete(ga.pending, key)
+		return nil, false, ErrGELFInvalidChunk
+	}
+	if cs.chunks[seq] != nil {
+		return nil, false, nil //duplicate chunk
+	}
+	body := pkt[gelfChunkHeaderSize:]
+	if cs.size += len(body); cs.size > ga.maxSize {
+		delete(ga.pending, key)
+		return nil, false, ErrGELFOversized
+	}
+	cs.chunks[seq] = append([]byte(nil), body...)
+	if cs.seen++; cs.seen < count {
+		return nil, false, nil
+	}
+	delete(ga.pending, key)
+	return bytes.Join(cs.chunks, nil), true, nil
+}
+
+// expire drops any partial messages older than the timeout and returns how many were dropped
+func (ga *gelfAssembler) expire(now time.Time) (cnt int) {
+	for k, v := range ga.pending {
+		if now.Sub(v.start) > ga.timeout {
+			delete(ga.pending, k)
+			cnt++
+		}
+	}
+	return
+}
+
+func nullSplitter(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,124 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	defaultGELFChunkTimeout            = 5 * time.Second // the GELF spec says senders must finish chunked messages within 5s
+	defaultGELFMaxChunkedMessages uint = 1024
+)
+
+var (
+	ErrMissingGELFTagField = errors.New("Tag-Match requires a Tag-Field")
+)
+
+type gelfListener struct {
+	baseConfig
+	Default_Tag          string
+	Tag_Field            string   // GELF field used for tag routing, e.g. host, facility, or _tag
+	Tag_Match            []string // value:tag pairs matched against the Tag-Field
+	Attach_Fields        bool     // attach GELF fields as enumerated values
+	Max_Message_Size     uint     // largest decompressed message, defaults to 1MB
+	Chunk_Timeout        string   // how long to wait for all chunks of a UDP message, defaults to 5s
+	Max_Chunked_Messages uint     // how many partial UDP messages are held at once, defaults to 1024
+}
+
+func (gl *gelfListener) Validate() error {
+	if err := gl.baseConfig.Validate(); err != nil {
+		return err
+	}
+	if _, _, err := translateBindType(gl.Bind_String); err != nil {
+		return err
+	}
+	if gl.Timestamp_Format_Override != `` {
+		return errors.New("Timestamp-Format-Override is not supported, GELF messages carry an epoch timestamp")
+	}
+	gl.initDefaultTag()
+	if _, err := gl.defaultTag(); err != nil {
+		return err
+	}
+	gl.Tag_Field = strings.TrimSpace(gl.Tag_Field)
+	if len(gl.Tag_Match) > 0 && gl.Tag_Field == `` {
+		return ErrMissingGELFTagField
+	} else if _, err := gl.TagMatchers(); err != nil {
+		return err
+	}
+	if _, err := gl.chunkTimeout(); err != nil {
+		return err
+	}
+	if gl.Max_Message_Size == 0 {
+		gl.Max_Message_Size = defaultMaxObjectSize
+	}
+	if gl.Max_Chunked_Messages == 0 {
+		gl.Max_Chunked_Messages = defaultGELFMaxChunkedMessages
+	}
+	return nil
+}
+
+func (gl *gelfListener) initDefaultTag() {
+	if strings.TrimSpace(gl.Default_Tag) == `` {
+		if v := strings.TrimSpace(gl.Tag_Name); v != `` {
+			gl.Default_Tag = v
+		} else {
+			gl.Default_Tag = entry.DefaultTagName
+		}
+	}
+}
+
+func (gl gelfListener) defaultTag() (tag string, err error) {
+	return jsonListener{Default_Tag: gl.Default_Tag}.defaultTag()
+}
+
+func (gl gelfListener) chunkTimeout() (d time.Duration, err error) {
+	if v := strings.TrimSpace(gl.Chunk_Timeout); v == `` {
+		d = defaultGELFChunkTimeout
+	} else if d, err = time.ParseDuration(v); err != nil {
+		err = fmt.Errorf("Invalid Chunk-Timeout %q %w", v, err)
+	} else if d <= 0 {
+		err = fmt.Errorf("Invalid Chunk-Timeout %q, must be positive", v)
+	}
+	return
+}
+
+func (gl gelfListener) TagMatchers() (tags []TagMatcher, err error) {
+	var tm TagMatcher
+	for i := range gl.Tag_Match {
+		if tm.Value, tm.Tag, err = extractElementTag(gl.Tag_Match[i]); err != nil {
+			return
+		}
+		tags = append(tags, tm)
+	}
+	return
+}
+
+func (gl gelfListener) Tags() (tags []string, err error) {
+	var tms []TagMatcher
+	tags = []string{gl.Default_Tag}
+	if tms, err = gl.TagMatchers(); err != nil {
+		return
+	}
+	mp := map[string]bool{
+		gl.Default_Tag: true,
+	}
+	for _, tm := range tms {
+		if !mp[tm.Tag] {
+			mp[tm.Tag] = true
+			tags = append(tags, tm.Tag)
+		}
+	}
+	return
+}
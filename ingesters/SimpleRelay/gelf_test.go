@@ -0,0 +1,252 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const testGELFMsg = `{"version":"1.1","host":"web01","short_message":"hello","timestamp":1700000000.123456,"level":3,"facility":"nginx","_tag":"weblogs","_user_id":42,"_ratio":0.5,"_ok":true}`
+
+func gelfChunks(id string, msg []byte, size int) (pkts [][]byte) {
+	cnt := (len(msg) + size - 1) / size
+	for i := 0; i < cnt; i++ {
+		end := (i + 1) * size
+		if end > len(msg) {
+			end = len(msg)
+		}
+		pkt := append([]byte{0x1e, 0x0f}, []byte(id)...)
+		pkt = append(pkt, byte(i), byte(cnt))
+		pkts = append(pkts, append(pkt, msg[i*size:end]...))
+	}
+	return
+}
+
+func TestGELFEntry(t *testing.T) {
+	cfg := gelfHandlerConfig{
+		defTag:       1,
+		tagField:     `_tag`,
+		tags:         map[string]entry.EntryTag{`weblogs`: 2},
+		attachFields: true,
+	}
+	ent, err := cfg.buildEntry([]byte(testGELFMsg), net.ParseIP(`10.0.0.1`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ent.Tag != 2 {
+		t.Fatalf("bad tag %d", ent.Tag)
+	} else if string(ent.Data) != testGELFMsg {
+		t.Fatalf("bad data %q", ent.Data)
+	}
+	exp := time.Unix(1700000000, 123456000).UTC()
+	if !ent.TS.StandardTime().Equal(exp) {
+		t.Fatalf("bad timestamp %v != %v", ent.TS.StandardTime(), exp)
+	}
+	evs := map[string]interface{}{
+		`host`:     `web01`,
+		`level`:    int64(3),
+		`facility`: `nginx`,
+		`tag`:      `weblogs`,
+		`user_id`:  int64(42),
+		`ratio`:    0.5,
+		`ok`:       true,
+	}
+	for k, v := range evs {
+		if ev, ok := ent.GetEnumeratedValue(k); !ok {
+			t.Fatalf("missing EV %s", k)
+		} else if ev != v {
+			t.Fatalf("bad EV %s: %v(%T) != %v(%T)", k, ev, ev, v, v)
+		}
+	}
+	for _, k := range []string{`short_message`, `version`, `timestamp`} {
+		if _, ok := ent.GetEnumeratedValue(k); ok {
+			t.Fatalf("unexpected EV %s", k)
+		}
+	}
+
+	//unmatched routing value and no EVs
+	cfg.tagField = `host`
+	cfg.attachFields = false
+	if ent, err = cfg.buildEntry([]byte(testGELFMsg), nil); err != nil {
+		t.Fatal(err)
+	} else if ent.Tag != 1 || ent.EVB.Populated() {
+		t.Fatalf("bad default entry: %d %v", ent.Tag, ent.EVB.Populated())
+	}
+
+	if _, err = cfg.buildEntry([]byte(`not json`), nil); err == nil {
+		t.Fatal("failed to catch bad message")
+	}
+}
+
+func TestGELFTimestamp(t *testing.T) {
+	tsts := map[string]time.Time{
+		`1700000000`:            time.Unix(1700000000, 0),
+		`1700000000.5`:          time.Unix(1700000000, 500000000),
+		`1700000000.000000001`:  time.Unix(1700000000, 1),
+		`1700000000.0000000019`: time.Unix(1700000000, 1),
+		`1.7e9`:                 time.Unix(1700000000, 0),
+	}
+	for v, exp := range tsts {
+		ts, err := parseGELFTimestamp([]byte(v))
+		if err != nil {
+			t.Fatal(v, err)
+		} else if !ts.StandardTime().Equal(exp) {
+			t.Fatalf("%s: %v != %v", v, ts.StandardTime(), exp)
+		}
+	}
+}
+
+func TestGELFDecompress(t *testing.T) {
+	msg := []byte(testGELFMsg)
+	gzb := bytes.NewBuffer(nil)
+	gw := gzip.NewWriter(gzb)
+	gw.Write(msg)
+	gw.Close()
+	zb := bytes.NewBuffer(nil)
+	zw := zlib.NewWriter(zb)
+	zw.Write(msg)
+	zw.Close()
+
+	for _, b := range [][]byte{msg, gzb.Bytes(), zb.Bytes()} {
+		if r, err := gelfDecompress(b, 1024); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(r, msg) {
+			t.Fatalf("bad decompressed payload %q", r)
+		}
+	}
+	if _, err := gelfDecompress(zb.Bytes(), 16); err != ErrGELFOversized {
+		t.Fatalf("failed to catch oversized message: %v", err)
+	}
+}
+
+func TestGELFAssembler(t *testing.T) {
+	now := time.Now()
+	msg := []byte(testGELFMsg)
+	asm := newGELFAssembler(time.Second, 2, 1024)
+
+	//unchunked messages go straight through
+	if r, ok, err := asm.add(`a`, msg, now); err != nil || !ok || !bytes.Equal(r, msg) {
+		t.Fatalf("bad unchunked message: %v %v", ok, err)
+	}
+
+	//out of order chunks, interleaved with a duplicate
+	pkts := gelfChunks(`abcdefgh`, msg, 32)
+	order := []int{2, 0, 0}
+	for i := range pkts {
+		if i != 0 && i != 2 {
+			order = append(order, i)
+		}
+	}
+	for i, idx := range order {
+		r, ok, err := asm.add(`a`, pkts[idx], now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == len(order)-1 {
+			if !ok || !bytes.Equal(r, msg) {
+				t.Fatalf("bad reassembly: %q", r)
+			}
+		} else if ok {
+			t.Fatalf("message completed early at chunk %d", i)
+		}
+	}
+	if len(asm.pending) != 0 {
+		t.Fatal("completed message left pending")
+	}
+
+	//partial messages from different sources with the same ID are kept apart and expire
+	for _, src := range []string{`a`, `b`} {
+		if _, ok, err := asm.add(src, pkts[0], now); err != nil || ok {
+			t.Fatalf("bad partial: %v %v", ok, err)
+		}
+	}
+	if _, _, err := asm.add(`c`, pkts[0], now); err != ErrGELFTooManyChunks {
+		t.Fatalf("failed to catch too many pending: %v", err)
+	}
+	if n := asm.expire(now.Add(500 * time.Millisecond)); n != 0 {
+		t.Fatalf("expired too soon: %d", n)
+	} else if n = asm.expire(now.Add(2 * time.Second)); n != 2 {
+		t.Fatalf("bad expire count: %d", n)
+	}
+
+	//bad chunk headers
+	bad := [][]byte{
+		{0x1e, 0x0f, 1, 2, 3},
+		append([]byte{0x1e, 0x0f, 1, 2, 3, 4, 5, 6, 7, 8, 5, 5}, 'x'),
+		append([]byte{0x1e, 0x0f, 1, 2, 3, 4, 5, 6, 7, 8, 0, 129}, 'x'),
+	}
+	for i, b := range bad {
+		if _, _, err := asm.add(`a`, b, now); err != ErrGELFInvalidChunk {
+			t.Fatalf("failed to catch bad chunk %d: %v", i, err)
+		}
+	}
+
+	//oversized reassembly
+	asm = newGELFAssembler(time.Second, 2, 48)
+	for _, pkt := range pkts {
+		if _, _, err := asm.add(`a`, pkt, now); err == ErrGELFOversized {
+			return
+		}
+	}
+	t.Fatal("failed to catch oversized chunked message")
+}
+
+func TestGELFNullSplitter(t *testing.T) {
+	sc := bufio.NewScanner(strings.NewReader("{\"a\":1}\x00{\"b\":2}\x00\x00{\"c\":3}"))
+	sc.Split(nullSplitter)
+	var msgs []string
+	for sc.Scan() {
+		msgs = append(msgs, sc.Text())
+	}
+	if len(msgs) != 4 || msgs[0] != `{"a":1}` || msgs[2] != `` || msgs[3] != `{"c":3}` {
+		t.Fatalf("bad split: %q", msgs)
+	}
+}
+
+func TestGELFConfig(t *testing.T) {
+	gl := gelfListener{
+		baseConfig: baseConfig{Bind_String: `udp://0.0.0.0:12201`, Tag_Name: `gelf`},
+		Tag_Field:  `host`,
+		Tag_Match:  []string{`web01:web`, `db01:db`},
+	}
+	if err := gl.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if gl.Default_Tag != `gelf` || gl.Max_Message_Size != defaultMaxObjectSize || gl.Max_Chunked_Messages != defaultGELFMaxChunkedMessages {
+		t.Fatalf("bad defaults: %+v", gl)
+	}
+	if tags, err := gl.Tags(); err != nil {
+		t.Fatal(err)
+	} else if len(tags) != 3 {
+		t.Fatalf("bad tags: %v", tags)
+	}
+
+	bad := []gelfListener{
+		{},
+		{baseConfig: baseConfig{Bind_String: `sctp://:12201`}},
+		{baseConfig: baseConfig{Bind_String: `:12201`}, Tag_Match: []string{`web01:web`}},
+		{baseConfig: baseConfig{Bind_String: `:12201`}, Tag_Field: `host`, Tag_Match: []string{`web01:bad tag`}},
+		{baseConfig: baseConfig{Bind_String: `:12201`}, Chunk_Timeout: `-1s`},
+		{baseConfig: baseConfig{Bind_String: `:12201`, Timestamp_Format_Override: `RFC3339`}},
+	}
+	for i, b := range bad {
+		if err := b.Validate(); err == nil {
+			t.Fatalf("failed to catch bad config %d", i)
+		}
+	}
+}
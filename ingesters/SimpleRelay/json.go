@@ -122,7 +122,7 @@ func startJSONListeners(cfg *cfgType, igst *ingest.IngestMuxer, wg *sync.WaitGro
 			if err != nil {
 				return fmt.Errorf("%s Bind-String \"%s\" is invalid: %v\n", k, v.Bind_String, err)
 			}
-			l, err := net.ListenTCP("tcp", addr)
+			l, err := v.listen("tcp", addr, nil)
 			if err != nil {
 				return fmt.Errorf("%s Failed to listen on \"%s\": %v\n", k, addr, err)
 			}
@@ -145,7 +145,7 @@ func startJSONListeners(cfg *cfgType, igst *ingest.IngestMuxer, wg *sync.WaitGro
 			if err != nil {
 				lg.FatalCode(0, "invalid Bind-String", log.KV("bindstring", v.Bind_String), log.KV("jsonlistener", k), log.KVErr(err))
 			}
-			l, err := tls.Listen("tcp", addr.String(), config)
+			l, err := v.listen("tcp", addr, config)
 			if err != nil {
 				lg.FatalCode(0, "failed to listen via TLS", log.KV("address", addr), log.KV("jsonlistener", k), log.KVErr(err))
 			}
@@ -192,10 +192,11 @@ func jsonAcceptor(lst net.Listener, id int, igst *ingest.IngestMuxer, cfg jsonHa
 			}
 			continue
 		}
-		debugout("Accepted %v connection from %s in json mode\n", tp.String(), conn.RemoteAddr())
-		lg.Info("accepted connection", log.KV("address", conn.RemoteAddr()), log.KV("readertype", `json`), log.KV("mode", tp), log.KV("listener", cfg.name))
 		failCount = 0
-		go jsonConnHandler(conn, cfg, igst)
+		go func(conn net.Conn) {
+			logAccepted(conn, `json`, tp, cfg.name)
+			jsonConnHandler(conn, cfg, igst)
+		}(conn)
 	}
 	return
 }
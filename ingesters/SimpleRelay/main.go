@@ -104,6 +104,21 @@ func main() {
 		lg.FatalCode(0, "Failed to start json listeners", log.KV("ingesteruuid", id), log.KVErr(err))
 		return
 	}
+	//fire off our GELF listeners
+	if err := startGELFListeners(cfg, igst, wg, &flshr, ctx); err != nil {
+		lg.FatalCode(0, "Failed to start GELF listeners", log.KV("ingesteruuid", id), log.KVErr(err))
+		return
+	}
+	//fire off our fluent forward listeners
+	if err := startFluentListeners(cfg, igst, wg, &flshr, ctx); err != nil {
+		lg.FatalCode(0, "Failed to start fluent listeners", log.KV("ingesteruuid", id), log.KVErr(err))
+		return
+	}
+	//fire off our beats listeners
+	if err := startBeatsListeners(cfg, igst, wg, &flshr, ctx); err != nil {
+		lg.FatalCode(0, "Failed to start beats listeners", log.KV("ingesteruuid", id), log.KVErr(err))
+		return
+	}
 
 	lg.Info("Ingester running")
 
@@ -107,7 +107,7 @@ func startRegexListeners(cfg *cfgType, igst *ingest.IngestMuxer, wg *sync.WaitGr
 			if err != nil {
 				return fmt.Errorf("%s Bind-String \"%s\" is invalid: %v\n", k, v.Bind_String, err)
 			}
-			l, err := net.ListenTCP("tcp", addr)
+			l, err := v.listen("tcp", addr, nil)
 			if err != nil {
 				return fmt.Errorf("%s Failed to listen on \"%s\": %v\n", k, addr, err)
 			}
@@ -130,7 +130,7 @@ func startRegexListeners(cfg *cfgType, igst *ingest.IngestMuxer, wg *sync.WaitGr
 			if err != nil {
 				lg.FatalCode(0, "invalid Bind-String", log.KV("bindstring", v.Bind_String), log.KV("regexlistener", k), log.KVErr(err))
 			}
-			l, err := tls.Listen("tcp", addr.String(), config)
+			l, err := v.listen("tcp", addr, config)
 			if err != nil {
 				lg.FatalCode(0, "failed to listen via TLS", log.KV("address", addr), log.KV("regexlistener", k), log.KVErr(err))
 			}
@@ -176,10 +176,11 @@ func regexAcceptor(lst net.Listener, id int, igst *ingest.IngestMuxer, cfg regex
 			}
 			continue
 		}
-		debugout("Accepted %v connection from %s in regex mode\n", tp.String(), conn.RemoteAddr())
-		lg.Info("accepted connection", log.KV("address", conn.RemoteAddr()), log.KV("readertype", `regex`), log.KV("mode", tp), log.KV("listener", cfg.name))
 		failCount = 0
-		go regexConnHandler(conn, cfg, igst)
+		go func(conn net.Conn) {
+			logAccepted(conn, `regex`, tp, cfg.name)
+			regexConnHandler(conn, cfg, igst)
+		}(conn)
 	}
 	return
 }
@@ -0,0 +1,295 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/timegrinder"
+)
+
+// RELP (Reliable Event Logging Protocol) as spoken by rsyslog's omrelp module.
+// Every frame takes the form "TXNR SP COMMAND SP DATALEN [SP DATA] LF" and every
+// command sent by the client is acknowledged with an "rsp" frame carrying the same TXNR.
+const (
+	relpVersion     = `0`
+	relpSoftware    = `gravwell`
+	relpMaxTxnr     = 999999999
+	relpMaxCmdLen   = 32
+	relpMaxFieldLen = 9 //both the TXNR and DATALEN are at most 9 digits
+
+	relpCmdOpen   = `open`
+	relpCmdClose  = `close`
+	relpCmdSyslog = `syslog`
+	relpCmdRsp    = `rsp`
+
+	relpRspOK    = `200 OK`
+	relpRspError = `500`
+)
+
+var (
+	errRELPFrame     = errors.New("invalid RELP frame")
+	errRELPNotOpen   = errors.New("RELP session is not open")
+	errRELPTooLarge  = errors.New("RELP frame exceeds maximum size")
+	errRELPNoSyslog  = errors.New("RELP client does not support the syslog command")
+	errRELPBadOffers = errors.New("RELP open command is missing offers")
+)
+
+type relpFrame struct {
+	txnr int
+	cmd  string
+	data []byte
+}
+
+func relpConnHandlerTCP(c net.Conn, cfg handlerConfig) {
+	cfg.wg.Add(1)
+	id := addConn(c)
+	defer cfg.wg.Done()
+	defer delConn(id)
+	defer c.Close()
+	var rip net.IP
+	debugout("new RELP connection from %v\n", c.RemoteAddr().String())
+
+	if cfg.src == nil {
+		ipstr, _, err := net.SplitHostPort(c.RemoteAddr().String())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get host from rmote addr \"%s\": %v\n", c.RemoteAddr().String(), err)
+			return
+		}
+		if rip = net.ParseIP(ipstr); rip == nil {
+			fmt.Fprintf(os.Stderr, "Failed to get remote addr from \"%s\"\n", ipstr)
+			return
+		}
+	} else {
+		rip = cfg.src
+	}
+
+	tcfg := timegrinder.Config{
+		EnableLeftMostSeed: true,
+	}
+	tg, err := timegrinder.NewTimeGrinder(tcfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get a handle on the timegrinder: %v\n", err)
+		return
+	} else if err = cfg.timeFormats.LoadFormats(tg); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load custom time formats: %v\n", err)
+		return
+	}
+
+	if cfg.setLocalTime {
+		tg.SetLocalTime()
+	}
+	if cfg.timezoneOverride != `` {
+		err = tg.SetTimezone(cfg.timezoneOverride)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to set timezone to %v: %v\n", cfg.timezoneOverride, err)
+			return
+		}
+	}
+	if cfg.formatOverride != `` {
+		if err = tg.SetFormatOverride(cfg.formatOverride); err != nil {
+			lg.Error("Failed to load format override", log.KV("override", cfg.formatOverride), log.KVErr(err))
+			return
+		}
+	}
+	if err = relpLoop(c, c, cfg, rip, tg); err != nil {
+		lg.Info("RELP session closed", log.KV("listener", cfg.name), log.KV("remoteaddress", c.RemoteAddr().String()), log.KVErr(err))
+	}
+}
+
+// relpLoop services a single RELP session, entries are only acknowledged once the
+// processor set has handed them to the ingest muxer
+func relpLoop(rdr io.Reader, wtr io.Writer, cfg handlerConfig, rip net.IP, tg *timegrinder.TimeGrinder) (err error) {
+	br := bufio.NewReaderSize(rdr, initDataSize)
+	bw := bufio.NewWriter(wtr)
+	var open bool
+	for {
+		var f relpFrame
+		if f, err = readRELPFrame(br); err != nil {
+			if err == io.EOF {
+				err = nil //client hung up without a close, nothing we can do
+			}
+			return
+		}
+		switch f.cmd {
+		case relpCmdOpen:
+			if err = checkRELPOffers(f.data); err != nil {
+				writeRELPResponse(bw, f.txnr, relpRspError+` `+err.Error())
+				return
+			}
+			open = true
+			err = writeRELPResponse(bw, f.txnr, relpRspOK+"\nrelp_version="+relpVersion+"\nrelp_software="+relpSoftware+"\ncommands="+relpCmdSyslog)
+		case relpCmdSyslog:
+			if !open {
+				writeRELPResponse(bw, f.txnr, relpRspError+` `+errRELPNotOpen.Error())
+				return errRELPNotOpen
+			}
+			data := bytes.Trim(f.data, "\n\r\t \x00")
+			if cfg.dropPriority {
+				data = dropPriority(data)
+			}
+			if len(data) > 0 {
+				if err = relpHandleSyslog(data, cfg, rip, tg); err != nil {
+					//tell the client the entry was not accepted so that it can retransmit on a new session
+					writeRELPResponse(bw, f.txnr, relpRspError+` `+err.Error())
+					return
+				}
+			}
+			err = writeRELPResponse(bw, f.txnr, relpRspOK)
+		case relpCmdClose:
+			writeRELPResponse(bw, f.txnr, ``)
+			return
+		default:
+			//unknown commands are rejected but the session stays up
+			err = writeRELPResponse(bw, f.txnr, relpRspError+` unsupported command `+f.cmd)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func relpHandleSyslog(data []byte, cfg handlerConfig, rip net.IP, tg *timegrinder.TimeGrinder) error {
+	ent, err := handleLog(bytes.Clone(data), rip, cfg.ignoreTimestamps, cfg.tag, tg)
+	if err != nil {
+		return err
+	} else if ent == nil {
+		return nil
+	}
+	return cfg.proc.ProcessContext(ent, cfg.ctx)
+}
+
+// checkRELPOffers ensures the client offers the syslog command, offers are newline delimited name=value pairs
+func checkRELPOffers(data []byte) error {
+	if len(data) == 0 {
+		return errRELPBadOffers
+	}
+	for _, ln := range bytes.Split(data, []byte("\n")) {
+		name, val, _ := bytes.Cut(bytes.TrimSpace(ln), []byte("="))
+		if string(name) != `commands` {
+			continue
+		}
+		for _, cmd := range bytes.Split(val, []byte(",")) {
+			if string(bytes.TrimSpace(cmd)) == relpCmdSyslog {
+				return nil
+			}
+		}
+		return errRELPNoSyslog
+	}
+	//no commands offer means the client did not restrict anything
+	return nil
+}
+
+// readRELPFrame reads a single frame, the trailing LF is consumed
+func readRELPFrame(br *bufio.Reader) (f relpFrame, err error) {
+	var fld string
+	if fld, err = readRELPField(br, relpMaxFieldLen); err != nil {
+		return
+	}
+	if f.txnr, err = strconv.Atoi(fld); err != nil || f.txnr < 0 || f.txnr > relpMaxTxnr {
+		err = errRELPFrame
+		return
+	}
+	if f.cmd, err = readRELPField(br, relpMaxCmdLen); err != nil {
+		return
+	}
+	var sz int
+	var b byte
+	//DATALEN is terminated by a space when data follows or by the trailer when it is zero
+	for i := 0; ; i++ {
+		if b, err = br.ReadByte(); err != nil {
+			err = relpEOF(err)
+			return
+		}
+		if b == ' ' || b == '\n' {
+			if i == 0 {
+				err = errRELPFrame
+				return
+			}
+			break
+		} else if b < '0' || b > '9' || i >= relpMaxFieldLen {
+			err = errRELPFrame
+			return
+		}
+		sz = sz*10 + int(b-'0')
+	}
+	if sz > maxDataSize {
+		err = errRELPTooLarge
+		return
+	}
+	if b == '\n' {
+		if sz != 0 {
+			err = errRELPFrame
+		}
+		return
+	}
+	f.data = make([]byte, sz)
+	if _, err = io.ReadFull(br, f.data); err != nil {
+		err = relpEOF(err)
+		return
+	}
+	if b, err = br.ReadByte(); err != nil {
+		err = relpEOF(err)
+	} else if b != '\n' {
+		err = errRELPFrame
+	}
+	return
+}
+
+// readRELPField reads a space terminated header field, leading newlines are tolerated between frames
+func readRELPField(br *bufio.Reader, max int) (string, error) {
+	var fld []byte
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			if len(fld) > 0 {
+				err = relpEOF(err)
+			}
+			return ``, err
+		}
+		if b == ' ' {
+			if len(fld) == 0 {
+				return ``, errRELPFrame
+			}
+			return string(fld), nil
+		} else if b == '\n' || b == '\r' {
+			if len(fld) == 0 {
+				continue
+			}
+			return ``, errRELPFrame
+		} else if len(fld) >= max {
+			return ``, errRELPFrame
+		}
+		fld = append(fld, b)
+	}
+}
+
+func relpEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func writeRELPResponse(bw *bufio.Writer, txnr int, msg string) error {
+	if msg == `` {
+		fmt.Fprintf(bw, "%d %s 0\n", txnr, relpCmdRsp)
+	} else {
+		fmt.Fprintf(bw, "%d %s %d %s\n", txnr, relpCmdRsp, len(msg), msg)
+	}
+	return bw.Flush()
+}
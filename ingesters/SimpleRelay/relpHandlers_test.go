@@ -0,0 +1,152 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+	"github.com/gravwell/gravwell/v3/timegrinder"
+)
+
+func relpFrameBytes(txnr int, cmd, data string) string {
+	if data == `` {
+		return fmt.Sprintf("%d %s 0\n", txnr, cmd)
+	}
+	return fmt.Sprintf("%d %s %d %s\n", txnr, cmd, len(data), data)
+}
+
+type failWriter struct {
+	nilWriter
+}
+
+func (f *failWriter) WriteEntryContext(context.Context, *entry.Entry) error {
+	return errors.New("muxer is gone")
+}
+
+func runRELP(t *testing.T, input string, fail bool) (*tracker, []string, error) {
+	t.Helper()
+	lg = log.New(os.Stderr)
+	trk := &tracker{}
+	cfg := handlerConfig{
+		tag:          1,
+		ctx:          context.Background(),
+		dropPriority: true,
+	}
+	if fail {
+		cfg.proc = processors.NewProcessorSet(&failWriter{})
+	} else {
+		cfg.proc = processors.NewProcessorSet(&nilWriter{})
+	}
+	cfg.proc.AddProcessor(trk)
+	tg, err := timegrinder.New(timegrinder.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := bytes.NewBuffer(nil)
+	err = relpLoop(strings.NewReader(input), out, cfg, net.ParseIP(`10.0.0.1`), tg)
+	var rsps []string
+	s := bufio.NewScanner(out)
+	for s.Scan() {
+		rsps = append(rsps, s.Text())
+	}
+	return trk, rsps, err
+}
+
+func TestRELPSession(t *testing.T) {
+	offers := "relp_version=0\nrelp_software=librelp,1.10.0,http://librelp.adiscon.com\ncommands=syslog"
+	input := relpFrameBytes(1, `open`, offers) +
+		relpFrameBytes(2, `syslog`, `<13>Jun  1 12:00:00 host app: hello`) +
+		relpFrameBytes(3, `syslog`, "<13>Jun  1 12:00:01 host app: multi\nline") +
+		relpFrameBytes(4, `starttls`, ``) +
+		relpFrameBytes(5, `close`, ``)
+	trk, rsps, err := runRELP(t, input, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`1 rsp 60 200 OK`, `relp_version=0`, `relp_software=gravwell`, `commands=syslog`,
+		`2 rsp 6 200 OK`,
+		`3 rsp 6 200 OK`,
+		`4 rsp 32 500 unsupported command starttls`,
+		`5 rsp 0`,
+	}
+	if len(rsps) != len(expected) {
+		t.Fatalf("bad response count %d != %d: %v", len(rsps), len(expected), rsps)
+	}
+	for i := range expected {
+		if rsps[i] != expected[i] {
+			t.Fatalf("bad response %d %q != %q", i, rsps[i], expected[i])
+		}
+	}
+	if len(trk.ents) != 2 {
+		t.Fatalf("bad entry count %d", len(trk.ents))
+	}
+	if string(trk.ents[0].Data) != `Jun  1 12:00:00 host app: hello` {
+		t.Fatalf("priority was not dropped: %q", trk.ents[0].Data)
+	} else if string(trk.ents[1].Data) != "Jun  1 12:00:01 host app: multi\nline" {
+		t.Fatalf("bad multiline entry: %q", trk.ents[1].Data)
+	} else if !trk.ents[0].SRC.Equal(net.ParseIP(`10.0.0.1`)) {
+		t.Fatalf("bad source %v", trk.ents[0].SRC)
+	}
+}
+
+func TestRELPNotOpen(t *testing.T) {
+	trk, rsps, err := runRELP(t, relpFrameBytes(1, `syslog`, `test`), false)
+	if err != errRELPNotOpen {
+		t.Fatalf("bad error %v", err)
+	} else if len(rsps) != 1 || !strings.HasPrefix(rsps[0], `1 rsp `) || !strings.Contains(rsps[0], ` 500 `) {
+		t.Fatalf("bad responses %v", rsps)
+	} else if len(trk.ents) != 0 {
+		t.Fatal("entry was accepted on an unopened session")
+	}
+}
+
+func TestRELPNoAckOnFailure(t *testing.T) {
+	input := relpFrameBytes(1, `open`, `commands=syslog`) +
+		relpFrameBytes(2, `syslog`, `test 1`) +
+		relpFrameBytes(3, `syslog`, `test 2`)
+	_, rsps, err := runRELP(t, input, true)
+	if err == nil {
+		t.Fatal("failed to surface ingest error")
+	}
+	if len(rsps) != 5 {
+		t.Fatalf("bad response count %v", rsps)
+	} else if !strings.HasPrefix(rsps[4], `2 rsp `) || !strings.Contains(rsps[4], ` 500 `) {
+		t.Fatalf("failed entry was acknowledged: %v", rsps[4])
+	}
+}
+
+func TestRELPBadFrames(t *testing.T) {
+	bad := []string{
+		"1 open\n",
+		"x open 0\n",
+		"1 open 5 abc\n",
+		"1 open 3 abcd\n",
+		"1234567890 open 0\n",
+		"1 open 3\n",
+		fmt.Sprintf("1 syslog %d ", maxDataSize+1),
+		relpFrameBytes(1, `open`, "relp_version=0\ncommands=foo"),
+	}
+	for _, b := range bad {
+		if _, _, err := runRELP(t, b, false); err == nil {
+			t.Fatalf("failed to catch bad frame %q", b)
+		}
+	}
+}
@@ -109,7 +109,7 @@ func startSimpleListeners(cfg *cfgType, igst *ingest.IngestMuxer, wg *sync.WaitG
 			if err != nil {
 				return fmt.Errorf("%s Bind-String \"%s\" is invalid: %v\n", k, v.Bind_String, err)
 			}
-			l, err := net.ListenTCP(tp.String(), addr)
+			l, err := v.listen(tp.String(), addr, nil)
 			if err != nil {
 				return fmt.Errorf("%s Failed to listen on \"%s\": %v\n", k, addr, err)
 			}
@@ -132,7 +132,7 @@ func startSimpleListeners(cfg *cfgType, igst *ingest.IngestMuxer, wg *sync.WaitG
 			if err != nil {
 				lg.FatalCode(0, "invalid Bind-String", log.KV("bindstring", v.Bind_String), log.KV("listener", k), log.KVErr(err))
 			}
-			l, err := tls.Listen("tcp", addr.String(), config)
+			l, err := v.listen("tcp", addr, config)
 			if err != nil {
 				lg.FatalCode(0, "failed to listen via TLS", log.KV("address", addr), log.KV("listener", k), log.KVErr(err))
 			}
@@ -177,23 +177,36 @@ func acceptor(lst net.Listener, id int, igst *ingest.IngestMuxer, cfg handlerCon
 			}
 			continue
 		}
-		debugout("Accepted %v connection from %s in %v mode\n", conn.RemoteAddr(), cfg.lrt, tp.String())
-		lg.Info("accepted connection", log.KV("address", conn.RemoteAddr()), log.KV("readertype", cfg.lrt), log.KV("mode", tp), log.KV("listener", cfg.name))
 		failCount = 0
+		var handler func(net.Conn, handlerConfig)
 		switch cfg.lrt {
 		case lineReader:
-			go lineConnHandlerTCP(conn, cfg)
+			handler = lineConnHandlerTCP
 		case rfc5424Reader:
-			go rfc5424ConnHandlerTCP(conn, cfg)
+			handler = rfc5424ConnHandlerTCP
 		case rfc6587Reader:
-			go rfc6587ConnHandlerTCP(conn, cfg)
+			handler = rfc6587ConnHandlerTCP
+		case relpReader:
+			handler = relpConnHandlerTCP
 		default:
 			lg.Error("invalid reader type", log.KV("readertype", cfg.lrt))
+			conn.Close()
 			return
 		}
+		go func(conn net.Conn) {
+			logAccepted(conn, cfg.lrt, tp, cfg.name)
+			handler(conn, cfg)
+		}(conn)
 	}
 }
 
+// logAccepted must be called from the connection goroutine, the remote address of a
+// PROXY protocol connection is not known until its header arrives
+func logAccepted(conn net.Conn, rt interface{}, tp bindType, name string) {
+	debugout("Accepted %v connection from %s in %v mode\n", tp.String(), conn.RemoteAddr(), rt)
+	lg.Info("accepted connection", log.KV("address", conn.RemoteAddr()), log.KV("readertype", rt), log.KV("mode", tp), log.KV("listener", name))
+}
+
 func acceptorUDP(conn *net.UDPConn, id int, cfg handlerConfig, igst *ingest.IngestMuxer) {
 	defer cfg.wg.Done()
 	defer delConn(id)
@@ -0,0 +1,370 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/attach"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+	"github.com/gravwell/gravwell/v3/ingest/processors/tags"
+	"github.com/gravwell/gravwell/v3/timegrinder"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	defaultPrefetch  int = 512
+	defaultBatchSize int = 512
+	defaultSRCHeader     = `SRC`
+	defaultTagHeader     = `TAG`
+	defaultQueueType     = amqp.QueueTypeClassic
+)
+
+type ConfigConsumer struct {
+	URL              string
+	Username         string
+	Password         string `json:"-"` //DO NOT send this when marshalling
+	Queue            string
+	Declare_Queue    bool   //declare a durable queue if it does not exist
+	Queue_Type       string //classic, quorum, or stream, only used with Declare-Queue
+	Consumer_Tag     string
+	Exclusive        bool
+	Prefetch         int
+	Stream_Offset    string //first, last, next, or an offset, only valid on stream queues
+	Source_Override  string
+	Source_Header    string
+	Tag_Header       string
+	Source_As_Binary bool
+	Synchronous      bool
+	Batch_Size       int
+	Default_Tag      string
+
+	tags.TaggerConfig
+
+	//TLS stuff, TLS is enabled with an amqps:// URL
+	Insecure_Skip_TLS_Verify bool
+
+	//consumer configs
+	Ignore_Timestamps         bool //Just apply the current timestamp to messages as we get them
+	Extract_Timestamps        bool //Ignore the AMQP timestamp, use timegrinder
+	Assume_Local_Timezone     bool
+	Timezone_Override         string
+	Timestamp_Format_Override string //override the timestamp format
+
+	//list of preprocessors to run
+	Preprocessor []string
+}
+
+type consumerCfg struct {
+	tags.TaggerConfig
+	defTag       string
+	url          string
+	uri          amqp.URI
+	username     string
+	password     string
+	queue        string
+	declare      bool
+	queueType    string
+	consumerTag  string
+	exclusive    bool
+	prefetch     int
+	streamOffset interface{}
+	sync         bool
+	batchSize    int
+	srcKey       string
+	tagKey       string
+	srcBin       bool
+	srcOverride  net.IP
+	skipVerify   bool
+
+	//consumer configs for timestamps and time grinding
+	ignoreTS     bool
+	extractTS    bool
+	tg           *timegrinder.TimeGrinder
+	preprocessor []string
+}
+
+type cfgReadType struct {
+	Global       config.IngestConfig
+	Attach       attach.AttachConfig
+	Consumer     map[string]*ConfigConsumer
+	Preprocessor processors.ProcessorConfig
+	TimeFormat   config.CustomTimeFormat
+}
+
+type cfgType struct {
+	config.IngestConfig
+	Attach       attach.AttachConfig
+	Consumers    map[string]*consumerCfg
+	Preprocessor processors.ProcessorConfig
+	TimeFormat   config.CustomTimeFormat
+}
+
+func GetConfig(path, overlayPath string) (*cfgType, error) {
+	var cr cfgReadType
+	if err := config.LoadConfigFile(&cr, path); err != nil {
+		return nil, err
+	} else if err = config.LoadConfigOverlays(&cr, overlayPath); err != nil {
+		return nil, err
+	}
+
+	c := &cfgType{
+		IngestConfig: cr.Global,
+		Attach:       cr.Attach,
+		Consumers:    make(map[string]*consumerCfg, len(cr.Consumer)),
+		Preprocessor: cr.Preprocessor,
+		TimeFormat:   cr.TimeFormat,
+	}
+
+	for k, v := range cr.Consumer {
+		if err := c.Preprocessor.CheckProcessors(v.Preprocessor); err != nil {
+			return nil, fmt.Errorf("Consumer %s preprocessor invalid: %v", k, err)
+		}
+		cnsmr, err := v.validateAndProcess(k)
+		if err != nil {
+			return nil, fmt.Errorf("Consumer %s is invalid: %w", k, err)
+		}
+		//the timegrinder only exists when we are extracting timestamps
+		if cnsmr.tg != nil {
+			if err = c.TimeFormat.LoadFormats(cnsmr.tg); err != nil {
+				return nil, err
+			} else if v.Timestamp_Format_Override != `` {
+				if err = cnsmr.tg.SetFormatOverride(v.Timestamp_Format_Override); err != nil {
+					return nil, err
+				}
+			}
+		}
+		c.Consumers[k] = &cnsmr
+	}
+	if err := c.Verify(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *cfgType) Verify() error {
+	//validate the global params
+	if err := c.IngestConfig.Verify(); err != nil {
+		return err
+	} else if err = c.Attach.Verify(); err != nil {
+		return err
+	} else if len(c.Consumers) == 0 {
+		return errors.New("no consumers defined")
+	} else if err = c.Preprocessor.Validate(); err != nil {
+		return err
+	} else if err = c.TimeFormat.Validate(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *cfgType) Tags() (tags []string, err error) {
+	tagMp := make(map[string]bool, len(c.Consumers))
+	for name, v := range c.Consumers {
+		var ltags []string
+		if v.defTag == `` {
+			err = fmt.Errorf("Consumer %s is missing Default-Tag definition", name)
+			return
+		} else if err = ingest.CheckTag(v.defTag); err != nil {
+			err = fmt.Errorf("Consumer %s Default-Tag is invalid: %v", name, err)
+			return
+		} else if _, ok := tagMp[v.defTag]; !ok {
+			tags = append(tags, v.defTag)
+			tagMp[v.defTag] = true
+		}
+		if ltags, _, err = v.TaggerConfig.TagSet(); err != nil {
+			return
+		}
+		for _, lt := range ltags {
+			if _, ok := tagMp[lt]; !ok {
+				tags = append(tags, lt)
+				tagMp[lt] = true
+			}
+		}
+	}
+
+	if len(tags) == 0 {
+		err = errors.New("No tags specified")
+	} else {
+		sort.Strings(tags)
+	}
+	return
+}
+
+func (c *cfgType) IngestBaseConfig() config.IngestConfig {
+	return c.IngestConfig
+}
+
+func (c *cfgType) AttachConfig() attach.AttachConfig {
+	return c.Attach
+}
+
+func (cc ConfigConsumer) validateAndProcess(name string) (c consumerCfg, err error) {
+	//check tag
+	if len(cc.Default_Tag) == 0 {
+		err = errors.New("missing Default-Tag")
+		return
+	} else if err = ingest.CheckTag(cc.Default_Tag); err != nil {
+		return
+	} else if err = cc.TaggerConfig.Validate(); err != nil {
+		return
+	}
+	c.defTag = cc.Default_Tag
+	c.TaggerConfig = cc.TaggerConfig
+	if cc.Source_Header == `` {
+		cc.Source_Header = defaultSRCHeader
+	}
+	if cc.Tag_Header == `` {
+		cc.Tag_Header = defaultTagHeader
+	}
+	c.srcKey = cc.Source_Header
+	c.tagKey = cc.Tag_Header
+	c.srcBin = cc.Source_As_Binary
+
+	//check the broker URL, the library handles defaults for ports and vhosts
+	if cc.URL == `` {
+		err = errors.New("missing URL")
+		return
+	} else if c.uri, err = amqp.ParseURI(cc.URL); err != nil {
+		err = fmt.Errorf("invalid URL - %w", err) //do not echo the URL, it may hold credentials
+		return
+	}
+	c.url = cc.URL
+	c.skipVerify = cc.Insecure_Skip_TLS_Verify
+	if cc.Password != `` && cc.Username == `` {
+		err = errors.New("Password requires a Username")
+		return
+	}
+	c.username = cc.Username
+	c.password = cc.Password
+
+	//check the queue
+	if c.queue = strings.TrimSpace(cc.Queue); c.queue == `` {
+		err = errors.New("missing Queue name")
+		return
+	}
+	c.declare = cc.Declare_Queue
+	if c.queueType, err = cc.queueType(); err != nil {
+		return
+	}
+	if c.streamOffset, err = cc.streamOffset(); err != nil {
+		return
+	} else if c.streamOffset != nil && c.declare && c.queueType != amqp.QueueTypeStream {
+		err = errors.New("Stream-Offset requires a stream queue")
+		return
+	}
+	if c.consumerTag = cc.Consumer_Tag; c.consumerTag == `` {
+		c.consumerTag = `gravwell-` + name
+	}
+	c.exclusive = cc.Exclusive
+
+	//prefetch bounds the number of unacknowledged messages we can have in flight
+	if cc.Prefetch < 0 {
+		err = fmt.Errorf("invalid Prefetch %d", cc.Prefetch)
+		return
+	} else if c.prefetch = cc.Prefetch; c.prefetch == 0 {
+		c.prefetch = defaultPrefetch
+	}
+	if c.batchSize = cc.Batch_Size; c.batchSize <= 0 {
+		c.batchSize = defaultBatchSize
+	}
+	//a batch larger than the prefetch would only ever be flushed by the timer
+	if c.batchSize > c.prefetch {
+		c.batchSize = c.prefetch
+	}
+
+	//just set the sync
+	c.sync = cc.Synchronous
+
+	// check that the source override is valid
+	if len(cc.Source_Override) > 0 {
+		if c.srcOverride = net.ParseIP(cc.Source_Override); c.srcOverride == nil {
+			err = fmt.Errorf("Invalid source override %s", cc.Source_Override)
+			return
+		}
+	}
+
+	if cc.Timezone_Override != "" {
+		if cc.Assume_Local_Timezone {
+			// cannot do both
+			err = fmt.Errorf("Cannot specify Assume-Local-Timezone and Timezone-Override in the same consumer")
+			return
+		}
+		if _, err = time.LoadLocation(cc.Timezone_Override); err != nil {
+			err = fmt.Errorf("Invalid timezone override %v in consumer: %v", cc.Timezone_Override, err)
+			return
+		}
+	}
+	if cc.Ignore_Timestamps {
+		c.ignoreTS = true
+	} else if cc.Extract_Timestamps {
+		c.extractTS = true
+		tcfg := timegrinder.Config{
+			EnableLeftMostSeed: true,
+			FormatOverride:     cc.Timestamp_Format_Override,
+		}
+		if c.tg, err = timegrinder.NewTimeGrinder(tcfg); err != nil {
+			err = fmt.Errorf("Failed to generate new timegrinder: %v", err)
+			return
+		}
+		if cc.Assume_Local_Timezone {
+			c.tg.SetLocalTime()
+		}
+		if cc.Timezone_Override != `` {
+			if err = c.tg.SetTimezone(cc.Timezone_Override); err != nil {
+				err = fmt.Errorf("Failed to override timezone: %v", err)
+				return
+			}
+		}
+	}
+
+	c.preprocessor = cc.Preprocessor
+	return
+}
+
+func (cc ConfigConsumer) queueType() (qt string, err error) {
+	switch qt = strings.ToLower(strings.TrimSpace(cc.Queue_Type)); qt {
+	case ``:
+		qt = defaultQueueType
+	case amqp.QueueTypeClassic, amqp.QueueTypeQuorum, amqp.QueueTypeStream:
+	default:
+		err = fmt.Errorf("Unknown Queue-Type %q", cc.Queue_Type)
+		return
+	}
+	if cc.Queue_Type != `` && !cc.Declare_Queue {
+		err = errors.New("Queue-Type requires Declare-Queue")
+	}
+	return
+}
+
+// streamOffset returns the x-stream-offset consumer argument, nil means the broker default
+func (cc ConfigConsumer) streamOffset() (v interface{}, err error) {
+	so := strings.ToLower(strings.TrimSpace(cc.Stream_Offset))
+	switch so {
+	case ``:
+	case `first`, `last`, `next`:
+		v = so
+	default:
+		var off int64
+		if off, err = strconv.ParseInt(so, 10, 64); err != nil || off < 0 {
+			err = fmt.Errorf("invalid Stream-Offset %q", cc.Stream_Offset)
+			return
+		}
+		v = off
+	}
+	return
+}
@@ -0,0 +1,281 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+	"github.com/gravwell/gravwell/v3/ingest/processors/tags"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	globalConfig = `
+[Global]
+Ingest-Secret = IngestSecrets
+Connection-Timeout = 0
+Cleartext-Backend-target=127.0.0.1:4023
+Log-Level=INFO
+`
+	baseConfig = globalConfig + `
+[Consumer "default"]
+	URL="amqp://rabbitmq.example.com/"
+	Queue=audit
+	Default-Tag=foo
+	Tags=bar*
+	Tags=*baz
+
+[Consumer "stream"]
+	URL="amqps://rabbitmq.example.com/vhost"
+	Username=gravwell
+	Password=secret
+	Queue=audit-stream
+	Declare-Queue=true
+	Queue-Type=stream
+	Stream-Offset=1234
+	Prefetch=100
+	Batch-Size=1000
+	Default-Tag=foo
+	Tags=bar
+	Tag-Header=tag
+	Source-Header=src
+	Source-As-Binary=true
+`
+)
+
+func writeConfig(t *testing.T, v string) string {
+	t.Helper()
+	pth := filepath.Join(t.TempDir(), `amqp.conf`)
+	if err := os.WriteFile(pth, []byte(v), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return pth
+}
+
+func TestBasicConfig(t *testing.T) {
+	cfg, err := GetConfig(writeConfig(t, baseConfig), ``)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Consumers) != 2 {
+		t.Fatalf("invalid consumer count: %d != 2", len(cfg.Consumers))
+	}
+	if tags, err := cfg.Tags(); err != nil {
+		t.Fatal(err)
+	} else if strings.Join(tags, `,`) != `bar,foo` {
+		t.Fatalf("bad tags %v", tags)
+	}
+	def := cfg.Consumers[`default`]
+	if def.prefetch != defaultPrefetch || def.batchSize != defaultBatchSize || def.consumerTag != `gravwell-default` {
+		t.Fatalf("bad defaults %d %d %q", def.prefetch, def.batchSize, def.consumerTag)
+	} else if def.srcKey != defaultSRCHeader || def.tagKey != defaultTagHeader || def.streamOffset != nil {
+		t.Fatalf("bad header defaults %q %q %v", def.srcKey, def.tagKey, def.streamOffset)
+	} else if def.uri.Port != 5672 || def.uri.Vhost != `/` {
+		t.Fatalf("bad URI %+v", def.uri)
+	}
+	st := cfg.Consumers[`stream`]
+	if st.batchSize != 100 {
+		t.Fatalf("batch size was not clamped to the prefetch: %d", st.batchSize)
+	} else if st.queueType != amqp.QueueTypeStream || st.streamOffset != int64(1234) {
+		t.Fatalf("bad stream settings %q %v", st.queueType, st.streamOffset)
+	} else if st.uri.Port != 5671 || st.uri.Vhost != `vhost` {
+		t.Fatalf("bad TLS URI %+v", st.uri)
+	}
+}
+
+func TestBadConfigs(t *testing.T) {
+	cons := `
+[Consumer "c"]
+	URL="amqp://127.0.0.1"
+	Queue=q
+	Default-Tag=foo
+	Tags=foo
+`
+	bad := []string{
+		``,                               //no consumers
+		cons + `	URL="http://127.0.0.1"`, //bad scheme
+		cons + `	Queue=" "`,
+		cons + `	Tags="bad tag"`,
+		cons + `	Password=foo`,      //no username
+		cons + `	Queue-Type=quorum`, //requires Declare-Queue
+		cons + `	Declare-Queue=true
+	Queue-Type=lazy`,
+		cons + `	Declare-Queue=true
+	Queue-Type=quorum
+	Stream-Offset=first`,
+		cons + `	Stream-Offset=yesterday`,
+		cons + `	Stream-Offset=-1`,
+		cons + `	Prefetch=-1`,
+		cons + `	Source-Override=foo`,
+		cons + `	Assume-Local-Timezone=true
+	Timezone-Override="America/Denver"`,
+		`
+[Consumer "c"]
+	URL="amqp://127.0.0.1"
+	Queue=q
+	Tags=foo
+`, //no default tag
+	}
+	for i, v := range bad {
+		if _, err := GetConfig(writeConfig(t, globalConfig+v), ``); err == nil {
+			t.Fatalf("bad config %d was accepted:\n%s", i, v)
+		}
+	}
+}
+
+type testResolver map[string]entry.EntryTag
+
+func (tr testResolver) NegotiateTag(tn string) (entry.EntryTag, error) {
+	if err := ingest.CheckTag(tn); err != nil {
+		return 0, err
+	}
+	tg, ok := tr[tn]
+	if !ok {
+		tg = entry.EntryTag(len(tr) + 1)
+		tr[tn] = tg
+	}
+	return tg, nil
+}
+
+type testWriter struct {
+	sync.Mutex
+	ents []*entry.Entry
+	err  error
+}
+
+func (tw *testWriter) WriteEntry(ent *entry.Entry) error {
+	tw.Lock()
+	defer tw.Unlock()
+	if tw.err != nil {
+		return tw.err
+	}
+	tw.ents = append(tw.ents, ent)
+	return nil
+}
+
+func (tw *testWriter) WriteEntryContext(ctx context.Context, ent *entry.Entry) error {
+	return tw.WriteEntry(ent)
+}
+
+func (tw *testWriter) WriteBatch(ents []*entry.Entry) error {
+	for _, ent := range ents {
+		if err := tw.WriteEntry(ent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tw *testWriter) WriteBatchContext(ctx context.Context, ents []*entry.Entry) error {
+	return tw.WriteBatch(ents)
+}
+
+type testAcker struct {
+	acks []uint64
+}
+
+func (ta *testAcker) Ack(tag uint64, multiple bool) error {
+	if !multiple {
+		return errors.New("expected a multiple ack")
+	}
+	ta.acks = append(ta.acks, tag)
+	return nil
+}
+
+func (ta *testAcker) Nack(tag uint64, multiple bool, requeue bool) error {
+	return errors.New("unexpected nack")
+}
+
+func (ta *testAcker) Reject(tag uint64, requeue bool) error {
+	return errors.New("unexpected reject")
+}
+
+func TestFlush(t *testing.T) {
+	tr := testResolver{}
+	tgr, err := tags.NewTagger(tags.TaggerConfig{Tags: []string{`default`, `audit-*`}}, tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tw testWriter
+	ac, err := newAmqpConsumer(amqpConsumerConfig{
+		consumerCfg: consumerCfg{srcKey: defaultSRCHeader, tagKey: defaultTagHeader},
+		defTag:      tr[`default`],
+		igst:        &ingest.IngestMuxer{},
+		lg:          log.NewDiscardLogger(),
+		pproc:       processors.NewProcessorSet(&tw),
+		tgr:         tgr,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ac.Close()
+	ac.src = net.ParseIP(`10.0.0.1`)
+
+	ts := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	ta := &testAcker{}
+	batch := []amqp.Delivery{
+		{Acknowledger: ta, DeliveryTag: 1, Body: []byte(`a`), Timestamp: ts, Headers: amqp.Table{`TAG`: `audit-login`, `SRC`: `192.168.1.1`}},
+		{Acknowledger: ta, DeliveryTag: 2, Body: []byte(`b`), Headers: amqp.Table{`TAG`: []byte(`secret`)}},    //disallowed
+		{Acknowledger: ta, DeliveryTag: 3, Body: []byte(`c`), Headers: amqp.Table{`TAG`: `bad tag`, `SRC`: 1}}, //invalid
+	}
+	if err = ac.flush(batch); err != nil {
+		t.Fatal(err)
+	}
+	if len(ta.acks) != 1 || ta.acks[0] != 3 {
+		t.Fatalf("batch was not acknowledged through the last delivery: %v", ta.acks)
+	} else if len(tw.ents) != 3 || ac.count != 3 {
+		t.Fatalf("bad entry count %d %d", len(tw.ents), ac.count)
+	}
+	if ent := tw.ents[0]; ent.Tag != tr[`audit-login`] || !ent.SRC.Equal(net.ParseIP(`192.168.1.1`)) || !ent.TS.StandardTime().Equal(ts) {
+		t.Fatalf("bad header routing %+v", ent)
+	}
+	for _, ent := range tw.ents[1:] {
+		if ent.Tag != tr[`default`] || !ent.SRC.Equal(ac.src) {
+			t.Fatalf("bad default routing %+v", ent)
+		}
+	}
+	if _, ok := tr[`secret`]; ok {
+		t.Fatal("disallowed tag was negotiated")
+	}
+
+	//failed writes must not be acknowledged
+	tw.err = errors.New("nope")
+	if err = ac.flush([]amqp.Delivery{{Acknowledger: ta, DeliveryTag: 4, Body: []byte(`d`)}}); err == nil {
+		t.Fatal("writer error was not returned")
+	} else if len(ta.acks) != 1 {
+		t.Fatalf("failed batch was acknowledged: %v", ta.acks)
+	}
+}
+
+func TestExtractSrc(t *testing.T) {
+	ac := &amqpConsumer{}
+	if ip := ac.extractSrc([]byte(`::1`)); !ip.Equal(net.IPv6loopback) {
+		t.Fatalf("bad text source %v", ip)
+	} else if ip = ac.extractSrc([]byte{10, 0, 0, 1}); ip != nil {
+		t.Fatalf("binary source accepted without Source-As-Binary: %v", ip)
+	}
+	ac.srcBin = true
+	if ip := ac.extractSrc([]byte{10, 0, 0, 1}); !ip.Equal(net.IPv4(10, 0, 0, 1)) {
+		t.Fatalf("bad binary source %v", ip)
+	} else if ip = ac.extractSrc([]byte(`10.0.0.2`)); !ip.Equal(net.IPv4(10, 0, 0, 2)) {
+		t.Fatalf("bad text source with Source-As-Binary %v", ip)
+	}
+}
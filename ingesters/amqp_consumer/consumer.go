@@ -0,0 +1,359 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+	"github.com/gravwell/gravwell/v3/ingest/processors/tags"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	ipv4Len         = 4
+	ipv6Len         = 16
+	minTLSVersion   = tls.VersionTLS12
+	streamOffsetArg = `x-stream-offset`
+	schemeTLS       = `amqps`
+	reconnectDelay  = 5 * time.Second
+	flushInterval   = time.Second
+)
+
+var (
+	errConnClosed     = errors.New("connection closed")
+	errDeliveryClosed = errors.New("delivery channel closed")
+)
+
+type closer interface {
+	Close() error
+}
+
+type closers struct {
+	mtx sync.Mutex
+	wg  sync.WaitGroup
+	set []closer
+}
+
+func newClosers() *closers {
+	return &closers{}
+}
+
+func (c *closers) add(v closer) (wg *sync.WaitGroup) {
+	if v == nil {
+		return nil
+	}
+	c.mtx.Lock()
+	c.set = append(c.set, v)
+	wg = &c.wg
+	c.mtx.Unlock()
+	return
+}
+
+func (c *closers) Close() (err error) {
+	c.mtx.Lock()
+	for _, v := range c.set {
+		err = errors.Join(err, v.Close())
+	}
+	c.wg.Wait()
+	c.set = nil
+	c.mtx.Unlock()
+	return
+}
+
+type amqpConsumer struct {
+	amqpConsumerConfig
+	mtx     sync.Mutex
+	started bool
+	ctx     context.Context
+	cf      context.CancelFunc
+	count   uint
+	size    uint
+	src     net.IP
+}
+
+type amqpConsumerConfig struct {
+	consumerCfg
+	name   string
+	defTag entry.EntryTag
+	igst   *ingest.IngestMuxer
+	lg     *log.Logger
+	pproc  *processors.ProcessorSet
+	tgr    *tags.Tagger
+}
+
+func newAmqpConsumer(cfg amqpConsumerConfig) (ac *amqpConsumer, err error) {
+	if cfg.igst == nil {
+		err = errors.New("nil ingest connection")
+	} else if cfg.lg == nil {
+		err = errors.New("nil logger")
+	} else if cfg.tgr == nil {
+		err = errors.New("nil tagger")
+	} else {
+		ac = &amqpConsumer{
+			amqpConsumerConfig: cfg,
+		}
+		ac.ctx, ac.cf = context.WithCancel(context.Background())
+	}
+	return
+}
+
+func (ac *amqpConsumer) Start(wg *sync.WaitGroup) (err error) {
+	ac.mtx.Lock()
+	if ac.started {
+		err = errors.New("already started")
+	} else if ac.ctx == nil || ac.cf == nil {
+		err = errors.New("closer context is nil, already closed")
+	} else {
+		wg.Add(1)
+		ac.started = true
+		go ac.routine(wg)
+	}
+	ac.mtx.Unlock()
+	return
+}
+
+// Close stops the consumer, any messages that have not been acknowledged are requeued by the broker
+func (ac *amqpConsumer) Close() (err error) {
+	if ac == nil {
+		err = errors.New("nil consumer")
+	} else {
+		ac.mtx.Lock()
+		if ac.cf == nil {
+			err = errors.New("nil closer conn, routine closed")
+		} else {
+			ac.cf()
+			ac.cf = nil
+		}
+		ac.mtx.Unlock()
+	}
+	return
+}
+
+// routine consumes until we are closed, reconnecting to the broker whenever the session drops
+func (ac *amqpConsumer) routine(wg *sync.WaitGroup) {
+	defer wg.Done()
+	for i := 1; ac.ctx.Err() == nil; i++ {
+		ac.lg.Info("consumer start", log.KV("consumer", ac.name), log.KV("host", ac.uri.Host),
+			log.KV("vhost", ac.uri.Vhost), log.KV("queue", ac.queue), log.KV("attempt", i))
+		err := ac.consume()
+		if ac.ctx.Err() != nil {
+			break
+		}
+		ac.lg.Error("consumer error", log.KV("consumer", ac.name), log.KVErr(err))
+		select {
+		case <-ac.ctx.Done():
+		case <-time.After(reconnectDelay):
+		}
+	}
+	ac.lg.Info("amqp consumer stats", log.KV("consumer", ac.name), log.KV("queue", ac.queue),
+		log.KV("count", ac.count), log.KV("size", ac.size))
+}
+
+func (ac *amqpConsumer) dialConfig() (cfg amqp.Config) {
+	cfg.Properties = amqp.NewConnectionProperties()
+	cfg.Properties.SetClientConnectionName(ac.consumerTag)
+	if ac.username != `` {
+		cfg.SASL = []amqp.Authentication{&amqp.PlainAuth{Username: ac.username, Password: ac.password}}
+	}
+	if ac.uri.Scheme == schemeTLS {
+		cfg.TLSClientConfig = &tls.Config{
+			MinVersion:         minTLSVersion,
+			ServerName:         ac.uri.Host,
+			InsecureSkipVerify: ac.skipVerify,
+		}
+	}
+	return
+}
+
+func (ac *amqpConsumer) consume() (err error) {
+	//wait for a hot ingester so that we are not holding a pile of unacknowledged messages
+	if err = ac.igst.WaitForHotContext(ac.ctx, 0); err != nil {
+		return
+	}
+	if ac.srcOverride != nil {
+		ac.src = ac.srcOverride
+	} else if ip, err := ac.igst.SourceIP(); err == nil {
+		ac.src = ip
+	} else {
+		ac.src = nil
+	}
+
+	var conn *amqp.Connection
+	if conn, err = amqp.DialConfig(ac.url, ac.dialConfig()); err != nil {
+		return
+	}
+	defer conn.Close()
+	closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+
+	var ch *amqp.Channel
+	if ch, err = conn.Channel(); err != nil {
+		return
+	} else if err = ch.Qos(ac.prefetch, 0, false); err != nil {
+		return
+	}
+	if ac.declare {
+		args := amqp.Table{amqp.QueueTypeArg: ac.queueType}
+		if _, err = ch.QueueDeclare(ac.queue, true, false, false, false, args); err != nil {
+			return
+		}
+	}
+	var args amqp.Table
+	if ac.streamOffset != nil {
+		args = amqp.Table{streamOffsetArg: ac.streamOffset}
+	}
+	var dch <-chan amqp.Delivery
+	if dch, err = ch.Consume(ac.queue, ac.consumerTag, false, ac.exclusive, false, false, args); err != nil {
+		return
+	}
+	ac.lg.Info("consumer started", log.KV("consumer", ac.name), log.KV("queue", ac.queue), log.KV("source", ac.src))
+
+	tckr := time.NewTicker(flushInterval)
+	defer tckr.Stop()
+	batch := make([]amqp.Delivery, 0, ac.batchSize)
+	for {
+		select {
+		case <-ac.ctx.Done():
+			//anything not yet acknowledged is requeued when the connection closes
+			return nil
+		case cerr := <-closed:
+			if cerr != nil {
+				return cerr
+			}
+			return errConnClosed
+		case d, ok := <-dch:
+			if !ok {
+				return errDeliveryClosed
+			}
+			batch = append(batch, d)
+			if len(batch) == cap(batch) {
+				if err = ac.flush(batch); err != nil {
+					return fmt.Errorf("failed to write %d entries: %w", len(batch), err)
+				}
+				batch = batch[0:0]
+			}
+		case <-tckr.C:
+			if len(batch) > 0 {
+				if err = ac.flush(batch); err != nil {
+					return fmt.Errorf("failed to write %d entries: %w", len(batch), err)
+				}
+				batch = batch[0:0]
+			}
+		}
+	}
+}
+
+// flush hands a batch of deliveries to the ingest muxer and only acknowledges them once
+// the muxer has accepted every entry, a failed batch is left for the broker to redeliver
+func (ac *amqpConsumer) flush(batch []amqp.Delivery) (err error) {
+	var sz uint
+	var cnt uint
+	for i := range batch {
+		d := &batch[i]
+		ent := &entry.Entry{
+			TS:   entry.Now(),
+			Data: d.Body,
+		}
+		if !ac.ignoreTS {
+			if !d.Timestamp.IsZero() {
+				ent.TS = entry.FromStandard(d.Timestamp)
+			}
+			if ac.extractTS && ac.tg != nil {
+				if hts, ok, err := ac.tg.Extract(ent.Data); err != nil {
+					ac.lg.Warn("catastrophic timegrinder error", log.KVErr(err))
+				} else if ok {
+					ent.TS = entry.FromStandard(hts)
+				}
+				// if not ok, we'll just use the message timestamp
+			}
+		}
+		if ent.Tag, ent.SRC, err = ac.resolveSourceAndTag(d.Headers); err != nil {
+			return
+		}
+		if err = ac.pproc.ProcessContext(ent, ac.ctx); err != nil {
+			return
+		}
+		sz += uint(ent.Size())
+		cnt++
+	}
+	if ac.sync {
+		if err = ac.igst.SyncContext(ac.ctx, time.Second); err != nil {
+			return
+		}
+	}
+	//deliveries arrive in order on a single channel, so one multiple ack covers the batch
+	if len(batch) > 0 {
+		if err = batch[len(batch)-1].Ack(true); err != nil {
+			return
+		}
+	}
+	ac.count += cnt
+	ac.size += sz
+	return
+}
+
+func (ac *amqpConsumer) resolveTag(tn string) (tag entry.EntryTag, ok bool, err error) {
+	//invalid and disallowed tag names fall back to the default tag without being negotiated
+	if !ac.tgr.AllowedName(tn) {
+		return
+	} else if tag, err = ac.tgr.Negotiate(tn); err != nil {
+		return
+	}
+	ok = ac.tgr.Allowed(tag)
+	return
+}
+
+func (ac *amqpConsumer) resolveSourceAndTag(hdrs amqp.Table) (tag entry.EntryTag, ip net.IP, err error) {
+	var tagHit bool
+	if v, ok := headerValue(hdrs, ac.srcKey); ok {
+		ip = ac.extractSrc(v)
+	}
+	if v, ok := headerValue(hdrs, ac.tagKey); ok {
+		if tag, tagHit, err = ac.resolveTag(string(v)); err != nil {
+			return
+		}
+	}
+	//if we still missed, just use the src
+	if ip == nil {
+		ip = ac.src
+	}
+	if !tagHit {
+		tag = ac.defTag
+	}
+	return
+}
+
+func (ac *amqpConsumer) extractSrc(v []byte) (ip net.IP) {
+	if ac.srcBin && (len(v) == ipv4Len || len(v) == ipv6Len) {
+		ip = net.IP(v)
+	} else {
+		ip = net.ParseIP(string(v))
+	}
+	return
+}
+
+// headerValue pulls a header as bytes, AMQP headers are typed and we only accept strings and byte arrays
+func headerValue(hdrs amqp.Table, key string) (v []byte, ok bool) {
+	switch t := hdrs[key].(type) {
+	case string:
+		v, ok = []byte(t), true
+	case []byte:
+		v, ok = t, true
+	}
+	return
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/config/validate"
 	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
 	"github.com/gravwell/gravwell/v3/ingesters/utils"
 	"github.com/gravwell/gravwell/v3/ingesters/version"
 
@@ -125,6 +126,12 @@ func Init(ibc IngesterBaseConfig) (ib IngesterBase, err error) {
 	}
 
 	cfg.AddLocalLogging(ib.Logger)
+	if cfg.Preprocessor_Trace_Rate > 0 {
+		processors.SetDefaultTrace(processors.TraceConfig{
+			SampleRate: cfg.Preprocessor_Trace_Rate,
+			Logger:     ib.Logger,
+		})
+	}
 
 	if err = ib.validateUUID(cfg, *confLoc); err != nil {
 		return
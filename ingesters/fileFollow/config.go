@@ -17,6 +17,7 @@ import (
 	"sort"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gravwell/gravwell/v3/filewatch"
 	"github.com/gravwell/gravwell/v3/ingest"
@@ -63,6 +64,11 @@ type follower struct {
 	Timestamp_Delimited       bool
 	Timezone_Override         string
 	Regex_Delimiter           string
+	Engine                    string //csv, w3c, json, container, or audit for structured files, lines are the default
+	CSV_Delimiter             string
+	Audit_Window              string   //how long the records of an audit event may be spread over
+	Container_Label           []string //container labels to attach as EVs, all labels if empty
+	Container_Tag_Label       string   //container label that names the tag to send entries to
 	Preprocessor              []string
 	// these two must be used together
 	Timestamp_Regex         string
@@ -75,6 +81,7 @@ type global struct {
 	config.IngestConfig
 	Max_Files_Watched    int
 	State_Store_Location string
+	File_Identity        string //inode or fingerprint
 }
 
 type cfgType struct {
@@ -113,6 +120,8 @@ func (c *cfgType) Verify() error {
 		return err
 	} else if err = c.global.verifyStateStore(); err != nil {
 		return err
+	} else if _, err = filewatch.ParseFileIdentity(c.global.File_Identity); err != nil {
+		return err
 	} else if c.global.Max_Files_Watched <= 0 {
 		c.global.Max_Files_Watched = defaultMaxWatchedFiles
 	}
@@ -159,6 +168,9 @@ func (c *cfgType) Verify() error {
 		if err := c.Preprocessor.CheckProcessors(v.Preprocessor); err != nil {
 			return fmt.Errorf("Follower %s preprocessor invalid: %v", k, err)
 		}
+		if err := v.verifyEngine(); err != nil {
+			return fmt.Errorf("Follower %s %v", k, err)
+		}
 	}
 	return nil
 }
@@ -201,6 +213,62 @@ func (cfg *cfgType) Followers() map[string]follower {
 	}
 	return mp
 }
+func (f follower) verifyEngine() error {
+	eng, _, ok := f.StructuredEngine()
+	if (len(f.Container_Label) > 0 || f.Container_Tag_Label != ``) && eng != filewatch.ContainerEngine {
+		return errors.New("Container-Label and Container-Tag-Label require Engine=container")
+	} else if f.Audit_Window != `` && eng != filewatch.AuditEngine {
+		return errors.New("Audit-Window requires Engine=audit")
+	} else if f.Audit_Window != `` {
+		if d, err := time.ParseDuration(f.Audit_Window); err != nil || d <= 0 {
+			return fmt.Errorf("invalid Audit-Window %q", f.Audit_Window)
+		}
+	}
+	if f.Engine == `` {
+		if f.CSV_Delimiter != `` {
+			return errors.New("CSV-Delimiter requires Engine=csv")
+		}
+		return nil
+	}
+	if !ok {
+		return fmt.Errorf("unknown Engine %q", f.Engine)
+	} else if f.Regex_Delimiter != `` || f.Timestamp_Delimited {
+		return errors.New("Engine cannot be combined with Regex-Delimiter or Timestamp-Delimited")
+	} else if f.CSV_Delimiter != `` && eng != filewatch.CSVEngine {
+		return errors.New("CSV-Delimiter requires Engine=csv")
+	} else if f.CSV_Delimiter != `` && utf8.RuneCountInString(f.CSV_Delimiter) != 1 && f.CSV_Delimiter != `\t` {
+		return fmt.Errorf("invalid CSV-Delimiter %q", f.CSV_Delimiter)
+	}
+	return nil
+}
+
+// StructuredEngine returns the reader engine for followers that handle structured files
+func (f follower) StructuredEngine() (engine int, args string, ok bool) {
+	switch strings.ToLower(strings.TrimSpace(f.Engine)) {
+	case `csv`:
+		engine, ok = filewatch.CSVEngine, true
+		if args = f.CSV_Delimiter; args == `\t` {
+			args = "\t"
+		}
+	case `w3c`, `iis`, `zeek`:
+		engine, ok = filewatch.W3CEngine, true
+	case `json`, `ndjson`:
+		engine, ok = filewatch.JSONEngine, true
+	case `container`, `docker`, `cri`:
+		engine, ok = filewatch.ContainerEngine, true
+	case `audit`, `auditd`:
+		engine, ok = filewatch.AuditEngine, true
+		args = f.Audit_Window
+	}
+	return
+}
+
+// ContainerLogs returns true if the follower reads container runtime logs
+func (f follower) ContainerLogs() bool {
+	eng, _, ok := f.StructuredEngine()
+	return ok && eng == filewatch.ContainerEngine
+}
+
 func (f follower) TimestampOverride() (v string, err error) {
 	v = strings.TrimSpace(f.Timestamp_Format_Override)
 	return
@@ -256,7 +324,10 @@ func (g *global) Verify() (err error) {
 	if err = g.IngestConfig.Verify(); err != nil {
 		return
 	}
-	err = g.verifyStateStore()
+	if err = g.verifyStateStore(); err != nil {
+		return
+	}
+	_, err = filewatch.ParseFileIdentity(g.File_Identity)
 	return
 }
 
@@ -264,6 +335,12 @@ func (g *global) StatePath() string {
 	return g.State_Store_Location
 }
 
+// FileIdentity returns how followed files are identified, the value is checked in Verify
+func (g *global) FileIdentity() filewatch.FileIdentity {
+	fi, _ := filewatch.ParseFileIdentity(g.File_Identity)
+	return fi
+}
+
 func dumpStateFile(pth string) {
 	states, err := filewatch.DecodeStateFile(pth)
 	if err != nil {
@@ -272,6 +349,18 @@ func dumpStateFile(pth string) {
 	}
 	fmt.Printf("%-24s %-16s %s\n", "Listener Name", "File Offset", "File Path")
 	for _, state := range states {
-		fmt.Printf("%-24s %-16d %s\n", state.BaseName, state.State, state.FilePath)
+		if state.FilePath != `` {
+			fmt.Printf("%-24s %-16d %s\n", state.BaseName, state.State, state.FilePath)
+		}
+	}
+	var hdr bool
+	for _, state := range states {
+		if state.FilePath != `` {
+			continue
+		} else if !hdr {
+			fmt.Printf("\n%-24s %-16s %s\n", "Listener Name", "File Offset", "Content Fingerprint")
+			hdr = true
+		}
+		fmt.Printf("%-24s %-16d %s\n", state.BaseName, state.State, state.Fingerprint)
 	}
 }
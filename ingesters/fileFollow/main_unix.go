@@ -102,6 +102,7 @@ func main() {
 	//pass in the ingest muxer to the file watcher so it can throw info and errors down the muxer chan
 	wtcher.SetLogger(igst)
 	wtcher.SetMaxFilesWatched(cfg.Max_Files_Watched)
+	wtcher.SetFileIdentity(cfg.FileIdentity())
 
 	var procs []*processors.ProcessorSet
 
@@ -141,6 +142,10 @@ func main() {
 			TimeFormat:              cfg.TimeFormat,
 			AttachFilename:          val.Attach_Filename,
 			Trim:                    val.Trim,
+			ContainerMeta:           val.ContainerLogs(),
+			ContainerLabels:         val.Container_Label,
+			TagLabel:                val.Container_Tag_Label,
+			TagResolver:             igst.NegotiateTag,
 		}
 		if debugOn {
 			cfg.Debugger = debugout
@@ -164,6 +169,9 @@ func main() {
 		} else if val.Regex_Delimiter != `` {
 			c.Engine = filewatch.RegexEngine
 			c.EngineArgs = val.Regex_Delimiter
+		} else if eng, args, ok := val.StructuredEngine(); ok {
+			c.Engine = eng
+			c.EngineArgs = args
 		} else {
 			c.Engine = filewatch.LineEngine
 		}
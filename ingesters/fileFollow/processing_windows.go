@@ -82,6 +82,7 @@ func NewService(cfg *cfgType) (*mainService, error) {
 	}
 	//pass in the ingest muxer to the file watcher so it can throw info and errors down the muxer chan
 	wtchr.SetMaxFilesWatched(cfg.Max_Files_Watched)
+	wtchr.SetFileIdentity(cfg.FileIdentity())
 
 	id, ok := cfg.IngesterUUID()
 	if !ok {
@@ -362,6 +363,10 @@ func (m *mainService) init(ctx context.Context) error {
 			TimeFormat:              m.timeFormats,
 			AttachFilename:          val.Attach_Filename,
 			Trim:                    val.Trim,
+			ContainerMeta:           val.ContainerLogs(),
+			ContainerLabels:         val.Container_Label,
+			TagLabel:                val.Container_Tag_Label,
+			TagResolver:             m.igst.NegotiateTag,
 		}
 
 		lh, err := filewatch.NewLogHandler(cfg, pproc)
@@ -385,6 +390,9 @@ func (m *mainService) init(ctx context.Context) error {
 		} else if val.Regex_Delimiter != `` {
 			c.Engine = filewatch.RegexEngine
 			c.EngineArgs = val.Regex_Delimiter
+		} else if eng, args, ok := val.StructuredEngine(); ok {
+			c.Engine = eng
+			c.EngineArgs = args
 		} else {
 			c.Engine = filewatch.LineEngine
 		}
@@ -0,0 +1,462 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/attach"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+	"github.com/gravwell/gravwell/v3/ingest/processors/tags"
+	"github.com/gravwell/gravwell/v3/timegrinder"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	defaultPort          uint16 = 4222
+	defaultPrefetch      int    = 512
+	defaultBatchSize     int    = 512
+	defaultAckWait              = 30 * time.Second
+	defaultSRCHeader            = `SRC`
+	defaultTagHeader            = `TAG`
+	defaultDeliverPolicy        = jetstream.DeliverAllPolicy
+)
+
+type ConfigConsumer struct {
+	Server_URL       []string
+	Username         string
+	Password         string `json:"-"` //DO NOT send this when marshalling
+	Token            string `json:"-"` //DO NOT send this when marshalling
+	Credentials_File string
+	Stream           string
+	Durable_Name     string
+	Filter_Subject   []string
+	Deliver_Policy   string //all, new, last, or last-per-subject, cannot be changed once the durable exists
+	Max_Ack_Pending  int
+	Ack_Wait         string
+	Prefetch         int
+	Source_Override  string
+	Source_Header    string
+	Tag_Header       string
+	Synchronous      bool
+	Batch_Size       int
+	Default_Tag      string
+
+	tags.TaggerConfig
+
+	//TLS stuff
+	Use_TLS                  bool
+	Insecure_Skip_TLS_Verify bool
+
+	//consumer configs
+	Ignore_Timestamps         bool //Just apply the current timestamp to messages as we get them
+	Extract_Timestamps        bool //Ignore the JetStream timestamp, use timegrinder
+	Assume_Local_Timezone     bool
+	Timezone_Override         string
+	Timestamp_Format_Override string //override the timestamp format
+
+	//list of preprocessors to run
+	Preprocessor []string
+}
+
+type consumerCfg struct {
+	tags.TaggerConfig
+	defTag        string
+	servers       []string
+	username      string
+	password      string
+	token         string
+	creds         string
+	stream        string
+	durable       string
+	filters       []string
+	deliverPolicy jetstream.DeliverPolicy
+	maxAckPending int
+	ackWait       time.Duration
+	prefetch      int
+	sync          bool
+	batchSize     int
+	srcKey        string
+	tagKey        string
+	srcOverride   net.IP
+
+	//tls configs
+	useTLS     bool
+	skipVerify bool
+
+	//consumer configs for timestamps and time grinding
+	ignoreTS     bool
+	extractTS    bool
+	tg           *timegrinder.TimeGrinder
+	preprocessor []string
+}
+
+type cfgReadType struct {
+	Global       config.IngestConfig
+	Attach       attach.AttachConfig
+	Consumer     map[string]*ConfigConsumer
+	Preprocessor processors.ProcessorConfig
+	TimeFormat   config.CustomTimeFormat
+}
+
+type cfgType struct {
+	config.IngestConfig
+	Attach       attach.AttachConfig
+	Consumers    map[string]*consumerCfg
+	Preprocessor processors.ProcessorConfig
+	TimeFormat   config.CustomTimeFormat
+}
+
+func GetConfig(path, overlayPath string) (*cfgType, error) {
+	var cr cfgReadType
+	if err := config.LoadConfigFile(&cr, path); err != nil {
+		return nil, err
+	} else if err = config.LoadConfigOverlays(&cr, overlayPath); err != nil {
+		return nil, err
+	}
+
+	c := &cfgType{
+		IngestConfig: cr.Global,
+		Attach:       cr.Attach,
+		Consumers:    make(map[string]*consumerCfg, len(cr.Consumer)),
+		Preprocessor: cr.Preprocessor,
+		TimeFormat:   cr.TimeFormat,
+	}
+
+	durables := make(map[string]string, len(cr.Consumer))
+	for k, v := range cr.Consumer {
+		if err := c.Preprocessor.CheckProcessors(v.Preprocessor); err != nil {
+			return nil, fmt.Errorf("Consumer %s preprocessor invalid: %v", k, err)
+		}
+		cnsmr, err := v.validateAndProcess(k)
+		if err != nil {
+			return nil, fmt.Errorf("Consumer %s is invalid: %w", k, err)
+		}
+		//two of our consumers sharing a durable would fight over its configuration
+		dk := cnsmr.stream + `/` + cnsmr.durable
+		if other, ok := durables[dk]; ok {
+			return nil, fmt.Errorf("Consumer %s and %s share durable %s on stream %s", k, other, cnsmr.durable, cnsmr.stream)
+		}
+		durables[dk] = k
+		//the timegrinder only exists when we are extracting timestamps
+		if cnsmr.tg != nil {
+			if err = c.TimeFormat.LoadFormats(cnsmr.tg); err != nil {
+				return nil, err
+			} else if v.Timestamp_Format_Override != `` {
+				if err = cnsmr.tg.SetFormatOverride(v.Timestamp_Format_Override); err != nil {
+					return nil, err
+				}
+			}
+		}
+		c.Consumers[k] = &cnsmr
+	}
+	if err := c.Verify(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *cfgType) Verify() error {
+	//validate the global params
+	if err := c.IngestConfig.Verify(); err != nil {
+		return err
+	} else if err = c.Attach.Verify(); err != nil {
+		return err
+	} else if len(c.Consumers) == 0 {
+		return errors.New("no consumers defined")
+	} else if err = c.Preprocessor.Validate(); err != nil {
+		return err
+	} else if err = c.TimeFormat.Validate(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *cfgType) Tags() (tags []string, err error) {
+	tagMp := make(map[string]bool, len(c.Consumers))
+	for name, v := range c.Consumers {
+		var ltags []string
+		if v.defTag == `` {
+			err = fmt.Errorf("Consumer %s is missing Default-Tag definition", name)
+			return
+		} else if err = ingest.CheckTag(v.defTag); err != nil {
+			err = fmt.Errorf("Consumer %s Default-Tag is invalid: %v", name, err)
+			return
+		} else if _, ok := tagMp[v.defTag]; !ok {
+			tags = append(tags, v.defTag)
+			tagMp[v.defTag] = true
+		}
+		if ltags, _, err = v.TaggerConfig.TagSet(); err != nil {
+			return
+		}
+		for _, lt := range ltags {
+			if _, ok := tagMp[lt]; !ok {
+				tags = append(tags, lt)
+				tagMp[lt] = true
+			}
+		}
+	}
+
+	if len(tags) == 0 {
+		err = errors.New("No tags specified")
+	} else {
+		sort.Strings(tags)
+	}
+	return
+}
+
+func (c *cfgType) IngestBaseConfig() config.IngestConfig {
+	return c.IngestConfig
+}
+
+func (c *cfgType) AttachConfig() attach.AttachConfig {
+	return c.Attach
+}
+
+func (cc ConfigConsumer) validateAndProcess(name string) (c consumerCfg, err error) {
+	//check tag
+	if len(cc.Default_Tag) == 0 {
+		err = errors.New("missing Default-Tag")
+		return
+	} else if err = ingest.CheckTag(cc.Default_Tag); err != nil {
+		return
+	} else if err = cc.TaggerConfig.Validate(); err != nil {
+		return
+	}
+	c.defTag = cc.Default_Tag
+	c.TaggerConfig = cc.TaggerConfig
+	if cc.Source_Header == `` {
+		cc.Source_Header = defaultSRCHeader
+	}
+	if cc.Tag_Header == `` {
+		cc.Tag_Header = defaultTagHeader
+	}
+	c.srcKey = cc.Source_Header
+	c.tagKey = cc.Tag_Header
+
+	//check the servers
+	if len(cc.Server_URL) == 0 {
+		err = errors.New("missing Server-URL")
+		return
+	}
+	for _, s := range cc.Server_URL {
+		var srv string
+		if srv, err = serverURL(s); err != nil {
+			return
+		}
+		c.servers = append(c.servers, srv)
+	}
+
+	//check auth, only one method may be used at a time
+	var methods int
+	if cc.Username != `` || cc.Password != `` {
+		if cc.Username == `` || cc.Password == `` {
+			err = errors.New("Username and Password must be specified together")
+			return
+		}
+		methods++
+	}
+	if cc.Token != `` {
+		methods++
+	}
+	if cc.Credentials_File != `` {
+		if _, err = os.Stat(cc.Credentials_File); err != nil {
+			err = fmt.Errorf("invalid Credentials-File %w", err)
+			return
+		}
+		methods++
+	}
+	if methods > 1 {
+		err = errors.New("only one of Username, Token, or Credentials-File may be specified")
+		return
+	}
+	c.username = cc.Username
+	c.password = cc.Password
+	c.token = cc.Token
+	c.creds = cc.Credentials_File
+
+	if cc.Use_TLS {
+		c.useTLS = true
+		c.skipVerify = cc.Insecure_Skip_TLS_Verify
+	}
+
+	//check the stream and durable consumer
+	if c.stream = strings.TrimSpace(cc.Stream); c.stream == `` {
+		err = errors.New("missing Stream name")
+		return
+	} else if strings.ContainsAny(c.stream, ". *>") {
+		err = fmt.Errorf("invalid Stream name %q", c.stream)
+		return
+	}
+	if c.durable = strings.TrimSpace(cc.Durable_Name); c.durable == `` {
+		c.durable = `gravwell-` + name
+	}
+	if strings.ContainsAny(c.durable, ". *>") {
+		err = fmt.Errorf("invalid Durable-Name %q", c.durable)
+		return
+	}
+	for _, f := range cc.Filter_Subject {
+		if f = strings.TrimSpace(f); f == `` || strings.ContainsAny(f, " \t") {
+			err = fmt.Errorf("invalid Filter-Subject %q", f)
+			return
+		}
+		c.filters = append(c.filters, f)
+	}
+	if c.deliverPolicy, err = cc.deliverPolicy(); err != nil {
+		return
+	}
+
+	//Max-Ack-Pending bounds unacknowledged messages on the server, Prefetch bounds our local buffer
+	if cc.Max_Ack_Pending < 0 {
+		err = fmt.Errorf("invalid Max-Ack-Pending %d", cc.Max_Ack_Pending)
+		return
+	}
+	c.maxAckPending = cc.Max_Ack_Pending
+	if cc.Prefetch < 0 {
+		err = fmt.Errorf("invalid Prefetch %d", cc.Prefetch)
+		return
+	} else if c.prefetch = cc.Prefetch; c.prefetch == 0 {
+		c.prefetch = defaultPrefetch
+	}
+	if c.batchSize = cc.Batch_Size; c.batchSize <= 0 {
+		c.batchSize = defaultBatchSize
+	}
+	//a batch larger than we can have outstanding would only ever be flushed by the timer
+	if c.batchSize > c.prefetch {
+		c.batchSize = c.prefetch
+	}
+	if c.maxAckPending > 0 && c.batchSize > c.maxAckPending {
+		c.batchSize = c.maxAckPending
+	}
+	if cc.Ack_Wait == `` {
+		c.ackWait = defaultAckWait
+	} else if c.ackWait, err = time.ParseDuration(cc.Ack_Wait); err != nil {
+		err = fmt.Errorf("invalid Ack-Wait %q %w", cc.Ack_Wait, err)
+		return
+	} else if c.ackWait < time.Second {
+		err = fmt.Errorf("Ack-Wait %v is too short", c.ackWait)
+		return
+	}
+
+	//just set the sync
+	c.sync = cc.Synchronous
+
+	// check that the source override is valid
+	if len(cc.Source_Override) > 0 {
+		if c.srcOverride = net.ParseIP(cc.Source_Override); c.srcOverride == nil {
+			err = fmt.Errorf("Invalid source override %s", cc.Source_Override)
+			return
+		}
+	}
+
+	if cc.Timezone_Override != "" {
+		if cc.Assume_Local_Timezone {
+			// cannot do both
+			err = fmt.Errorf("Cannot specify Assume-Local-Timezone and Timezone-Override in the same consumer")
+			return
+		}
+		if _, err = time.LoadLocation(cc.Timezone_Override); err != nil {
+			err = fmt.Errorf("Invalid timezone override %v in consumer: %v", cc.Timezone_Override, err)
+			return
+		}
+	}
+	if cc.Ignore_Timestamps {
+		c.ignoreTS = true
+	} else if cc.Extract_Timestamps {
+		c.extractTS = true
+		tcfg := timegrinder.Config{
+			EnableLeftMostSeed: true,
+			FormatOverride:     cc.Timestamp_Format_Override,
+		}
+		if c.tg, err = timegrinder.NewTimeGrinder(tcfg); err != nil {
+			err = fmt.Errorf("Failed to generate new timegrinder: %v", err)
+			return
+		}
+		if cc.Assume_Local_Timezone {
+			c.tg.SetLocalTime()
+		}
+		if cc.Timezone_Override != `` {
+			if err = c.tg.SetTimezone(cc.Timezone_Override); err != nil {
+				err = fmt.Errorf("Failed to override timezone: %v", err)
+				return
+			}
+		}
+	}
+
+	c.preprocessor = cc.Preprocessor
+	return
+}
+
+// consumerConfig is the durable pull consumer we create or update on the stream
+func (c consumerCfg) consumerConfig() jetstream.ConsumerConfig {
+	return jetstream.ConsumerConfig{
+		Durable:        c.durable,
+		DeliverPolicy:  c.deliverPolicy,
+		AckPolicy:      jetstream.AckExplicitPolicy,
+		AckWait:        c.ackWait,
+		MaxAckPending:  c.maxAckPending,
+		FilterSubjects: c.filters,
+	}
+}
+
+func (cc ConfigConsumer) deliverPolicy() (dp jetstream.DeliverPolicy, err error) {
+	switch strings.ToLower(strings.TrimSpace(cc.Deliver_Policy)) {
+	case ``:
+		dp = defaultDeliverPolicy
+	case `all`:
+		dp = jetstream.DeliverAllPolicy
+	case `new`:
+		dp = jetstream.DeliverNewPolicy
+	case `last`:
+		dp = jetstream.DeliverLastPolicy
+	case `last-per-subject`:
+		dp = jetstream.DeliverLastPerSubjectPolicy
+	default:
+		err = fmt.Errorf("Unknown Deliver-Policy %q", cc.Deliver_Policy)
+	}
+	return
+}
+
+// serverURL normalizes a server, bare hosts get the nats scheme and the default port
+func serverURL(s string) (r string, err error) {
+	if s = strings.TrimSpace(s); s == `` {
+		err = errors.New("empty Server-URL")
+		return
+	}
+	if !strings.Contains(s, `://`) {
+		s = `nats://` + s
+	}
+	var u *url.URL
+	if u, err = url.Parse(s); err != nil {
+		err = errors.New("invalid Server-URL") //do not echo the URL, it may hold credentials
+		return
+	}
+	switch strings.ToLower(u.Scheme) {
+	case `nats`, `tls`:
+	default:
+		err = fmt.Errorf("unsupported Server-URL scheme %q", u.Scheme)
+		return
+	}
+	if u.Hostname() == `` {
+		err = errors.New("Server-URL is missing a host")
+		return
+	}
+	u.Host = config.AppendDefaultPort(u.Host, defaultPort)
+	r = u.String()
+	return
+}
@@ -0,0 +1,278 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+	"github.com/gravwell/gravwell/v3/ingest/processors/tags"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	globalConfig = `
+[Global]
+Ingest-Secret = IngestSecrets
+Connection-Timeout = 0
+Cleartext-Backend-target=127.0.0.1:4023
+Log-Level=INFO
+`
+	baseConfig = globalConfig + `
+[Consumer "default"]
+	Server-URL="127.0.0.1"
+	Server-URL="tls://[::1]:4223"
+	Stream=AUDIT
+	Default-Tag=foo
+	Tags=bar*
+	Tags=*baz
+
+[Consumer "logins"]
+	Server-URL="nats://nats.example.com"
+	Username=gravwell
+	Password=secret
+	Stream=AUDIT
+	Durable-Name=logins
+	Filter-Subject="audit.login.>"
+	Filter-Subject="audit.logout.*"
+	Deliver-Policy=new
+	Max-Ack-Pending=100
+	Ack-Wait=1m
+	Batch-Size=1000
+	Default-Tag=foo
+	Tags=bar
+`
+)
+
+func writeConfig(t *testing.T, v string) string {
+	t.Helper()
+	pth := filepath.Join(t.TempDir(), `nats.conf`)
+	if err := os.WriteFile(pth, []byte(v), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return pth
+}
+
+func TestBasicConfig(t *testing.T) {
+	cfg, err := GetConfig(writeConfig(t, baseConfig), ``)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Consumers) != 2 {
+		t.Fatalf("invalid consumer count: %d != 2", len(cfg.Consumers))
+	}
+	if tags, err := cfg.Tags(); err != nil {
+		t.Fatal(err)
+	} else if strings.Join(tags, `,`) != `bar,foo` {
+		t.Fatalf("bad tags %v", tags)
+	}
+	def := cfg.Consumers[`default`]
+	if strings.Join(def.servers, `,`) != `nats://127.0.0.1:4222,tls://[::1]:4223` {
+		t.Fatalf("bad servers %v", def.servers)
+	} else if def.durable != `gravwell-default` || def.deliverPolicy != jetstream.DeliverAllPolicy || def.ackWait != defaultAckWait {
+		t.Fatalf("bad consumer defaults %q %v %v", def.durable, def.deliverPolicy, def.ackWait)
+	} else if def.prefetch != defaultPrefetch || def.batchSize != defaultBatchSize || def.maxAckPending != 0 {
+		t.Fatalf("bad flow defaults %d %d %d", def.prefetch, def.batchSize, def.maxAckPending)
+	} else if def.srcKey != defaultSRCHeader || def.tagKey != defaultTagHeader {
+		t.Fatalf("bad header defaults %q %q", def.srcKey, def.tagKey)
+	}
+	lc := cfg.Consumers[`logins`].consumerConfig()
+	if lc.Durable != `logins` || lc.DeliverPolicy != jetstream.DeliverNewPolicy || lc.AckPolicy != jetstream.AckExplicitPolicy {
+		t.Fatalf("bad consumer config %+v", lc)
+	} else if lc.AckWait != time.Minute || lc.MaxAckPending != 100 || len(lc.FilterSubjects) != 2 {
+		t.Fatalf("bad consumer config %+v", lc)
+	} else if cfg.Consumers[`logins`].batchSize != 100 {
+		t.Fatalf("batch size was not clamped to Max-Ack-Pending: %d", cfg.Consumers[`logins`].batchSize)
+	}
+}
+
+func TestBadConfigs(t *testing.T) {
+	cons := `
+[Consumer "c"]
+	Server-URL="127.0.0.1"
+	Stream=S
+	Default-Tag=foo
+	Tags=foo
+`
+	bad := []string{
+		``,                                      //no consumers
+		cons + `	Server-URL="http://127.0.0.1"`, //bad scheme
+		cons + `	Server-URL="nats://"`,
+		cons + `	Stream="a.b"`,
+		cons + `	Durable-Name="a b"`,
+		cons + `	Filter-Subject=" "`,
+		cons + `	Tags="bad tag"`,
+		cons + `	Password=foo`, //no username
+		cons + `	Username=foo
+	Password=bar
+	Token=baz`, //multiple auth methods
+		cons + `	Credentials-File=/does/not/exist`,
+		cons + `	Deliver-Policy=sometimes`,
+		cons + `	Ack-Wait=10ms`,
+		cons + `	Ack-Wait=forever`,
+		cons + `	Max-Ack-Pending=-1`,
+		cons + `	Prefetch=-1`,
+		cons + `	Source-Override=foo`,
+		cons + `	Assume-Local-Timezone=true
+	Timezone-Override="America/Denver"`,
+		cons + `
+[Consumer "c2"]
+	Server-URL="127.0.0.1"
+	Stream=S
+	Durable-Name=gravwell-c
+	Default-Tag=foo
+	Tags=foo
+`, //shared durable
+	}
+	for i, v := range bad {
+		if _, err := GetConfig(writeConfig(t, globalConfig+v), ``); err == nil {
+			t.Fatalf("bad config %d was accepted:\n%s", i, v)
+		}
+	}
+}
+
+type testResolver map[string]entry.EntryTag
+
+func (tr testResolver) NegotiateTag(tn string) (entry.EntryTag, error) {
+	if err := ingest.CheckTag(tn); err != nil {
+		return 0, err
+	}
+	tg, ok := tr[tn]
+	if !ok {
+		tg = entry.EntryTag(len(tr) + 1)
+		tr[tn] = tg
+	}
+	return tg, nil
+}
+
+type testWriter struct {
+	sync.Mutex
+	ents []*entry.Entry
+	err  error
+}
+
+func (tw *testWriter) WriteEntry(ent *entry.Entry) error {
+	tw.Lock()
+	defer tw.Unlock()
+	if tw.err != nil {
+		return tw.err
+	}
+	tw.ents = append(tw.ents, ent)
+	return nil
+}
+
+func (tw *testWriter) WriteEntryContext(ctx context.Context, ent *entry.Entry) error {
+	return tw.WriteEntry(ent)
+}
+
+func (tw *testWriter) WriteBatch(ents []*entry.Entry) error {
+	for _, ent := range ents {
+		if err := tw.WriteEntry(ent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tw *testWriter) WriteBatchContext(ctx context.Context, ents []*entry.Entry) error {
+	return tw.WriteBatch(ents)
+}
+
+// testMsg implements just enough of jetstream.Msg to be flushed
+type testMsg struct {
+	jetstream.Msg
+	data  []byte
+	hdrs  nats.Header
+	ts    time.Time
+	acked bool
+}
+
+func (tm *testMsg) Data() []byte         { return tm.data }
+func (tm *testMsg) Headers() nats.Header { return tm.hdrs }
+func (tm *testMsg) Ack() error           { tm.acked = true; return nil }
+
+func (tm *testMsg) Metadata() (*jetstream.MsgMetadata, error) {
+	return &jetstream.MsgMetadata{Timestamp: tm.ts}, nil
+}
+
+func TestFlush(t *testing.T) {
+	tr := testResolver{}
+	tgr, err := tags.NewTagger(tags.TaggerConfig{Tags: []string{`default`, `audit-*`}}, tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tw testWriter
+	nc, err := newNatsConsumer(natsConsumerConfig{
+		consumerCfg: consumerCfg{srcKey: defaultSRCHeader, tagKey: defaultTagHeader},
+		defTag:      tr[`default`],
+		igst:        &ingest.IngestMuxer{},
+		lg:          log.NewDiscardLogger(),
+		pproc:       processors.NewProcessorSet(&tw),
+		tgr:         tgr,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	nc.src = net.ParseIP(`10.0.0.1`)
+
+	ts := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	msgs := []*testMsg{
+		{data: []byte(`a`), ts: ts, hdrs: nats.Header{`TAG`: []string{`audit-login`}, `SRC`: []string{`192.168.1.1`}}},
+		{data: []byte(`b`), hdrs: nats.Header{`TAG`: []string{`secret`}}},                        //disallowed
+		{data: []byte(`c`), hdrs: nats.Header{`TAG`: []string{`bad tag`}, `SRC`: []string{`x`}}}, //invalid
+	}
+	batch := make([]jetstream.Msg, 0, len(msgs))
+	for _, m := range msgs {
+		batch = append(batch, m)
+	}
+	if err = nc.flush(batch); err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range msgs {
+		if !m.acked {
+			t.Fatalf("message %d was not acknowledged", i)
+		}
+	}
+	if len(tw.ents) != 3 || nc.count != 3 {
+		t.Fatalf("bad entry count %d %d", len(tw.ents), nc.count)
+	}
+	if ent := tw.ents[0]; ent.Tag != tr[`audit-login`] || !ent.SRC.Equal(net.ParseIP(`192.168.1.1`)) || !ent.TS.StandardTime().Equal(ts) {
+		t.Fatalf("bad header routing %+v", ent)
+	}
+	for _, ent := range tw.ents[1:] {
+		if ent.Tag != tr[`default`] || !ent.SRC.Equal(nc.src) {
+			t.Fatalf("bad default routing %+v", ent)
+		}
+	}
+	if _, ok := tr[`secret`]; ok {
+		t.Fatal("disallowed tag was negotiated")
+	}
+
+	//failed writes must not be acknowledged
+	tw.err = errors.New("nope")
+	m := &testMsg{data: []byte(`d`)}
+	if err = nc.flush([]jetstream.Msg{m}); err == nil {
+		t.Fatal("writer error was not returned")
+	} else if m.acked {
+		t.Fatal("failed batch was acknowledged")
+	}
+}
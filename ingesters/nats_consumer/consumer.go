@@ -0,0 +1,356 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+	"github.com/gravwell/gravwell/v3/ingest/processors/tags"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	minTLSVersion  = tls.VersionTLS12
+	reconnectDelay = 5 * time.Second
+	requestTimeout = 10 * time.Second
+	flushInterval  = time.Second
+)
+
+type closer interface {
+	Close() error
+}
+
+type closers struct {
+	mtx sync.Mutex
+	wg  sync.WaitGroup
+	set []closer
+}
+
+func newClosers() *closers {
+	return &closers{}
+}
+
+func (c *closers) add(v closer) (wg *sync.WaitGroup) {
+	if v == nil {
+		return nil
+	}
+	c.mtx.Lock()
+	c.set = append(c.set, v)
+	wg = &c.wg
+	c.mtx.Unlock()
+	return
+}
+
+func (c *closers) Close() (err error) {
+	c.mtx.Lock()
+	for _, v := range c.set {
+		err = errors.Join(err, v.Close())
+	}
+	c.wg.Wait()
+	c.set = nil
+	c.mtx.Unlock()
+	return
+}
+
+type natsConsumer struct {
+	natsConsumerConfig
+	mtx     sync.Mutex
+	started bool
+	ctx     context.Context
+	cf      context.CancelFunc
+	count   uint
+	size    uint
+	src     net.IP
+}
+
+type natsConsumerConfig struct {
+	consumerCfg
+	name   string
+	defTag entry.EntryTag
+	igst   *ingest.IngestMuxer
+	lg     *log.Logger
+	pproc  *processors.ProcessorSet
+	tgr    *tags.Tagger
+}
+
+func newNatsConsumer(cfg natsConsumerConfig) (nc *natsConsumer, err error) {
+	if cfg.igst == nil {
+		err = errors.New("nil ingest connection")
+	} else if cfg.lg == nil {
+		err = errors.New("nil logger")
+	} else if cfg.tgr == nil {
+		err = errors.New("nil tagger")
+	} else {
+		nc = &natsConsumer{
+			natsConsumerConfig: cfg,
+		}
+		nc.ctx, nc.cf = context.WithCancel(context.Background())
+	}
+	return
+}
+
+func (nc *natsConsumer) Start(wg *sync.WaitGroup) (err error) {
+	nc.mtx.Lock()
+	if nc.started {
+		err = errors.New("already started")
+	} else if nc.ctx == nil || nc.cf == nil {
+		err = errors.New("closer context is nil, already closed")
+	} else {
+		wg.Add(1)
+		nc.started = true
+		go nc.routine(wg)
+	}
+	nc.mtx.Unlock()
+	return
+}
+
+// Close stops the consumer, any messages that have not been acknowledged are redelivered after Ack-Wait
+func (nc *natsConsumer) Close() (err error) {
+	if nc == nil {
+		err = errors.New("nil consumer")
+	} else {
+		nc.mtx.Lock()
+		if nc.cf == nil {
+			err = errors.New("nil closer conn, routine closed")
+		} else {
+			nc.cf()
+			nc.cf = nil
+		}
+		nc.mtx.Unlock()
+	}
+	return
+}
+
+// routine consumes until we are closed, starting over whenever the consumer fails
+func (nc *natsConsumer) routine(wg *sync.WaitGroup) {
+	defer wg.Done()
+	for i := 1; nc.ctx.Err() == nil; i++ {
+		nc.lg.Info("consumer start", log.KV("consumer", nc.name), log.KV("stream", nc.stream),
+			log.KV("durable", nc.durable), log.KV("attempt", i))
+		err := nc.consume()
+		if nc.ctx.Err() != nil {
+			break
+		}
+		nc.lg.Error("consumer error", log.KV("consumer", nc.name), log.KVErr(err))
+		select {
+		case <-nc.ctx.Done():
+		case <-time.After(reconnectDelay):
+		}
+	}
+	nc.lg.Info("nats consumer stats", log.KV("consumer", nc.name), log.KV("stream", nc.stream),
+		log.KV("durable", nc.durable), log.KV("count", nc.count), log.KV("size", nc.size))
+}
+
+func (nc *natsConsumer) options() (opts []nats.Option) {
+	opts = []nats.Option{
+		nats.Name(nc.durable),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(reconnectDelay),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				nc.lg.Warn("disconnected from server", log.KV("consumer", nc.name), log.KVErr(err))
+			}
+		}),
+		nats.ReconnectHandler(func(c *nats.Conn) {
+			nc.lg.Info("reconnected to server", log.KV("consumer", nc.name), log.KV("server", c.ConnectedAddr()))
+		}),
+	}
+	if nc.username != `` {
+		opts = append(opts, nats.UserInfo(nc.username, nc.password))
+	} else if nc.token != `` {
+		opts = append(opts, nats.Token(nc.token))
+	} else if nc.creds != `` {
+		opts = append(opts, nats.UserCredentials(nc.creds))
+	}
+	if nc.useTLS {
+		opts = append(opts, nats.Secure(&tls.Config{
+			MinVersion:         minTLSVersion,
+			InsecureSkipVerify: nc.skipVerify,
+		}))
+	}
+	return
+}
+
+func (nc *natsConsumer) consume() (err error) {
+	//wait for a hot ingester so that we are not holding a pile of unacknowledged messages
+	if err = nc.igst.WaitForHotContext(nc.ctx, 0); err != nil {
+		return
+	}
+	if nc.srcOverride != nil {
+		nc.src = nc.srcOverride
+	} else if ip, err := nc.igst.SourceIP(); err == nil {
+		nc.src = ip
+	} else {
+		nc.src = nil
+	}
+
+	var conn *nats.Conn
+	if conn, err = nats.Connect(strings.Join(nc.servers, `,`), nc.options()...); err != nil {
+		return
+	}
+	defer conn.Close()
+	var js jetstream.JetStream
+	if js, err = jetstream.New(conn); err != nil {
+		return
+	}
+	//create the durable consumer if it does not exist, or bring an existing one in line with our config
+	var cons jetstream.Consumer
+	ctx, cf := context.WithTimeout(nc.ctx, requestTimeout)
+	cons, err = js.CreateOrUpdateConsumer(ctx, nc.stream, nc.consumerConfig())
+	cf()
+	if err != nil {
+		return
+	}
+	var iter jetstream.MessagesContext
+	if iter, err = cons.Messages(jetstream.PullMaxMessages(nc.prefetch), jetstream.WithMessagesErrOnMissingHeartbeat(false)); err != nil {
+		return
+	}
+	defer iter.Stop()
+	nc.lg.Info("consumer started", log.KV("consumer", nc.name), log.KV("stream", nc.stream),
+		log.KV("durable", nc.durable), log.KV("server", conn.ConnectedAddr()), log.KV("source", nc.src))
+
+	//pump messages out of the blocking iterator so that we can flush partial batches on a timer
+	lctx, lcf := context.WithCancel(nc.ctx)
+	defer lcf()
+	mch := make(chan jetstream.Msg)
+	ech := make(chan error, 1)
+	go func() {
+		for {
+			m, err := iter.Next()
+			if err != nil {
+				ech <- err
+				return
+			}
+			select {
+			case mch <- m:
+			case <-lctx.Done():
+				return
+			}
+		}
+	}()
+
+	tckr := time.NewTicker(flushInterval)
+	defer tckr.Stop()
+	batch := make([]jetstream.Msg, 0, nc.batchSize)
+	for {
+		select {
+		case <-nc.ctx.Done():
+			//anything not yet acknowledged is redelivered once Ack-Wait expires
+			return nil
+		case err = <-ech:
+			return
+		case m := <-mch:
+			batch = append(batch, m)
+			if len(batch) == cap(batch) {
+				if err = nc.flush(batch); err != nil {
+					return fmt.Errorf("failed to write %d entries: %w", len(batch), err)
+				}
+				batch = batch[0:0]
+			}
+		case <-tckr.C:
+			if len(batch) > 0 {
+				if err = nc.flush(batch); err != nil {
+					return fmt.Errorf("failed to write %d entries: %w", len(batch), err)
+				}
+				batch = batch[0:0]
+			}
+		}
+	}
+}
+
+// flush hands a batch of messages to the ingest muxer and only acknowledges them once
+// the muxer has accepted every entry, a failed batch is left for the server to redeliver
+func (nc *natsConsumer) flush(batch []jetstream.Msg) (err error) {
+	var sz uint
+	var cnt uint
+	for _, m := range batch {
+		ent := &entry.Entry{
+			TS:   entry.Now(),
+			Data: m.Data(),
+		}
+		if !nc.ignoreTS {
+			if md, err := m.Metadata(); err == nil && !md.Timestamp.IsZero() {
+				ent.TS = entry.FromStandard(md.Timestamp)
+			}
+			if nc.extractTS && nc.tg != nil {
+				if hts, ok, err := nc.tg.Extract(ent.Data); err != nil {
+					nc.lg.Warn("catastrophic timegrinder error", log.KVErr(err))
+				} else if ok {
+					ent.TS = entry.FromStandard(hts)
+				}
+				// if not ok, we'll just use the message timestamp
+			}
+		}
+		if ent.Tag, ent.SRC, err = nc.resolveSourceAndTag(m.Headers()); err != nil {
+			return
+		}
+		if err = nc.pproc.ProcessContext(ent, nc.ctx); err != nil {
+			return
+		}
+		sz += uint(ent.Size())
+		cnt++
+	}
+	if nc.sync {
+		if err = nc.igst.SyncContext(nc.ctx, time.Second); err != nil {
+			return
+		}
+	}
+	//acknowledge everything now that the muxer has it
+	for _, m := range batch {
+		if err = m.Ack(); err != nil {
+			return
+		}
+	}
+	nc.count += cnt
+	nc.size += sz
+	return
+}
+
+func (nc *natsConsumer) resolveTag(tn string) (tag entry.EntryTag, ok bool, err error) {
+	//invalid and disallowed tag names fall back to the default tag without being negotiated
+	if !nc.tgr.AllowedName(tn) {
+		return
+	} else if tag, err = nc.tgr.Negotiate(tn); err != nil {
+		return
+	}
+	ok = nc.tgr.Allowed(tag)
+	return
+}
+
+func (nc *natsConsumer) resolveSourceAndTag(hdrs nats.Header) (tag entry.EntryTag, ip net.IP, err error) {
+	var tagHit bool
+	if v := hdrs.Get(nc.srcKey); v != `` {
+		ip = net.ParseIP(v)
+	}
+	if v := hdrs.Get(nc.tagKey); v != `` {
+		if tag, tagHit, err = nc.resolveTag(v); err != nil {
+			return
+		}
+	}
+	//if we still missed, just use the src
+	if ip == nil {
+		ip = nc.src
+	}
+	if !tagHit {
+		tag = nc.defTag
+	}
+	return
+}
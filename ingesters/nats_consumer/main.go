@@ -0,0 +1,146 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	// Embed tzdata so that we don't rely on potentially broken timezone DBs on the host
+	_ "time/tzdata"
+
+	"github.com/gravwell/gravwell/v3/debug"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+	"github.com/gravwell/gravwell/v3/ingest/processors/tags"
+	"github.com/gravwell/gravwell/v3/ingesters/base"
+	"github.com/gravwell/gravwell/v3/ingesters/utils"
+)
+
+const (
+	defaultConfigLoc  = `/opt/gravwell/etc/nats.conf`
+	defaultConfigDLoc = `/opt/gravwell/etc/nats.conf.d`
+	ingesterName      = `nats_consumer`
+	appName           = `nats`
+)
+
+var (
+	debugOn bool
+	lg      *log.Logger
+)
+
+func main() {
+	go debug.HandleDebugSignals(ingesterName)
+	var cfg *cfgType
+	ibc := base.IngesterBaseConfig{
+		IngesterName:                 ingesterName,
+		AppName:                      appName,
+		DefaultConfigLocation:        defaultConfigLoc,
+		DefaultConfigOverlayLocation: defaultConfigDLoc,
+		GetConfigFunc:                GetConfig,
+	}
+	ib, err := base.Init(ibc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to get configuration %v\n", err)
+		return
+	} else if err = ib.AssignConfig(&cfg); err != nil || cfg == nil {
+		fmt.Fprintf(os.Stderr, "failed to assign configuration %v %v\n", err, cfg == nil)
+		return
+	}
+	debugOn = ib.Verbose
+	lg = ib.Logger
+	id, ok := cfg.IngesterUUID()
+	if !ok {
+		ib.Logger.FatalCode(0, "could not read ingester UUID")
+	}
+
+	igst, err := ib.GetMuxer()
+	if err != nil {
+		ib.Logger.FatalCode(0, "failed to get ingest connection", log.KVErr(err))
+		return
+	}
+	defer igst.Close()
+	ib.AnnounceStartup()
+
+	debugout("Started ingester muxer\n")
+
+	clsrs := newClosers()
+
+	var procs []*processors.ProcessorSet
+
+	//fire up our consumers
+	for k, v := range cfg.Consumers {
+		ncfg := natsConsumerConfig{
+			consumerCfg: *v,
+			name:        k,
+			igst:        igst,
+			lg:          lg,
+		}
+		//the default tag is always allowed
+		ncfg.TaggerConfig.Tags = append(ncfg.TaggerConfig.Tags, v.defTag)
+		if ncfg.tgr, err = tags.NewTagger(ncfg.TaggerConfig, igst); err != nil {
+			lg.Fatal("failed to establish a new tagger", log.KV("consumer", k), log.KVErr(err))
+		} else if ncfg.defTag, err = igst.GetTag(v.defTag); err != nil {
+			lg.Fatal("failed to resolve default tag", log.KV("consumer", k), log.KV("tag", v.defTag), log.KVErr(err))
+		}
+		if ncfg.pproc, err = cfg.Preprocessor.ProcessorSet(igst, v.preprocessor); err != nil {
+			lg.Fatal("preprocessor construction error", log.KVErr(err))
+		}
+		procs = append(procs, ncfg.pproc)
+		nc, err := newNatsConsumer(ncfg)
+		if err != nil {
+			lg.Error("failed to build nats consumer", log.KV("consumer", k), log.KVErr(err))
+			if err = clsrs.Close(); err != nil {
+				lg.Error("failed to close all consumers", log.KVErr(err))
+			}
+			return
+		}
+		wg := clsrs.add(nc)
+		if err = nc.Start(wg); err != nil {
+			lg.Error("failed to start nats consumer", log.KV("consumer", k), log.KVErr(err))
+			if err = clsrs.Close(); err != nil {
+				lg.Error("failed to close all consumers", log.KVErr(err))
+			}
+			return
+		}
+	}
+
+	//listen for signals so we can close gracefully
+	utils.WaitForQuit()
+	ib.AnnounceShutdown()
+
+	//close down our consumers
+	if err := clsrs.Close(); err != nil {
+		lg.Error("failed to close all consumers", log.KVErr(err))
+	}
+
+	//close down all the preprocessors
+	for _, v := range procs {
+		if v != nil {
+			if err := v.Close(); err != nil {
+				lg.Error("failed to close processors", log.KVErr(err))
+			}
+		}
+	}
+
+	lg.Info("nats_consumer ingester exiting", log.KV("ingesteruuid", id))
+	if err := igst.Sync(time.Second); err != nil {
+		lg.Error("failed to sync", log.KVErr(err))
+	}
+	if err := igst.Close(); err != nil {
+		lg.Error("failed to close", log.KVErr(err))
+	}
+}
+
+func debugout(format string, args ...interface{}) {
+	if debugOn {
+		fmt.Printf(format, args...)
+	}
+}
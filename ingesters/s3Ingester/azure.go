@@ -0,0 +1,157 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+)
+
+// AzureConfig describes an Azure Blob Storage container
+type AzureConfig struct {
+	Account_Name   string
+	Account_Key    string `json:"-"` //shared key auth, do not ship this as part of a config report
+	SAS_Token      string `json:"-"` //shared access signature auth, do not ship this as part of a config report
+	Container_Name string
+	Endpoint       string //defaults to https://<account>.blob.core.windows.net/, set for emulators such as Azurite
+}
+
+func (ac AzureConfig) validate() error {
+	if ac.Account_Name == `` {
+		return errors.New("missing Account-Name")
+	} else if ac.Container_Name == `` {
+		return errors.New("missing Container-Name")
+	} else if (ac.Account_Key == ``) == (ac.SAS_Token == ``) {
+		return errors.New("exactly one of Account-Key or SAS-Token is required")
+	} else if ac.Endpoint != `` {
+		if _, err := url.Parse(ac.Endpoint); err != nil {
+			return fmt.Errorf("invalid Endpoint %q %w", ac.Endpoint, err)
+		}
+	}
+	return nil
+}
+
+func (ac AzureConfig) serviceURL() string {
+	if ac.Endpoint != `` {
+		return strings.TrimSuffix(ac.Endpoint, `/`) + `/`
+	}
+	return fmt.Sprintf("https://%s.blob.core.windows.net/", ac.Account_Name)
+}
+
+type azureStore struct {
+	client    *azblob.Client
+	container string
+	stateKey  string
+}
+
+func newAzureStore(ac AzureConfig) (as *azureStore, err error) {
+	if err = ac.validate(); err != nil {
+		return
+	}
+	svcURL := ac.serviceURL()
+	var client *azblob.Client
+	if ac.Account_Key != `` {
+		var cred *azblob.SharedKeyCredential
+		if cred, err = azblob.NewSharedKeyCredential(ac.Account_Name, ac.Account_Key); err != nil {
+			err = fmt.Errorf("invalid Account-Key %w", err)
+			return
+		}
+		client, err = azblob.NewClientWithSharedKeyCredential(svcURL, cred, nil)
+	} else {
+		client, err = azblob.NewClientWithNoCredential(svcURL+`?`+strings.TrimPrefix(ac.SAS_Token, `?`), nil)
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to create Azure Blob client %w", err)
+		return
+	}
+	as = &azureStore{
+		client:    client,
+		container: ac.Container_Name,
+		stateKey:  svcURL + ac.Container_Name,
+	}
+	return
+}
+
+// StateKey is the container URL, container names are only unique within an account
+func (as *azureStore) StateKey() string {
+	return as.stateKey
+}
+
+func (as *azureStore) Test(ctx context.Context) (err error) {
+	pager := as.client.NewListBlobsFlatPager(as.container, &azblob.ListBlobsFlatOptions{
+		MaxResults: to.Ptr(int32(1)), //just need one to check
+	})
+	_, err = pager.NextPage(ctx)
+	return
+}
+
+func (as *azureStore) List(ctx context.Context, prefix string, fn func(objectInfo) bool) error {
+	var opts azblob.ListBlobsFlatOptions
+	if prefix != `` {
+		opts.Prefix = to.Ptr(prefix)
+	}
+	pager := as.client.NewListBlobsFlatPager(as.container, &opts)
+	for pager.More() {
+		resp, err := pager.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		if resp.Segment == nil {
+			continue
+		}
+		for _, item := range resp.Segment.BlobItems {
+			if item == nil || item.Name == nil || item.Properties == nil ||
+				item.Properties.ContentLength == nil || item.Properties.LastModified == nil {
+				continue
+			}
+			oi := objectInfo{
+				Key:          *item.Name,
+				Size:         *item.Properties.ContentLength,
+				LastModified: *item.Properties.LastModified,
+			}
+			if !fn(oi) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+func (as *azureStore) Get(ctx context.Context, key string, offset int64) (rc io.ReadCloser, sz int64, err error) {
+	var opts azblob.DownloadStreamOptions
+	if offset > 0 {
+		opts.Range = blob.HTTPRange{Offset: offset} //zero count is the rest of the blob
+	}
+	var resp azblob.DownloadStreamResponse
+	if resp, err = as.client.DownloadStream(ctx, as.container, key, &opts); err != nil {
+		return
+	}
+	if resp.ContentLength != nil {
+		sz = *resp.ContentLength
+	}
+	rc = resp.Body
+	return
+}
+
+// NewAzureBucketReader creates a reader that scans an Azure Blob Storage container
+func NewAzureBucketReader(cfg BucketConfig, ac AzureConfig) (br *BucketReader, err error) {
+	var as *azureStore
+	if as, err = newAzureStore(ac); err != nil {
+		return
+	}
+	return newBucketReader(cfg, as)
+}
@@ -40,9 +40,9 @@ type AuthConfig struct {
 type BucketConfig struct {
 	AuthConfig
 	TimeConfig
+	ReaderConfig
 	Verbose          bool
 	MaxLineSize      int
-	Reader           string //defaults to line
 	Name             string
 	FileFilters      []string
 	Tag              entry.EntryTag
@@ -59,25 +59,16 @@ type BucketConfig struct {
 type BucketReader struct {
 	BucketConfig
 	prefixFilter string
-	session      *session.Session
-	svc          *s3.S3
+	store        objectStore
 	filter       *matcher
-	tg           timegrinder.TimeGrinder
 	src          net.IP
-	rdr          reader
+	rdr          *objectReader
 }
 
+// NewBucketReader creates a reader that scans an S3 bucket
 func NewBucketReader(cfg BucketConfig) (br *BucketReader, err error) {
-	var rdr reader
 	var sess *session.Session
-	if err = cfg.validate(); err != nil {
-		return
-	}
-	var filter *matcher
-	if filter, err = newMatcher(cfg.FileFilters); err != nil {
-		return
-	}
-	if rdr, err = parseReader(cfg.Reader); err != nil {
+	if err = cfg.AuthConfig.validate(); err != nil {
 		return
 	}
 	c, err := sqs_common.GetCredentials(cfg.Credentials_Type, cfg.ID, cfg.Secret)
@@ -88,11 +79,26 @@ func NewBucketReader(cfg BucketConfig) (br *BucketReader, err error) {
 		err = fmt.Errorf("Failed to create S3 session %w", err)
 		return
 	}
+	return newBucketReader(cfg, &s3Store{svc: s3.New(sess), bucket: cfg.Bucket_Name})
+}
+
+// newBucketReader wires up a reader for any object store backend
+func newBucketReader(cfg BucketConfig, st objectStore) (br *BucketReader, err error) {
+	var rdr *objectReader
+	if err = cfg.validate(); err != nil {
+		return
+	}
+	var filter *matcher
+	if filter, err = newMatcher(cfg.FileFilters); err != nil {
+		return
+	}
+	if rdr, err = cfg.ReaderConfig.newObjectReader(cfg.MaxLineSize); err != nil {
+		return
+	}
 
 	br = &BucketReader{
 		BucketConfig: cfg,
-		session:      sess,
-		svc:          s3.New(sess),
+		store:        st,
 		filter:       filter,
 		src:          cfg.srcOverride(),
 		rdr:          rdr,
@@ -102,9 +108,7 @@ func NewBucketReader(cfg BucketConfig) (br *BucketReader, err error) {
 }
 
 func (bc *BucketConfig) validate() (err error) {
-	if err = bc.AuthConfig.validate(); err != nil {
-		return
-	} else if err = bc.TimeConfig.validate(); err != nil {
+	if err = bc.TimeConfig.validate(); err != nil {
 		return
 	} else if bc.Proc == nil {
 		err = errors.New("processor is empty")
@@ -136,14 +140,7 @@ func (bc *BucketConfig) srcOverride() net.IP {
 }
 
 func (br *BucketReader) Test(ctx context.Context) error {
-	//list the objects in the bucket
-	req := s3.ListObjectsV2Input{
-		Bucket:  aws.String(br.Bucket_Name),
-		MaxKeys: aws.Int64(1), //just need one to check
-	}
-	return br.svc.ListObjectsV2Pages(&req, func(resp *s3.ListObjectsV2Output, lastPage bool) bool {
-		return false //do not continue the scan
-	})
+	return br.store.Test(ctx)
 }
 
 // ShouldTrack just checks if we should process this file
@@ -153,84 +150,77 @@ func (br *BucketReader) ShouldTrack(obj string) (ok bool) {
 	return //nope
 }
 
-// Process reads the object in and processes its contents
-func (br *BucketReader) Process(obj *s3.Object, ctx context.Context) (sz int64, s3rtt, rtt time.Duration, err error) {
-	return ProcessContext(obj, ctx, br.svc, br.Bucket_Name, br.rdr, br.TG, br.src, br.Tag, br.Proc, br.MaxLineSize)
+// Process reads the object in and processes its contents, cp may be nil
+func (br *BucketReader) Process(obj objectInfo, ctx context.Context, cp *checkpointer) (sz int64, s3rtt, rtt time.Duration, err error) {
+	return ProcessContext(obj.Key, ctx, br.store, br.rdr, cp, br.TG, br.src, br.Tag, br.Proc)
 }
 
-func (br *BucketReader) ManualScan(lg *log.Logger, ctx context.Context, ot *objectTracker, queue chan<- *s3.Object) (err error) {
+func (br *BucketReader) ManualScan(lg *log.Logger, ctx context.Context, ot *objectTracker, queue chan<- objectInfo) (err error) {
 	lg.Info("manual scan started", log.KV("bucket", br.Name))
 
-	//list the objects in the bucket
-	req := s3.ListObjectsV2Input{
-		Bucket: aws.String(br.Bucket_Name),
-	}
-	if br.prefixFilter != `` {
-		req.Prefix = aws.String(br.prefixFilter)
-	}
-
 	var count uint64
-
-	var lerr error
-	objListHandler := func(resp *s3.ListObjectsV2Output, lastPage bool) bool {
-		for _, item := range resp.Contents {
-			select {
-			case queue <- item:
-				count++
-			case <-ctx.Done():
-				return false
-			}
-			if ctx.Err() != nil {
-				return false
-			}
+	err = br.store.List(ctx, br.prefixFilter, func(item objectInfo) bool {
+		select {
+		case queue <- item:
+			count++
+		case <-ctx.Done():
+			return false
 		}
-		return true
-	}
-
-	if err = br.svc.ListObjectsV2Pages(&req, objListHandler); err == nil {
-		err = lerr
-	}
+		return ctx.Err() == nil
+	})
 
 	lg.Info("manual scan completed", log.KV("bucket", br.Name), log.KV("object_count", count))
 	return
 }
 
-func (br *BucketReader) worker(lg *log.Logger, ctx context.Context, ot *objectTracker, queue <-chan *s3.Object, wg *sync.WaitGroup) {
+func (br *BucketReader) worker(lg *log.Logger, ctx context.Context, ot *objectTracker, queue <-chan objectInfo, wg *sync.WaitGroup) {
 	lg.Info("manual scan worker started", log.KV("bucket", br.Name))
 	defer wg.Done()
 
 	var processed, alreadyProcessed, skipped, errored uint64
+	stateKey := br.store.StateKey()
 
 	for item := range queue {
-		if item == nil {
-			return
-		}
-
-		//do a quick check for stupidity
-		if item.Size == nil || item.LastModified == nil || item.Key == nil {
-			skipped++
-			continue
-		}
-		sz, lm, key := *item.Size, *item.LastModified, *item.Key
+		sz, lm, key := item.Size, item.LastModified, item.Key
 		if sz == 0 || !br.ShouldTrack(key) {
 			skipped++
 			continue //skip empty objects or things we should not track
 		}
 		//lookup the object in the objectTracker
-		state, ok := ot.Get(br.Bucket_Name, key)
+		state, ok := ot.Get(stateKey, key)
+		var start objectCheckpoint
 		if ok && state.Updated.Equal(lm) {
-			alreadyProcessed++
-			continue //already handled this
+			if state.Checkpoint == nil {
+				alreadyProcessed++
+				continue //already handled this
+			}
+			//we were part way through this one
+			start = *state.Checkpoint
+			br.Logger.Info("resuming object",
+				log.KV("name", br.Name),
+				log.KV("object", key),
+				log.KV("offset", start.Offset),
+				log.KV("skip", start.Skip))
 		}
 
 		//ok, lets process this thing
-		if objsz, s3rtt, rtt, err := br.Process(item, ctx); err != nil {
+		cp := newCheckpointer(ot, stateKey, key, trackedObjectState{Updated: lm, Size: sz}, start)
+		if objsz, s3rtt, rtt, err := br.Process(item, ctx, cp); err != nil {
 			br.Logger.Error("failed to process object",
 				log.KV("name", br.Name),
 				log.KV("object", key),
 				log.KV("tag", br.TagName),
 				log.KVErr(err))
+			if err = cp.flush(); err != nil {
+				br.Logger.Error("failed to save object checkpoint",
+					log.KV("name", br.Name),
+					log.KV("object", key),
+					log.KVErr(err))
+			}
 			errored++
+			if ctx.Err() != nil {
+				break
+			}
 			continue
 		} else {
 			br.Logger.Info("consumed object",
@@ -246,7 +236,7 @@ func (br *BucketReader) worker(lg *log.Logger, ctx context.Context, ot *objectTr
 			Updated: lm,
 			Size:    sz,
 		}
-		err := ot.Set(br.Bucket_Name, key, state, false)
+		err := ot.Set(stateKey, key, state, false)
 		if err != nil {
 			br.Logger.Error("failed to update state",
 				log.KV("name", br.Name),
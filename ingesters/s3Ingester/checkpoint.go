@@ -0,0 +1,177 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	checkpointInterval = 10 * time.Second
+)
+
+var (
+	magicGzip  = []byte{0x1f, 0x8b}
+	magicBzip2 = []byte{'B', 'Z', 'h'}
+)
+
+// objectCheckpoint marks how far into an object processing got so that a restart can pick up
+// where it left off.  Offset is always a point a reader can restart from, Skip is the number of
+// entries past that point that were already sent.
+type objectCheckpoint struct {
+	Offset     int64    //offset into the object content, decompressed if the object is compressed
+	Skip       int64    `json:",omitempty"`
+	Header     []string `json:",omitempty"` //CSV header from the start of the object
+	Compressed bool     `json:",omitempty"` //resuming means re-reading from the start and skipping Offset bytes
+}
+
+// checkpointer tracks progress through a single object and periodically pushes it into the
+// object tracker.  A checkpointer is owned by a single worker, a nil checkpointer is valid and
+// does nothing.
+type checkpointer struct {
+	ot       *objectTracker
+	stateKey string
+	key      string
+	state    trackedObjectState
+	start    objectCheckpoint
+	cp       objectCheckpoint
+	dirty    bool
+	err      error //last failure to save a periodic checkpoint
+	last     time.Time
+	interval time.Duration
+}
+
+func newCheckpointer(ot *objectTracker, stateKey, key string, state trackedObjectState, start objectCheckpoint) *checkpointer {
+	return &checkpointer{
+		ot:       ot,
+		stateKey: stateKey,
+		key:      key,
+		state:    state,
+		start:    start,
+		cp:       start,
+		last:     time.Now(),
+		interval: checkpointInterval,
+	}
+}
+
+// resumeFrom returns the checkpoint processing should start at
+func (c *checkpointer) resumeFrom() (cp objectCheckpoint) {
+	if c != nil {
+		cp = c.start
+	}
+	return
+}
+
+// objectSize returns the size of the object being processed, zero if unknown
+func (c *checkpointer) objectSize() (sz int64) {
+	if c != nil {
+		sz = c.state.Size
+	}
+	return
+}
+
+// restart discards the starting checkpoint so that the object is processed from the beginning
+func (c *checkpointer) restart() {
+	if c != nil {
+		c.start = objectCheckpoint{}
+		c.cp = c.start
+	}
+}
+
+func (c *checkpointer) setCompressed(v bool) {
+	if c != nil {
+		c.cp.Compressed = v
+	}
+}
+
+func (c *checkpointer) setHeader(hdr []string) {
+	if c != nil {
+		c.cp.Header = hdr
+	}
+}
+
+// update records that everything up to offset plus skip entries has been sent, failing to save a
+// checkpoint does not stop processing so the error is held until the next flush
+func (c *checkpointer) update(offset, skip int64) {
+	if c == nil {
+		return
+	}
+	c.cp.Offset, c.cp.Skip = offset, skip
+	c.dirty = true
+	if time.Since(c.last) >= c.interval {
+		if err := c.save(); err != nil {
+			c.err = err
+		}
+	}
+}
+
+// flush pushes the latest checkpoint to the state file, this is called when processing stops part
+// way through an object
+func (c *checkpointer) flush() (err error) {
+	if c == nil {
+		return
+	}
+	if c.dirty {
+		err = c.save()
+	} else {
+		err = c.err
+	}
+	c.err = nil
+	return
+}
+
+func (c *checkpointer) save() (err error) {
+	state := c.state
+	cp := c.cp
+	state.Checkpoint = &cp
+	if err = c.ot.Set(c.stateKey, c.key, state, true); err != nil {
+		err = fmt.Errorf("failed to save checkpoint %w", err)
+	}
+	c.dirty = false
+	c.last = time.Now()
+	return
+}
+
+// decompress sniffs the start of an object and transparently decompresses gzip and bzip2 content,
+// it is only used when the Decompress reader option is set
+func decompress(r io.Reader) (io.Reader, bool, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(magicBzip2))
+	if err != nil && err != io.EOF {
+		return nil, false, err
+	}
+	if bytes.HasPrefix(magic, magicGzip) {
+		gzr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid gzip object %w", err)
+		}
+		return gzr, true, nil
+	} else if bytes.HasPrefix(magic, magicBzip2) {
+		return bzip2.NewReader(br), true, nil
+	}
+	return br, false, nil
+}
+
+// countingReader tracks how many bytes have been read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(b []byte) (n int, err error) {
+	n, err = cr.r.Read(b)
+	cr.n += int64(n)
+	return
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+	"github.com/parquet-go/parquet-go"
+)
+
+var errTestWriterClosed = errors.New("writer closed")
+
+// limitWriter accepts limit entries and then fails, a zero limit never fails
+type limitWriter struct {
+	testWriter
+	limit int
+}
+
+func (lw *limitWriter) WriteEntry(ent *entry.Entry) error {
+	lw.Lock()
+	defer lw.Unlock()
+	if lw.limit > 0 && len(lw.ents) >= lw.limit {
+		return errTestWriterClosed
+	}
+	lw.ents = append(lw.ents, ent)
+	return nil
+}
+
+func (lw *limitWriter) WriteEntryContext(ctx context.Context, ent *entry.Entry) error {
+	return lw.WriteEntry(ent)
+}
+
+func (lw *limitWriter) WriteBatch(ents []*entry.Entry) error {
+	for _, ent := range ents {
+		if err := lw.WriteEntry(ent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (lw *limitWriter) WriteBatchContext(ctx context.Context, ents []*entry.Entry) error {
+	return lw.WriteBatch(ents)
+}
+
+func gzipBytes(t *testing.T, v []byte) []byte {
+	t.Helper()
+	bb := bytes.NewBuffer(nil)
+	gzw := gzip.NewWriter(bb)
+	if _, err := gzw.Write(v); err != nil {
+		t.Fatal(err)
+	} else if err = gzw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bb.Bytes()
+}
+
+func TestCheckpointResume(t *testing.T) {
+	lines := []byte("one\ntwo\n\nthree\nfour\n")
+	pq := bytes.NewBuffer(nil)
+	if err := parquet.Write(pq, []testRow{{Name: `a`}, {Name: `b`}, {Name: `c`}}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		rc      ReaderConfig
+		data    []byte
+		limit   int
+		ranged  bool //resume should use a ranged get
+		results []string
+	}{
+		{name: `line`, data: lines, limit: 2, ranged: true, results: []string{`one`, `two`, `three`, `four`}},
+		{name: `gzip`, rc: ReaderConfig{Decompress: true}, data: gzipBytes(t, lines), limit: 3, results: []string{`one`, `two`, `three`, `four`}},
+		{
+			name:    `json`,
+			rc:      ReaderConfig{Reader: `json`, Records_Path: `Records`},
+			data:    []byte(`{"Records":[{"a":1},{"a":2},{"a":3}]}` + "\n" + `{"Records":[{"a":4},{"a":5}]} {"Records":[{"a":6}]}`),
+			limit:   4, //stops part way through the second document
+			ranged:  true,
+			results: []string{`{"a":1}`, `{"a":2}`, `{"a":3}`, `{"a":4}`, `{"a":5}`, `{"a":6}`},
+		},
+		{
+			name:    `csv`,
+			rc:      ReaderConfig{Reader: `csv`},
+			data:    []byte("a,b\n1,2\n3,4\n5,6\n"),
+			limit:   2,
+			ranged:  true,
+			results: []string{`{"a":"1","b":"2"}`, `{"a":"3","b":"4"}`, `{"a":"5","b":"6"}`},
+		},
+		{
+			name:    `parquet`,
+			rc:      ReaderConfig{Reader: `parquet`},
+			data:    pq.Bytes(),
+			limit:   1,
+			results: []string{`{"count":0,"name":"a","score":null,"time":""}`, `{"count":0,"name":"b","score":null,"time":""}`, `{"count":0,"name":"c","score":null,"time":""}`},
+		},
+	}
+	lm := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ms := &memStore{objs: map[string]memObject{`obj`: {data: tc.data, lm: lm}}}
+			ot, err := NewObjectTracker(filepath.Join(t.TempDir(), `state`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rdr, err := tc.rc.newObjectReader(defaultMaxLineSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			state := trackedObjectState{Updated: lm, Size: int64(len(tc.data))}
+
+			//first pass falls over part way through
+			first := &limitWriter{limit: tc.limit}
+			cp := newCheckpointer(ot, ms.StateKey(), `obj`, state, objectCheckpoint{})
+			if _, _, _, err = ProcessContext(`obj`, context.Background(), ms, rdr, cp, nil, nil, 0, processors.NewProcessorSet(first)); !errors.Is(err, errTestWriterClosed) {
+				t.Fatalf("expected writer failure, got %v", err)
+			} else if err = cp.flush(); err != nil {
+				t.Fatal(err)
+			}
+			st, ok := ot.Get(ms.StateKey(), `obj`)
+			if !ok || st.Checkpoint == nil {
+				t.Fatalf("checkpoint was not saved: %+v", st)
+			}
+
+			//reload the state file to make sure checkpoints survive a restart
+			if ot, err = NewObjectTracker(ot.statePath); err != nil {
+				t.Fatal(err)
+			} else if st, ok = ot.Get(ms.StateKey(), `obj`); !ok || st.Checkpoint == nil {
+				t.Fatalf("checkpoint was not persisted: %+v", st)
+			}
+			second := &limitWriter{}
+			cp = newCheckpointer(ot, ms.StateKey(), `obj`, state, *st.Checkpoint)
+			if _, _, _, err = ProcessContext(`obj`, context.Background(), ms, rdr, cp, nil, nil, 0, processors.NewProcessorSet(second)); err != nil {
+				t.Fatal(err)
+			}
+			if tc.ranged != (ms.lastOffset > 0) {
+				t.Fatalf("bad resume offset %d", ms.lastOffset)
+			}
+			checkEntries(t, append(first.ents, second.ents...), tc.results)
+		})
+	}
+}
+
+func TestCheckpointComplete(t *testing.T) {
+	data := []byte("one\ntwo\n")
+	lm := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	ms := &memStore{objs: map[string]memObject{`obj`: {data: data, lm: lm}}}
+	ot, err := NewObjectTracker(filepath.Join(t.TempDir(), `state`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdr, err := ReaderConfig{}.newObjectReader(defaultMaxLineSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//a checkpoint at the end of the object means everything was sent, no ranged get past the end
+	var lw limitWriter
+	state := trackedObjectState{Updated: lm, Size: int64(len(data))}
+	cp := newCheckpointer(ot, ms.StateKey(), `obj`, state, objectCheckpoint{Offset: int64(len(data))})
+	if sz, _, _, err := ProcessContext(`obj`, context.Background(), ms, rdr, cp, nil, nil, 0, processors.NewProcessorSet(&lw)); err != nil {
+		t.Fatal(err)
+	} else if sz != int64(len(data)) || ms.gets != 0 || len(lw.ents) != 0 {
+		t.Fatalf("completed object was fetched again: %d %d %d", sz, ms.gets, len(lw.ents))
+	}
+}
+
+func TestDecompressDisabled(t *testing.T) {
+	data := gzipBytes(t, []byte("one\ntwo\n"))
+	lm := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	ms := &memStore{objs: map[string]memObject{`obj`: {data: data, lm: lm}}}
+	ot, err := NewObjectTracker(filepath.Join(t.TempDir(), `state`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdr, err := ReaderConfig{}.newObjectReader(defaultMaxLineSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//a compressed checkpoint left over from when Decompress was enabled starts the object over
+	var lw limitWriter
+	state := trackedObjectState{Updated: lm, Size: int64(len(data))}
+	cp := newCheckpointer(ot, ms.StateKey(), `obj`, state, objectCheckpoint{Offset: 4, Compressed: true})
+	if _, _, _, err = ProcessContext(`obj`, context.Background(), ms, rdr, cp, nil, nil, 0, processors.NewProcessorSet(&lw)); err != nil {
+		t.Fatal(err)
+	} else if ms.lastOffset != 0 || len(lw.ents) == 0 {
+		t.Fatalf("object was not restarted: %d %d", ms.lastOffset, len(lw.ents))
+	}
+	var raw []byte
+	for _, ent := range lw.ents {
+		raw = append(raw, ent.Data...)
+	}
+	if !bytes.HasPrefix(raw, magicGzip) {
+		t.Fatal("object was decompressed without Decompress set")
+	}
+}
+
+func TestCheckpointInterval(t *testing.T) {
+	ot, err := NewObjectTracker(filepath.Join(t.TempDir(), `state`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp := newCheckpointer(ot, `bkt`, `obj`, trackedObjectState{Size: 100}, objectCheckpoint{})
+	cp.update(10, 0)
+	if _, ok := ot.Get(`bkt`, `obj`); ok {
+		t.Fatal("checkpoint saved before the interval")
+	}
+	cp.interval = 0
+	cp.update(20, 1)
+	if st, ok := ot.Get(`bkt`, `obj`); !ok || st.Checkpoint == nil || st.Checkpoint.Offset != 20 || st.Checkpoint.Skip != 1 || st.Size != 100 {
+		t.Fatalf("bad periodic checkpoint %+v", st)
+	}
+
+	//a nil checkpointer is a no-op
+	var ncp *checkpointer
+	ncp.update(1, 1)
+	if err = ncp.flush(); err != nil || ncp.resumeFrom().Offset != 0 {
+		t.Fatal("nil checkpointer did something")
+	}
+}
+
+func TestBucketReaderResume(t *testing.T) {
+	lm := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	ms := &memStore{objs: map[string]memObject{
+		`a.log`: {data: []byte("one\ntwo\nthree\n"), lm: lm},
+	}}
+	lw := &limitWriter{limit: 1}
+	br, err := newBucketReader(BucketConfig{
+		Name:   `mem`,
+		Proc:   processors.NewProcessorSet(lw),
+		Logger: log.NewDiscardLogger(),
+	}, ms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ot, err := NewObjectTracker(filepath.Join(t.TempDir(), `state`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lg := log.NewDiscardLogger()
+	fullScan(context.Background(), []*BucketReader{br}, ot, lg, 1)
+	if st, ok := ot.Get(ms.StateKey(), `a.log`); !ok || st.Checkpoint == nil || st.Checkpoint.Offset != 4 {
+		t.Fatalf("failed object did not leave a checkpoint: %+v", st)
+	}
+
+	lw.Lock()
+	lw.limit = 0
+	lw.Unlock()
+	fullScan(context.Background(), []*BucketReader{br}, ot, lg, 1)
+	checkEntries(t, lw.ents, []string{`one`, `two`, `three`})
+	if st, ok := ot.Get(ms.StateKey(), `a.log`); !ok || st.Checkpoint != nil || !st.Updated.Equal(lm) {
+		t.Fatalf("completed object still has a checkpoint: %+v", st)
+	}
+
+	//done objects are not touched again
+	gets := ms.gets
+	fullScan(context.Background(), []*BucketReader{br}, ot, lg, 1)
+	if ms.gets != gets {
+		t.Fatal("completed object was re-read")
+	}
+}
@@ -31,10 +31,20 @@ type TimeConfig struct {
 	Timestamp_Format_Override string //override the timestamp format
 }
 
+// ReaderConfig controls how object contents are turned into entries
+type ReaderConfig struct {
+	Reader        string   //defaults to line
+	Records_Path  string   //dotted path to the array of records in each JSON document
+	CSV_Delimiter string   //defaults to a comma for csv and a tab for tsv
+	CSV_Comment   string   //lines starting with this character are skipped
+	CSV_Headers   []string //field names for objects that do not start with a header row
+	Decompress    bool     //transparently decompress gzip and bzip2 objects, detected by their leading bytes
+}
+
 type bucket struct {
 	TimeConfig
 	AuthConfig
-	Reader           string //defaults to line
+	ReaderConfig
 	Tag_Name         string
 	Source_Override  string
 	File_Filters     []string
@@ -45,9 +55,31 @@ type bucket struct {
 	Secret           string `json:"-"` // DO NOT send this when marshalling
 }
 
+type gcsBucket struct {
+	TimeConfig
+	ReaderConfig
+	GCSConfig
+	Tag_Name        string
+	Source_Override string
+	File_Filters    []string
+	Preprocessor    []string
+	Max_Line_Size   int
+}
+
+type azureContainer struct {
+	TimeConfig
+	ReaderConfig
+	AzureConfig
+	Tag_Name        string
+	Source_Override string
+	File_Filters    []string
+	Preprocessor    []string
+	Max_Line_Size   int
+}
+
 type sqsS3 struct {
 	TimeConfig
-	Reader           string //defaults to line
+	ReaderConfig
 	Tag_Name         string
 	Queue_URL        string
 	Region           string
@@ -70,6 +102,8 @@ type cfgReadType struct {
 	Global          global
 	Attach          attach.AttachConfig
 	Bucket          map[string]*bucket
+	GCS_Bucket      map[string]*gcsBucket
+	Azure_Container map[string]*azureContainer
 	SQS_S3_Listener map[string]*sqsS3
 	Preprocessor    processors.ProcessorConfig
 	TimeFormat      config.CustomTimeFormat
@@ -81,6 +115,8 @@ type cfgType struct {
 	State_Store_Location string
 	Worker_Pool_Size     int
 	Bucket               map[string]*bucket
+	GCS_Bucket           map[string]*gcsBucket
+	Azure_Container      map[string]*azureContainer
 	SQS_S3_Listener      map[string]*sqsS3
 	Preprocessor         processors.ProcessorConfig
 	TimeFormat           config.CustomTimeFormat
@@ -100,6 +136,8 @@ func GetConfig(path, overlayPath string) (*cfgType, error) {
 		State_Store_Location: cr.Global.State_Store_Location,
 		Worker_Pool_Size:     cr.Global.Worker_Pool_Size,
 		Bucket:               cr.Bucket,
+		GCS_Bucket:           cr.GCS_Bucket,
+		Azure_Container:      cr.Azure_Container,
 		SQS_S3_Listener:      cr.SQS_S3_Listener,
 		Preprocessor:         cr.Preprocessor,
 		TimeFormat:           cr.TimeFormat,
@@ -127,7 +165,7 @@ func (c *cfgType) Verify() error {
 		c.Worker_Pool_Size = 1
 	}
 
-	if len(c.Bucket) == 0 && len(c.SQS_S3_Listener) == 0 {
+	if len(c.Bucket) == 0 && len(c.GCS_Bucket) == 0 && len(c.Azure_Container) == 0 && len(c.SQS_S3_Listener) == 0 {
 		return errors.New("No listeners specified")
 	}
 	if c.State_Store_Location == `` {
@@ -141,74 +179,74 @@ func (c *cfgType) Verify() error {
 	}
 
 	for k, v := range c.Bucket {
-		if len(v.Tag_Name) == 0 {
-			v.Tag_Name = entry.DefaultTagName
-		}
-		if ingest.CheckTag(v.Tag_Name) != nil {
-			return errors.New("Invalid characters in the Tag-Name for " + k)
-		}
-		if v.Timezone_Override != "" {
-			if v.Assume_Local_Timezone {
-				// cannot do both
-				return fmt.Errorf("Cannot specify Assume-Local-Timezone and Timezone-Override in the same listener %v", k)
-			}
-			if _, err := time.LoadLocation(v.Timezone_Override); err != nil {
-				return fmt.Errorf("Invalid timezone override %v in listener %v: %v", v.Timezone_Override, k, err)
-			}
-		}
-		if v.Source_Override != `` {
-			if net.ParseIP(v.Source_Override) == nil {
-				return fmt.Errorf("Source-Override %s is not a valid IP address", v.Source_Override)
-			}
-		}
-
-		if err := c.Preprocessor.CheckProcessors(v.Preprocessor); err != nil {
-			return fmt.Errorf("Listener %s preprocessor invalid: %v", k, err)
+		if err := c.verifyListener(k, &v.Tag_Name, v.TimeConfig, v.ReaderConfig, v.Source_Override, v.Preprocessor, v.Max_Line_Size); err != nil {
+			return err
 		}
 		if err := v.AuthConfig.validate(); err != nil {
 			return err
 		}
-		if _, err := parseReader(v.Reader); err != nil {
-			return fmt.Errorf("Invalid Reader %q - %v", v.Reader, err)
-		}
 		if _, err := sqs_common.GetCredentials(v.Credentials_Type, v.ID, v.Secret); err != nil {
 			return err
 		}
 	}
 
-	for k, v := range c.SQS_S3_Listener {
-		if len(v.Tag_Name) == 0 {
-			v.Tag_Name = entry.DefaultTagName
+	for k, v := range c.GCS_Bucket {
+		if err := c.verifyListener(k, &v.Tag_Name, v.TimeConfig, v.ReaderConfig, v.Source_Override, v.Preprocessor, v.Max_Line_Size); err != nil {
+			return err
+		} else if err = v.GCSConfig.validate(); err != nil {
+			return fmt.Errorf("GCS-Bucket %s is invalid: %v", k, err)
 		}
-		if ingest.CheckTag(v.Tag_Name) != nil {
-			return errors.New("Invalid characters in the Tag-Name for " + k)
+	}
+
+	for k, v := range c.Azure_Container {
+		if err := c.verifyListener(k, &v.Tag_Name, v.TimeConfig, v.ReaderConfig, v.Source_Override, v.Preprocessor, v.Max_Line_Size); err != nil {
+			return err
+		} else if err = v.AzureConfig.validate(); err != nil {
+			return fmt.Errorf("Azure-Container %s is invalid: %v", k, err)
 		}
-		if v.Timezone_Override != "" {
-			if v.Assume_Local_Timezone {
-				// cannot do both
-				return fmt.Errorf("Cannot specify Assume-Local-Timezone and Timezone-Override in the same listener %v", k)
-			}
-			if _, err := time.LoadLocation(v.Timezone_Override); err != nil {
-				return fmt.Errorf("Invalid timezone override %v in listener %v: %v", v.Timezone_Override, k, err)
-			}
+	}
+
+	for k, v := range c.SQS_S3_Listener {
+		if err := c.verifyListener(k, &v.Tag_Name, v.TimeConfig, v.ReaderConfig, v.Source_Override, v.Preprocessor, v.Max_Line_Size); err != nil {
+			return err
 		}
-		if v.Source_Override != `` {
-			if net.ParseIP(v.Source_Override) == nil {
-				return fmt.Errorf("Source-Override %s is not a valid IP address", v.Source_Override)
-			}
+		if _, err := sqs_common.GetCredentials(v.Credentials_Type, v.ID, v.Secret); err != nil {
+			return err
 		}
+	}
 
-		if err := c.Preprocessor.CheckProcessors(v.Preprocessor); err != nil {
-			return fmt.Errorf("Listener %s preprocessor invalid: %v", k, err)
+	return nil
+}
+
+// verifyListener checks the parameters that every listener type shares, an empty tag is set to the default
+func (c *cfgType) verifyListener(k string, tag *string, tc TimeConfig, rc ReaderConfig, src string, preprocs []string, maxLineSize int) error {
+	if len(*tag) == 0 {
+		*tag = entry.DefaultTagName
+	}
+	if ingest.CheckTag(*tag) != nil {
+		return errors.New("Invalid characters in the Tag-Name for " + k)
+	}
+	if tc.Timezone_Override != "" {
+		if tc.Assume_Local_Timezone {
+			// cannot do both
+			return fmt.Errorf("Cannot specify Assume-Local-Timezone and Timezone-Override in the same listener %v", k)
 		}
-		if _, err := parseReader(v.Reader); err != nil {
-			return fmt.Errorf("Invalid Reader %q - %v", v.Reader, err)
+		if _, err := time.LoadLocation(tc.Timezone_Override); err != nil {
+			return fmt.Errorf("Invalid timezone override %v in listener %v: %v", tc.Timezone_Override, k, err)
 		}
-		if _, err := sqs_common.GetCredentials(v.Credentials_Type, v.ID, v.Secret); err != nil {
-			return err
+	}
+	if src != `` {
+		if net.ParseIP(src) == nil {
+			return fmt.Errorf("Source-Override %s is not a valid IP address", src)
 		}
 	}
 
+	if err := c.Preprocessor.CheckProcessors(preprocs); err != nil {
+		return fmt.Errorf("Listener %s preprocessor invalid: %v", k, err)
+	}
+	if _, err := rc.newObjectReader(maxLineSize); err != nil {
+		return fmt.Errorf("Invalid Reader %q - %v", rc.Reader, err)
+	}
 	return nil
 }
 
@@ -226,6 +264,26 @@ func (c *cfgType) Tags() ([]string, error) {
 		}
 	}
 
+	for _, v := range c.GCS_Bucket {
+		if len(v.Tag_Name) == 0 {
+			continue
+		}
+		if _, ok := tagMp[v.Tag_Name]; !ok {
+			tags = append(tags, v.Tag_Name)
+			tagMp[v.Tag_Name] = true
+		}
+	}
+
+	for _, v := range c.Azure_Container {
+		if len(v.Tag_Name) == 0 {
+			continue
+		}
+		if _, ok := tagMp[v.Tag_Name]; !ok {
+			tags = append(tags, v.Tag_Name)
+			tagMp[v.Tag_Name] = true
+		}
+	}
+
 	for _, v := range c.SQS_S3_Listener {
 		if len(v.Tag_Name) == 0 {
 			continue
@@ -251,6 +309,18 @@ func (c *cfgType) AttachConfig() attach.AttachConfig {
 	return c.Attach
 }
 
+// listenerPipeline resolves the tag, preprocessors, and timegrinder for a listener
+func (c *cfgType) listenerPipeline(igst *ingest.IngestMuxer, k, tagName string, tc TimeConfig, preprocs []string) (tag entry.EntryTag, proc *processors.ProcessorSet, tg *timegrinder.TimeGrinder, err error) {
+	if tag, err = igst.GetTag(tagName); err != nil {
+		err = fmt.Errorf("failed to get established tag %s %w", tagName, err)
+	} else if proc, err = c.Preprocessor.ProcessorSet(igst, preprocs); err != nil {
+		err = fmt.Errorf("preprocessor failure %w", err)
+	} else if !tc.Ignore_Timestamps {
+		tg, err = c.newTimeGrinder(tc)
+	}
+	return
+}
+
 func (c *cfgType) newTimeGrinder(tc TimeConfig) (tg *timegrinder.TimeGrinder, err error) {
 	tcfg := timegrinder.Config{
 		EnableLeftMostSeed: true,
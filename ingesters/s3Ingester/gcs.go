@@ -0,0 +1,120 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+const gcsStatePrefix = `gs://`
+
+// GCSConfig describes a Google Cloud Storage bucket
+type GCSConfig struct {
+	Bucket_Name      string
+	Credentials_File string //service account JSON, application default credentials are used if empty
+	Endpoint         string //for emulators such as fake-gcs-server
+}
+
+func (gc GCSConfig) validate() error {
+	if gc.Bucket_Name == `` {
+		return errors.New("missing Bucket-Name")
+	}
+	return nil
+}
+
+type gcsStore struct {
+	client *storage.Client
+	bucket *storage.BucketHandle
+	name   string
+}
+
+func newGCSStore(gc GCSConfig) (gs *gcsStore, err error) {
+	if err = gc.validate(); err != nil {
+		return
+	}
+	var opts []option.ClientOption
+	if gc.Credentials_File != `` {
+		opts = append(opts, option.WithCredentialsFile(gc.Credentials_File))
+	}
+	if gc.Endpoint != `` {
+		opts = append(opts, option.WithEndpoint(gc.Endpoint))
+		if gc.Credentials_File == `` {
+			//emulators do not do auth
+			opts = append(opts, option.WithoutAuthentication())
+		}
+	}
+	var client *storage.Client
+	if client, err = storage.NewClient(context.Background(), opts...); err != nil {
+		err = fmt.Errorf("failed to create GCS client %w", err)
+		return
+	}
+	gs = &gcsStore{
+		client: client,
+		bucket: client.Bucket(gc.Bucket_Name),
+		name:   gc.Bucket_Name,
+	}
+	return
+}
+
+func (gs *gcsStore) StateKey() string {
+	return gcsStatePrefix + gs.name
+}
+
+func (gs *gcsStore) Test(ctx context.Context) error {
+	//list rather than getting bucket attributes, read only accounts often can't do the latter
+	it := gs.bucket.Objects(ctx, nil)
+	if _, err := it.Next(); err != nil && err != iterator.Done {
+		return err
+	}
+	return nil
+}
+
+func (gs *gcsStore) List(ctx context.Context, prefix string, fn func(objectInfo) bool) error {
+	q := &storage.Query{Prefix: prefix}
+	if err := q.SetAttrSelection([]string{`Name`, `Size`, `Updated`}); err != nil {
+		return err
+	}
+	it := gs.bucket.Objects(ctx, q)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !fn(objectInfo{Key: attrs.Name, Size: attrs.Size, LastModified: attrs.Updated}) {
+			return nil
+		}
+	}
+}
+
+func (gs *gcsStore) Get(ctx context.Context, key string, offset int64) (rc io.ReadCloser, sz int64, err error) {
+	var r *storage.Reader
+	if r, err = gs.bucket.Object(key).NewRangeReader(ctx, offset, -1); err != nil {
+		return
+	}
+	rc, sz = r, r.Attrs.Size
+	return
+}
+
+// NewGCSBucketReader creates a reader that scans a Google Cloud Storage bucket
+func NewGCSBucketReader(cfg BucketConfig, gc GCSConfig) (br *BucketReader, err error) {
+	var gs *gcsStore
+	if gs, err = newGCSStore(gc); err != nil {
+		return
+	}
+	return newBucketReader(cfg, gs)
+}
@@ -85,7 +85,7 @@ func main() {
 			TimeConfig:       v.TimeConfig,
 			Verbose:          ib.Verbose,
 			Name:             k,
-			Reader:           v.Reader,
+			ReaderConfig:     v.ReaderConfig,
 			FileFilters:      v.File_Filters,
 			TagName:          v.Tag_Name,
 			SourceOverride:   v.Source_Override,
@@ -117,6 +117,50 @@ func main() {
 		}
 	}
 
+	//GCS buckets and Azure containers are scanned just like S3 buckets
+	for k, v := range cfg.GCS_Bucket {
+		bcfg := BucketConfig{
+			TimeConfig:     v.TimeConfig,
+			ReaderConfig:   v.ReaderConfig,
+			Verbose:        ib.Verbose,
+			Name:           k,
+			FileFilters:    v.File_Filters,
+			TagName:        v.Tag_Name,
+			SourceOverride: v.Source_Override,
+			Logger:         ib.Logger,
+			MaxLineSize:    v.Max_Line_Size,
+		}
+		if bcfg.Tag, bcfg.Proc, bcfg.TG, err = cfg.listenerPipeline(igst, k, v.Tag_Name, v.TimeConfig, v.Preprocessor); err != nil {
+			ib.Logger.FatalCode(0, "failed to create GCS bucket pipeline", log.KV("bucket", k), log.KVErr(err))
+		}
+		if b, err := NewGCSBucketReader(bcfg, v.GCSConfig); err != nil {
+			ib.Logger.FatalCode(0, "failed to create GCS bucket reader", log.KVErr(err))
+		} else {
+			brs = append(brs, b)
+		}
+	}
+	for k, v := range cfg.Azure_Container {
+		bcfg := BucketConfig{
+			TimeConfig:     v.TimeConfig,
+			ReaderConfig:   v.ReaderConfig,
+			Verbose:        ib.Verbose,
+			Name:           k,
+			FileFilters:    v.File_Filters,
+			TagName:        v.Tag_Name,
+			SourceOverride: v.Source_Override,
+			Logger:         ib.Logger,
+			MaxLineSize:    v.Max_Line_Size,
+		}
+		if bcfg.Tag, bcfg.Proc, bcfg.TG, err = cfg.listenerPipeline(igst, k, v.Tag_Name, v.TimeConfig, v.Preprocessor); err != nil {
+			ib.Logger.FatalCode(0, "failed to create Azure container pipeline", log.KV("container", k), log.KVErr(err))
+		}
+		if b, err := NewAzureBucketReader(bcfg, v.AzureConfig); err != nil {
+			ib.Logger.FatalCode(0, "failed to create Azure container reader", log.KVErr(err))
+		} else {
+			brs = append(brs, b)
+		}
+	}
+
 	//build up our list of sqsS3 listeners
 	var sqsS3 []*SQSS3Listener
 	for k, v := range cfg.SQS_S3_Listener {
@@ -124,7 +168,7 @@ func main() {
 			TimeConfig:       v.TimeConfig,
 			Verbose:          ib.Verbose,
 			Name:             k,
-			Reader:           v.Reader,
+			ReaderConfig:     v.ReaderConfig,
 			TagName:          v.Tag_Name,
 			SourceOverride:   v.Source_Override,
 			Logger:           ib.Logger,
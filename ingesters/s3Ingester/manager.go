@@ -10,8 +10,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/gravwell/gravwell/v3/ingest/log"
 )
@@ -129,20 +127,8 @@ func (s *SQSS3Listener) worker(ctx context.Context, lg *log.Logger, wg *sync.Wai
 					continue
 				}
 
-				obj := &s3.Object{
-					Key: aws.String(x),
-				}
-
-				if obj != nil && obj.Size != nil && *obj.Size == int64(0) {
-					// don't even bother fetching it, just delete and move on
-					lg.Info("skipping zero-byte object",
-						log.KV("worker", workerID),
-						log.KV("bucket", buckets[i]),
-						log.KV("key", x))
-					continue
-				}
-
-				sz, s3rtt, rtt, err = ProcessContext(obj, ctx, s.svc, buckets[i], s.rdr, s.TG, s.src, s.Tag, s.Proc, s.MaxLineSize)
+				st := &s3Store{svc: s.svc, bucket: buckets[i]}
+				sz, s3rtt, rtt, err = ProcessContext(x, ctx, st, s.rdr, nil, s.TG, s.src, s.Tag, s.Proc)
 				if err != nil {
 					shouldDelete = false
 					lg.Error("error processing message", log.KV("bucket", buckets[i]), log.KV("key", x), log.KVErr(err))
@@ -327,7 +313,7 @@ func fullScan(ctx context.Context, buckets []*BucketReader, ot *objectTracker, l
 	lg.Info("starting full manual scan")
 	for _, b := range buckets {
 		// start workers
-		queue := make(chan *s3.Object, QUEUE_DEPTH)
+		queue := make(chan objectInfo, QUEUE_DEPTH)
 		for i := 0; i < numWorkers; i++ {
 			wg.Add(1)
 			go b.worker(lg, ctx, ot, queue, &wg)
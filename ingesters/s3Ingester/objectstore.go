@@ -0,0 +1,98 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// objectInfo describes an object found while listing a bucket
+type objectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// objectStore is implemented by each storage backend that a BucketReader can scan
+type objectStore interface {
+	// StateKey is the name object states are tracked under, it must be unique across backends
+	StateKey() string
+	// Test checks that we can talk to the store and list objects
+	Test(ctx context.Context) error
+	// List calls fn for every object that starts with prefix until fn returns false
+	List(ctx context.Context, prefix string, fn func(objectInfo) bool) error
+	// Get opens an object for reading starting at offset and returns its size if known
+	Get(ctx context.Context, key string, offset int64) (io.ReadCloser, int64, error)
+}
+
+type s3Store struct {
+	svc    *s3.S3
+	bucket string
+}
+
+// StateKey for S3 is just the bucket name so that existing state files keep working
+func (s *s3Store) StateKey() string {
+	return s.bucket
+}
+
+func (s *s3Store) Test(ctx context.Context) error {
+	req := s3.ListObjectsV2Input{
+		Bucket:  aws.String(s.bucket),
+		MaxKeys: aws.Int64(1), //just need one to check
+	}
+	return s.svc.ListObjectsV2PagesWithContext(ctx, &req, func(resp *s3.ListObjectsV2Output, lastPage bool) bool {
+		return false //do not continue the scan
+	})
+}
+
+func (s *s3Store) List(ctx context.Context, prefix string, fn func(objectInfo) bool) error {
+	req := s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+	}
+	if prefix != `` {
+		req.Prefix = aws.String(prefix)
+	}
+	return s.svc.ListObjectsV2PagesWithContext(ctx, &req, func(resp *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, item := range resp.Contents {
+			//do a quick check for stupidity
+			if item.Size == nil || item.LastModified == nil || item.Key == nil {
+				continue
+			}
+			if !fn(objectInfo{Key: *item.Key, Size: *item.Size, LastModified: *item.LastModified}) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func (s *s3Store) Get(ctx context.Context, key string, offset int64) (rc io.ReadCloser, sz int64, err error) {
+	req := s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+	if offset > 0 {
+		req.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+	}
+	var r *s3.GetObjectOutput
+	if r, err = s.svc.GetObjectWithContext(ctx, &req); err != nil {
+		return
+	}
+	if r.ContentLength != nil {
+		sz = *r.ContentLength
+	}
+	rc = r.Body
+	return
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+)
+
+type memObject struct {
+	data []byte
+	lm   time.Time
+}
+
+// memStore is an in memory objectStore so that scanning can be tested without a backend
+type memStore struct {
+	sync.Mutex
+	objs       map[string]memObject
+	gets       int
+	lastOffset int64
+}
+
+func (ms *memStore) StateKey() string               { return `mem://test` }
+func (ms *memStore) Test(ctx context.Context) error { return nil }
+
+func (ms *memStore) List(ctx context.Context, prefix string, fn func(objectInfo) bool) error {
+	for k, v := range ms.objs {
+		if strings.HasPrefix(k, prefix) && !fn(objectInfo{Key: k, Size: int64(len(v.data)), LastModified: v.lm}) {
+			break
+		}
+	}
+	return nil
+}
+
+func (ms *memStore) Get(ctx context.Context, key string, offset int64) (io.ReadCloser, int64, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	obj, ok := ms.objs[key]
+	if !ok {
+		return nil, 0, errors.New("not found")
+	} else if offset > 0 && offset >= int64(len(obj.data)) {
+		return nil, 0, errors.New("invalid range") //S3 responds with a 416
+	}
+	ms.gets++
+	ms.lastOffset = offset
+	data := obj.data[offset:]
+	return io.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+}
+
+func TestBucketReaderScan(t *testing.T) {
+	lm := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	ms := &memStore{objs: map[string]memObject{
+		`logs/a.log`:   {data: []byte("one\ntwo\n"), lm: lm},
+		`logs/b.log`:   {data: []byte("three\n"), lm: lm},
+		`logs/c.txt`:   {data: []byte("filtered\n"), lm: lm},
+		`logs/d.log`:   {data: nil, lm: lm},
+		`other/e.json`: {data: []byte("{}"), lm: lm},
+	}}
+	var tw testWriter
+	br, err := newBucketReader(BucketConfig{
+		Name:        `mem`,
+		FileFilters: []string{`logs/*.log`},
+		Proc:        processors.NewProcessorSet(&tw),
+		Logger:      log.NewDiscardLogger(),
+	}, ms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ot, err := NewObjectTracker(filepath.Join(t.TempDir(), `state`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lg := log.NewDiscardLogger()
+	fullScan(context.Background(), []*BucketReader{br}, ot, lg, 2)
+	if len(tw.ents) != 3 || ms.gets != 2 {
+		t.Fatalf("bad scan, %d entries from %d objects", len(tw.ents), ms.gets)
+	}
+	if st, ok := ot.Get(`mem://test`, `logs/a.log`); !ok || !st.Updated.Equal(lm) || st.Size != 8 {
+		t.Fatalf("object state not tracked under the store key: %+v %v", st, ok)
+	}
+
+	//nothing changed, nothing is re-read
+	fullScan(context.Background(), []*BucketReader{br}, ot, lg, 2)
+	if len(tw.ents) != 3 || ms.gets != 2 {
+		t.Fatalf("unchanged objects were re-read, %d entries from %d objects", len(tw.ents), ms.gets)
+	}
+
+	ms.objs[`logs/b.log`] = memObject{data: []byte("three\nfour\n"), lm: lm.Add(time.Minute)}
+	fullScan(context.Background(), []*BucketReader{br}, ot, lg, 2)
+	if len(tw.ents) != 5 || ms.gets != 3 {
+		t.Fatalf("updated object was not re-read, %d entries from %d objects", len(tw.ents), ms.gets)
+	}
+}
+
+func TestAzureConfig(t *testing.T) {
+	bad := []AzureConfig{
+		{Container_Name: `logs`, Account_Key: `a2V5`},
+		{Account_Name: `acct`, Account_Key: `a2V5`},
+		{Account_Name: `acct`, Container_Name: `logs`},
+		{Account_Name: `acct`, Container_Name: `logs`, Account_Key: `a2V5`, SAS_Token: `sv=1`},
+	}
+	for _, ac := range bad {
+		if ac.validate() == nil {
+			t.Fatalf("invalid config was accepted %+v", ac)
+		}
+	}
+	ac := AzureConfig{Account_Name: `devstoreaccount1`, Container_Name: `logs`, SAS_Token: `?sv=1&sig=x`}
+	as, err := newAzureStore(ac)
+	if err != nil {
+		t.Fatal(err)
+	} else if as.StateKey() != `https://devstoreaccount1.blob.core.windows.net/logs` {
+		t.Fatalf("bad state key %q", as.StateKey())
+	}
+	ac.Endpoint = `http://127.0.0.1:10000/devstoreaccount1`
+	if as, err = newAzureStore(ac); err != nil {
+		t.Fatal(err)
+	} else if as.StateKey() != `http://127.0.0.1:10000/devstoreaccount1/logs` {
+		t.Fatalf("bad emulator state key %q", as.StateKey())
+	}
+}
+
+func TestGCSConfig(t *testing.T) {
+	if (GCSConfig{}).validate() == nil {
+		t.Fatal("missing bucket name was accepted")
+	}
+	gs, err := newGCSStore(GCSConfig{Bucket_Name: `logs`, Endpoint: `http://127.0.0.1:4443/storage/v1/`})
+	if err != nil {
+		t.Fatal(err)
+	} else if gs.StateKey() != `gs://logs` {
+		t.Fatalf("bad state key %q", gs.StateKey())
+	}
+}
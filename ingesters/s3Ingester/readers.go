@@ -0,0 +1,315 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+	"github.com/gravwell/gravwell/v3/ingesters/utils"
+	"github.com/gravwell/gravwell/v3/timegrinder"
+	"github.com/gravwell/jsonparser"
+	"github.com/parquet-go/parquet-go"
+)
+
+const (
+	cloudtrailRecordsPath = `Records`
+	cloudtrailTimeField   = `eventTime`
+)
+
+// objectReader holds everything needed to turn an S3 object into entries
+type objectReader struct {
+	typ         reader
+	recordsPath []string //path to the array of records in JSON documents
+	timeField   string   //member of a JSON record that holds the timestamp
+	delim       rune
+	comment     rune
+	headers     []string
+	maxLineSize int
+	decompress  bool
+}
+
+func (rc ReaderConfig) newObjectReader(maxLineSize int) (or *objectReader, err error) {
+	var rdr reader
+	if rdr, err = parseReader(rc.Reader); err != nil {
+		return
+	}
+	or = &objectReader{
+		typ:         rdr,
+		maxLineSize: maxLineSize,
+		decompress:  rc.Decompress,
+	}
+	if rc.Records_Path != `` && rdr != jsonReader {
+		err = errors.New("Records-Path requires the json reader")
+		return
+	} else if (rc.CSV_Delimiter != `` || rc.CSV_Comment != `` || len(rc.CSV_Headers) > 0) && rdr != csvReader && rdr != tsvReader {
+		err = errors.New("CSV-Delimiter, CSV-Comment, and CSV-Headers require the csv or tsv reader")
+		return
+	}
+	switch rdr {
+	case cloudtrailReader:
+		or.recordsPath = []string{cloudtrailRecordsPath}
+		or.timeField = cloudtrailTimeField
+	case jsonReader:
+		if rc.Records_Path != `` {
+			or.recordsPath = strings.Split(rc.Records_Path, `.`)
+		}
+	case csvReader, tsvReader:
+		if or.delim = ','; rdr == tsvReader {
+			or.delim = '\t'
+		}
+		if rc.CSV_Delimiter != `` {
+			if or.delim, err = parseCSVRune(rc.CSV_Delimiter); err != nil {
+				err = fmt.Errorf("invalid CSV-Delimiter %q", rc.CSV_Delimiter)
+				return
+			}
+		}
+		if rc.CSV_Comment != `` {
+			if or.comment, err = parseCSVRune(rc.CSV_Comment); err != nil || or.comment == or.delim {
+				err = fmt.Errorf("invalid CSV-Comment %q", rc.CSV_Comment)
+				return
+			}
+		}
+		or.headers = rc.CSV_Headers
+	}
+	return
+}
+
+func parseCSVRune(v string) (r rune, err error) {
+	if v == `\t` {
+		return '\t', nil
+	}
+	var sz int
+	if r, sz = utf8.DecodeRuneInString(v); sz != len(v) || r == utf8.RuneError || r == '"' || r == '\r' || r == '\n' {
+		err = errors.New("not a single valid character")
+	}
+	return
+}
+
+// process reads entries out of rdr, which is positioned at the start checkpoint.  Each reader
+// reports progress to cp in terms of the object content so that it can resume later.
+func (or *objectReader) process(ctx context.Context, rdr io.Reader, start objectCheckpoint, cp *checkpointer, tg *timegrinder.TimeGrinder, src net.IP, tag entry.EntryTag, proc *processors.ProcessorSet) error {
+	switch or.typ {
+	case lineReader:
+		return processLinesContext(ctx, rdr, or.maxLineSize, start, cp, tg, src, tag, proc)
+	case cloudtrailReader, jsonReader:
+		return processJSONContext(ctx, rdr, or.recordsPath, or.timeField, start, cp, tg, src, tag, proc)
+	case csvReader, tsvReader:
+		return processCSVContext(ctx, rdr, or.delim, or.comment, or.headers, start, cp, tg, src, tag, proc)
+	case parquetReader:
+		return processParquetContext(ctx, rdr, start, cp, tg, src, tag, proc)
+	}
+	return errors.New("no reader set")
+}
+
+// extractTimestamp falls back to now if there is no timegrinder or it can't find a timestamp
+func extractTimestamp(tg *timegrinder.TimeGrinder, bts []byte) time.Time {
+	if tg != nil {
+		if ts, ok, _ := tg.Extract(bts); ok {
+			return ts
+		}
+	}
+	return time.Now()
+}
+
+func sendEntry(ctx context.Context, ent *entry.Entry, proc *processors.ProcessorSet) error {
+	if ctx != nil {
+		return proc.ProcessContext(ent, ctx)
+	}
+	return proc.Process(ent)
+}
+
+// processJSONContext handles a stream of JSON documents, if path is set each document holds an
+// array of records at that path, otherwise top level arrays are split into their elements
+// and anything else is a single record.  Checkpoints point at the start of the current document
+// along with the number of its records that have been sent.
+func processJSONContext(ctx context.Context, rdr io.Reader, path []string, timeField string, start objectCheckpoint, cp *checkpointer, tg *timegrinder.TimeGrinder, src net.IP, tag entry.EntryTag, proc *processors.ProcessorSet) (err error) {
+	var obj json.RawMessage
+	var docStart, sent int64
+	skip := start.Skip //only applies to the first document
+	dec := json.NewDecoder(rdr)
+
+	handle := func(val []byte, vt jsonparser.ValueType) error {
+		if sent++; sent <= skip {
+			return nil //sent before we were interrupted
+		}
+		bts := val
+		// if our record is an object try to grab a handle on the timestamp member
+		// if not, just take the whole thing and let TG do its thing
+		if timeField != `` && vt == jsonparser.Object {
+			if ts, err := jsonparser.GetString(val, timeField); err == nil {
+				bts = []byte(ts)
+			}
+		}
+		ent := entry.Entry{
+			TS:   entry.FromStandard(extractTimestamp(tg, bts)),
+			SRC:  src, //may be nil, ingest muxer will handle if it is
+			Data: append([]byte(nil), val...),
+			Tag:  tag,
+		}
+		if err := sendEntry(ctx, &ent, proc); err != nil {
+			return err
+		}
+		cp.update(docStart, sent)
+		return nil
+	}
+
+	var cberr error
+	cb := func(val []byte, vt jsonparser.ValueType, off int, lerr error) {
+		if cberr != nil {
+			return
+		} else if lerr != nil {
+			cberr = lerr
+			return
+		}
+		cberr = handle(val, vt)
+	}
+
+	for {
+		docStart = start.Offset + dec.InputOffset()
+		if err = dec.Decode(&obj); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			break
+		}
+		records, dt, _, lerr := jsonparser.Get([]byte(obj), path...)
+		if lerr != nil {
+			err = fmt.Errorf("failed to find records at %q: %v", strings.Join(path, `.`), lerr)
+			break
+		} else if dt != jsonparser.Array {
+			if len(path) > 0 {
+				err = fmt.Errorf("records at %q are an invalid type: %v", strings.Join(path, `.`), dt)
+			} else {
+				err = handle(records, dt)
+			}
+			if err != nil {
+				break
+			}
+		} else if _, err = jsonparser.ArrayEach(records, cb); err != nil {
+			break
+		} else if cberr != nil {
+			err = cberr
+			break
+		}
+		//the whole document is done, next time start at the one after it
+		sent, skip = 0, 0
+		cp.update(start.Offset+dec.InputOffset(), 0)
+	}
+	return
+}
+
+// processCSVContext emits each row as a JSON object keyed by the header row, or by the configured
+// headers if the object does not have one.  A header row read from the object is saved with the
+// checkpoint because resuming will not see it again.
+func processCSVContext(ctx context.Context, rdr io.Reader, delim, comment rune, headers []string, start objectCheckpoint, cp *checkpointer, tg *timegrinder.TimeGrinder, src net.IP, tag entry.EntryTag, proc *processors.ProcessorSet) (err error) {
+	if headers == nil && start.Offset > 0 {
+		headers = start.Header
+	}
+	cr := csv.NewReader(rdr)
+	cr.Comma = delim
+	cr.Comment = comment
+	cr.LazyQuotes = true
+	cr.FieldsPerRecord = -1
+	for {
+		var vals []string
+		if vals, err = cr.Read(); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+		if headers == nil {
+			if len(vals) > 0 {
+				vals[0] = strings.TrimPrefix(vals[0], string(utils.UTF8BOM))
+			}
+			headers = vals
+			cp.setHeader(headers)
+			continue
+		}
+		bts := utils.FieldsObject(headers, vals)
+		ent := entry.Entry{
+			TS:   entry.FromStandard(extractTimestamp(tg, bts)),
+			SRC:  src,
+			Data: bts,
+			Tag:  tag,
+		}
+		if err = sendEntry(ctx, &ent, proc); err != nil {
+			return
+		}
+		cp.update(start.Offset+cr.InputOffset(), 0)
+	}
+}
+
+// processParquetContext emits each row as a JSON object.  Parquet needs random access to the file
+// so the object is spooled to a temporary file first, checkpoints are a count of rows sent.
+func processParquetContext(ctx context.Context, rdr io.Reader, start objectCheckpoint, cp *checkpointer, tg *timegrinder.TimeGrinder, src net.IP, tag entry.EntryTag, proc *processors.ProcessorSet) (err error) {
+	var fout *os.File
+	if fout, err = os.CreateTemp(``, `s3ingester-*.parquet`); err != nil {
+		err = fmt.Errorf("failed to create temporary file %w", err)
+		return
+	}
+	defer os.Remove(fout.Name())
+	defer fout.Close()
+	var sz int64
+	if sz, err = io.Copy(fout, rdr); err != nil {
+		err = fmt.Errorf("failed to spool parquet object %w", err)
+		return
+	}
+	var pf *parquet.File
+	if pf, err = parquet.OpenFile(fout, sz); err != nil {
+		err = fmt.Errorf("invalid parquet object %w", err)
+		return
+	}
+	prdr := parquet.NewReader(pf)
+	defer prdr.Close()
+	rows := start.Skip
+	if rows > 0 {
+		if err = prdr.SeekToRow(rows); err != nil {
+			err = fmt.Errorf("failed to seek to checkpoint row %d %w", rows, err)
+			return
+		}
+	}
+	for {
+		row := map[string]interface{}{}
+		if err = prdr.Read(&row); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+		var bts []byte
+		if bts, err = json.Marshal(row); err != nil {
+			err = fmt.Errorf("failed to convert parquet row %w", err)
+			return
+		}
+		ent := entry.Entry{
+			TS:   entry.FromStandard(extractTimestamp(tg, bts)),
+			SRC:  src,
+			Data: bts,
+			Tag:  tag,
+		}
+		if err = sendEntry(ctx, &ent, proc); err != nil {
+			return
+		}
+		rows++
+		cp.update(0, rows)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+	"github.com/gravwell/gravwell/v3/timegrinder"
+	"github.com/parquet-go/parquet-go"
+)
+
+type testWriter struct {
+	sync.Mutex
+	ents []*entry.Entry
+}
+
+func (tw *testWriter) WriteEntry(ent *entry.Entry) error {
+	tw.Lock()
+	tw.ents = append(tw.ents, ent)
+	tw.Unlock()
+	return nil
+}
+
+func (tw *testWriter) WriteEntryContext(ctx context.Context, ent *entry.Entry) error {
+	return tw.WriteEntry(ent)
+}
+
+func (tw *testWriter) WriteBatch(ents []*entry.Entry) error {
+	tw.Lock()
+	tw.ents = append(tw.ents, ents...)
+	tw.Unlock()
+	return nil
+}
+
+func (tw *testWriter) WriteBatchContext(ctx context.Context, ents []*entry.Entry) error {
+	return tw.WriteBatch(ents)
+}
+
+func runReader(t *testing.T, rc ReaderConfig, data []byte) []*entry.Entry {
+	t.Helper()
+	or, err := rc.newObjectReader(defaultMaxLineSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tg, err := timegrinder.NewTimeGrinder(timegrinder.Config{EnableLeftMostSeed: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tw testWriter
+	if err = or.process(context.Background(), bytes.NewReader(data), objectCheckpoint{}, nil, tg, nil, 0, processors.NewProcessorSet(&tw)); err != nil {
+		t.Fatal(err)
+	}
+	return tw.ents
+}
+
+func checkEntries(t *testing.T, ents []*entry.Entry, expected []string) {
+	t.Helper()
+	if len(ents) != len(expected) {
+		t.Fatalf("entry count mismatch %d != %d", len(ents), len(expected))
+	}
+	for i := range ents {
+		if string(ents[i].Data) != expected[i] {
+			t.Fatalf("entry %d mismatch %q != %q", i, ents[i].Data, expected[i])
+		}
+	}
+}
+
+func TestJSONReader(t *testing.T) {
+	//guardduty style exports are a bare array
+	ents := runReader(t, ReaderConfig{Reader: `json`}, []byte(`[{"a":1,"updatedAt":"2024-05-01T00:00:00Z"},{"a":2}]`+"\n"+`{"a":3}`))
+	checkEntries(t, ents, []string{`{"a":1,"updatedAt":"2024-05-01T00:00:00Z"}`, `{"a":2}`, `{"a":3}`})
+	if !ents[0].TS.StandardTime().Equal(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("bad timestamp %v", ents[0].TS)
+	}
+
+	ents = runReader(t, ReaderConfig{Reader: `json`, Records_Path: `data.findings`}, []byte(`{"data":{"findings":[{"id":"x"},{"id":"y"}]}}{"data":{"findings":[]}}`))
+	checkEntries(t, ents, []string{`{"id":"x"}`, `{"id":"y"}`})
+
+	ents = runReader(t, ReaderConfig{Reader: `cloudtrail`}, []byte(`{"Records":[{"eventTime":"2024-05-01T00:00:00Z","msg":"2020-01-01T00:00:00Z"}]}`))
+	if len(ents) != 1 || !ents[0].TS.StandardTime().Equal(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("cloudtrail records did not use eventTime: %+v", ents)
+	}
+
+	or, err := ReaderConfig{Reader: `json`, Records_Path: `Records`}.newObjectReader(defaultMaxLineSize)
+	if err != nil {
+		t.Fatal(err)
+	} else if err = or.process(nil, strings.NewReader(`{"other":[]}`), objectCheckpoint{}, nil, nil, nil, 0, processors.NewProcessorSet(&testWriter{})); err == nil {
+		t.Fatal("missing records path was not an error")
+	}
+}
+
+func TestCSVReader(t *testing.T) {
+	data := "\xef\xbb\xbfsrc,dst,note\n# comment line\n1.1.1.1,2.2.2.2,\"quoted, value\"\n3.3.3.3,4.4.4.4,x,extra\n"
+	ents := runReader(t, ReaderConfig{Reader: `csv`, CSV_Comment: `#`}, []byte(data))
+	checkEntries(t, ents, []string{
+		`{"src":"1.1.1.1","dst":"2.2.2.2","note":"quoted, value"}`,
+		`{"src":"3.3.3.3","dst":"4.4.4.4","note":"x","field4":"extra"}`,
+	})
+
+	//VPC flow logs are space delimited, ALB logs have no header at all
+	ents = runReader(t, ReaderConfig{Reader: `csv`, CSV_Delimiter: ` `, CSV_Headers: []string{`type`, `time`, `request`}}, []byte("http 2024-05-01T00:00:00Z \"GET http://x/ HTTP/1.1\"\n"))
+	checkEntries(t, ents, []string{`{"type":"http","time":"2024-05-01T00:00:00Z","request":"GET http://x/ HTTP/1.1"}`})
+
+	ents = runReader(t, ReaderConfig{Reader: `tsv`}, []byte("a\tb\n1\t2\n"))
+	checkEntries(t, ents, []string{`{"a":"1","b":"2"}`})
+}
+
+type testRow struct {
+	Name  string  `parquet:"name"`
+	Count int64   `parquet:"count"`
+	Time  string  `parquet:"time"`
+	Score float64 `parquet:"score,optional"`
+}
+
+func TestParquetReader(t *testing.T) {
+	bb := bytes.NewBuffer(nil)
+	if err := parquet.Write(bb, []testRow{
+		{Name: `alpha`, Count: 1, Time: `2024-05-01T00:00:00Z`, Score: 0.5},
+		{Name: `beta`, Count: 2, Time: `2024-05-02T00:00:00Z`},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	ents := runReader(t, ReaderConfig{Reader: `parquet`}, bb.Bytes())
+	checkEntries(t, ents, []string{
+		`{"count":1,"name":"alpha","score":0.5,"time":"2024-05-01T00:00:00Z"}`,
+		`{"count":2,"name":"beta","score":null,"time":"2024-05-02T00:00:00Z"}`,
+	})
+	if !ents[1].TS.StandardTime().Equal(time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("bad timestamp %v", ents[1].TS)
+	}
+}
+
+func TestReaderConfig(t *testing.T) {
+	bad := []ReaderConfig{
+		{Reader: `xml`},
+		{Reader: `line`, Records_Path: `Records`},
+		{Reader: `json`, CSV_Delimiter: `,`},
+		{Reader: `csv`, CSV_Delimiter: `;;`},
+		{Reader: `csv`, CSV_Comment: `,`},
+	}
+	for _, rc := range bad {
+		if _, err := rc.newObjectReader(defaultMaxLineSize); err == nil {
+			t.Fatalf("invalid reader config was accepted: %+v", rc)
+		}
+	}
+	if or, err := (ReaderConfig{Reader: `ndjson`}).newObjectReader(defaultMaxLineSize); err != nil || or.typ != lineReader {
+		t.Fatalf("ndjson is not a line reader %v", err)
+	}
+}
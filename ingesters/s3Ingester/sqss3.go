@@ -16,6 +16,7 @@ import (
 
 type SQSS3Config struct {
 	TimeConfig
+	ReaderConfig
 	Verbose          bool
 	MaxLineSize      int
 	Name             string
@@ -28,7 +29,6 @@ type SQSS3Config struct {
 	FileFilters      []string
 	Region           string
 	Queue            string
-	Reader           string
 	ID               string `json:"-"` //do not ship this as part of a config report
 	Secret           string `json:"-"` //do not ship this as part of a config report
 	Credentials_Type string
@@ -41,12 +41,12 @@ type SQSS3Listener struct {
 	svc     *s3.S3
 	tg      timegrinder.TimeGrinder
 	src     net.IP
-	rdr     reader
+	rdr     *objectReader
 	filter  *matcher
 }
 
 func NewSQSS3Listener(cfg SQSS3Config) (s *SQSS3Listener, err error) {
-	var rdr reader
+	var rdr *objectReader
 	var sess *session.Session
 	if err = cfg.validate(); err != nil {
 		return
@@ -57,7 +57,7 @@ func NewSQSS3Listener(cfg SQSS3Config) (s *SQSS3Listener, err error) {
 		return
 	}
 
-	if rdr, err = parseReader(cfg.Reader); err != nil {
+	if rdr, err = cfg.ReaderConfig.newObjectReader(cfg.MaxLineSize); err != nil {
 		return
 	}
 
@@ -18,20 +18,21 @@ import (
 	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/arn"
-	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/bmatcuk/doublestar/v4"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 	"github.com/gravwell/gravwell/v3/ingest/log"
 	"github.com/gravwell/gravwell/v3/ingest/processors"
 	"github.com/gravwell/gravwell/v3/timegrinder"
-	"github.com/gravwell/jsonparser"
 )
 
 const (
 	lineReader       reader = `line`
 	cloudtrailReader reader = `cloudtrail`
+	jsonReader       reader = `json`
+	csvReader        reader = `csv`
+	tsvReader        reader = `tsv`
+	parquetReader    reader = `parquet`
 )
 
 var (
@@ -89,8 +90,9 @@ type objectTracker struct {
 type bucketObjects map[string]trackedObjectState
 
 type trackedObjectState struct {
-	Updated time.Time
-	Size    int64
+	Updated    time.Time
+	Size       int64
+	Checkpoint *objectCheckpoint `json:",omitempty"` //set while an object is only partially processed
 }
 
 func NewObjectTracker(pth string) (ot *objectTracker, err error) {
@@ -180,10 +182,10 @@ func parseReader(v string) (reader, error) {
 	switch reader(v) {
 	case ``: //empty means line
 		return lineReader, nil
-	case lineReader:
+	case lineReader, `ndjson`:
 		return lineReader, nil
-	case cloudtrailReader:
-		return cloudtrailReader, nil
+	case cloudtrailReader, jsonReader, csvReader, tsvReader, parquetReader:
+		return reader(v), nil
 	}
 	return ``, ErrUnknownReader
 }
@@ -280,131 +282,90 @@ var (
 	awsUrlRegex = regexp.MustCompile(`s3[-\.]?([a-zA-Z\-0-9]+)?\.amazonaws\.com`)
 )
 
-func ProcessContext(obj *s3.Object, ctx context.Context, svc *s3.S3, bucket string, rdr reader, tg *timegrinder.TimeGrinder, src net.IP, tag entry.EntryTag, proc *processors.ProcessorSet, maxLineSize int) (sz int64, s3rtt, rtt time.Duration, err error) {
-	var r *s3.GetObjectOutput
+// ProcessContext reads an object from the store and processes its contents.  If cp is not nil
+// processing resumes from its checkpoint and progress is recorded as entries are sent.
+func ProcessContext(key string, ctx context.Context, st objectStore, rdr *objectReader, cp *checkpointer, tg *timegrinder.TimeGrinder, src net.IP, tag entry.EntryTag, proc *processors.ProcessorSet) (sz int64, s3rtt, rtt time.Duration, err error) {
+	var body io.ReadCloser
 	now := time.Now()
-	r, err = svc.GetObject(&s3.GetObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    obj.Key,
-	})
-	if err != nil {
+	getCtx := ctx
+	if getCtx == nil {
+		getCtx = context.Background()
+	}
+	if rdr == nil {
+		err = errors.New("no reader set")
 		return
 	}
-	defer r.Body.Close()
+	start := cp.resumeFrom()
+	if start.Compressed && !rdr.decompress {
+		//decompression was turned off since the checkpoint, its offset means nothing now
+		cp.restart()
+		start = cp.resumeFrom()
+	}
+	var offset int64
+	if !start.Compressed {
+		offset = start.Offset //plain objects can pick up right where we left off
+		if osz := cp.objectSize(); offset > 0 && osz > 0 && offset >= osz {
+			//everything was sent before the checkpoint was cleared, a ranged get past the end would fail
+			sz = osz
+			return
+		}
+	}
+	if body, sz, err = st.Get(getCtx, key, offset); err != nil {
+		return
+	}
+	defer body.Close()
 	s3rtt = time.Since(now)
 
-	switch rdr {
-	case lineReader:
-		err = processLinesContext(ctx, r.Body, maxLineSize, tg, src, tag, proc)
-	case cloudtrailReader:
-		err = processCloudtrailContext(ctx, r.Body, tg, src, tag, proc)
-	default:
-		err = errors.New("no reader set")
+	var content io.Reader = body
+	if offset == 0 && rdr.decompress {
+		var compressed bool
+		if content, compressed, err = decompress(body); err != nil {
+			return
+		}
+		cp.setCompressed(compressed)
+		if compressed && start.Offset > 0 {
+			//no way to seek in a compressed stream, burn through what we already sent
+			if _, err = io.CopyN(io.Discard, content, start.Offset); err != nil {
+				err = fmt.Errorf("failed to skip to checkpoint at %d %w", start.Offset, err)
+				return
+			}
+		}
 	}
+	err = rdr.process(ctx, content, start, cp, tg, src, tag, proc)
 	rtt = time.Since(now)
-	if r != nil && r.ContentLength != nil {
-		sz = *r.ContentLength
-	}
 	return
 }
 
-func processLinesContext(ctx context.Context, rdr io.Reader, maxLineSize int, tg *timegrinder.TimeGrinder, src net.IP, tag entry.EntryTag, proc *processors.ProcessorSet) (err error) {
+func processLinesContext(ctx context.Context, rdr io.Reader, maxLineSize int, start objectCheckpoint, cp *checkpointer, tg *timegrinder.TimeGrinder, src net.IP, tag entry.EntryTag, proc *processors.ProcessorSet) (err error) {
+	offset := start.Offset
 	sc := bufio.NewScanner(rdr)
 	sc.Buffer(nil, maxLineSize)
+	sc.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		advance, token, err = bufio.ScanLines(data, atEOF)
+		offset += int64(advance) //track where the next line starts
+		return
+	})
 	for sc.Scan() {
 		bts := sc.Bytes()
 		if len(bts) == 0 {
 			continue
 		}
-		ts, ok, _ := tg.Extract(bts)
-		if !ok {
-			ts = time.Now()
-		}
 		ent := entry.Entry{
-			TS:   entry.FromStandard(ts),
+			TS:   entry.FromStandard(extractTimestamp(tg, bts)),
 			SRC:  src, //may be nil, ingest muxer will handle if it is
 			Tag:  tag,
 			Data: bytes.Clone(bts), //scanner re-uses the buffer
 		}
-		if ctx != nil {
-			err = proc.ProcessContext(&ent, ctx)
-		} else {
-			err = proc.Process(&ent)
-		}
-		if err != nil {
+		if err = sendEntry(ctx, &ent, proc); err != nil {
 			return //just leave
 		}
+		cp.update(offset, 0)
 	}
+	err = sc.Err()
 
 	return
 }
 
-func processCloudtrailContext(ctx context.Context, rdr io.Reader, tg *timegrinder.TimeGrinder, src net.IP, tag entry.EntryTag, proc *processors.ProcessorSet) (err error) {
-	var obj json.RawMessage
-	dec := json.NewDecoder(rdr)
-
-	var cberr error
-	cb := func(val []byte, vt jsonparser.ValueType, off int, lerr error) {
-		if lerr != nil {
-			cberr = lerr
-			return
-		}
-		var bts []byte
-		// if our record is an object try to grab a handle on the eventTime member
-		// if not, just take the whole thing, this is an optimization to process timestamps
-		if vt == jsonparser.Object {
-			if eventTime, err := jsonparser.GetString(val, `eventTime`); err == nil {
-				bts = []byte(eventTime)
-			} else {
-				bts = val // could not match, just set to whole thing and let TG do its thing
-			}
-		} else {
-			bts = val
-		}
-		ts, ok, _ := tg.Extract(bts)
-		if !ok {
-			ts = time.Now()
-		}
-		ent := entry.Entry{
-			TS:   entry.FromStandard(ts),
-			SRC:  src,                         //may be nil, ingest muxer will handle if it is
-			Data: append([]byte(nil), val...), //scanner re-uses the buffer
-			Tag:  tag,
-		}
-		if ctx != nil {
-			cberr = proc.ProcessContext(&ent, ctx)
-		} else {
-			cberr = proc.Process(&ent)
-		}
-		return
-	}
-
-	for {
-		var recordarray []byte
-		var dt jsonparser.ValueType
-		if err = dec.Decode(&obj); err != nil {
-			if err == io.EOF {
-				err = nil
-			}
-			break
-		}
-		if recordarray, dt, _, err = jsonparser.Get([]byte(obj), `Records`); err != nil {
-			err = fmt.Errorf("failed to find Records array in cloudtrail log: %v", err)
-			break
-		} else if dt != jsonparser.Array {
-			err = fmt.Errorf("Records member is an invalid type: %v", dt)
-			break
-		}
-		if _, err = jsonparser.ArrayEach(recordarray, cb); err != nil {
-			break
-		} else if cberr != nil {
-			err = cberr
-			break
-		}
-	}
-	return
-}
-
 func logSnsKeyDecode(lg *log.Logger, keytype string, buckets, keys []string) {
 	if len(buckets) != len(keys) {
 		lg.Info("successfully decoded messages", log.KV("type", keytype), log.KV("buckets", buckets), log.KV("keys", keys))
@@ -0,0 +1,43 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// UTF8BOM is the byte order mark some tools write at the start of UTF-8 text files
+var UTF8BOM = []byte{0xef, 0xbb, 0xbf}
+
+// FieldsObject renders named values as a JSON object with the keys in order, values
+// beyond the named set are given positional names
+func FieldsObject(names, vals []string) []byte {
+	bb := bytes.NewBuffer(make([]byte, 0, 256))
+	enc := json.NewEncoder(bb)
+	enc.SetEscapeHTML(false)
+	bb.WriteByte('{')
+	for i, v := range vals {
+		if i > 0 {
+			bb.WriteByte(',')
+		}
+		name := fmt.Sprintf("field%d", i+1)
+		if i < len(names) && names[i] != `` {
+			name = names[i]
+		}
+		enc.Encode(name)
+		bb.Truncate(bb.Len() - 1) //the encoder always adds a newline
+		bb.WriteByte(':')
+		enc.Encode(v)
+		bb.Truncate(bb.Len() - 1)
+	}
+	bb.WriteByte('}')
+	return bb.Bytes()
+}
@@ -0,0 +1,32 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package utils
+
+import (
+	"testing"
+)
+
+func TestFieldsObject(t *testing.T) {
+	tests := []struct {
+		names []string
+		vals  []string
+		exp   string
+	}{
+		{nil, nil, `{}`},
+		{[]string{`a`, `b`}, []string{`1`, `2`}, `{"a":"1","b":"2"}`},
+		{[]string{`b`, `a`}, []string{`1`, `2`}, `{"b":"1","a":"2"}`}, // keys keep their order
+		{[]string{`a`, ``}, []string{`1`, `2`, `3`}, `{"a":"1","field2":"2","field3":"3"}`},
+		{[]string{`<x>`}, []string{"a\"b&c\n"}, `{"<x>":"a\"b&c\n"}`}, // no HTML escaping
+	}
+	for _, tc := range tests {
+		if r := string(FieldsObject(tc.names, tc.vals)); r != tc.exp {
+			t.Fatalf("%v %v: %s != %s", tc.names, tc.vals, r, tc.exp)
+		}
+	}
+}
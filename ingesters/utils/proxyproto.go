@@ -0,0 +1,321 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ProxyHeaderTimeout is how long we wait for a trusted upstream to deliver its PROXY header
+	ProxyHeaderTimeout = 5 * time.Second
+
+	proxyV1Prefix    = "PROXY "
+	proxyV1MaxLen    = 107
+	proxyV2HeaderLen = 16
+	proxyV2Version   = 0x2
+	proxyV2CmdLocal  = 0x0
+	proxyV2CmdProxy  = 0x1
+	proxyV2FamInet   = 0x1
+	proxyV2FamInet6  = 0x2
+)
+
+var (
+	ErrProxyHeaderInvalid = errors.New("invalid PROXY protocol header")
+	ErrNoTrustedUpstreams = errors.New("PROXY protocol requires at least one trusted upstream")
+
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// ParseTrustedUpstreams parses a list of CIDRs or bare IP addresses which are allowed to send PROXY headers
+func ParseTrustedUpstreams(vals []string) (nets []*net.IPNet, err error) {
+	for _, v := range vals {
+		if v = strings.TrimSpace(v); v == `` {
+			continue
+		}
+		if !strings.Contains(v, `/`) {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				err = fmt.Errorf("invalid trusted upstream %q", v)
+				return
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		var n *net.IPNet
+		if _, n, err = net.ParseCIDR(v); err != nil {
+			err = fmt.Errorf("invalid trusted upstream %q %w", v, err)
+			return
+		}
+		nets = append(nets, n)
+	}
+	if len(nets) == 0 {
+		err = ErrNoTrustedUpstreams
+	}
+	return
+}
+
+// ProxyListener wraps a stream listener and decodes PROXY protocol v1 and v2 headers sent by trusted
+// upstreams such as HAProxy or an AWS NLB.  Connections from trusted upstreams report the original
+// client address through RemoteAddr, connections from anywhere else are passed through untouched.
+// When wrapping a TLS listener the ProxyListener must sit underneath the TLS layer.
+type ProxyListener struct {
+	net.Listener
+	trusted []*net.IPNet
+	timeout time.Duration
+}
+
+// NewProxyListener wraps the listener so that PROXY headers from the trusted networks are decoded
+func NewProxyListener(l net.Listener, trusted []*net.IPNet) *ProxyListener {
+	return &ProxyListener{
+		Listener: l,
+		trusted:  trusted,
+		timeout:  ProxyHeaderTimeout,
+	}
+}
+
+func (pl *ProxyListener) Accept() (net.Conn, error) {
+	c, err := pl.Listener.Accept()
+	if err != nil || !pl.Trusted(c.RemoteAddr()) {
+		return c, err
+	}
+	return &proxyConn{
+		Conn:    c,
+		timeout: pl.timeout,
+	}, nil
+}
+
+// Trusted returns true if the address falls within one of the trusted upstream networks
+func (pl *ProxyListener) Trusted(addr net.Addr) bool {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	default:
+		return false
+	}
+	for _, n := range pl.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyConn lazily reads the PROXY header on the first Read, RemoteAddr, or LocalAddr call so that
+// a slow upstream never stalls the accept loop, callers must not touch any of them until the
+// connection has been handed off to its own goroutine
+type proxyConn struct {
+	net.Conn
+	once     sync.Once
+	br       *bufio.Reader
+	src, dst net.Addr
+	err      error
+	timeout  time.Duration
+
+	dlMtx      sync.Mutex
+	readDL     time.Time
+	inProgress bool
+}
+
+func (pc *proxyConn) init() {
+	pc.once.Do(func() {
+		pc.dlMtx.Lock()
+		pc.inProgress = true
+		pc.dlMtx.Unlock()
+		if pc.timeout > 0 {
+			pc.Conn.SetReadDeadline(time.Now().Add(pc.timeout))
+		}
+		pc.br = bufio.NewReader(pc.Conn)
+		pc.src, pc.dst, pc.err = readProxyHeader(pc.br)
+		if pc.err != nil && errors.Is(pc.err, os.ErrDeadlineExceeded) && pc.br.Buffered() == 0 {
+			//trusted upstream that did not send a header, the reader holds onto the error so replace it
+			pc.err = nil
+			pc.br = bufio.NewReader(pc.Conn)
+		} else if pc.err == io.EOF {
+			pc.err = nil //let the caller discover the EOF on its own
+		}
+		pc.dlMtx.Lock()
+		pc.inProgress = false
+		pc.Conn.SetReadDeadline(pc.readDL) //restore whatever deadline the caller asked for
+		pc.dlMtx.Unlock()
+	})
+}
+
+func (pc *proxyConn) Read(b []byte) (int, error) {
+	if pc.init(); pc.err != nil {
+		return 0, pc.err
+	}
+	return pc.br.Read(b)
+}
+
+func (pc *proxyConn) RemoteAddr() net.Addr {
+	if pc.init(); pc.src != nil {
+		return pc.src
+	}
+	return pc.Conn.RemoteAddr()
+}
+
+func (pc *proxyConn) LocalAddr() net.Addr {
+	if pc.init(); pc.dst != nil {
+		return pc.dst
+	}
+	return pc.Conn.LocalAddr()
+}
+
+func (pc *proxyConn) SetDeadline(t time.Time) error {
+	if err := pc.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return pc.Conn.SetWriteDeadline(t)
+}
+
+func (pc *proxyConn) SetReadDeadline(t time.Time) error {
+	pc.dlMtx.Lock()
+	defer pc.dlMtx.Unlock()
+	pc.readDL = t
+	if pc.inProgress {
+		return nil //applied once the header has been consumed
+	}
+	return pc.Conn.SetReadDeadline(t)
+}
+
+// readProxyHeader consumes a v1 or v2 PROXY header if one is present, the returned addresses are
+// nil if there was no header or the header did not carry addresses (LOCAL and UNKNOWN commands)
+func readProxyHeader(br *bufio.Reader) (src, dst net.Addr, err error) {
+	var b []byte
+	if b, err = br.Peek(1); err != nil {
+		return
+	}
+	switch b[0] {
+	case proxyV1Prefix[0]:
+		if b, err = br.Peek(len(proxyV1Prefix)); err == nil && string(b) == proxyV1Prefix {
+			return readProxyV1(br)
+		}
+	case proxyV2Signature[0]:
+		if b, err = br.Peek(len(proxyV2Signature)); err == nil && bytes.Equal(b, proxyV2Signature) {
+			return readProxyV2(br)
+		}
+	}
+	if err == io.EOF {
+		err = nil //short stream that is not a header
+	}
+	return
+}
+
+// readProxyV1 decodes the human readable header, e.g. "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"
+func readProxyV1(br *bufio.Reader) (src, dst net.Addr, err error) {
+	var line []byte
+	for len(line) <= proxyV1MaxLen {
+		var b byte
+		if b, err = br.ReadByte(); err != nil {
+			return
+		}
+		if line = append(line, b); b == '\n' {
+			break
+		}
+	}
+	if len(line) > proxyV1MaxLen || !bytes.HasSuffix(line, []byte("\r\n")) {
+		err = ErrProxyHeaderInvalid
+		return
+	}
+	flds := strings.Fields(string(line[:len(line)-2]))
+	if len(flds) < 2 {
+		err = ErrProxyHeaderInvalid
+		return
+	}
+	switch flds[1] {
+	case `UNKNOWN`:
+		return //connection details are unknown, keep the real addresses
+	case `TCP4`, `TCP6`:
+	default:
+		err = ErrProxyHeaderInvalid
+		return
+	}
+	if len(flds) != 6 {
+		err = ErrProxyHeaderInvalid
+		return
+	}
+	srcIP, dstIP := net.ParseIP(flds[2]), net.ParseIP(flds[3])
+	srcPort, serr := strconv.ParseUint(flds[4], 10, 16)
+	dstPort, derr := strconv.ParseUint(flds[5], 10, 16)
+	if srcIP == nil || dstIP == nil || serr != nil || derr != nil {
+		err = ErrProxyHeaderInvalid
+		return
+	} else if (flds[1] == `TCP4`) != (srcIP.To4() != nil) {
+		err = ErrProxyHeaderInvalid
+		return
+	}
+	src = &net.TCPAddr{IP: srcIP, Port: int(srcPort)}
+	dst = &net.TCPAddr{IP: dstIP, Port: int(dstPort)}
+	return
+}
+
+// readProxyV2 decodes the binary header
+func readProxyV2(br *bufio.Reader) (src, dst net.Addr, err error) {
+	var hdr [proxyV2HeaderLen]byte
+	if _, err = io.ReadFull(br, hdr[:]); err != nil {
+		return
+	}
+	if hdr[12]>>4 != proxyV2Version {
+		err = ErrProxyHeaderInvalid
+		return
+	}
+	cmd, fam := hdr[12]&0xf, hdr[13]>>4
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err = io.ReadFull(br, body); err != nil {
+		return
+	}
+	switch cmd {
+	case proxyV2CmdLocal:
+		return //health checks from the proxy itself, keep the real addresses
+	case proxyV2CmdProxy:
+	default:
+		err = ErrProxyHeaderInvalid
+		return
+	}
+	var sz int
+	switch fam {
+	case proxyV2FamInet:
+		sz = net.IPv4len
+	case proxyV2FamInet6:
+		sz = net.IPv6len
+	default:
+		return //unix sockets and unspecified families carry nothing useful
+	}
+	if len(body) < 2*sz+4 {
+		err = ErrProxyHeaderInvalid
+		return
+	}
+	srcIP := net.IP(bytes.Clone(body[:sz]))
+	dstIP := net.IP(bytes.Clone(body[sz : 2*sz]))
+	srcPort := binary.BigEndian.Uint16(body[2*sz:])
+	dstPort := binary.BigEndian.Uint16(body[2*sz+2:])
+	//any TLVs after the addresses are ignored
+	src = &net.TCPAddr{IP: srcIP, Port: int(srcPort)}
+	dst = &net.TCPAddr{IP: dstIP, Port: int(dstPort)}
+	return
+}
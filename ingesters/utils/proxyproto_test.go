@@ -0,0 +1,167 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func proxyV2Header(cmd byte, src, dst *net.TCPAddr) []byte {
+	bb := bytes.NewBuffer(nil)
+	bb.Write(proxyV2Signature)
+	bb.WriteByte(proxyV2Version<<4 | cmd)
+	var body []byte
+	fam := byte(proxyV2FamInet)
+	if src != nil {
+		sip, dip := src.IP.To4(), dst.IP.To4()
+		if sip == nil {
+			fam, sip, dip = proxyV2FamInet6, src.IP.To16(), dst.IP.To16()
+		}
+		body = append(body, sip...)
+		body = append(body, dip...)
+		body = binary.BigEndian.AppendUint16(body, uint16(src.Port))
+		body = binary.BigEndian.AppendUint16(body, uint16(dst.Port))
+		body = append(body, 0x04, 0x00, 0x01, 0xff) //a TLV we should skip
+	}
+	bb.WriteByte(fam<<4 | 0x1)
+	binary.Write(bb, binary.BigEndian, uint16(len(body)))
+	bb.Write(body)
+	return bb.Bytes()
+}
+
+// proxyTest accepts a single connection that sends the payload and returns the remote address and data
+func proxyTest(t *testing.T, trusted []string, payload []byte) (net.Addr, []byte, error) {
+	t.Helper()
+	nets, err := ParseTrustedUpstreams(trusted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl := NewProxyListener(l, nets)
+	pl.timeout = 250 * time.Millisecond
+	defer pl.Close()
+	go func() {
+		c, err := net.Dial(`tcp`, l.Addr().String())
+		if err != nil {
+			return
+		}
+		c.Write(payload)
+		c.Close()
+	}()
+	c, err := pl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	addr := c.RemoteAddr()
+	b, err := io.ReadAll(c)
+	return addr, b, err
+}
+
+func TestProxyProtocol(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP(`203.0.113.7`), Port: 51000}
+	dst := &net.TCPAddr{IP: net.ParseIP(`10.0.0.5`), Port: 601}
+	src6 := &net.TCPAddr{IP: net.ParseIP(`2001:db8::7`), Port: 51000}
+	dst6 := &net.TCPAddr{IP: net.ParseIP(`2001:db8::5`), Port: 601}
+	data := []byte("hello world\n")
+	tsts := []struct {
+		name    string
+		trusted []string
+		hdr     []byte
+		src     string
+	}{
+		{`v1`, []string{`127.0.0.0/8`}, []byte("PROXY TCP4 203.0.113.7 10.0.0.5 51000 601\r\n"), `203.0.113.7:51000`},
+		{`v1-tcp6`, []string{`127.0.0.1`}, []byte("PROXY TCP6 2001:db8::7 2001:db8::5 51000 601\r\n"), `[2001:db8::7]:51000`},
+		{`v1-unknown`, []string{`127.0.0.1`}, []byte("PROXY UNKNOWN\r\n"), ``},
+		{`v2`, []string{`127.0.0.1`}, proxyV2Header(proxyV2CmdProxy, src, dst), `203.0.113.7:51000`},
+		{`v2-ipv6`, []string{`127.0.0.1`}, proxyV2Header(proxyV2CmdProxy, src6, dst6), `[2001:db8::7]:51000`},
+		{`v2-local`, []string{`127.0.0.1`}, proxyV2Header(proxyV2CmdLocal, nil, nil), ``},
+		{`no-header`, []string{`127.0.0.1`}, nil, ``},
+	}
+	for _, tst := range tsts {
+		addr, b, err := proxyTest(t, tst.trusted, append(bytes.Clone(tst.hdr), data...))
+		if err != nil {
+			t.Fatalf("%s: %v", tst.name, err)
+		} else if !bytes.Equal(b, data) {
+			t.Fatalf("%s: bad data %q", tst.name, b)
+		}
+		ta, ok := addr.(*net.TCPAddr)
+		if !ok {
+			t.Fatalf("%s: bad address type %T", tst.name, addr)
+		}
+		if tst.src == `` {
+			if !ta.IP.IsLoopback() {
+				t.Fatalf("%s: expected the real address, got %v", tst.name, addr)
+			}
+		} else if ta.String() != tst.src {
+			t.Fatalf("%s: bad source %v != %s", tst.name, addr, tst.src)
+		}
+	}
+}
+
+func TestProxyProtocolUntrusted(t *testing.T) {
+	//headers from untrusted peers are treated as data
+	hdr := []byte("PROXY TCP4 203.0.113.7 10.0.0.5 51000 601\r\n")
+	addr, b, err := proxyTest(t, []string{`192.0.2.0/24`}, hdr)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(b, hdr) {
+		t.Fatalf("bad data %q", b)
+	} else if !addr.(*net.TCPAddr).IP.IsLoopback() {
+		t.Fatalf("untrusted peer was able to spoof its address: %v", addr)
+	}
+}
+
+func TestProxyProtocolInvalid(t *testing.T) {
+	bad := [][]byte{
+		[]byte("PROXY TCP4 203.0.113.7 10.0.0.5 51000\r\n"),
+		[]byte("PROXY TCP4 2001:db8::7 10.0.0.5 51000 601\r\n"),
+		[]byte("PROXY TCP9 203.0.113.7 10.0.0.5 51000 601\r\n"),
+		append([]byte("PROXY TCP4 "), bytes.Repeat([]byte("1"), 128)...),
+		append(bytes.Clone(proxyV2Signature), 0x31, 0x11, 0, 0),
+	}
+	for i, hdr := range bad {
+		if _, _, err := proxyTest(t, []string{`127.0.0.1`}, hdr); err == nil {
+			t.Fatalf("failed to catch bad header %d", i)
+		}
+	}
+}
+
+func TestParseTrustedUpstreams(t *testing.T) {
+	nets, err := ParseTrustedUpstreams([]string{`10.0.0.0/8`, ` 192.168.1.1 `, `fd00::/8`, `::1`})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(nets) != 4 {
+		t.Fatalf("bad network count %d", len(nets))
+	}
+	pl := NewProxyListener(nil, nets)
+	for _, ip := range []string{`10.1.2.3`, `192.168.1.1`, `fd00::1`, `::1`} {
+		if !pl.Trusted(&net.TCPAddr{IP: net.ParseIP(ip)}) {
+			t.Fatalf("%s should be trusted", ip)
+		}
+	}
+	for _, ip := range []string{`11.1.2.3`, `192.168.1.2`, `fe80::1`} {
+		if pl.Trusted(&net.TCPAddr{IP: net.ParseIP(ip)}) {
+			t.Fatalf("%s should not be trusted", ip)
+		}
+	}
+	for _, v := range [][]string{nil, {``}, {`10.0.0.0/33`}, {`bad`}} {
+		if _, err := ParseTrustedUpstreams(v); err == nil {
+			t.Fatalf("failed to catch bad upstreams %v", v)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/gravwell/gcfg"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+)
+
+const (
+	preprocessor = `Preprocessor`
+)
+
+// testConfig only pulls the preprocessor definitions and the section the chain is taken from
+// out of an ingester config, every other section is ingester specific so we ignore it.
+type testConfig struct {
+	Preprocessor processors.ProcessorConfig
+
+	sect string                 // section the preprocessor list comes from, empty if not used
+	vc   *config.VariableConfig // values of that section, nil if the section was not found
+}
+
+// loadConfig loads the config file and any overlays.  The section type is only known at runtime
+// so the gcfg target is built with a field for it alongside the Preprocessor definitions.
+func loadConfig(pth, overlay, sect string) (cfg *testConfig, err error) {
+	cfg = &testConfig{sect: sect}
+	var typ, name string
+	fields := []reflect.StructField{
+		{Name: preprocessor, Type: reflect.TypeOf(processors.ProcessorConfig{})},
+	}
+	if sect != `` {
+		var ok bool
+		if typ, name, ok = parseSection(sect); !ok {
+			err = fmt.Errorf("invalid section %q", sect)
+			return
+		}
+		ft := reflect.TypeOf(config.VariableConfig{})
+		if name != `` {
+			ft = reflect.TypeOf(map[string]*config.VariableConfig{})
+		}
+		fields = append(fields, reflect.StructField{
+			Name: `Section`,
+			Type: ft,
+			Tag:  reflect.StructTag(fmt.Sprintf(`gcfg:%q`, typ)),
+		})
+	}
+	v := reflect.New(reflect.StructOf(fields))
+	if err = gcfg.FatalOnly(config.LoadConfigFile(v.Interface(), pth)); err != nil {
+		return
+	} else if err = config.LoadConfigOverlaysFatalOnly(v.Interface(), overlay); err != nil {
+		return
+	}
+	cfg.Preprocessor = v.Elem().Field(0).Interface().(processors.ProcessorConfig)
+	if sect == `` {
+		return
+	}
+	if sf := v.Elem().Field(1); name != `` {
+		cfg.vc = sf.Interface().(map[string]*config.VariableConfig)[name]
+	} else if vc := sf.Addr().Interface().(*config.VariableConfig); vc.Vals != nil {
+		cfg.vc = vc
+	}
+	return
+}
+
+// chain returns the list of preprocessors to run, either from an explicit list or
+// from the Preprocessor parameters in the configured section.
+func (tc *testConfig) chain(list string) (names []string, err error) {
+	if list != `` {
+		if names = splitList(list); len(names) == 0 {
+			err = errors.New("empty -preprocessors list")
+		}
+		return
+	} else if tc.sect == `` {
+		err = errors.New("either -section or -preprocessors is required")
+		return
+	} else if tc.vc == nil {
+		err = fmt.Errorf("section %s not found", tc.sect)
+		return
+	}
+	var vals []string
+	if vals, err = tc.vc.GetStringSlice(preprocessor); err != nil {
+		return
+	}
+	for _, v := range vals {
+		if v = strings.TrimSpace(v); v != `` {
+			names = append(names, v)
+		}
+	}
+	if len(names) == 0 {
+		err = fmt.Errorf("section %s does not define any preprocessors", tc.sect)
+	}
+	return
+}
+
+// parseSection splits a section given as Type "name" or [Type "name"], the name is optional
+func parseSection(v string) (typ, name string, ok bool) {
+	v = strings.TrimSpace(v)
+	v = strings.TrimSuffix(strings.TrimPrefix(v, `[`), `]`)
+	typ, name, _ = strings.Cut(strings.TrimSpace(v), ` `)
+	if typ = strings.TrimSpace(typ); typ == `` || strings.EqualFold(typ, preprocessor) {
+		return
+	}
+	name = strings.Trim(strings.TrimSpace(name), `"`)
+	ok = true
+	return
+}
@@ -0,0 +1,221 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingesters/utils"
+)
+
+const (
+	lineFormat = `line`
+
+	maxLineSize = 1024 * 1024
+)
+
+// collector acts as the ingest muxer for the preprocessor set, handing out tags and holding
+// on to every entry written so that they can be rendered once the run completes.
+type collector struct {
+	tags map[string]entry.EntryTag
+	ents []*entry.Entry
+}
+
+func newCollector() *collector {
+	return &collector{
+		tags: map[string]entry.EntryTag{},
+	}
+}
+
+func (c *collector) NegotiateTag(name string) (tg entry.EntryTag, err error) {
+	if err = ingest.CheckTag(name); err != nil {
+		return
+	}
+	var ok bool
+	if tg, ok = c.tags[name]; !ok {
+		tg = entry.EntryTag(len(c.tags))
+		c.tags[name] = tg
+	}
+	return
+}
+
+func (c *collector) LookupTag(tg entry.EntryTag) (string, bool) {
+	for k, v := range c.tags {
+		if v == tg {
+			return k, true
+		}
+	}
+	return ``, false
+}
+
+func (c *collector) KnownTags() (r []string) {
+	for k := range c.tags {
+		r = append(r, k)
+	}
+	return
+}
+
+// GetTag and OverrideTags implement utils.TagHandler for the reimport readers
+func (c *collector) GetTag(name string) (entry.EntryTag, error) {
+	return c.NegotiateTag(name)
+}
+
+func (c *collector) OverrideTags(entry.EntryTag) {}
+
+func (c *collector) WriteEntry(ent *entry.Entry) error {
+	if ent != nil {
+		c.ents = append(c.ents, ent)
+	}
+	return nil
+}
+
+func (c *collector) WriteEntryContext(ctx context.Context, ent *entry.Entry) error {
+	return c.WriteEntry(ent)
+}
+
+func (c *collector) WriteBatch(ents []*entry.Entry) error {
+	for _, ent := range ents {
+		c.WriteEntry(ent)
+	}
+	return nil
+}
+
+func (c *collector) WriteBatchContext(ctx context.Context, ents []*entry.Entry) error {
+	return c.WriteBatch(ents)
+}
+
+type outputEV struct {
+	Name  string
+	Value string
+}
+
+type outputEntry struct {
+	TS   string
+	Tag  string
+	SRC  string `json:",omitempty"`
+	Data string
+	EVs  []outputEV `json:",omitempty"`
+}
+
+// render writes one JSON object per entry, the output is stable so it can be used for golden tests
+func (c *collector) render(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, ent := range c.ents {
+		oe := outputEntry{
+			TS:   ent.TS.StandardTime().UTC().Format(time.RFC3339Nano),
+			Data: string(ent.Data),
+		}
+		if name, ok := c.LookupTag(ent.Tag); ok {
+			oe.Tag = name
+		} else {
+			oe.Tag = fmt.Sprintf("unknown(%d)", ent.Tag)
+		}
+		if ent.SRC != nil {
+			oe.SRC = ent.SRC.String()
+		}
+		for _, ev := range ent.EnumeratedValues() {
+			oe.EVs = append(oe.EVs, outputEV{Name: ev.Name, Value: ev.Value.String()})
+		}
+		if err := enc.Encode(oe); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lineReader turns each line of the input into an entry
+type lineReader struct {
+	sc  *bufio.Scanner
+	tag entry.EntryTag
+	ts  entry.Timestamp
+}
+
+func (lr *lineReader) ReadEntry() (*entry.Entry, error) {
+	for lr.sc.Scan() {
+		ln := bytes.TrimRight(lr.sc.Bytes(), "\r")
+		if len(ln) == 0 {
+			continue
+		}
+		return &entry.Entry{
+			TS:   lr.ts,
+			Tag:  lr.tag,
+			Data: append([]byte(nil), ln...),
+		}, nil
+	}
+	if err := lr.sc.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (lr *lineReader) OverrideTags(tg entry.EntryTag) {
+	lr.tag = tg
+}
+
+func (lr *lineReader) DisableEVs() {}
+
+func getReader(format, pth string, rdr io.ReadCloser, c *collector, tag string, ts entry.Timestamp) (ir utils.ReimportReader, err error) {
+	if format == `` && (pth == `-` || filepath.Ext(pth) != `.json` && filepath.Ext(pth) != `.csv`) {
+		format = lineFormat
+	}
+	if strings.ToLower(strings.TrimSpace(format)) == lineFormat {
+		lr := &lineReader{
+			sc: bufio.NewScanner(rdr),
+			ts: ts,
+		}
+		lr.sc.Buffer(make([]byte, 64*1024), maxLineSize)
+		if lr.tag, err = c.NegotiateTag(tag); err == nil {
+			ir = lr
+		}
+		return
+	}
+	if format, err = utils.GetImportFormat(format, pth); err != nil {
+		return
+	}
+	ir, err = utils.GetImportReader(format, rdr, c)
+	return
+}
+
+// diffLines prints lines which differ between the expected and actual output and returns the count
+func diffLines(exp, act []byte, w io.Writer) (diffs int) {
+	el := strings.Split(strings.TrimRight(string(exp), "\n"), "\n")
+	al := strings.Split(strings.TrimRight(string(act), "\n"), "\n")
+	for i := 0; i < len(el) || i < len(al); i++ {
+		var e, a string
+		if i < len(el) {
+			e = el[i]
+		}
+		if i < len(al) {
+			a = al[i]
+		}
+		if e == a {
+			continue
+		}
+		diffs++
+		fmt.Fprintf(w, "line %d:\n", i+1)
+		if i < len(el) {
+			fmt.Fprintf(w, "- %s\n", e)
+		}
+		if i < len(al) {
+			fmt.Fprintf(w, "+ %s\n", a)
+		}
+	}
+	return
+}
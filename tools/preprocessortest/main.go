@@ -0,0 +1,159 @@
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+// preprocessortest loads the preprocessor definitions out of an ingester config file
+// and runs sample data through a named chain, printing the resulting entries.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	// Embed tzdata so that we don't rely on potentially broken timezone DBs on the host
+	_ "time/tzdata"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+)
+
+var (
+	configPath   = flag.String("config-file", "", "Path to the ingester configuration file")
+	overlayPath  = flag.String("config-overlays", "", "Optional path to a conf.d overlay directory")
+	section      = flag.String("section", "", `Config section whose Preprocessor list is used, e.g. 'Listener "default"'`)
+	chain        = flag.String("preprocessors", "", "Comma separated list of preprocessors to run, overrides -section")
+	dataPath     = flag.String("data-path", "", "Path to sample data, - reads from stdin")
+	fmtF         = flag.String("import-format", "", "Input format: line, json, or csv, defaults to the file extension or line")
+	defTag       = flag.String("tag", "default", "Tag applied to line input")
+	lineTime     = flag.String("line-time", "", "RFC3339 timestamp applied to line input, defaults to now")
+	expectedPath = flag.String("expected", "", "Compare output against this file and exit non-zero on differences")
+	update       = flag.Bool("update", false, "Write the output to the -expected file instead of comparing")
+	stats        = flag.Bool("stats", false, "Print per-preprocessor statistics to stderr")
+)
+
+func main() {
+	flag.Parse()
+	if *configPath == `` {
+		fatalf("missing -config-file\n")
+	} else if *dataPath == `` {
+		fatalf("missing -data-path\n")
+	}
+
+	sect := *section
+	if *chain != `` {
+		sect = `` // an explicit list overrides the section
+	}
+	cfg, err := loadConfig(*configPath, *overlayPath, sect)
+	if err != nil {
+		fatalf("Failed to load config %q: %v\n", *configPath, err)
+	}
+	names, err := cfg.chain(*chain)
+	if err != nil {
+		fatalf("%v\n", err)
+	} else if err = cfg.Preprocessor.CheckProcessors(names); err != nil {
+		fatalf("%v\n", err)
+	}
+
+	ts := entry.Now()
+	if *lineTime != `` {
+		var t time.Time
+		if t, err = time.Parse(time.RFC3339Nano, *lineTime); err != nil {
+			fatalf("Invalid -line-time %q: %v\n", *lineTime, err)
+		}
+		ts = entry.FromStandard(t)
+	}
+
+	cw := newCollector()
+	ps, err := cfg.Preprocessor.ProcessorSet(cw, names)
+	if err != nil {
+		fatalf("Failed to build preprocessor set: %v\n", err)
+	}
+
+	var fin io.ReadCloser
+	if *dataPath == `-` {
+		fin = os.Stdin
+	} else if fin, err = os.Open(*dataPath); err != nil {
+		fatalf("Failed to open data file %q: %v\n", *dataPath, err)
+	}
+	rdr, err := getReader(*fmtF, *dataPath, fin, cw, *defTag, ts)
+	if err != nil {
+		fatalf("%v\n", err)
+	}
+
+	var input int
+	for {
+		ent, err := rdr.ReadEntry()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			fatalf("Failed to read entry %d: %v\n", input+1, err)
+		}
+		input++
+		if err = ps.Process(ent); err != nil {
+			fmt.Fprintf(os.Stderr, "preprocessor error on entry %d: %v\n", input, err)
+		}
+	}
+	fin.Close()
+	// closing the set flushes anything the preprocessors are holding on to
+	if err = ps.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to close preprocessors: %v\n", err)
+	}
+	if *stats {
+		printStats(os.Stderr, ps)
+	}
+
+	out := bytes.NewBuffer(nil)
+	if err = cw.render(out); err != nil {
+		fatalf("Failed to render output: %v\n", err)
+	}
+	if *expectedPath == `` {
+		os.Stdout.Write(out.Bytes())
+	} else if *update {
+		if err = os.WriteFile(*expectedPath, out.Bytes(), 0640); err != nil {
+			fatalf("Failed to write %q: %v\n", *expectedPath, err)
+		}
+	} else {
+		exp, err := os.ReadFile(*expectedPath)
+		if err != nil {
+			fatalf("Failed to read %q: %v\n", *expectedPath, err)
+		}
+		if diffs := diffLines(exp, out.Bytes(), os.Stdout); diffs > 0 {
+			fatalf("%d lines differ from %s\n", diffs, *expectedPath)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "INPUT: %d\nOUTPUT: %d\n", input, len(cw.ents))
+}
+
+func printStats(w io.Writer, ps *processors.ProcessorSet) {
+	pss, ok := ps.Stats().(processors.ProcessorSetStats)
+	if !ok {
+		return
+	}
+	for _, s := range pss.Preprocessors {
+		fmt.Fprintf(w, "%s (%s): in %d out %d dropped %d errors %d bytes in %d bytes out %d\n",
+			s.Name, s.Type, s.EntriesIn, s.EntriesOut, s.Dropped, s.Errors, s.BytesIn, s.BytesOut)
+	}
+}
+
+func fatalf(f string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, f, args...)
+	os.Exit(1)
+}
+
+func splitList(v string) (r []string) {
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != `` {
+			r = append(r, s)
+		}
+	}
+	return
+}
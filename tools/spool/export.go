@@ -0,0 +1,78 @@
+//go:build linux || darwin
+// +build linux darwin
+
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/client/types"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+)
+
+// exportEntry is a human readable form of a spooled entry, one JSON object per line
+type exportEntry struct {
+	TS   string
+	Tag  string
+	SRC  string `json:",omitempty"`
+	Data string
+	EVs  map[string]string `json:",omitempty"`
+}
+
+func export(s *processors.Spool) (err error) {
+	var w io.Writer = os.Stdout
+	if *outputPath != `` {
+		var fout *os.File
+		if fout, err = os.Create(*outputPath); err != nil {
+			return
+		}
+		defer func() {
+			if lerr := fout.Close(); lerr != nil && err == nil {
+				err = lerr
+			}
+		}()
+		w = fout
+	}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	err = each(s, func(strents []types.StringTagEntry) error {
+		for _, ste := range strents {
+			if err := enc.Encode(newExportEntry(ste)); err != nil {
+				return err
+			}
+		}
+		return bw.Flush() // flush per batch so consumed batches are always on disk
+	})
+	return
+}
+
+func newExportEntry(ste types.StringTagEntry) (ee exportEntry) {
+	ee = exportEntry{
+		TS:   ste.TS.Format(time.RFC3339Nano),
+		Tag:  ste.Tag,
+		Data: string(ste.Data),
+	}
+	if ste.SRC != nil {
+		ee.SRC = ste.SRC.String()
+	}
+	if len(ste.Enumerated) > 0 {
+		ee.EVs = make(map[string]string, len(ste.Enumerated))
+		for _, ep := range ste.Enumerated {
+			ee.EVs[ep.Name] = ep.Value
+		}
+	}
+	return
+}
@@ -0,0 +1,185 @@
+//go:build linux || darwin
+// +build linux darwin
+
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+// spool inspects, exports, or replays the spool files written by the forwarder,
+// gravwellforwarder, and persistent-buffer preprocessors.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	// Embed tzdata so that we don't rely on potentially broken timezone DBs on the host
+	_ "time/tzdata"
+
+	"github.com/gravwell/gravwell/v3/client/types"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+)
+
+const (
+	actionInspect = `inspect`
+	actionExport  = `export`
+	actionReplay  = `replay`
+)
+
+var (
+	spoolPath    = flag.String("spool-file", "", "Path to the spool file")
+	action       = flag.String("action", actionInspect, "inspect, export, or replay")
+	outputPath   = flag.String("output", "", "Export destination, defaults to stdout")
+	consume      = flag.Bool("consume", false, "Remove batches from the spool as they are exported or replayed")
+	clearConns   = flag.String("clear-conns", "", "Comma-separated server:port list of cleartext replay targets")
+	tlsConns     = flag.String("tls-conns", "", "Comma-separated server:port list of TLS replay targets")
+	pipeConns    = flag.String("pipe-conns", "", "Comma-separated list of paths for named pipe replay targets")
+	tlsNoVerify  = flag.Bool("insecure-no-tls-validate", false, "Disable remote TLS validation on replay connections")
+	ingestSecret = flag.String("ingest-secret", "IngestSecrets", "Ingest key used for replay")
+	ingestTenant = flag.String("ingest-tenant", "", "Ingest tenant ID used for replay, blank for system tenant")
+	timeout      = flag.Duration("timeout", 10*time.Second, "Time to wait for replay connections to come up and sync")
+)
+
+func main() {
+	flag.Parse()
+	if *spoolPath == `` {
+		fatalf("missing -spool-file\n")
+	} else if _, err := os.Stat(*spoolPath); err != nil {
+		fatalf("invalid spool file %q: %v\n", *spoolPath, err)
+	}
+	s, cleanup, err := openSpool(*spoolPath, *consume)
+	if err != nil {
+		fatalf("Failed to open spool %q: %v\n", *spoolPath, err)
+	}
+	switch strings.ToLower(*action) {
+	case actionInspect:
+		err = inspect(s, os.Stdout)
+	case actionExport:
+		err = export(s)
+	case actionReplay:
+		err = replay(s)
+	default:
+		err = fmt.Errorf("unknown action %q", *action)
+	}
+	if lerr := s.Close(); lerr != nil && err == nil {
+		err = lerr
+	}
+	cleanup()
+	if err != nil {
+		fatalf("%v\n", err)
+	}
+}
+
+// openSpool opens the spool in place when consuming, otherwise we work from a copy
+// so that reading does not alter the original.
+func openSpool(pth string, consume bool) (s *processors.Spool, cleanup func(), err error) {
+	cleanup = func() {}
+	if consume {
+		s, err = processors.OpenSpool(pth, 0)
+		return
+	}
+	var dir string
+	if dir, err = os.MkdirTemp(``, `spool`); err != nil {
+		return
+	}
+	cleanup = func() { os.RemoveAll(dir) }
+	cp := filepath.Join(dir, filepath.Base(pth))
+	if err = copyFile(pth, cp); err == nil {
+		s, err = processors.OpenSpool(cp, 0)
+	}
+	if err != nil {
+		cleanup()
+	}
+	return
+}
+
+func copyFile(src, dst string) (err error) {
+	var fin, fout *os.File
+	if fin, err = os.Open(src); err != nil {
+		return
+	}
+	defer fin.Close()
+	if fout, err = os.Create(dst); err != nil {
+		return
+	}
+	if _, err = io.Copy(fout, fin); err != nil {
+		fout.Close()
+		return
+	}
+	return fout.Close()
+}
+
+// each calls fn with every batch in the spool in order
+func each(s *processors.Spool, fn func([]types.StringTagEntry) error) error {
+	for {
+		strents, err := s.Peek()
+		if err == processors.ErrBufferEmpty {
+			return nil
+		} else if err != nil {
+			return err
+		} else if err = fn(strents); err != nil {
+			return err
+		} else if _, err = s.Pop(); err != nil {
+			return err
+		}
+	}
+}
+
+func inspect(s *processors.Spool, w io.Writer) error {
+	var batches, count, size int
+	var first, last time.Time
+	tags := map[string]int{}
+	used, capacity := s.Size(), s.Capacity()
+	err := each(s, func(strents []types.StringTagEntry) error {
+		batches++
+		for _, ste := range strents {
+			count++
+			size += len(ste.Data)
+			tags[ste.Tag]++
+			if first.IsZero() || ste.TS.Before(first) {
+				first = ste.TS
+			}
+			if ste.TS.After(last) {
+				last = ste.TS
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Spool:    %s\n", *spoolPath)
+	fmt.Fprintf(w, "Capacity: %d bytes\n", capacity)
+	fmt.Fprintf(w, "Used:     %d bytes\n", used)
+	fmt.Fprintf(w, "Batches:  %d\n", batches)
+	fmt.Fprintf(w, "Entries:  %d (%d bytes of data)\n", count, size)
+	if count > 0 {
+		fmt.Fprintf(w, "Oldest:   %s\n", first.Format(time.RFC3339Nano))
+		fmt.Fprintf(w, "Newest:   %s\n", last.Format(time.RFC3339Nano))
+		names := make([]string, 0, len(tags))
+		for k := range tags {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(w, "Tags:\n")
+		for _, k := range names {
+			fmt.Fprintf(w, "\t%s: %d\n", k, tags[k])
+		}
+	}
+	return nil
+}
+
+func fatalf(f string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, f, args...)
+	os.Exit(1)
+}
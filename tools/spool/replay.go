@@ -0,0 +1,109 @@
+//go:build linux || darwin
+// +build linux darwin
+
+/*************************************************************************
+ * Copyright 2024 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gravwell/gravwell/v3/client/types"
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+	"github.com/gravwell/gravwell/v3/ingesters/version"
+)
+
+const replayName = `spool_replay`
+
+func replay(s *processors.Spool) (err error) {
+	var conns []string
+	var lgr *log.Logger
+	if conns, err = replayTargets(); err != nil {
+		return
+	} else if lgr, err = log.NewStderrLogger(``); err != nil {
+		return
+	}
+	umc := ingest.UniformMuxerConfig{
+		Destinations:    conns,
+		VerifyCert:      !*tlsNoVerify,
+		Tags:            []string{entry.DefaultTagName},
+		Auth:            *ingestSecret,
+		Tenant:          *ingestTenant,
+		IngesterName:    replayName,
+		IngesterVersion: version.GetVersion(),
+		IngesterUUID:    uuid.New().String(),
+		Logger:          lgr,
+		LogLevel:        `ERROR`,
+	}
+	var mxr *ingest.IngestMuxer
+	if mxr, err = ingest.NewUniformMuxer(umc); err != nil {
+		return
+	} else if err = mxr.Start(); err != nil {
+		mxr.Close()
+		return
+	} else if err = mxr.WaitForHot(*timeout); err != nil {
+		mxr.Close()
+		return
+	}
+	var count int
+	err = each(s, func(strents []types.StringTagEntry) (err error) {
+		ents := make([]*entry.Entry, 0, len(strents))
+		for _, ste := range strents {
+			var ent *entry.Entry
+			if ent, err = processors.EntryFromStringTagEntry(ste, mxr); err != nil {
+				return
+			}
+			ents = append(ents, ent)
+		}
+		if err = mxr.WriteBatch(ents); err != nil {
+			return
+		}
+		count += len(ents)
+		// make sure the batch is on an indexer before it is removed from the spool
+		return mxr.Sync(*timeout)
+	})
+	if lerr := mxr.Close(); lerr != nil && err == nil {
+		err = lerr
+	}
+	fmt.Fprintf(os.Stderr, "replayed %d entries\n", count)
+	return
+}
+
+func replayTargets() (conns []string, err error) {
+	for _, c := range splitConns(*clearConns) {
+		conns = append(conns, `tcp://`+config.AppendDefaultPort(c, config.DefaultCleartextPort))
+	}
+	for _, c := range splitConns(*tlsConns) {
+		conns = append(conns, `tls://`+config.AppendDefaultPort(c, config.DefaultTLSPort))
+	}
+	for _, c := range splitConns(*pipeConns) {
+		conns = append(conns, `pipe://`+c)
+	}
+	if len(conns) == 0 {
+		err = errors.New("replay requires at least one of -clear-conns, -tls-conns, or -pipe-conns")
+	}
+	return
+}
+
+func splitConns(v string) (r []string) {
+	for _, c := range strings.Split(v, `,`) {
+		if c = strings.TrimSpace(c); c != `` {
+			r = append(r, c)
+		}
+	}
+	return
+}